@@ -3,12 +3,16 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 
 	"github.com/GoldenDeals/DepGit/internal/config"
 	"github.com/GoldenDeals/DepGit/internal/database"
+	"github.com/GoldenDeals/DepGit/internal/git"
 	"github.com/GoldenDeals/DepGit/internal/share/logger"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+	"github.com/GoldenDeals/DepGit/internal/web"
 )
 
 var log = logger.New("main")
@@ -26,13 +30,6 @@ func main() {
 		log.Fatalf("Failed to create database directory: %v", err)
 	}
 
-	// Create migrations directory if specified
-	if cfg.DB.MigrationsPath != "" {
-		if err := os.MkdirAll(cfg.DB.MigrationsPath, 0o755); err != nil {
-			log.Fatalf("Failed to create migrations directory: %v", err)
-		}
-	}
-
 	// Initialize the database
 	log.Info("Initializing database...")
 	db := &database.DB{}
@@ -41,8 +38,75 @@ func main() {
 	}
 	defer db.Close()
 
+	ctx := context.Background()
+	storage, err := stroage.FromConfig(ctx, cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// Purge deleted records once their retention expired, along with the
+	// namespace each purged repository was stored in
+	if cfg.DB.Retention > 0 {
+		purger := &database.Purger{
+			Store:     db,
+			Retention: cfg.DB.Retention,
+			PurgeRepo: func(ctx context.Context, repo database.DeletedRecord) error {
+				// No namespace could have been written under such a name
+				if err := stroage.CheckNamespace(repo.Name); err != nil {
+					log.WithField("repo", repo.Name).WithError(err).Warn("Not removing the storage of a purged repository")
+					return nil
+				}
+				return stroage.DeleteNamespace(ctx, storage, repo.Name)
+			},
+		}
+		go purger.Loop(ctx, cfg.DB.PurgeInterval)
+	}
+
+	// The API and the git server share the verifier, so that keys changed
+	// through the API are no longer trusted for pushes either
+	verifier := git.NewVerifier(db)
+
+	// Serve the API, and signed downloads if the storage hands them out
+	handler := web.NewAPIHandler(db, verifier)
+	handler.SetStorage(storage)
+	if signer, ok := storage.(stroage.URLSigner); ok {
+		handler.SetURLSigner(signer)
+	}
+	webConfig := web.Config{
+		Address:     cfg.Web.Address,
+		StaticDir:   cfg.Web.StaticDir,
+		APIBasePath: cfg.Web.APIBasePath,
+	}
+	if ds, ok := storage.(stroage.DownloadServer); ok {
+		webConfig.Downloads = ds.DownloadHandler()
+		webConfig.DownloadPath = ds.DownloadPath()
+	}
+	go func() {
+		if err := web.New(webConfig, handler).Start(ctx); err != nil {
+			log.WithError(err).Error("Web server stopped")
+		}
+	}()
+
+	// Serve git over SSH, locking repositories as configured
+	gitServer, err := git.Init(git.Config{
+		Address:               cfg.SSH.Address,
+		RequireSignedBranches: cfg.SSH.RequireSignedBranches,
+		Locks:                 cfg.SSH.Locks,
+		LeaseTTL:              cfg.SSH.LeaseTTL,
+	}, storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize git server: %v", err)
+	}
+	gitServer.SetVerifier(verifier)
+	gitServer.SetRepos(db)
+	defer gitServer.Close()
+	go func() {
+		if err := gitServer.Serve(ctx); err != nil {
+			log.WithError(err).Error("Git server stopped")
+		}
+	}()
+
 	log.Info("DepGit server starting")
-	// TODO: Add server initialization and other startup logic here
 
 	// Keep the server running
 	select {}
@@ -0,0 +1,124 @@
+// Package main is the entry point for dbctl, which manages the schema of
+// the database configured for DepGit.
+//
+// Usage:
+//
+//	dbctl status
+//	dbctl migrate
+//	dbctl rollback [-steps n]
+//
+// status lists the migrations with their state: applied, pending, or
+// missing if they were applied but their files are gone, e.g. after
+// deploying an older version. Applied migrations whose files changed since
+// are marked as such: migrate and the server refuse to run until they are
+// restored, unless DEPGIT_DB_ALLOW_MIGRATION_DRIFT is set.
+//
+// migrate applies the pending migrations, as the server does on startup.
+//
+// rollback reverts the last applied migrations using their down scripts.
+// Run the dbctl of the version being reverted, which embeds its
+// migrations, before deploying the older version.
+//
+// Like the server, dbctl uses the migrations embedded in it unless
+// DEPGIT_MIGRATIONS_PATH names a directory to load them from.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/config"
+	"github.com/GoldenDeals/DepGit/internal/database"
+	"github.com/GoldenDeals/DepGit/internal/database/migrations"
+	"github.com/GoldenDeals/DepGit/internal/share/logger"
+)
+
+var log = logger.New("dbctl")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "  status     list applied, pending and missing migrations")
+	fmt.Fprintln(os.Stderr, "  migrate    apply the pending migrations")
+	fmt.Fprintln(os.Stderr, "  rollback   revert the last applied migrations")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	db, m, err := database.OpenMigrations(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	switch os.Args[1] {
+	case "status":
+		err = status(ctx, m)
+	case "migrate":
+		err = m.Apply(ctx)
+	case "rollback":
+		err = rollback(ctx, m, os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		log.Fatalf("%s failed: %v", os.Args[1], err)
+	}
+}
+
+func status(ctx context.Context, m *migrations.Manager) error {
+	list, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tSTATE\tAPPLIED AT\tREVERSIBLE")
+	for _, st := range list {
+		applied := "-"
+		if !st.AppliedAt.IsZero() {
+			applied = st.AppliedAt.Format(time.DateTime)
+		}
+		reversible := "no"
+		if st.Reversible {
+			reversible = "yes"
+		}
+		state := string(st.State)
+		if st.Drifted {
+			state += " (changed)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", st.Name, state, applied, reversible)
+	}
+	return w.Flush()
+}
+
+func rollback(ctx context.Context, m *migrations.Manager, args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	names, err := m.Rollback(ctx, *steps)
+	for _, name := range names {
+		log.WithField("migration", name).Info("Rolled back")
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+// Package main is the entry point for storagectl, which maintains the
+// object storage configured for DepGit.
+//
+// Usage:
+//
+//	storagectl migrate [-dry-run] [-checkpoint file] [-namespace a,b]
+//	storagectl repair
+//	storagectl scrub
+//	storagectl rotate [-namespace a,b]
+//
+// migrate copies all objects from the configured storage to the configured
+// secondary storage (storage.secondary). Run it while the server mirrors
+// writes to the secondary, then make the secondary the primary.
+//
+// repair makes all replicas of a replicated storage hold the same objects.
+//
+// scrub reads every object and reports those not matching their recorded
+// checksum.
+//
+// rotate re-wraps the data keys of encrypted objects with the primary key
+// of the keyring, so that older keys can be removed from it afterwards.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/GoldenDeals/DepGit/internal/config"
+	"github.com/GoldenDeals/DepGit/internal/share/logger"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+)
+
+var log = logger.New("storagectl")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "  migrate    copy all objects to the secondary storage")
+	fmt.Fprintln(os.Stderr, "  repair     bring all storage replicas in sync")
+	fmt.Fprintln(os.Stderr, "  scrub      verify the checksums of all objects")
+	fmt.Fprintln(os.Stderr, "  rotate     re-encrypt data keys with the primary key")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch os.Args[1] {
+	case "migrate":
+		err = migrate(ctx, cfg, os.Args[2:])
+	case "repair":
+		err = repair(ctx, cfg)
+	case "scrub":
+		err = scrub(ctx, cfg)
+	case "rotate":
+		err = rotate(ctx, cfg, os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		log.Fatalf("%s failed: %v", os.Args[1], err)
+	}
+}
+
+func migrate(ctx context.Context, cfg *config.Configuration, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be copied without writing")
+	checkpoint := flags.String("checkpoint", "data/migrate.checkpoint", "file recording progress to resume from")
+	namespaces := flags.String("namespace", "", "comma separated namespaces to migrate, all by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	primary := cfg.Storage
+	primary.Secondary = nil
+	primary.RepairInterval = 0
+	src, err := stroage.FromConfig(ctx, primary)
+	if err != nil {
+		return fmt.Errorf("error opening source storage: %w", err)
+	}
+	dst, err := stroage.SecondaryFromConfig(ctx, cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("error opening destination storage: %w", err)
+	}
+
+	m := &stroage.Migrator{
+		Src:            src,
+		Dst:            dst,
+		DryRun:         *dryRun,
+		CheckpointPath: *checkpoint,
+		Report: func(namespace, objname string, action stroage.MigrateAction) {
+			log.WithField("namespace", namespace).
+				WithField("object", objname).
+				WithField("dry_run", *dryRun).
+				Debug(action.String())
+		},
+	}
+	if *namespaces != "" {
+		m.Namespaces = strings.Split(*namespaces, ",")
+	}
+
+	stats, err := m.Run(ctx)
+	log.WithField("copied", stats.Copied).
+		WithField("replaced", stats.Replaced).
+		WithField("skipped", stats.Skipped).
+		WithField("bytes", stats.Bytes).
+		WithField("dry_run", *dryRun).
+		Info("Migration summary")
+	return err
+}
+
+func repair(ctx context.Context, cfg *config.Configuration) error {
+	st, err := stroage.ReplicatedFromConfig(ctx, cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("error opening storage: %w", err)
+	}
+
+	stats, err := st.Repair(ctx)
+	log.WithField("checked", stats.Checked).
+		WithField("repaired", stats.Repaired).
+		Info("Repair summary")
+	return err
+}
+
+func scrub(ctx context.Context, cfg *config.Configuration) error {
+	primary := cfg.Storage
+	primary.Secondary = nil
+	primary.RepairInterval = 0
+	st, err := stroage.FromConfig(ctx, primary)
+	if err != nil {
+		return fmt.Errorf("error opening storage: %w", err)
+	}
+
+	stats, err := stroage.Scrub(ctx, st, func(namespace, objname string, err error) {
+		log.WithField("namespace", namespace).
+			WithField("object", objname).
+			WithError(err).
+			Error("Object failed verification")
+	})
+	log.WithField("checked", stats.Checked).
+		WithField("corrupted", stats.Corrupted).
+		WithField("failed", stats.Failed).
+		Info("Scrub summary")
+	if err != nil {
+		return err
+	}
+
+	if stats.Corrupted > 0 || stats.Failed > 0 {
+		return fmt.Errorf("%d corrupted and %d unreadable objects", stats.Corrupted, stats.Failed)
+	}
+	return nil
+}
+
+func rotate(ctx context.Context, cfg *config.Configuration, args []string) error {
+	flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+	namespaces := flags.String("namespace", "", "comma separated namespaces to rotate, all by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	st, err := stroage.EncryptedFromConfig(ctx, cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("error opening storage: %w", err)
+	}
+
+	var nss []string
+	if *namespaces != "" {
+		nss = strings.Split(*namespaces, ",")
+	} else if nss, err = st.Namespaces(ctx); err != nil {
+		return err
+	}
+
+	total := 0
+	for _, ns := range nss {
+		rotated, err := st.Rotate(ctx, ns)
+		total += rotated
+		log.WithField("namespace", ns).
+			WithField("rotated", rotated).
+			Debug("Rotated namespace")
+		if err != nil {
+			log.WithField("rotated", total).Info("Rotation summary")
+			return fmt.Errorf("namespace %s: %w", ns, err)
+		}
+	}
+
+	log.WithField("namespaces", len(nss)).
+		WithField("rotated", total).
+		Info("Rotation summary")
+	return nil
+}
@@ -6,27 +6,120 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 // DBConfig holds database-specific configuration
 type DBConfig struct {
+	// Driver selects the database: "sqlite3" (default) or "postgres"
+	Driver string `mapstructure:"driver"`
+	// DSN is the connection string of a PostgreSQL database. For SQLite it
+	// overrides Path when set.
+	DSN string `mapstructure:"dsn"`
+
 	Path             string `mapstructure:"db_path"`
 	MigrationsPath   string `mapstructure:"migrations_path"`
 	InitialMigration string `mapstructure:"initial_migration"`
+	// AllowMigrationDrift starts despite applied migrations having been
+	// changed since, which is refused by default
+	AllowMigrationDrift bool `mapstructure:"allow_migration_drift"`
+
+	// Retention is how long deleted records stay restorable before they are
+	// purged. Zero keeps them forever.
+	Retention time.Duration `mapstructure:"retention"`
+	// PurgeInterval is how often expired records are purged
+	PurgeInterval time.Duration `mapstructure:"purge_interval"`
 }
 
 // SSHConfig holds SSH Git server configuration
 type SSHConfig struct {
-	Address string `mapstructure:"ssh_git_address"`
-	HostKey string `mapstructure:"ssh_git_hostkey"`
+	Address string `mapstructure:"address"`
+	HostKey string `mapstructure:"hostkey"`
+
+	// Locks is "memory" to serialize pushes per node or "storage" to share
+	// repository locks through the storage between nodes
+	Locks    string        `mapstructure:"locks"`
+	LeaseTTL time.Duration `mapstructure:"lease_ttl"`
+
+	// RequireSignedBranches are glob patterns of the branches that only
+	// accept commits signed by a registered key
+	RequireSignedBranches []string `mapstructure:"require_signed_branches"`
+}
+
+// MinioConfig holds MinIO connection settings
+type MinioConfig struct {
+	Endpoint  string `mapstructure:"endpoint"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	Bucket    string `mapstructure:"bucket"`
+	UseSSL    bool   `mapstructure:"use_ssl"`
+}
+
+// CacheConfig holds the read-through cache sizes, zero disables a tier
+type CacheConfig struct {
+	MemoryBytes int64  `mapstructure:"memory_bytes"`
+	DiskPath    string `mapstructure:"disk_path"`
+	DiskBytes   int64  `mapstructure:"disk_bytes"`
+}
+
+// SignedURLConfig enables signed download URLs for the file backend, which
+// are served by the web server below BaseURL
+type SignedURLConfig struct {
+	BaseURL string `mapstructure:"base_url"`
+	KeyFile string `mapstructure:"keyfile"`
+}
+
+// StorageConfig holds object storage configuration
+type StorageConfig struct {
+	// Backend is "file", "minio", "memory" or "replicated"
+	Backend string      `mapstructure:"backend"`
+	Path    string      `mapstructure:"path"`
+	Minio   MinioConfig `mapstructure:"minio"`
+
+	// Fanout spreads the objects of the "file" backend over subdirectories
+	// named after the first Fanout characters of their names, 0 disables it.
+	// Existing data is moved when it changes.
+	Fanout int `mapstructure:"fanout"`
+
+	// Replicas, WriteQuorum and RepairInterval configure the "replicated"
+	// backend. Replicas can only be set in the config file.
+	Replicas       []StorageConfig `mapstructure:"replicas"`
+	WriteQuorum    int             `mapstructure:"write_quorum"`
+	RepairInterval time.Duration   `mapstructure:"repair_interval"`
+
+	// Compression is empty, "gzip" or "zstd"
+	Compression string `mapstructure:"compression"`
+
+	// EncryptionKeyFile enables encryption at rest with the keys it holds
+	EncryptionKeyFile string `mapstructure:"encryption_keyfile"`
+	// EncryptionAllowPlaintext reads objects stored before encryption was
+	// enabled as they are, instead of failing
+	EncryptionAllowPlaintext bool `mapstructure:"encryption_allow_plaintext"`
+
+	Cache CacheConfig `mapstructure:"cache"`
+
+	SignedURLs SignedURLConfig `mapstructure:"signed_urls"`
+
+	// Secondary mirrors all writes to another backend while migrating to
+	// it, see storagectl migrate
+	Secondary *StorageConfig `mapstructure:"secondary"`
+}
+
+// WebConfig holds the web server configuration
+type WebConfig struct {
+	Address     string `mapstructure:"address"`
+	StaticDir   string `mapstructure:"static_dir"`
+	APIBasePath string `mapstructure:"api_base_path"`
 }
 
 // Configuration holds all module-specific configurations
 type Configuration struct {
-	DB  DBConfig  `mapstructure:"db"`
-	SSH SSHConfig `mapstructure:"ssh"`
+	DB      DBConfig      `mapstructure:"db"`
+	SSH     SSHConfig     `mapstructure:"ssh"`
+	Web     WebConfig     `mapstructure:"web"`
+	Storage StorageConfig `mapstructure:"storage"`
 }
 
 // Load initializes the configuration from environment variables and config files
@@ -34,11 +127,40 @@ func Load() (*Configuration, error) {
 	v := viper.New()
 
 	// Set default values
+	v.SetDefault("db.driver", "sqlite3")
+	v.SetDefault("db.dsn", "")
 	v.SetDefault("db.path", "data/depgit.db")
 	v.SetDefault("db.migrations_path", "")
 	v.SetDefault("db.initial_migration", "")
+	v.SetDefault("db.allow_migration_drift", false)
+	v.SetDefault("db.retention", 30*24*time.Hour)
+	v.SetDefault("db.purge_interval", time.Hour)
 	v.SetDefault("ssh.address", "0.0.0.0:2222")
 	v.SetDefault("ssh.hostkey", "")
+	v.SetDefault("ssh.locks", "memory")
+	v.SetDefault("ssh.lease_ttl", 30*time.Second)
+	v.SetDefault("ssh.require_signed_branches", []string{})
+	v.SetDefault("web.address", ":8080")
+	v.SetDefault("web.static_dir", "./web/dist")
+	v.SetDefault("web.api_base_path", "/api/v1")
+	v.SetDefault("storage.backend", "file")
+	v.SetDefault("storage.path", "data/objects")
+	v.SetDefault("storage.fanout", 0)
+	v.SetDefault("storage.minio.endpoint", "")
+	v.SetDefault("storage.minio.access_key", "")
+	v.SetDefault("storage.minio.secret_key", "")
+	v.SetDefault("storage.minio.bucket", "depgit")
+	v.SetDefault("storage.minio.use_ssl", false)
+	v.SetDefault("storage.write_quorum", 0)
+	v.SetDefault("storage.repair_interval", 5*time.Minute)
+	v.SetDefault("storage.compression", "")
+	v.SetDefault("storage.encryption_keyfile", "")
+	v.SetDefault("storage.encryption_allow_plaintext", false)
+	v.SetDefault("storage.cache.memory_bytes", 0)
+	v.SetDefault("storage.cache.disk_path", "data/cache")
+	v.SetDefault("storage.cache.disk_bytes", 0)
+	v.SetDefault("storage.signed_urls.base_url", "")
+	v.SetDefault("storage.signed_urls.keyfile", "")
 
 	// Enable environment variable support with nested key support
 	v.SetEnvPrefix("DEPGIT")
@@ -62,6 +184,60 @@ func Load() (*Configuration, error) {
 		return nil, fmt.Errorf("error binding environment variable: %w", err)
 	}
 
+	for key, env := range map[string]string{
+		"db.driver": "DEPGIT_DB_DRIVER",
+		"db.dsn":    "DEPGIT_DB_DSN",
+
+		"db.allow_migration_drift": "DEPGIT_DB_ALLOW_MIGRATION_DRIFT",
+
+		"db.retention":      "DEPGIT_DB_RETENTION",
+		"db.purge_interval": "DEPGIT_DB_PURGE_INTERVAL",
+
+		"ssh.locks":     "DEPGIT_SSH_LOCKS",
+		"ssh.lease_ttl": "DEPGIT_SSH_LEASE_TTL",
+
+		"ssh.require_signed_branches": "DEPGIT_SSH_REQUIRE_SIGNED_BRANCHES",
+
+		"web.address":       "DEPGIT_WEB_ADDRESS",
+		"web.static_dir":    "DEPGIT_WEB_STATIC_DIR",
+		"web.api_base_path": "DEPGIT_WEB_API_BASE_PATH",
+
+		"storage.backend":            "DEPGIT_STORAGE_BACKEND",
+		"storage.path":               "DEPGIT_STORAGE_PATH",
+		"storage.minio.endpoint":     "DEPGIT_STORAGE_MINIO_ENDPOINT",
+		"storage.minio.access_key":   "DEPGIT_STORAGE_MINIO_ACCESS_KEY",
+		"storage.minio.secret_key":   "DEPGIT_STORAGE_MINIO_SECRET_KEY",
+		"storage.minio.bucket":       "DEPGIT_STORAGE_MINIO_BUCKET",
+		"storage.minio.use_ssl":      "DEPGIT_STORAGE_MINIO_USE_SSL",
+		"storage.write_quorum":       "DEPGIT_STORAGE_WRITE_QUORUM",
+		"storage.repair_interval":    "DEPGIT_STORAGE_REPAIR_INTERVAL",
+		"storage.compression":        "DEPGIT_STORAGE_COMPRESSION",
+		"storage.encryption_keyfile": "DEPGIT_STORAGE_ENCRYPTION_KEYFILE",
+		"storage.cache.memory_bytes": "DEPGIT_STORAGE_CACHE_MEMORY_BYTES",
+		"storage.cache.disk_path":    "DEPGIT_STORAGE_CACHE_DISK_PATH",
+		"storage.cache.disk_bytes":   "DEPGIT_STORAGE_CACHE_DISK_BYTES",
+
+		"storage.encryption_allow_plaintext": "DEPGIT_STORAGE_ENCRYPTION_ALLOW_PLAINTEXT",
+
+		"storage.fanout":               "DEPGIT_STORAGE_FANOUT",
+		"storage.signed_urls.base_url": "DEPGIT_STORAGE_SIGNED_URLS_BASE_URL",
+		"storage.signed_urls.keyfile":  "DEPGIT_STORAGE_SIGNED_URLS_KEYFILE",
+
+		"storage.secondary.backend":            "DEPGIT_STORAGE_SECONDARY_BACKEND",
+		"storage.secondary.path":               "DEPGIT_STORAGE_SECONDARY_PATH",
+		"storage.secondary.minio.endpoint":     "DEPGIT_STORAGE_SECONDARY_MINIO_ENDPOINT",
+		"storage.secondary.minio.access_key":   "DEPGIT_STORAGE_SECONDARY_MINIO_ACCESS_KEY",
+		"storage.secondary.minio.secret_key":   "DEPGIT_STORAGE_SECONDARY_MINIO_SECRET_KEY",
+		"storage.secondary.minio.bucket":       "DEPGIT_STORAGE_SECONDARY_MINIO_BUCKET",
+		"storage.secondary.minio.use_ssl":      "DEPGIT_STORAGE_SECONDARY_MINIO_USE_SSL",
+		"storage.secondary.compression":        "DEPGIT_STORAGE_SECONDARY_COMPRESSION",
+		"storage.secondary.encryption_keyfile": "DEPGIT_STORAGE_SECONDARY_ENCRYPTION_KEYFILE",
+	} {
+		if err := v.BindEnv(key, env); err != nil {
+			return nil, fmt.Errorf("error binding environment variable: %w", err)
+		}
+	}
+
 	// Map old env vars to new structure for backward compatibility
 	if path := os.Getenv("DEPGIT_DB_PATH"); path != "" {
 		v.Set("db.path", path)
@@ -112,6 +288,14 @@ func (c *Configuration) GetDatabasePath() string {
 	return c.DB.Path
 }
 
+// GetDatabaseDSN returns the connection string of the configured database
+func (c *Configuration) GetDatabaseDSN() string {
+	if c.DB.DSN != "" {
+		return c.DB.DSN
+	}
+	return c.DB.Path
+}
+
 // GetMigrationsPath returns the configured migrations path
 func (c *Configuration) GetMigrationsPath() string {
 	return c.DB.MigrationsPath
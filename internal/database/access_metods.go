@@ -5,16 +5,19 @@ package database
 import (
 	"context"
 	"database/sql"
-	"path/filepath"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/GoldenDeals/DepGit/internal/config"
+	"github.com/GoldenDeals/DepGit/internal/database/dialect"
 	migrations "github.com/GoldenDeals/DepGit/internal/database/migrations"
 	"github.com/GoldenDeals/DepGit/internal/share/errors"
 	"github.com/GoldenDeals/DepGit/internal/share/logger"
+	schema "github.com/GoldenDeals/DepGit/migrations"
 	"github.com/gobwas/glob"
 	"github.com/google/uuid"
+	_ "github.com/lib/pq"           // PostgreSQL driver
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 	"github.com/sirupsen/logrus"
 )
@@ -25,6 +28,10 @@ const (
 	RSA_SHA2_512
 	SSH_RSA
 	ECDSA_SHA2_NISTP256
+	// OPENPGP_KEY is an armored OpenPGP public key used to verify signed
+	// commits and tags. It is not accepted for SSH authentication.
+	OPENPGP_KEY
+	SSH_ED25519
 	// .... TODO: Add more
 )
 
@@ -87,7 +94,12 @@ type Classes interface {
 }
 
 type DB struct {
-	db               *sql.DB
+	db *sql.DB
+	// conn runs the statements, db itself or the transaction of tx
+	conn querier
+	tx   *sql.Tx
+
+	dialect          dialect.Dialect
 	migrationManager *migrations.Manager
 	config           *config.Configuration
 }
@@ -97,44 +109,110 @@ func (d *DB) DB() *sql.DB {
 	return d.db
 }
 
+// Dialect returns the SQL dialect of the database
+func (d *DB) Dialect() dialect.Dialect {
+	return d.dialect
+}
+
 func (d *DB) Close() error {
 	return d.db.Close()
 }
 
-func (d *DB) Init(cfg *config.Configuration) error {
+// rebind adapts a query written with ? placeholders to the dialect
+func (d *DB) rebind(query string) string {
+	return d.dialect.Rebind(query)
+}
+
+// nullTime stores t in a nullable column, the zero time being NULL
+func (d *DB) nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return d.dialect.Time(t)
+}
+
+// zeroTime scans a nullable time column into t, NULL being the zero time
+type zeroTime struct {
+	t *time.Time
+}
+
+func (z zeroTime) Scan(src any) error {
+	var nt sql.NullTime
+	if err := nt.Scan(src); err != nil {
+		return err
+	}
+	*z.t = nt.Time
+	return nil
+}
+
+// OpenMigrations connects to the configured database and loads its
+// migrations without applying them, for tools managing the schema. Close
+// the returned DB when done.
+func OpenMigrations(ctx context.Context, cfg *config.Configuration) (*DB, *migrations.Manager, error) {
+	d := &DB{}
+	if err := d.open(ctx, cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := d.loadMigrations(ctx); err != nil {
+		d.Close()
+		return nil, nil, err
+	}
+	return d, d.migrationManager, nil
+}
+
+// open connects to the database and prepares the migration manager
+func (d *DB) open(ctx context.Context, cfg *config.Configuration) error {
 	var err error
 	d.config = cfg
-	d.db, err = sql.Open("sqlite3", cfg.GetDatabasePath())
+	d.dialect, err = dialect.ByName(cfg.DB.Driver)
+	if err != nil {
+		return err
+	}
+	d.db, err = sql.Open(d.dialect.Driver(), d.dialect.DSN(cfg.GetDatabaseDSN()))
 	if err != nil {
 		return err
 	}
+	d.conn = d.db
 	err = d.db.Ping()
 	if err != nil {
 		return err
 	}
 
 	// Initialize the migration manager
-	d.migrationManager = migrations.New(d.db)
+	d.migrationManager = migrations.NewWithDialect(d.db, d.dialect)
+	d.migrationManager.AllowDrift(cfg.DB.AllowMigrationDrift)
 
 	// Initialize the migrations table
-	ctx := context.Background()
-	err = d.migrationManager.Initialize(ctx)
-	if err != nil {
+	return d.migrationManager.Initialize(ctx)
+}
+
+// loadMigrations loads the migrations embedded in the binary or, if a
+// migrations path is configured, those of that directory instead
+func (d *DB) loadMigrations(ctx context.Context) error {
+	dir := d.config.GetMigrationsPath()
+	if dir == "" {
+		return d.migrationManager.LoadFromFS(ctx, schema.FS)
+	}
+
+	if err := d.migrationManager.LoadFromDir(ctx, dir); err != nil {
 		return err
 	}
+	if len(d.migrationManager.GetMigrations()) == 0 {
+		return fmt.Errorf("no migrations found in %s", dir)
+	}
+	return nil
+}
 
-	// Check if custom migrations path is configured
-	migrationsDir := d.config.DB.MigrationsPath
-	if migrationsDir == "" {
-		migrationsDir = filepath.Join(filepath.Dir(cfg.GetDatabasePath()), "migrations")
+func (d *DB) Init(cfg *config.Configuration) error {
+	ctx := context.Background()
+	err := d.open(ctx, cfg)
+	if err != nil {
+		return err
 	}
 
-	err = d.migrationManager.LoadFromDir(ctx, migrationsDir)
+	err = d.loadMigrations(ctx)
 	if err != nil {
-		dbLogger.
-			WithContext(ctx).
-			WithField("error", err).
-			Warn("Failed to load migrations")
+		return err
 	}
 
 	// Apply migrations
@@ -145,6 +223,7 @@ func (d *DB) Init(cfg *config.Configuration) error {
 
 	dbLogger.
 		WithContext(ctx).
+		WithField("driver", d.dialect.Driver()).
 		WithField("database", cfg.GetDatabasePath()).
 		Info("Database open")
 	return nil
@@ -173,17 +252,24 @@ func (d *DB) CreateUser(ctx context.Context, user *User) error {
 		return errors.ErrBadData
 	}
 
+	return d.inTx(ctx, func(tx *DB) error {
+		return tx.createUser(ctx, user)
+	})
+}
+
+// createUser inserts user unless its id is taken
+func (d *DB) createUser(ctx context.Context, user *User) error {
 	// TODO: Error check or recovery
 	userid := user.ID.String()
 	var n int
-	row := d.db.QueryRow("SELECT COUNT(id) FROM users WHERE id = ?", userid)
+	row := d.conn.QueryRow(d.rebind("SELECT COUNT(id) FROM users WHERE id = ?"), userid)
 	if err := row.Scan(&n); err != nil {
 		return err
 	}
 	if n > 0 {
 		return errors.ErrAlreadyExists
 	}
-	statement, err := d.db.Prepare("INSERT INTO users (id, name, email, created, edited, deleted) VALUES (?,?,?,?,?,?)")
+	statement, err := d.conn.Prepare(d.rebind("INSERT INTO users (id, name, email, created, edited, deleted) VALUES (?,?,?,?,?,?)"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -193,7 +279,11 @@ func (d *DB) CreateUser(ctx context.Context, user *User) error {
 
 		return err
 	}
-	_, err = statement.Exec(userid, user.Name, user.Email, time.Now().Format(time.DateTime), user.Edited.Format(time.DateTime), user.Deleted.Format(time.DateTime))
+	_, err = statement.Exec(userid, user.Name, user.Email, d.dialect.Time(time.Now()), d.nullTime(user.Edited), nil)
+	if d.dialect.UniqueViolation(err) {
+		// Created concurrently since the check above
+		return errors.ErrAlreadyExists
+	}
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -216,7 +306,7 @@ func (d *DB) EditUser(ctx context.Context, userid IDT, user *User) error {
 		return errors.ErrBadData
 	}
 
-	statement, err := d.db.Prepare("UPDATE users SET name = ? , email = ? , created = ?, edited = ?, deleted = ? WHERE id = ?")
+	statement, err := d.conn.Prepare(d.rebind("UPDATE users SET name = ? , email = ? , created = ?, edited = ? WHERE id = ? AND deleted IS NULL"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -225,7 +315,7 @@ func (d *DB) EditUser(ctx context.Context, userid IDT, user *User) error {
 			Warn("error edit user")
 		return err
 	}
-	_, err = statement.Exec(user.Name, user.Email, user.Created.Format(time.DateTime), time.Now().Format(time.DateTime), nil, userid.String())
+	_, err = statement.Exec(user.Name, user.Email, d.dialect.Time(user.Created), d.dialect.Time(time.Now()), userid.String())
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -238,6 +328,8 @@ func (d *DB) EditUser(ctx context.Context, userid IDT, user *User) error {
 	return nil
 }
 
+// DeleteUser marks a user deleted along with its keys and roles. Deleted
+// users stay restorable until they are purged, see TrashStore.
 func (d *DB) DeleteUser(ctx context.Context, userid IDT) error {
 	if err := ctx.Err(); err != nil {
 		return err
@@ -247,8 +339,24 @@ func (d *DB) DeleteUser(ctx context.Context, userid IDT) error {
 		return errors.ErrBadData
 	}
 
+	return d.inTx(ctx, func(tx *DB) error {
+		return tx.deleteUser(ctx, userid)
+	})
+}
+
+// deleteUserQueries mark a user deleted along with the rows referencing it
+var deleteUserQueries = []string{
+	"UPDATE roles SET deleted = ? WHERE user_id = ? AND deleted IS NULL",
+	"UPDATE keys SET deleted = ? WHERE user_id = ? AND deleted IS NULL",
+	"UPDATE users SET deleted = ? WHERE id = ?",
+}
+
+// deleteUser marks an existing user deleted together with its keys and
+// roles. They share the deletion time, which lets Restore bring them back
+// together.
+func (d *DB) deleteUser(ctx context.Context, userid IDT) error {
 	var n int
-	row := d.db.QueryRow("SELECT COUNT(id) FROM users WHERE id = ?", userid)
+	row := d.conn.QueryRow(d.rebind("SELECT COUNT(id) FROM users WHERE id = ? AND deleted IS NULL"), userid.String())
 	if err := row.Scan(&n); err != nil {
 		dbLogger.WithContext(ctx).WithError(err).Warn("error checking user existence")
 		return err
@@ -258,23 +366,17 @@ func (d *DB) DeleteUser(ctx context.Context, userid IDT) error {
 		return errors.ErrNotFound
 	}
 
-	statement, err := d.db.Prepare("DELETE FROM users WHERE id = ?")
-	if err != nil {
-		dbLogger.
-			WithContext(ctx).
-			WithField("user_id", userid).
-			WithError(err).
-			Warn("error delete user")
-		return err
-	}
-	_, err = statement.Exec(time.Now().Format(time.DateTime), userid.String())
-	if err != nil {
-		dbLogger.
-			WithContext(ctx).
-			WithField("user_id", userid).
-			WithError(err).
-			Warn("error delete user")
-		return err
+	now := d.dialect.Time(time.Now())
+	for _, query := range deleteUserQueries {
+		_, err := d.conn.ExecContext(ctx, d.rebind(query), now, userid.String())
+		if err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithField("user_id", userid).
+				WithError(err).
+				Warn("error delete user")
+			return err
+		}
 	}
 	logrus.Trace("Delete user", userid)
 	return nil
@@ -289,7 +391,7 @@ func (d *DB) GetUser(ctx context.Context, userid IDT) (User, error) {
 		return user, errors.ErrBadData
 	}
 	var n int
-	row := d.db.QueryRow("SELECT COUNT(id) FROM users WHERE id = ?", userid)
+	row := d.conn.QueryRow(d.rebind("SELECT COUNT(id) FROM users WHERE id = ? AND deleted IS NULL"), userid)
 	if err := row.Scan(&n); err != nil {
 		dbLogger.WithContext(ctx).WithError(err).Warn("error checking user existence")
 		return user, err
@@ -299,9 +401,9 @@ func (d *DB) GetUser(ctx context.Context, userid IDT) (User, error) {
 		return user, errors.ErrNotFound
 	}
 
-	row = d.db.QueryRow("SELECT id, name, email, created, edited, deleted FROM users WHERE id = ?", userid)
+	row = d.conn.QueryRow(d.rebind("SELECT id, name, email, created, edited, deleted FROM users WHERE id = ?"), userid)
 	var idStr string
-	err := row.Scan(&idStr, &user.Name, &user.Email, &user.Created, &user.Edited, &user.Deleted)
+	err := row.Scan(&idStr, &user.Name, &user.Email, &user.Created, zeroTime{&user.Edited}, zeroTime{&user.Deleted})
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -331,8 +433,7 @@ func (d *DB) GetUsers(ctx context.Context) ([]User, error) {
 		return users, err
 	}
 
-	// Fixed typo in SQL query (FORM -> FROM) and added WHERE clause for non-deleted users
-	rows, err := d.db.Query("SELECT id, name, email, created_at, updated_at, deleted_at FROM users WHERE deleted_at IS NULL")
+	rows, err := d.conn.Query(d.rebind("SELECT id, name, email, created, edited, deleted FROM users WHERE deleted IS NULL ORDER BY created, id"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -345,7 +446,7 @@ func (d *DB) GetUsers(ctx context.Context) ([]User, error) {
 	for rows.Next() {
 		var user User
 		var idStr string
-		err = rows.Scan(&idStr, &user.Name, &user.Email, &user.Created, &user.Edited, &user.Deleted)
+		err = rows.Scan(&idStr, &user.Name, &user.Email, &user.Created, zeroTime{&user.Edited}, zeroTime{&user.Deleted})
 		if err != nil {
 			dbLogger.
 				WithContext(ctx).
@@ -396,11 +497,18 @@ func (d *DB) AddSshKey(ctx context.Context, userid IDT, key *SshKey) error {
 	// Set the UserID to the provided userid
 	key.UserID = userid
 
-	if userid == uuid.Nil || key.ID == uuid.Nil || key.Name == "" || key.Type > 5 {
+	if userid == uuid.Nil || key.ID == uuid.Nil || key.Name == "" || key.Type > SSH_ED25519 {
 		return errors.ErrBadData
 	}
+	return d.inTx(ctx, func(tx *DB) error {
+		return tx.addSshKey(ctx, userid, key)
+	})
+}
+
+// addSshKey inserts key unless the user already has one with the same data
+func (d *DB) addSshKey(ctx context.Context, userid IDT, key *SshKey) error {
 	// Check if key already exists
-	row := d.db.QueryRow("SELECT COUNT(id) FROM keys WHERE user_id = ? AND data = ?", userid, key.Data)
+	row := d.conn.QueryRow(d.rebind("SELECT COUNT(id) FROM keys WHERE user_id = ? AND data = ? AND deleted IS NULL"), userid, key.Data)
 	var n int
 	var err error
 	err = row.Scan(&n)
@@ -412,7 +520,7 @@ func (d *DB) AddSshKey(ctx context.Context, userid IDT, key *SshKey) error {
 	if n > 0 {
 		return errors.ErrAlreadyExists
 	}
-	statement, err := d.db.Prepare("INSERT INTO keys (id, user_id, name, type, data, created, deleted) VALUES (?,?,?,?,?,?,?)")
+	statement, err := d.conn.Prepare(d.rebind("INSERT INTO keys (id, user_id, name, type, data, created, deleted) VALUES (?,?,?,?,?,?,?)"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -430,9 +538,13 @@ func (d *DB) AddSshKey(ctx context.Context, userid IDT, key *SshKey) error {
 		key.Name,
 		key.Type,
 		key.Data,
-		key.Created.Format(time.DateTime),
+		d.dialect.Time(key.Created),
 		nil) // No deletion date for new key
 
+	if d.dialect.UniqueViolation(err) {
+		// Added concurrently since the check above
+		return errors.ErrAlreadyExists
+	}
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -452,7 +564,14 @@ func (d *DB) DeleteSshKey(ctx context.Context, keyid IDT) error {
 		return err
 	}
 
-	row := d.db.QueryRow("SELECT COUNT(id) FROM  keys  WHERE id = ?", keyid)
+	return d.inTx(ctx, func(tx *DB) error {
+		return tx.deleteSshKey(ctx, keyid)
+	})
+}
+
+// deleteSshKey removes an existing key
+func (d *DB) deleteSshKey(ctx context.Context, keyid IDT) error {
+	row := d.conn.QueryRow(d.rebind("SELECT COUNT(id) FROM keys WHERE id = ? AND deleted IS NULL"), keyid)
 	var n int
 	if err := row.Scan(&n); err != nil {
 		dbLogger.
@@ -465,7 +584,7 @@ func (d *DB) DeleteSshKey(ctx context.Context, keyid IDT) error {
 	if n == 0 {
 		return errors.ErrNotFound
 	}
-	statement, err := d.db.Prepare("DELETE FROM keys WHERE id = ?")
+	statement, err := d.conn.Prepare(d.rebind("UPDATE keys SET deleted = ? WHERE id = ?"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -474,7 +593,7 @@ func (d *DB) DeleteSshKey(ctx context.Context, keyid IDT) error {
 			Warn("error delete ssh key")
 		return err
 	}
-	_, err = statement.Exec(keyid)
+	_, err = statement.Exec(d.dialect.Time(time.Now()), keyid.String())
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -494,7 +613,7 @@ func (d *DB) GetSshKeys(ctx context.Context, userID IDT) ([]SshKey, error) {
 	}
 
 	// Query SSH keys for the user that are not deleted
-	rows, err := d.db.Query("SELECT id, user_id, name, type, data, created, deleted FROM keys WHERE user_id = ? AND deleted IS NULL", userID.String())
+	rows, err := d.conn.Query(d.rebind("SELECT id, user_id, name, type, data, created, deleted FROM keys WHERE user_id = ? AND deleted IS NULL"), userID.String())
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -561,6 +680,88 @@ func (d *DB) GetSshKeys(ctx context.Context, userID IDT) ([]SshKey, error) {
 	return keys, nil
 }
 
+// GetKeysByType returns all non-deleted keys of the given types across all
+// users. It is used to resolve signature keys without knowing the signer.
+func (d *DB) GetKeysByType(ctx context.Context, types ...SSH_KEY_TYPE) ([]SshKey, error) {
+	keys := make([]SshKey, 0, 16)
+	if err := ctx.Err(); err != nil {
+		return keys, err
+	}
+	if len(types) == 0 {
+		return keys, errors.ErrBadData
+	}
+
+	args := make([]interface{}, 0, len(types))
+	for _, t := range types {
+		args = append(args, t)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(types)), ",")
+
+	rows, err := d.conn.QueryContext(ctx, d.rebind("SELECT id, user_id, name, type, data, created, deleted FROM keys WHERE type IN ("+placeholders+") AND deleted IS NULL"), args...)
+	if err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithError(err).
+			Error("Error querying keys by type")
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key SshKey
+		var id, userID string
+		var createdAt, deletedAt sql.NullTime
+
+		err = rows.Scan(&id, &userID, &key.Name, &key.Type, &key.Data, &createdAt, &deletedAt)
+		if err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithError(err).
+				Warn("error scanning key row")
+			return keys, err
+		}
+
+		key.ID, err = uuid.Parse(id)
+		if err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithError(err).
+				WithField("key_id", id).
+				Error("Error parsing key ID")
+			return keys, err
+		}
+
+		key.UserID, err = uuid.Parse(userID)
+		if err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithError(err).
+				WithField("user_id", userID).
+				Error("Error parsing key user ID")
+			return keys, err
+		}
+
+		if createdAt.Valid {
+			key.Created = createdAt.Time
+		}
+		if deletedAt.Valid {
+			key.Deleted = deletedAt.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithError(err).
+			Warn("error iterating key rows")
+		return keys, err
+	}
+
+	return keys, nil
+}
+
 func NewRepo(name string) Repo {
 	return Repo{
 		ID:   uuid.New(),
@@ -575,7 +776,7 @@ func (d *DB) CreateRepo(ctx context.Context, repo *Repo) error {
 	if repo.ID == uuid.Nil || repo.Name == "" {
 		return errors.ErrBadData
 	}
-	statement, err := d.db.Prepare("INSERT INTO permitions (id, name, created, edited, deleted) VALUES (?, ?, ?, ?, ?)")
+	statement, err := d.conn.Prepare(d.rebind("INSERT INTO permitions (id, name, created, edited, deleted) VALUES (?, ?, ?, ?, ?)"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -590,9 +791,9 @@ func (d *DB) CreateRepo(ctx context.Context, repo *Repo) error {
 	_, err = statement.Exec(
 		repo.ID.String(),
 		repo.Name,
-		time.Now().Format(time.DateTime),
-		repo.Edited.Format(time.DateTime),
-		repo.Deleted.Format(time.DateTime),
+		d.dialect.Time(time.Now()),
+		d.nullTime(repo.Edited),
+		nil,
 	)
 	if err != nil {
 		dbLogger.
@@ -606,6 +807,9 @@ func (d *DB) CreateRepo(ctx context.Context, repo *Repo) error {
 	return nil
 }
 
+// DeleteRepo marks a repository deleted along with its roles. Like
+// DeleteUser, they stay restorable until purged. Deleting a missing
+// repository is not an error.
 func (d *DB) DeleteRepo(ctx context.Context, repoid IDT) error {
 	if err := ctx.Err(); err != nil {
 		return err
@@ -613,25 +817,31 @@ func (d *DB) DeleteRepo(ctx context.Context, repoid IDT) error {
 	if repoid == uuid.Nil {
 		return errors.ErrBadData
 	}
-	statement, err := d.db.Prepare("DELETE FROM permitions WHERE id = ?")
-	if err != nil {
-		dbLogger.
-			WithContext(ctx).
-			WithField("repoid", repoid).
-			WithError(err).
-			Warn("error delete repo")
-		return err
-	}
-	_, err = statement.Exec(repoid)
-	if err != nil {
-		dbLogger.
-			WithContext(ctx).
-			WithField("repoid", repoid).
-			WithError(err).
-			Warn("error delete repo")
-		return err
+	return d.inTx(ctx, func(tx *DB) error {
+		return tx.deleteRepo(ctx, repoid)
+	})
+}
+
+// deleteRepoQueries mark a repository deleted along with its roles
+var deleteRepoQueries = []string{
+	"UPDATE roles SET deleted = ? WHERE rep_id = ? AND deleted IS NULL",
+	"UPDATE permitions SET deleted = ? WHERE id = ? AND deleted IS NULL",
+}
+
+func (d *DB) deleteRepo(ctx context.Context, repoid IDT) error {
+	now := d.dialect.Time(time.Now())
+	for _, query := range deleteRepoQueries {
+		_, err := d.conn.ExecContext(ctx, d.rebind(query), now, repoid.String())
+		if err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithField("repoid", repoid).
+				WithError(err).
+				Warn("error delete repo")
+			return err
+		}
 	}
-	logrus.Trace("Deleted.Format(time.DateTime) Repositor", repoid)
+	logrus.Trace("Delete Repository", repoid)
 	return nil
 }
 
@@ -644,7 +854,7 @@ func (d *DB) UpdateRepo(ctx context.Context, repoid IDT, repo *Repo) error {
 		return errors.ErrBadData
 	}
 
-	statement, err := d.db.Prepare("UPDATE permitions SET name = ? , created = ?, deleted = ? WHERE id = ?")
+	statement, err := d.conn.Prepare(d.rebind("UPDATE permitions SET name = ? , created = ?, edited = ? WHERE id = ? AND deleted IS NULL"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -653,7 +863,7 @@ func (d *DB) UpdateRepo(ctx context.Context, repoid IDT, repo *Repo) error {
 			Warn("error update repo")
 		return err
 	}
-	_, err = statement.Exec(repo.Name, repo.Created.Format(time.DateTime), repo.Deleted.Format(time.DateTime), repoid.String())
+	_, err = statement.Exec(repo.Name, d.dialect.Time(repo.Created), d.dialect.Time(time.Now()), repoid.String())
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -676,7 +886,7 @@ func (d *DB) GetRepo(ctx context.Context, repoid IDT) (Repo, error) {
 	}
 
 	var n int
-	row := d.db.QueryRow("SELECT COUNT(id) FROM permitions WHERE id = ?", repoid)
+	row := d.conn.QueryRow(d.rebind("SELECT COUNT(id) FROM permitions WHERE id = ? AND deleted IS NULL"), repoid)
 	err := row.Scan(&n)
 	if err != nil {
 		dbLogger.WithContext(ctx).WithError(err).Warn("error checking repo existence")
@@ -687,9 +897,9 @@ func (d *DB) GetRepo(ctx context.Context, repoid IDT) (Repo, error) {
 		return repo, errors.ErrNotFound
 	}
 
-	row = d.db.QueryRow("SELECT id, name, created, edited, deleted FROM permitions WHERE id = ?", repoid)
+	row = d.conn.QueryRow(d.rebind("SELECT id, name, created, edited, deleted FROM permitions WHERE id = ?"), repoid)
 	var idStr string
-	err = row.Scan(&idStr, &repo.Name, &repo.Created, &repo.Edited, &repo.Deleted)
+	err = row.Scan(&idStr, &repo.Name, &repo.Created, zeroTime{&repo.Edited}, zeroTime{&repo.Deleted})
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -719,7 +929,7 @@ func (d *DB) GetRepos(ctx context.Context) ([]Repo, error) {
 		return repos, err
 	}
 
-	rows, err := d.db.Query("SELECT id, name, created, edited, deleted FROM permitions")
+	rows, err := d.conn.Query(d.rebind("SELECT id, name, created, edited, deleted FROM permitions WHERE deleted IS NULL ORDER BY created, id"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -732,7 +942,7 @@ func (d *DB) GetRepos(ctx context.Context) ([]Repo, error) {
 	for rows.Next() {
 		var repo Repo
 		var idStr string
-		err = rows.Scan(&idStr, &repo.Name, &repo.Created, &repo.Edited, &repo.Deleted)
+		err = rows.Scan(&idStr, &repo.Name, &repo.Created, zeroTime{&repo.Edited}, zeroTime{&repo.Deleted})
 		if err != nil {
 			dbLogger.
 				WithContext(ctx).
@@ -773,7 +983,7 @@ func (d *DB) CreateAccessRole(ctx context.Context, ar *AccessRole) error {
 	if ar.UserID == uuid.Nil || ar.RepoID == uuid.Nil || ar.RoleID == uuid.Nil {
 		return errors.ErrBadData
 	}
-	statement, err := d.db.Prepare("INSERT INTO roles (role_id, user_id, rep_id, branch, created, deleted) VALUES (?, ?, ?, ?, ?, ?)")
+	statement, err := d.conn.Prepare(d.rebind("INSERT INTO roles (role_id, user_id, rep_id, branch, created, deleted) VALUES (?, ?, ?, ?, ?, ?)"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -790,8 +1000,8 @@ func (d *DB) CreateAccessRole(ctx context.Context, ar *AccessRole) error {
 		ar.UserID.String(),
 		ar.RepoID.String(),
 		ar.Branches,
-		ar.Created.Format(time.DateTime),
-		ar.Deleted.Format(time.DateTime),
+		d.dialect.Time(ar.Created),
+		nil,
 	)
 	if err != nil {
 		dbLogger.
@@ -812,7 +1022,7 @@ func (d *DB) EditAccessRole(ctx context.Context, roleid IDT, ar *AccessRole) err
 	if roleid == uuid.Nil || ar.UserID == uuid.Nil || ar.RepoID == uuid.Nil || ar.RoleID == uuid.Nil {
 		return errors.ErrBadData
 	}
-	statement, err := d.db.Prepare("UPDATE roles SET user_id = ?, rep_id = ?, branch = ?, created = ?, deleted = ? WHERE role_id = ?")
+	statement, err := d.conn.Prepare(d.rebind("UPDATE roles SET user_id = ?, rep_id = ?, branch = ?, created = ? WHERE role_id = ? AND deleted IS NULL"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -821,7 +1031,7 @@ func (d *DB) EditAccessRole(ctx context.Context, roleid IDT, ar *AccessRole) err
 			Warn("error edit role")
 		return err
 	}
-	_, err = statement.Exec(ar.UserID.String(), ar.RepoID.String(), ar.Branches, ar.Created.Format(time.DateTime), ar.Deleted.Format(time.DateTime), ar.RoleID.String())
+	_, err = statement.Exec(ar.UserID.String(), ar.RepoID.String(), ar.Branches, d.dialect.Time(ar.Created), ar.RoleID.String())
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -841,7 +1051,7 @@ func (d *DB) DeleteAccessRole(ctx context.Context, roleid IDT) error {
 	if roleid == uuid.Nil {
 		return errors.ErrBadData
 	}
-	statement, err := d.db.Prepare("DELETE FROM roles WHERE role_id = ?")
+	statement, err := d.conn.Prepare(d.rebind("UPDATE roles SET deleted = ? WHERE role_id = ? AND deleted IS NULL"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -850,7 +1060,7 @@ func (d *DB) DeleteAccessRole(ctx context.Context, roleid IDT) error {
 			Warn("error deleted role")
 		return err
 	}
-	_, err = statement.Exec(roleid)
+	_, err = statement.Exec(d.dialect.Time(time.Now()), roleid.String())
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -873,7 +1083,7 @@ func (d *DB) GetAccessRole(ctx context.Context, roleid IDT) (AccessRole, error)
 	}
 
 	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM roles WHERE role_id = ?", roleid.String()).Scan(&count)
+	err := d.conn.QueryRow(d.rebind("SELECT COUNT(*) FROM roles WHERE role_id = ? AND deleted IS NULL"), roleid.String()).Scan(&count)
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -891,10 +1101,10 @@ func (d *DB) GetAccessRole(ctx context.Context, roleid IDT) (AccessRole, error)
 	var roleIDStr, userIDStr, repoIDStr string
 
 	// Query the role
-	row := d.db.QueryRow("SELECT role_id, user_id, rep_id, branch, created, deleted FROM roles WHERE role_id = ?", roleid.String())
+	row := d.conn.QueryRow(d.rebind("SELECT role_id, user_id, rep_id, branch, created, deleted FROM roles WHERE role_id = ?"), roleid.String())
 
 	// Scan the row into variables
-	err = row.Scan(&roleIDStr, &userIDStr, &repoIDStr, &role.Branches, &role.Created, &role.Deleted)
+	err = row.Scan(&roleIDStr, &userIDStr, &repoIDStr, &role.Branches, &role.Created, zeroTime{&role.Deleted})
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -950,7 +1160,7 @@ func (d *DB) GetAccessRoles(ctx context.Context) ([]AccessRole, error) {
 		return roles, err
 	}
 
-	rows, err := d.db.Query("SELECT role_id, user_id, rep_id, branch, created, deleted FROM roles")
+	rows, err := d.conn.Query(d.rebind("SELECT role_id, user_id, rep_id, branch, created, deleted FROM roles WHERE deleted IS NULL ORDER BY created, role_id"))
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -964,7 +1174,7 @@ func (d *DB) GetAccessRoles(ctx context.Context) ([]AccessRole, error) {
 		var role AccessRole
 		var roleIDStr, userIDStr, repoIDStr string
 
-		err = rows.Scan(&roleIDStr, &userIDStr, &repoIDStr, &role.Branches, &role.Created, &role.Deleted)
+		err = rows.Scan(&roleIDStr, &userIDStr, &repoIDStr, &role.Branches, &role.Created, zeroTime{&role.Deleted})
 		if err != nil {
 			dbLogger.
 				WithContext(ctx).
@@ -1028,9 +1238,12 @@ func (d *DB) UserByKey(ctx context.Context, key []byte) (User, error) {
 	if key == nil {
 		return user, errors.ErrBadData
 	}
-	row := d.db.QueryRow("SELECT userid.keys, users.name, users.email , users.created, users.edited, users.deleted FROM keys INNER JOIN users ON users.userid = keys.userid", key) // что делает и что выводит ?
-	var answer string
-	err = row.Scan(&answer, &user.Name, &user.Email, &user.Created, &user.Edited, &user.Deleted)
+	row := d.conn.QueryRow(d.rebind("SELECT users.id, users.name, users.email, users.created, users.edited, users.deleted FROM keys INNER JOIN users ON users.id = keys.user_id WHERE keys.data = ? AND keys.deleted IS NULL AND users.deleted IS NULL"), key)
+	var idStr string
+	err = row.Scan(&idStr, &user.Name, &user.Email, &user.Created, zeroTime{&user.Edited}, zeroTime{&user.Deleted})
+	if err == sql.ErrNoRows {
+		return user, errors.ErrNotFound
+	}
 	if err != nil {
 		dbLogger.
 			WithContext(ctx).
@@ -1039,6 +1252,15 @@ func (d *DB) UserByKey(ctx context.Context, key []byte) (User, error) {
 			Warn("error get user by key")
 		return user, err
 	}
+	user.ID, err = uuid.Parse(idStr)
+	if err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("user_id", idStr).
+			WithError(err).
+			Warn("error parsing user id")
+		return user, err
+	}
 	logrus.Trace("get info user keys ", user, key)
 	return user, nil
 }
@@ -1052,7 +1274,7 @@ func (d *DB) CheckPermissions(ctx context.Context, userid, repoid IDT, branch st
 	}
 
 	// No branch matching - just check if the user has any role for the repo
-	query := "SELECT COUNT(*) FROM roles WHERE user_id = ? AND rep_id = ?"
+	query := "SELECT COUNT(*) FROM roles WHERE user_id = ? AND rep_id = ? AND deleted IS NULL"
 	args := []interface{}{userid.String(), repoid.String()}
 
 	// Add branch condition if provided
@@ -1062,7 +1284,7 @@ func (d *DB) CheckPermissions(ctx context.Context, userid, repoid IDT, branch st
 	}
 
 	var count int
-	err := d.db.QueryRow(query, args...).Scan(&count)
+	err := d.conn.QueryRow(d.rebind(query), args...).Scan(&count)
 
 	if err != nil {
 		dbLogger.
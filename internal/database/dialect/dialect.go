@@ -0,0 +1,205 @@
+// Package dialect hides the differences between the SQL databases DepGit
+// can run on. Queries are written once with ? placeholders, as SQLite
+// expects them, and rebound for the database in use.
+package dialect
+
+import (
+	stderrors "errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect describes the SQL flavour of a database.
+type Dialect interface {
+	// Name identifies the dialect in configuration and in the names of
+	// migration files written for it alone.
+	Name() string
+
+	// Driver is the database/sql driver name.
+	Driver() string
+
+	// DSN completes a configured connection string with the options DepGit
+	// relies on, unless it sets them itself.
+	DSN(dsn string) string
+
+	// UniqueViolation reports whether err is the violation of a primary
+	// key or unique index.
+	UniqueViolation(err error) bool
+
+	// Rebind rewrites the ? placeholders of query into the dialect's own.
+	Rebind(query string) string
+
+	// Time converts t into the value stored in a timestamp column.
+	Time(t time.Time) any
+
+	// Upsert returns a statement inserting a row into table that updates
+	// the columns of an existing row with the same keys instead. Its
+	// placeholders take the keys, then the columns.
+	Upsert(table string, keys []string, columns ...string) string
+
+	// SerialKey is the definition of an auto-incremented integer primary
+	// key column.
+	SerialKey() string
+}
+
+var (
+	// SQLite is the default, file-based dialect.
+	SQLite Dialect = sqlite{}
+
+	// Postgres is the dialect of PostgreSQL.
+	Postgres Dialect = postgres{}
+)
+
+// Dialects lists every supported dialect.
+var Dialects = []Dialect{SQLite, Postgres}
+
+// ByName returns the dialect configured as name. The empty name selects
+// SQLite, driver names are accepted as well.
+func ByName(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "", "sqlite", "sqlite3":
+		return SQLite, nil
+	case "postgres", "postgresql", "pq":
+		return Postgres, nil
+	}
+	return nil, errors.ErrNotSupported.Msg("unknown database driver " + name)
+}
+
+type sqlite struct{}
+
+func (sqlite) Name() string   { return "sqlite" }
+func (sqlite) Driver() string { return "sqlite3" }
+
+// DSN makes transactions take the write lock when they begin, so those
+// reading before writing cannot deadlock, and waits for locks held by
+// other connections instead of failing with SQLITE_BUSY
+func (sqlite) DSN(dsn string) string {
+	var options []string
+	if !strings.Contains(dsn, "_txlock=") {
+		options = append(options, "_txlock=immediate")
+	}
+	if !strings.Contains(dsn, "_busy_timeout=") && !strings.Contains(dsn, "_timeout=") {
+		options = append(options, "_busy_timeout=5000")
+	}
+	if len(options) == 0 {
+		return dsn
+	}
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + strings.Join(options, "&")
+}
+
+func (sqlite) UniqueViolation(err error) bool {
+	var serr sqlite3.Error
+	return stderrors.As(err, &serr) &&
+		(serr.ExtendedCode == sqlite3.ErrConstraintUnique || serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+func (sqlite) Rebind(query string) string {
+	return query
+}
+
+// Time keeps the format timestamps were always stored in, in UTC like
+// CURRENT_TIMESTAMP
+func (sqlite) Time(t time.Time) any {
+	return t.UTC().Format(time.DateTime)
+}
+
+func (sqlite) Upsert(table string, keys []string, columns ...string) string {
+	return upsert(table, keys, columns)
+}
+
+func (sqlite) SerialKey() string {
+	return "INTEGER PRIMARY KEY AUTOINCREMENT"
+}
+
+type postgres struct{}
+
+func (postgres) Name() string   { return "postgres" }
+func (postgres) Driver() string { return "postgres" }
+
+func (postgres) DSN(dsn string) string {
+	return dsn
+}
+
+// uniqueViolation is the SQLSTATE of unique_violation
+const uniqueViolation = "23505"
+
+func (postgres) UniqueViolation(err error) bool {
+	var perr *pq.Error
+	return stderrors.As(err, &perr) && perr.Code == uniqueViolation
+}
+
+// Rebind numbers the placeholders $1, $2, ... Question marks in quoted
+// strings and identifiers are left alone.
+func (postgres) Rebind(query string) string {
+	var (
+		b     strings.Builder
+		n     int
+		quote rune
+	)
+	b.Grow(len(query) + 8)
+
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func (postgres) Time(t time.Time) any {
+	return t.UTC()
+}
+
+func (postgres) Upsert(table string, keys []string, columns ...string) string {
+	return postgres{}.Rebind(upsert(table, keys, columns))
+}
+
+func (postgres) SerialKey() string {
+	return "SERIAL PRIMARY KEY"
+}
+
+// upsert builds the ON CONFLICT clause both SQLite and PostgreSQL support
+func upsert(table string, keys, columns []string) string {
+	all := append(append([]string{}, keys...), columns...)
+
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + table + " (" + strings.Join(all, ", ") + ") VALUES (")
+	b.WriteString(strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", "))
+	b.WriteString(") ON CONFLICT (" + strings.Join(keys, ", ") + ") DO ")
+
+	if len(columns) == 0 {
+		b.WriteString("NOTHING")
+		return b.String()
+	}
+
+	b.WriteString("UPDATE SET ")
+	for i, c := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(c + " = excluded." + c)
+	}
+	return b.String()
+}
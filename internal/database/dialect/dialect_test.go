@@ -0,0 +1,57 @@
+package dialect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByName(t *testing.T) {
+	for name, want := range map[string]Dialect{
+		"":           SQLite,
+		"sqlite3":    SQLite,
+		"postgres":   Postgres,
+		"PostgreSQL": Postgres,
+	} {
+		d, err := ByName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, d, name)
+	}
+
+	_, err := ByName("oracle")
+	assert.Error(t, err)
+}
+
+func TestRebind(t *testing.T) {
+	query := "SELECT id FROM users WHERE name = ? AND email = '?' AND \"what?\" = ? OR id IN (?,?)"
+
+	assert.Equal(t, query, SQLite.Rebind(query))
+	assert.Equal(t,
+		"SELECT id FROM users WHERE name = $1 AND email = '?' AND \"what?\" = $2 OR id IN ($3,$4)",
+		Postgres.Rebind(query))
+}
+
+func TestUpsert(t *testing.T) {
+	assert.Equal(t,
+		"INSERT INTO roles (user_id, rep_id, branch) VALUES (?, ?, ?) ON CONFLICT (user_id, rep_id) DO UPDATE SET branch = excluded.branch",
+		SQLite.Upsert("roles", []string{"user_id", "rep_id"}, "branch"))
+	assert.Equal(t,
+		"INSERT INTO users (id) VALUES ($1) ON CONFLICT (id) DO NOTHING",
+		Postgres.Upsert("users", []string{"id"}))
+}
+
+func TestTime(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 30, 0, 0, time.FixedZone("X", 3600))
+
+	assert.Equal(t, "2025-03-01 11:30:00", SQLite.Time(at))
+	assert.Equal(t, at.UTC(), Postgres.Time(at))
+}
+
+func TestDSN(t *testing.T) {
+	assert.Equal(t, "data/depgit.db?_txlock=immediate&_busy_timeout=5000", SQLite.DSN("data/depgit.db"))
+	assert.Equal(t, "file:depgit.db?cache=shared&_txlock=immediate&_busy_timeout=5000", SQLite.DSN("file:depgit.db?cache=shared"))
+	assert.Equal(t, "depgit.db?_txlock=deferred&_timeout=100", SQLite.DSN("depgit.db?_txlock=deferred&_timeout=100"))
+	assert.Equal(t, "postgres://localhost/depgit", Postgres.DSN("postgres://localhost/depgit"))
+}
@@ -0,0 +1,390 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/google/uuid"
+)
+
+// Bounds of ListOptions.Limit
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// ListOptions selects a page of a listing.
+type ListOptions struct {
+	// Limit caps the number of items, DefaultPageSize if not set. Larger
+	// limits than MaxPageSize are rejected.
+	Limit int
+
+	// Cursor continues a listing from a previous Page.NextCursor. It is only
+	// valid with the Filter and Sort it was returned for.
+	Cursor string
+
+	// Filter keeps the items whose name, or email for users, contains it,
+	// ignoring case.
+	Filter string
+
+	// Sort names the field to order by, descending if prefixed with "-".
+	// Which fields are supported depends on the listing; by default items
+	// are in the order they were created.
+	Sort string
+}
+
+// Page is one page of a listing.
+type Page[T any] struct {
+	Items []T
+
+	// NextCursor is empty on the last page. It is opaque to callers.
+	NextCursor string
+}
+
+// sortKeyLayout formats times in sort keys, so that they order like the
+// times do
+const sortKeyLayout = "2006-01-02T15:04:05.000000000Z"
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format(sortKeyLayout)
+}
+
+// sortField is a field a listing can be sorted by
+type sortField struct {
+	column string
+	// time marks columns holding times
+	time bool
+}
+
+// listing describes how a table is listed
+type listing struct {
+	columns string
+	table   string
+	id      string
+
+	// where holds conditions every listed row meets, with their args
+	where []string
+	args  []any
+
+	// filter are the columns matched by ListOptions.Filter
+	filter []string
+
+	sorts       map[string]sortField
+	defaultSort string
+}
+
+// listCursor is the decoded form of Page.NextCursor: the sort key and id
+// of the last item of the page, and the listing it belongs to
+type listCursor struct {
+	Sort   string `json:"s"`
+	Filter string `json:"f,omitempty"`
+	Key    string `json:"k"`
+	ID     IDT    `json:"i"`
+}
+
+func encodeCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, opts ListOptions, sort string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errors.ErrBadData
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, errors.ErrBadData
+	}
+	if c.Sort != sort || c.Filter != opts.Filter || c.ID == uuid.Nil {
+		return c, errors.ErrBadData
+	}
+	return c, nil
+}
+
+// parseSort returns the sort field named by s and whether it descends
+func parseSort[F any](s string, fields map[string]F) (string, bool, error) {
+	name, desc := strings.CutPrefix(s, "-")
+	if _, ok := fields[name]; !ok {
+		return "", false, errors.ErrBadData
+	}
+	return name, desc, nil
+}
+
+// pageLimit validates the requested page size
+func pageLimit(opts ListOptions) (int, error) {
+	switch {
+	case opts.Limit == 0:
+		return DefaultPageSize, nil
+	case opts.Limit < 0 || opts.Limit > MaxPageSize:
+		return 0, errors.ErrBadData
+	}
+	return opts.Limit, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, using \ as escape
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// listPage runs a listing. scan reads a row, key returns the sort key of
+// an item for a field and its id.
+func listPage[T any](ctx context.Context, d *DB, l listing, opts ListOptions, scan func(*sql.Rows) (T, error), key func(T, string) (string, IDT)) (Page[T], error) {
+	page := Page[T]{Items: make([]T, 0, 16)}
+	if err := ctx.Err(); err != nil {
+		return page, err
+	}
+
+	limit, err := pageLimit(opts)
+	if err != nil {
+		return page, err
+	}
+	sort := opts.Sort
+	if sort == "" {
+		sort = l.defaultSort
+	}
+	name, desc, err := parseSort(sort, l.sorts)
+	if err != nil {
+		return page, err
+	}
+	field := l.sorts[name]
+
+	where := append([]string{}, l.where...)
+	args := append([]any{}, l.args...)
+
+	if opts.Filter != "" && len(l.filter) > 0 {
+		pattern := "%" + escapeLike(strings.ToLower(opts.Filter)) + "%"
+		conds := make([]string, 0, len(l.filter))
+		for _, column := range l.filter {
+			conds = append(conds, "LOWER("+column+`) LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		}
+		where = append(where, "("+strings.Join(conds, " OR ")+")")
+	}
+
+	order, cmp := "ASC", ">"
+	if desc {
+		order, cmp = "DESC", "<"
+	}
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor, opts, sort)
+		if err != nil {
+			return page, err
+		}
+		var value any = c.Key
+		if field.time {
+			t, err := time.Parse(sortKeyLayout, c.Key)
+			if err != nil {
+				return page, errors.ErrBadData
+			}
+			value = d.dialect.Time(t)
+		}
+		where = append(where, "("+field.column+" "+cmp+" ? OR ("+field.column+" = ? AND "+l.id+" "+cmp+" ?))")
+		args = append(args, value, value, c.ID.String())
+	}
+
+	query := "SELECT " + l.columns + " FROM " + l.table
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + field.column + " " + order + ", " + l.id + " " + order + " LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := d.conn.QueryContext(ctx, d.rebind(query), args...)
+	if err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("table", l.table).
+			WithError(err).
+			Warn("error listing rows")
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithField("table", l.table).
+				WithError(err).
+				Warn("error scanning row")
+			return page, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("table", l.table).
+			WithError(err).
+			Warn("error iterating rows")
+		return page, err
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		k, id := key(page.Items[limit-1], name)
+		page.NextCursor = encodeCursor(listCursor{Sort: sort, Filter: opts.Filter, Key: k, ID: id})
+	}
+	return page, nil
+}
+
+// scanID parses the id scanned into s
+func scanID(s string, id *IDT) error {
+	parsed, err := uuid.Parse(s)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+var userListing = listing{
+	columns: "id, name, email, created, edited, deleted",
+	table:   "users",
+	id:      "id",
+	where:   []string{"deleted IS NULL"},
+	filter:  []string{"name", "email"},
+	sorts: map[string]sortField{
+		"name":    {column: "name"},
+		"email":   {column: "email"},
+		"created": {column: "created", time: true},
+	},
+	defaultSort: "created",
+}
+
+func userKey(user User, field string) (string, IDT) {
+	switch field {
+	case "name":
+		return user.Name, user.ID
+	case "email":
+		return user.Email, user.ID
+	}
+	return timeKey(user.Created), user.ID
+}
+
+// ListUsers returns a page of the users. They are filtered by name and
+// email and sorted by "name", "email" or "created".
+func (d *DB) ListUsers(ctx context.Context, opts ListOptions) (Page[User], error) {
+	return listPage(ctx, d, userListing, opts, func(rows *sql.Rows) (User, error) {
+		var user User
+		var idStr string
+		if err := rows.Scan(&idStr, &user.Name, &user.Email, &user.Created, zeroTime{&user.Edited}, zeroTime{&user.Deleted}); err != nil {
+			return user, err
+		}
+		return user, scanID(idStr, &user.ID)
+	}, userKey)
+}
+
+func keyKey(key SshKey, field string) (string, IDT) {
+	if field == "name" {
+		return key.Name, key.ID
+	}
+	return timeKey(key.Created), key.ID
+}
+
+// ListSshKeys returns a page of the keys of a user. They are filtered by
+// name and sorted by "name" or "created".
+func (d *DB) ListSshKeys(ctx context.Context, userID IDT, opts ListOptions) (Page[SshKey], error) {
+	l := listing{
+		columns: "id, user_id, name, type, data, created, deleted",
+		table:   "keys",
+		id:      "id",
+		where:   []string{"user_id = ?", "deleted IS NULL"},
+		args:    []any{userID.String()},
+		filter:  []string{"name"},
+		sorts: map[string]sortField{
+			"name":    {column: "name"},
+			"created": {column: "created", time: true},
+		},
+		defaultSort: "created",
+	}
+	return listPage(ctx, d, l, opts, func(rows *sql.Rows) (SshKey, error) {
+		var key SshKey
+		var idStr, userIDStr string
+		if err := rows.Scan(&idStr, &userIDStr, &key.Name, &key.Type, &key.Data, &key.Created, zeroTime{&key.Deleted}); err != nil {
+			return key, err
+		}
+		if err := scanID(idStr, &key.ID); err != nil {
+			return key, err
+		}
+		return key, scanID(userIDStr, &key.UserID)
+	}, keyKey)
+}
+
+var repoListing = listing{
+	columns: "id, name, created, edited, deleted",
+	table:   "permitions",
+	id:      "id",
+	where:   []string{"deleted IS NULL"},
+	filter:  []string{"name"},
+	sorts: map[string]sortField{
+		"name":    {column: "name"},
+		"created": {column: "created", time: true},
+	},
+	defaultSort: "created",
+}
+
+func repoKey(repo Repo, field string) (string, IDT) {
+	if field == "name" {
+		return repo.Name, repo.ID
+	}
+	return timeKey(repo.Created), repo.ID
+}
+
+// ListRepos returns a page of the repositories. They are filtered by name
+// and sorted by "name" or "created".
+func (d *DB) ListRepos(ctx context.Context, opts ListOptions) (Page[Repo], error) {
+	return listPage(ctx, d, repoListing, opts, func(rows *sql.Rows) (Repo, error) {
+		var repo Repo
+		var idStr string
+		if err := rows.Scan(&idStr, &repo.Name, &repo.Created, zeroTime{&repo.Edited}, zeroTime{&repo.Deleted}); err != nil {
+			return repo, err
+		}
+		return repo, scanID(idStr, &repo.ID)
+	}, repoKey)
+}
+
+func roleKey(role AccessRole, _ string) (string, IDT) {
+	return timeKey(role.Created), role.RoleID
+}
+
+// ListAccessRoles returns a page of the roles on a repository, or on every
+// repository if repoID is uuid.Nil. Roles have no name, so they are not
+// filtered, and only sorted by "created".
+func (d *DB) ListAccessRoles(ctx context.Context, repoID IDT, opts ListOptions) (Page[AccessRole], error) {
+	l := listing{
+		columns: "role_id, user_id, rep_id, branch, created, deleted",
+		table:   "roles",
+		id:      "role_id",
+		where:   []string{"deleted IS NULL"},
+		sorts: map[string]sortField{
+			"created": {column: "created", time: true},
+		},
+		defaultSort: "created",
+	}
+	if repoID != uuid.Nil {
+		l.where = append(l.where, "rep_id = ?")
+		l.args = append(l.args, repoID.String())
+	}
+	return listPage(ctx, d, l, opts, func(rows *sql.Rows) (AccessRole, error) {
+		var role AccessRole
+		var roleIDStr, userIDStr, repoIDStr string
+		if err := rows.Scan(&roleIDStr, &userIDStr, &repoIDStr, &role.Branches, &role.Created, zeroTime{&role.Deleted}); err != nil {
+			return role, err
+		}
+		if err := scanID(roleIDStr, &role.RoleID); err != nil {
+			return role, err
+		}
+		if err := scanID(userIDStr, &role.UserID); err != nil {
+			return role, err
+		}
+		return role, scanID(repoIDStr, &role.RepoID)
+	}, roleKey)
+}
@@ -0,0 +1,190 @@
+package database_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	. "github.com/GoldenDeals/DepGit/internal/database"
+	dberror "github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/google/uuid"
+	ase "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listAll follows the cursors of a listing and returns the names of the
+// items, page by page
+func listAll[T any](t *testing.T, list func(ListOptions) (Page[T], error), opts ListOptions, name func(T) string) [][]string {
+	var pages [][]string
+	for {
+		page, err := list(opts)
+		require.NoError(t, err)
+
+		names := make([]string, 0, len(page.Items))
+		for _, item := range page.Items {
+			names = append(names, name(item))
+		}
+		pages = append(pages, names)
+
+		if page.NextCursor == "" {
+			return pages
+		}
+		require.Less(t, len(pages), 100, "The listing does not end")
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		// Mostly created within the same second, so ties are broken by id
+		for _, name := range []string{"dave", "alice", "carol", "bob", "erin"} {
+			user := NewUser(name, name+"@example.com")
+			require.NoError(t, db.CreateUser(ctx, &user))
+		}
+		deleted := NewUser("mallory", "mallory@example.com")
+		require.NoError(t, db.CreateUser(ctx, &deleted))
+		require.NoError(t, db.DeleteUser(ctx, deleted.ID))
+
+		list := func(opts ListOptions) (Page[User], error) { return db.ListUsers(ctx, opts) }
+		name := func(u User) string { return u.Name }
+
+		t.Run("Pages through every user once", func(t *testing.T) {
+			pages := listAll(t, list, ListOptions{Limit: 2}, name)
+			assert.Len(pages, 3)
+			assert.ElementsMatch([]string{"alice", "bob", "carol", "dave", "erin"}, slices.Concat(pages...))
+
+			all, err := db.GetUsers(ctx)
+			assert.Nil(err)
+			var created []string
+			for _, u := range all {
+				created = append(created, u.Name)
+			}
+			assert.Equal(created, slices.Concat(pages...), "By default users are in creation order")
+		})
+
+		t.Run("Sorts", func(t *testing.T) {
+			assert.Equal([][]string{{"alice", "bob"}, {"carol", "dave"}, {"erin"}}, listAll(t, list, ListOptions{Limit: 2, Sort: "name"}, name))
+			assert.Equal([][]string{{"erin", "dave", "carol"}, {"bob", "alice"}}, listAll(t, list, ListOptions{Limit: 3, Sort: "-email"}, name))
+		})
+
+		t.Run("Filters by name and email", func(t *testing.T) {
+			assert.Equal([][]string{{"carol"}}, listAll(t, list, ListOptions{Filter: "AR"}, name))
+			assert.Equal([][]string{{"bob"}}, listAll(t, list, ListOptions{Filter: "bob@"}, name))
+			assert.Equal([][]string{{}}, listAll(t, list, ListOptions{Filter: "%"}, name), "Wildcards are matched literally")
+		})
+
+		t.Run("Rejects bad options", func(t *testing.T) {
+			page, err := list(ListOptions{Limit: 1, Sort: "name"})
+			assert.Nil(err)
+
+			for _, opts := range []ListOptions{
+				{Limit: -1},
+				{Limit: MaxPageSize + 1},
+				{Sort: "password"},
+				{Cursor: "not a cursor"},
+				{Cursor: page.NextCursor},
+				{Cursor: page.NextCursor, Sort: "name", Filter: "a"},
+			} {
+				_, err := list(opts)
+				assert.Equal(dberror.ErrBadData, err, "%+v", opts)
+			}
+		})
+	})
+}
+
+func TestListSshKeys(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		user := NewUser("keys", "keys@example.com")
+		require.NoError(t, db.CreateUser(ctx, &user))
+		other := NewUser("other", "other@example.com")
+		require.NoError(t, db.CreateUser(ctx, &other))
+
+		for _, name := range []string{"laptop", "desktop", "laptop-old"} {
+			key := NewSShKey(name, SSH_RSA, []byte("ssh-rsa "+name))
+			require.NoError(t, db.AddSshKey(ctx, user.ID, &key))
+		}
+		key := NewSShKey("laptop-other", SSH_RSA, []byte("ssh-rsa other"))
+		require.NoError(t, db.AddSshKey(ctx, other.ID, &key))
+
+		list := func(opts ListOptions) (Page[SshKey], error) { return db.ListSshKeys(ctx, user.ID, opts) }
+		name := func(k SshKey) string { return k.Name }
+
+		assert.Equal([][]string{{"desktop", "laptop"}, {"laptop-old"}}, listAll(t, list, ListOptions{Limit: 2, Sort: "name"}, name))
+		assert.Equal([][]string{{"laptop-old", "laptop"}}, listAll(t, list, ListOptions{Filter: "lap", Sort: "-name"}, name))
+
+		_, err := list(ListOptions{Sort: "email"})
+		assert.Equal(dberror.ErrBadData, err)
+	})
+}
+
+func TestListRepos(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		first := NewRepo("first")
+		require.NoError(t, db.CreateRepo(ctx, &first))
+		time.Sleep(time.Second) // Creation times have a precision of seconds
+		for _, name := range []string{"second", "third"} {
+			repo := NewRepo(name)
+			require.NoError(t, db.CreateRepo(ctx, &repo))
+		}
+
+		list := func(opts ListOptions) (Page[Repo], error) { return db.ListRepos(ctx, opts) }
+		name := func(r Repo) string { return r.Name }
+
+		assert.Equal([][]string{{"first", "second", "third"}}, listAll(t, list, ListOptions{Sort: "name"}, name))
+
+		pages := listAll(t, list, ListOptions{Limit: 1, Sort: "-created"}, name)
+		assert.Len(pages, 3)
+		assert.Equal([]string{"first"}, pages[2], "The oldest repository comes last")
+
+		assert.Equal([][]string{{"second"}}, listAll(t, list, ListOptions{Filter: "SEC"}, name))
+	})
+}
+
+func TestListAccessRoles(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		repo := NewRepo("roles")
+		require.NoError(t, db.CreateRepo(ctx, &repo))
+		other := NewRepo("other")
+		require.NoError(t, db.CreateRepo(ctx, &other))
+
+		var want []string
+		for _, name := range []string{"a", "b", "c"} {
+			user := NewUser(name, name+"@example.com")
+			require.NoError(t, db.CreateUser(ctx, &user))
+			role := AccessRole{RoleID: uuid.New(), UserID: user.ID, RepoID: repo.ID, Created: time.Now()}
+			require.NoError(t, db.CreateAccessRole(ctx, &role))
+			want = append(want, role.RoleID.String())
+
+			role = AccessRole{RoleID: uuid.New(), UserID: user.ID, RepoID: other.ID, Created: time.Now()}
+			require.NoError(t, db.CreateAccessRole(ctx, &role))
+		}
+
+		list := func(opts ListOptions) (Page[AccessRole], error) { return db.ListAccessRoles(ctx, repo.ID, opts) }
+		id := func(r AccessRole) string { return r.RoleID.String() }
+
+		pages := listAll(t, list, ListOptions{Limit: 2}, id)
+		assert.Len(pages, 2)
+		assert.ElementsMatch(want, slices.Concat(pages...))
+
+		all := listAll(t, func(opts ListOptions) (Page[AccessRole], error) {
+			return db.ListAccessRoles(ctx, uuid.Nil, opts)
+		}, ListOptions{}, id)
+		assert.Len(slices.Concat(all...), 6)
+
+		_, err := list(ListOptions{Sort: "name"})
+		assert.Equal(dberror.ErrBadData, err)
+	})
+}
@@ -0,0 +1,794 @@
+package database
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/google/uuid"
+)
+
+// MemoryStore is an in-memory Store for tests. It validates input and
+// reports errors like DB does, but keeps nothing once dropped.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	users map[IDT]User
+	keys  map[IDT]SshKey
+	repos map[IDT]Repo
+	roles map[IDT]AccessRole
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: make(map[IDT]User),
+		keys:  make(map[IDT]SshKey),
+		repos: make(map[IDT]Repo),
+		roles: make(map[IDT]AccessRole),
+	}
+}
+
+// WithTx runs fn on a copy of the store, which replaces the store if fn
+// succeeds. Other callers wait until fn returns, so fn must only use tx.
+func (m *MemoryStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &MemoryStore{
+		users: maps.Clone(m.users),
+		keys:  maps.Clone(m.keys),
+		repos: maps.Clone(m.repos),
+		roles: maps.Clone(m.roles),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	m.users, m.keys, m.repos, m.roles = tx.users, tx.keys, tx.repos, tx.roles
+	return nil
+}
+
+// sortedValues returns the values of m ordered by creation time, then by
+// id, which keeps listings stable
+func sortedValues[T any](m map[IDT]T, created func(T) time.Time) []T {
+	ids := make([]IDT, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b IDT) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	res := make([]T, 0, len(m))
+	for _, id := range ids {
+		res = append(res, m[id])
+	}
+	slices.SortStableFunc(res, func(a, b T) int {
+		return created(a).Compare(created(b))
+	})
+	return res
+}
+
+// live drops the deleted records from values
+func live[T any](values []T, deleted func(T) time.Time) []T {
+	return slices.DeleteFunc(values, func(v T) bool {
+		return !deleted(v).IsZero()
+	})
+}
+
+func validUser(user *User) bool {
+	return user != nil && user.ID != uuid.Nil && user.Name != "" && user.Email != "" && strings.ContainsRune(user.Email, '@')
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, user *User) error {
+	if user == nil {
+		return errors.ErrBadData
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !validUser(user) {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.ID]; ok {
+		return errors.ErrAlreadyExists
+	}
+	stored := *user
+	stored.Created = time.Now()
+	m.users[user.ID] = stored
+	return nil
+}
+
+// EditUser replaces the fields of a user. Like DB, editing a missing user
+// is not an error.
+func (m *MemoryStore) EditUser(ctx context.Context, userid IDT, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !validUser(user) {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.users[userid]; !ok || !current.Deleted.IsZero() {
+		return nil
+	}
+	stored := *user
+	stored.ID = userid
+	stored.Edited = time.Now()
+	stored.Deleted = time.Time{}
+	m.users[userid] = stored
+	return nil
+}
+
+// DeleteUser marks a user deleted along with its keys and roles.
+func (m *MemoryStore) DeleteUser(ctx context.Context, userid IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if userid == uuid.Nil {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userid]
+	if !ok || !user.Deleted.IsZero() {
+		return errors.ErrNotFound
+	}
+
+	now := time.Now()
+	user.Deleted = now
+	m.users[userid] = user
+	for id, k := range m.keys {
+		if k.UserID == userid && k.Deleted.IsZero() {
+			k.Deleted = now
+			m.keys[id] = k
+		}
+	}
+	for id, r := range m.roles {
+		if r.UserID == userid && r.Deleted.IsZero() {
+			r.Deleted = now
+			m.roles[id] = r
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUser(ctx context.Context, userid IDT) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	if userid == uuid.Nil {
+		return User{}, errors.ErrBadData
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userid]
+	if !ok || !user.Deleted.IsZero() {
+		return User{}, errors.ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *MemoryStore) GetUsers(ctx context.Context) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return []User{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := sortedValues(m.users, func(u User) time.Time { return u.Created })
+	return live(users, func(u User) time.Time { return u.Deleted }), nil
+}
+
+// UserByKey returns the owner of the key with the given data.
+func (m *MemoryStore) UserByKey(ctx context.Context, key []byte) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	if key == nil {
+		return User{}, errors.ErrBadData
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.keys {
+		if !k.Deleted.IsZero() || !bytes.Equal(k.Data, key) {
+			continue
+		}
+		if user, ok := m.users[k.UserID]; ok && user.Deleted.IsZero() {
+			return user, nil
+		}
+	}
+	return User{}, errors.ErrNotFound
+}
+
+func (m *MemoryStore) AddSshKey(ctx context.Context, userid IDT, key *SshKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key.UserID = userid
+
+	if userid == uuid.Nil || key.ID == uuid.Nil || key.Name == "" || key.Type > SSH_ED25519 {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.keys {
+		if k.UserID == userid && k.Deleted.IsZero() && bytes.Equal(k.Data, key.Data) {
+			return errors.ErrAlreadyExists
+		}
+	}
+	stored := *key
+	stored.Data = bytes.Clone(key.Data)
+	m.keys[key.ID] = stored
+	return nil
+}
+
+func (m *MemoryStore) DeleteSshKey(ctx context.Context, keyid IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[keyid]
+	if !ok || !key.Deleted.IsZero() {
+		return errors.ErrNotFound
+	}
+	key.Deleted = time.Now()
+	m.keys[keyid] = key
+	return nil
+}
+
+func (m *MemoryStore) GetSshKeys(ctx context.Context, userID IDT) ([]SshKey, error) {
+	return m.filterKeys(ctx, func(k SshKey) bool {
+		return k.UserID == userID
+	})
+}
+
+func (m *MemoryStore) GetKeysByType(ctx context.Context, types ...SSH_KEY_TYPE) ([]SshKey, error) {
+	if len(types) == 0 {
+		return []SshKey{}, errors.ErrBadData
+	}
+	return m.filterKeys(ctx, func(k SshKey) bool {
+		return slices.Contains(types, k.Type)
+	})
+}
+
+func (m *MemoryStore) filterKeys(ctx context.Context, keep func(SshKey) bool) ([]SshKey, error) {
+	keys := make([]SshKey, 0, 16)
+	if err := ctx.Err(); err != nil {
+		return keys, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range sortedValues(m.keys, func(k SshKey) time.Time { return k.Created }) {
+		if k.Deleted.IsZero() && keep(k) {
+			k.Data = bytes.Clone(k.Data)
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) CreateRepo(ctx context.Context, repo *Repo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if repo.ID == uuid.Nil || repo.Name == "" {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.repos[repo.ID]; ok {
+		return errors.ErrAlreadyExists
+	}
+	stored := *repo
+	stored.Created = time.Now()
+	m.repos[repo.ID] = stored
+	return nil
+}
+
+// DeleteRepo marks a repository deleted along with its roles. Like DB,
+// deleting a missing repository is not an error.
+func (m *MemoryStore) DeleteRepo(ctx context.Context, repoid IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if repoid == uuid.Nil {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	repo, ok := m.repos[repoid]
+	if !ok || !repo.Deleted.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	repo.Deleted = now
+	m.repos[repoid] = repo
+	for id, r := range m.roles {
+		if r.RepoID == repoid && r.Deleted.IsZero() {
+			r.Deleted = now
+			m.roles[id] = r
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateRepo(ctx context.Context, repoid IDT, repo *Repo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if repoid == uuid.Nil || repo.ID == uuid.Nil || repo.Name == "" {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.repos[repoid]
+	if !ok || !current.Deleted.IsZero() {
+		return nil
+	}
+	current.Name = repo.Name
+	current.Created = repo.Created
+	current.Edited = time.Now()
+	m.repos[repoid] = current
+	return nil
+}
+
+func (m *MemoryStore) GetRepo(ctx context.Context, repoid IDT) (Repo, error) {
+	if err := ctx.Err(); err != nil {
+		return Repo{}, err
+	}
+	if repoid == uuid.Nil {
+		return Repo{}, errors.ErrBadData
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	repo, ok := m.repos[repoid]
+	if !ok || !repo.Deleted.IsZero() {
+		return Repo{}, errors.ErrNotFound
+	}
+	return repo, nil
+}
+
+func (m *MemoryStore) GetRepos(ctx context.Context) ([]Repo, error) {
+	if err := ctx.Err(); err != nil {
+		return []Repo{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	repos := sortedValues(m.repos, func(r Repo) time.Time { return r.Created })
+	return live(repos, func(r Repo) time.Time { return r.Deleted }), nil
+}
+
+func (m *MemoryStore) CreateAccessRole(ctx context.Context, ar *AccessRole) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ar.UserID == uuid.Nil || ar.RepoID == uuid.Nil || ar.RoleID == uuid.Nil {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roles[ar.RoleID]; ok {
+		return errors.ErrAlreadyExists
+	}
+	m.roles[ar.RoleID] = *ar
+	return nil
+}
+
+func (m *MemoryStore) EditAccessRole(ctx context.Context, roleid IDT, ar *AccessRole) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if roleid == uuid.Nil || ar.UserID == uuid.Nil || ar.RepoID == uuid.Nil || ar.RoleID == uuid.Nil {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.roles[ar.RoleID]; ok && current.Deleted.IsZero() {
+		m.roles[ar.RoleID] = *ar
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteAccessRole(ctx context.Context, roleid IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if roleid == uuid.Nil {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if role, ok := m.roles[roleid]; ok && role.Deleted.IsZero() {
+		role.Deleted = time.Now()
+		m.roles[roleid] = role
+	}
+	return nil
+}
+
+// GetAccessRole returns a role. Like DB, a missing role is reported as
+// errors.ErrBadData.
+func (m *MemoryStore) GetAccessRole(ctx context.Context, roleid IDT) (AccessRole, error) {
+	if err := ctx.Err(); err != nil {
+		return AccessRole{}, err
+	}
+	if roleid == uuid.Nil {
+		return AccessRole{}, errors.ErrBadData
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	role, ok := m.roles[roleid]
+	if !ok || !role.Deleted.IsZero() {
+		return AccessRole{}, errors.ErrBadData
+	}
+	return role, nil
+}
+
+func (m *MemoryStore) GetAccessRoles(ctx context.Context) ([]AccessRole, error) {
+	var roles []AccessRole
+	if err := ctx.Err(); err != nil {
+		return roles, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roles = sortedValues(m.roles, func(r AccessRole) time.Time { return r.Created })
+	return live(roles, func(r AccessRole) time.Time { return r.Deleted }), nil
+}
+
+// CheckPermissions reports whether the user has a role on the repository.
+// A branch must match the branch glob of the role.
+func (m *MemoryStore) CheckPermissions(ctx context.Context, userid, repoid IDT, branch string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if userid == uuid.Nil || repoid == uuid.Nil {
+		return false, errors.ErrBadData
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, role := range m.roles {
+		if role.UserID != userid || role.RepoID != repoid || !role.Deleted.IsZero() {
+			continue
+		}
+		if branch == "" || (role.Branches != nil && (*role.Branches).Match(branch)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) liveUser(id IDT) bool {
+	user, ok := m.users[id]
+	return ok && user.Deleted.IsZero()
+}
+
+func (m *MemoryStore) liveRepo(id IDT) bool {
+	repo, ok := m.repos[id]
+	return ok && repo.Deleted.IsZero()
+}
+
+// deletedIn returns the deleted record id of records, failing with
+// errors.ErrNotFound if there is none
+func deletedIn[T any](records map[IDT]T, id IDT, deleted func(T) time.Time) (T, error) {
+	rec, ok := records[id]
+	if !ok || deleted(rec).IsZero() {
+		var zero T
+		return zero, errors.ErrNotFound
+	}
+	return rec, nil
+}
+
+func (m *MemoryStore) GetDeleted(ctx context.Context, entity Entity) ([]DeletedRecord, error) {
+	records := make([]DeletedRecord, 0, 16)
+	if err := ctx.Err(); err != nil {
+		return records, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	add := func(id IDT, name string, deleted time.Time) {
+		if !deleted.IsZero() {
+			records = append(records, DeletedRecord{Entity: entity, ID: id, Name: name, Deleted: deleted})
+		}
+	}
+	switch entity {
+	case EntityUser:
+		for id, u := range m.users {
+			add(id, u.Name, u.Deleted)
+		}
+	case EntityKey:
+		for id, k := range m.keys {
+			add(id, k.Name, k.Deleted)
+		}
+	case EntityRepo:
+		for id, r := range m.repos {
+			add(id, r.Name, r.Deleted)
+		}
+	case EntityRole:
+		for id, r := range m.roles {
+			add(id, "", r.Deleted)
+		}
+	default:
+		return records, errors.ErrBadData
+	}
+
+	slices.SortFunc(records, func(a, b DeletedRecord) int {
+		if c := a.Deleted.Compare(b.Deleted); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+	return records, nil
+}
+
+func (m *MemoryStore) Restore(ctx context.Context, entity Entity, id IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id == uuid.Nil {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch entity {
+	case EntityUser:
+		user, err := deletedIn(m.users, id, func(u User) time.Time { return u.Deleted })
+		if err != nil {
+			return err
+		}
+		for kid, k := range m.keys {
+			if k.UserID == id && k.Deleted.Equal(user.Deleted) {
+				k.Deleted = time.Time{}
+				m.keys[kid] = k
+			}
+		}
+		for rid, r := range m.roles {
+			if r.UserID == id && r.Deleted.Equal(user.Deleted) && m.liveRepo(r.RepoID) {
+				r.Deleted = time.Time{}
+				m.roles[rid] = r
+			}
+		}
+		user.Deleted = time.Time{}
+		m.users[id] = user
+	case EntityKey:
+		key, err := deletedIn(m.keys, id, func(k SshKey) time.Time { return k.Deleted })
+		if err != nil {
+			return err
+		}
+		if !m.liveUser(key.UserID) {
+			return errors.ErrBadData
+		}
+		key.Deleted = time.Time{}
+		m.keys[id] = key
+	case EntityRepo:
+		repo, err := deletedIn(m.repos, id, func(r Repo) time.Time { return r.Deleted })
+		if err != nil {
+			return err
+		}
+		for rid, r := range m.roles {
+			if r.RepoID == id && r.Deleted.Equal(repo.Deleted) && m.liveUser(r.UserID) {
+				r.Deleted = time.Time{}
+				m.roles[rid] = r
+			}
+		}
+		repo.Deleted = time.Time{}
+		m.repos[id] = repo
+	case EntityRole:
+		role, err := deletedIn(m.roles, id, func(r AccessRole) time.Time { return r.Deleted })
+		if err != nil {
+			return err
+		}
+		if !m.liveUser(role.UserID) || !m.liveRepo(role.RepoID) {
+			return errors.ErrBadData
+		}
+		role.Deleted = time.Time{}
+		m.roles[id] = role
+	default:
+		return errors.ErrBadData
+	}
+	return nil
+}
+
+func (m *MemoryStore) Purge(ctx context.Context, entity Entity, id IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if id == uuid.Nil {
+		return errors.ErrBadData
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+	switch entity {
+	case EntityUser:
+		if _, err = deletedIn(m.users, id, func(u User) time.Time { return u.Deleted }); err == nil {
+			delete(m.users, id)
+			maps.DeleteFunc(m.keys, func(_ IDT, k SshKey) bool { return k.UserID == id })
+			maps.DeleteFunc(m.roles, func(_ IDT, r AccessRole) bool { return r.UserID == id })
+		}
+	case EntityKey:
+		if _, err = deletedIn(m.keys, id, func(k SshKey) time.Time { return k.Deleted }); err == nil {
+			delete(m.keys, id)
+		}
+	case EntityRepo:
+		if _, err = deletedIn(m.repos, id, func(r Repo) time.Time { return r.Deleted }); err == nil {
+			delete(m.repos, id)
+			maps.DeleteFunc(m.roles, func(_ IDT, r AccessRole) bool { return r.RepoID == id })
+		}
+	case EntityRole:
+		if _, err = deletedIn(m.roles, id, func(r AccessRole) time.Time { return r.Deleted }); err == nil {
+			delete(m.roles, id)
+		}
+	default:
+		err = errors.ErrBadData
+	}
+	return err
+}
+
+// listValues pages through values like listPage. sorts are the supported
+// sort fields, match reports whether an item contains the lower-cased
+// filter; without it the filter is ignored.
+func listValues[T any](values []T, opts ListOptions, sorts []string, match func(T, string) bool, key func(T, string) (string, IDT)) (Page[T], error) {
+	page := Page[T]{Items: make([]T, 0, 16)}
+
+	limit, err := pageLimit(opts)
+	if err != nil {
+		return page, err
+	}
+	sort := opts.Sort
+	if sort == "" {
+		sort = "created"
+	}
+	fields := make(map[string]bool, len(sorts))
+	for _, name := range sorts {
+		fields[name] = true
+	}
+	name, desc, err := parseSort(sort, fields)
+	if err != nil {
+		return page, err
+	}
+
+	if opts.Filter != "" && match != nil {
+		filter := strings.ToLower(opts.Filter)
+		values = slices.DeleteFunc(values, func(v T) bool {
+			return !match(v, filter)
+		})
+	}
+
+	compare := func(a, b T) int {
+		ak, aid := key(a, name)
+		bk, bid := key(b, name)
+		if c := strings.Compare(ak, bk); c != 0 {
+			return c
+		}
+		return strings.Compare(aid.String(), bid.String())
+	}
+	if desc {
+		slices.SortFunc(values, func(a, b T) int { return compare(b, a) })
+	} else {
+		slices.SortFunc(values, compare)
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor, opts, sort)
+		if err != nil {
+			return page, err
+		}
+		values = slices.DeleteFunc(values, func(v T) bool {
+			k, id := key(v, name)
+			n := cmp.Or(strings.Compare(k, c.Key), strings.Compare(id.String(), c.ID.String()))
+			if desc {
+				return n >= 0
+			}
+			return n <= 0
+		})
+	}
+
+	if len(values) > limit {
+		values = values[:limit]
+		k, id := key(values[limit-1], name)
+		page.NextCursor = encodeCursor(listCursor{Sort: sort, Filter: opts.Filter, Key: k, ID: id})
+	}
+	page.Items = append(page.Items, values...)
+	return page, nil
+}
+
+func (m *MemoryStore) ListUsers(ctx context.Context, opts ListOptions) (Page[User], error) {
+	users, err := m.GetUsers(ctx)
+	if err != nil {
+		return Page[User]{}, err
+	}
+	return listValues(users, opts, []string{"name", "email", "created"}, func(u User, filter string) bool {
+		return strings.Contains(strings.ToLower(u.Name), filter) || strings.Contains(strings.ToLower(u.Email), filter)
+	}, userKey)
+}
+
+func (m *MemoryStore) ListSshKeys(ctx context.Context, userID IDT, opts ListOptions) (Page[SshKey], error) {
+	keys, err := m.GetSshKeys(ctx, userID)
+	if err != nil {
+		return Page[SshKey]{}, err
+	}
+	return listValues(keys, opts, []string{"name", "created"}, func(k SshKey, filter string) bool {
+		return strings.Contains(strings.ToLower(k.Name), filter)
+	}, keyKey)
+}
+
+func (m *MemoryStore) ListRepos(ctx context.Context, opts ListOptions) (Page[Repo], error) {
+	repos, err := m.GetRepos(ctx)
+	if err != nil {
+		return Page[Repo]{}, err
+	}
+	return listValues(repos, opts, []string{"name", "created"}, func(r Repo, filter string) bool {
+		return strings.Contains(strings.ToLower(r.Name), filter)
+	}, repoKey)
+}
+
+func (m *MemoryStore) ListAccessRoles(ctx context.Context, repoID IDT, opts ListOptions) (Page[AccessRole], error) {
+	roles, err := m.GetAccessRoles(ctx)
+	if err != nil {
+		return Page[AccessRole]{}, err
+	}
+	if repoID != uuid.Nil {
+		roles = slices.DeleteFunc(roles, func(r AccessRole) bool { return r.RepoID != repoID })
+	}
+	return listValues(roles, opts, []string{"created"}, nil, roleKey)
+}
@@ -0,0 +1,111 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/database/dialect"
+)
+
+// LockTimeout bounds how long Apply and Rollback wait for the migration
+// lock while another instance holds it
+var LockTimeout = 5 * time.Minute
+
+// lockRetryDelay is the pause between attempts to take the lock, when the
+// database gives up waiting for it on its own as SQLite does
+const lockRetryDelay = 100 * time.Millisecond
+
+// lockError wraps the failure to take the migration lock
+type lockError struct {
+	err error
+}
+
+func (e lockError) Error() string { return "failed to acquire migration lock: " + e.err.Error() }
+func (e lockError) Unwrap() error { return e.err }
+
+// bootstrapLockID identifies the PostgreSQL advisory lock held while the
+// migration tables are created. The value is arbitrary.
+const bootstrapLockID int64 = 0x64657067697401
+
+// bootstrapLocked runs fn, which creates the migration tables, one instance
+// at a time. PostgreSQL fails concurrent CREATE TABLE IF NOT EXISTS of the
+// same table with a unique violation on its catalog instead of waiting, so
+// fn holds a session advisory lock there. SQLite serializes the statements
+// on its own.
+func (m *Manager) bootstrapLocked(ctx context.Context, fn func() error) error {
+	if m.dialect != dialect.Postgres {
+		return fn()
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration bootstrap lock: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", bootstrapLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration bootstrap lock: %w", err)
+	}
+	defer func() {
+		// Closing the session would release the lock as well, but the
+		// connection goes back to the pool
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", bootstrapLockID); err != nil {
+			migrationLogger.WithContext(ctx).WithError(err).Warn("Failed to release migration bootstrap lock")
+		}
+	}()
+
+	return fn()
+}
+
+// initializeLock creates the row locked while migrating
+func (m *Manager) initializeLock(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS migration_lock (
+		id INTEGER PRIMARY KEY,
+		locked_at TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create migration lock table: %w", err)
+	}
+
+	_, err = m.db.ExecContext(ctx, m.dialect.Upsert("migration_lock", []string{"id"}), 1)
+	if err != nil {
+		return fmt.Errorf("failed to create migration lock: %w", err)
+	}
+	return nil
+}
+
+// lockedTx runs fn in a transaction holding the migration lock. The lock
+// is a row every migrating transaction updates first: the database lets
+// one of them write it at a time, until it commits or rolls back.
+func (m *Manager) lockedTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "UPDATE migration_lock SET locked_at = CURRENT_TIMESTAMP WHERE id = 1")
+			if err != nil {
+				return lockError{err}
+			}
+			return fn(tx)
+		})
+
+		var lockErr lockError
+		if !errors.As(err, &lockErr) || time.Now().After(deadline) {
+			return err
+		}
+
+		migrationLogger.
+			WithContext(ctx).
+			WithError(lockErr.err).
+			Debug("Migration lock busy, retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(lockRetryDelay):
+		}
+	}
+}
@@ -2,86 +2,208 @@ package migrations
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/GoldenDeals/DepGit/internal/database/dialect"
 	"github.com/GoldenDeals/DepGit/internal/share/logger"
 )
 
 // Logger for migrations
 var migrationLogger = logger.New("db_migrations")
 
+// ErrIrreversible is returned when rolling back a migration without a down
+// script
+var ErrIrreversible = errors.New("migration cannot be rolled back")
+
+// ErrMissing is returned when rolling back an applied migration whose files
+// are gone
+var ErrMissing = errors.New("migration files missing")
+
+// ErrDrift is returned by Apply when applied migrations were changed since
+var ErrDrift = errors.New("applied migrations changed")
+
 // Migration represents a database migration
 type Migration struct {
-	ID        int64
-	Name      string
-	SQL       string
+	ID   int64
+	Name string
+	SQL  string
+	// Down reverts SQL, empty if the migration cannot be rolled back
+	Down string
+	// Checksum identifies SQL. For applied migrations it is the one SQL had
+	// when applied, empty if applied before checksums were recorded.
+	Checksum  string
 	AppliedAt time.Time
 }
 
+// State is where a migration stands in a database
+type State string
+
+const (
+	StateApplied State = "applied"
+	StatePending State = "pending"
+	// StateMissing marks applied migrations that are not loaded, e.g. after
+	// deploying an older version
+	StateMissing State = "missing"
+)
+
+// Status reports the state of a migration
+type Status struct {
+	Name  string
+	State State
+	// AppliedAt is zero for pending migrations
+	AppliedAt  time.Time
+	Reversible bool
+	// Drifted marks applied migrations whose SQL changed since
+	Drifted bool
+}
+
 // Manager handles database migrations
 type Manager struct {
 	db         *sql.DB
+	dialect    dialect.Dialect
 	migrations []*Migration
+	allowDrift bool
 }
 
-// New creates a new migration manager
+// New creates a new migration manager for an SQLite database
 func New(db *sql.DB) *Manager {
+	return NewWithDialect(db, dialect.SQLite)
+}
+
+// NewWithDialect creates a new migration manager for a database of the
+// given dialect
+func NewWithDialect(db *sql.DB, d dialect.Dialect) *Manager {
 	return &Manager{
 		db:         db,
+		dialect:    d,
 		migrations: []*Migration{},
 	}
 }
 
-// Initialize creates the migrations table if it doesn't exist
+// Initialize creates the migrations table if it doesn't exist. Instances
+// starting at once take turns.
 func (m *Manager) Initialize(ctx context.Context) error {
+	return m.bootstrapLocked(ctx, func() error {
+		return m.initialize(ctx)
+	})
+}
+
+func (m *Manager) initialize(ctx context.Context) error {
 	// Create migrations table if it doesn't exist
 	_, err := m.db.ExecContext(ctx, `
 	CREATE TABLE IF NOT EXISTS migrations (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		id `+m.dialect.SerialKey()+`,
 		name TEXT NOT NULL,
-		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		checksum TEXT
 	);
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create migrations table: %w", err)
 	}
-	return nil
+
+	// Tables created before checksums were recorded lack the column
+	if !m.hasChecksums(ctx) {
+		_, err := m.db.ExecContext(ctx, "ALTER TABLE migrations ADD COLUMN checksum TEXT")
+		if err != nil && !m.hasChecksums(ctx) {
+			return fmt.Errorf("failed to add checksums to migrations table: %w", err)
+		}
+	}
+
+	return m.initializeLock(ctx)
 }
 
-// LoadFromDir loads migrations from a directory
-func (m *Manager) LoadFromDir(ctx context.Context, dir string) error {
-	files, err := os.ReadDir(dir)
+// hasChecksums reports whether the migrations table has a checksum column
+func (m *Manager) hasChecksums(ctx context.Context) bool {
+	rows, err := m.db.QueryContext(ctx, "SELECT checksum FROM migrations WHERE 1 = 0")
 	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
+		return false
 	}
+	rows.Close()
+	return true
+}
 
+// AllowDrift makes Apply only warn about applied migrations that changed
+// since, instead of failing with ErrDrift
+func (m *Manager) AllowDrift(allow bool) {
+	m.allowDrift = allow
+}
+
+// LoadFromDir loads migrations from a directory, see LoadFromFS.
+func (m *Manager) LoadFromDir(ctx context.Context, dir string) error {
 	migrationLogger.
 		WithContext(ctx).
 		WithField("directory", dir).
 		Info("Loading migrations from directory")
 
+	return m.LoadFromFS(ctx, os.DirFS(dir))
+}
+
+// LoadFromFS loads migrations from the root of fsys. A migration is either
+// a single file like 001_schema.sql or a pair of 001_schema.up.sql and
+// 001_schema.down.sql, the latter reverting the former; both forms are
+// recorded as 001_schema.sql. A file named like 001_schema.postgres.sql or
+// 001_schema.down.postgres.sql is only loaded for that dialect, where it
+// replaces the generic file.
+func (m *Manager) LoadFromFS(ctx context.Context, fsys fs.FS) error {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	// script is the content of a migration file
+	type script struct {
+		file     string
+		sql      string
+		specific bool
+	}
+	ups := make(map[string]script)
+	downs := make(map[string]script)
 	for _, file := range files {
 		if file.IsDir() || !strings.HasSuffix(file.Name(), ".sql") {
 			continue
 		}
 
-		path := filepath.Join(dir, file.Name())
-		content, err := os.ReadFile(path)
+		name, d, down := parseFileName(file.Name())
+		if d != "" && d != m.dialect.Name() {
+			continue
+		}
+		scripts := ups
+		if down {
+			scripts = downs
+		}
+		if prev, ok := scripts[name]; ok {
+			if prev.specific == (d != "") {
+				return fmt.Errorf("migration %s is defined by both %s and %s", name, prev.file, file.Name())
+			}
+			if prev.specific {
+				continue
+			}
+		}
+
+		content, err := fs.ReadFile(fsys, file.Name())
 		if err != nil {
 			return fmt.Errorf("failed to read migration file %s: %w", file.Name(), err)
 		}
+		scripts[name] = script{file: file.Name(), sql: string(content), specific: d != ""}
+	}
 
-		m.migrations = append(m.migrations, &Migration{
-			Name: file.Name(),
-			SQL:  string(content),
-		})
+	for name, down := range downs {
+		if _, ok := ups[name]; !ok {
+			return fmt.Errorf("migration %s has no up script for %s", name, down.file)
+		}
+	}
+	for name, up := range ups {
+		m.AddReversibleMigration(name, up.sql, downs[name].sql)
 	}
 
 	// Sort migrations by name
@@ -116,100 +238,283 @@ func (m *Manager) GetAppliedMigrations(ctx context.Context) (map[string]bool, er
 	return applied, nil
 }
 
-// Apply applies all pending migrations
-func (m *Manager) Apply(ctx context.Context) error {
-	applied, err := m.GetAppliedMigrations(ctx)
-	if err != nil {
-		return err
-	}
+// querier runs queries on a database or within a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
 
-	// Begin transaction
-	tx, err := m.db.BeginTx(ctx, nil)
+// appliedMigrations returns the applied migrations in the order they were
+// applied. Only their ID, Name, Checksum and AppliedAt are set.
+func (m *Manager) appliedMigrations(ctx context.Context, q querier) ([]*Migration, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, name, applied_at, checksum FROM migrations ORDER BY id")
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to query migrations: %w", err)
 	}
-	defer func() {
-		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
-			migrationLogger.WithContext(ctx).WithError(rbErr).Error("Failed to rollback transaction")
+	defer rows.Close()
+
+	var applied []*Migration
+	for rows.Next() {
+		var migration Migration
+		var sum sql.NullString
+		if err := rows.Scan(&migration.ID, &migration.Name, &migration.AppliedAt, &sum); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
 		}
-	}()
+		migration.Checksum = sum.String
+		applied = append(applied, &migration)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migrations: %w", err)
+	}
+
+	return applied, nil
+}
 
+// loaded returns the loaded migrations by name
+func (m *Manager) loaded() map[string]*Migration {
+	loaded := make(map[string]*Migration, len(m.migrations))
 	for _, migration := range m.migrations {
-		if applied[migration.Name] {
+		loaded[migration.Name] = migration
+	}
+	return loaded
+}
+
+// drifted reports whether an applied migration changed since
+func drifted(applied, loaded *Migration) bool {
+	return loaded != nil && applied.Checksum != "" && applied.Checksum != loaded.Checksum
+}
+
+// Apply applies all pending migrations. It holds the migration lock while
+// doing so, so that instances starting at once apply them only once. It
+// refuses to apply anything if applied migrations changed since, unless
+// drift is allowed.
+func (m *Manager) Apply(ctx context.Context) error {
+	return m.lockedTx(ctx, func(tx *sql.Tx) error {
+		applied, err := m.appliedMigrations(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		loaded := m.loaded()
+		done := make(map[string]bool, len(applied))
+		var changed []string
+		for _, a := range applied {
+			done[a.Name] = true
+
+			migration := loaded[a.Name]
+			switch {
+			case drifted(a, migration):
+				changed = append(changed, a.Name)
+			case migration != nil && a.Checksum == "":
+				// Applied before checksums were recorded, trust the current SQL
+				_, err := tx.ExecContext(ctx, m.dialect.Rebind("UPDATE migrations SET checksum = ? WHERE id = ?"), migration.Checksum, a.ID)
+				if err != nil {
+					return fmt.Errorf("failed to record checksum of migration %s: %w", a.Name, err)
+				}
+			}
+		}
+		if len(changed) > 0 {
+			if !m.allowDrift {
+				return fmt.Errorf("%w: %s", ErrDrift, strings.Join(changed, ", "))
+			}
 			migrationLogger.
 				WithContext(ctx).
-				WithField("migration", migration.Name).
-				Info("Migration already applied, skipping")
-			continue
+				WithField("migrations", changed).
+				Warn("Applied migrations changed since, ignoring")
 		}
 
-		migrationLogger.
-			WithContext(ctx).
-			WithField("migration", migration.Name).
-			Info("Applying migration")
+		for _, migration := range m.migrations {
+			if done[migration.Name] {
+				migrationLogger.
+					WithContext(ctx).
+					WithField("migration", migration.Name).
+					Info("Migration already applied, skipping")
+				continue
+			}
 
-		// Split the SQL into individual statements
-		statements := splitStatements(migration.SQL)
+			migrationLogger.
+				WithContext(ctx).
+				WithField("migration", migration.Name).
+				Info("Applying migration")
 
-		for _, stmt := range statements {
-			if strings.TrimSpace(stmt) == "" {
-				continue
+			if err := m.execScript(ctx, tx, migration.SQL); err != nil {
+				return fmt.Errorf("failed to execute migration %s: %w", migration.Name, err)
 			}
 
-			_, err = tx.ExecContext(ctx, stmt)
+			// Record the migration
+			_, err = tx.ExecContext(ctx, m.dialect.Rebind("INSERT INTO migrations (name, checksum) VALUES (?, ?)"), migration.Name, migration.Checksum)
 			if err != nil {
-				return fmt.Errorf("failed to execute migration %s: %w", migration.Name, err)
+				return fmt.Errorf("failed to record migration %s: %w", migration.Name, err)
 			}
+
+			migrationLogger.
+				WithContext(ctx).
+				WithField("migration", migration.Name).
+				Info("Migration applied successfully")
 		}
+		return nil
+	})
+}
 
-		// Record the migration
-		_, err = tx.ExecContext(ctx, "INSERT INTO migrations (name) VALUES (?)", migration.Name)
+// Rollback reverts the last steps applied migrations, newest first, and
+// returns their names. They are reverted in a single transaction, holding
+// the migration lock: if one of them cannot be rolled back, because it has
+// no down script (ErrIrreversible) or is not loaded (ErrMissing), nothing
+// is.
+func (m *Manager) Rollback(ctx context.Context, steps int) ([]string, error) {
+	var names []string
+	err := m.lockedTx(ctx, func(tx *sql.Tx) error {
+		applied, err := m.appliedMigrations(ctx, tx)
 		if err != nil {
-			return fmt.Errorf("failed to record migration %s: %w", migration.Name, err)
+			return err
+		}
+		if steps <= 0 || steps > len(applied) {
+			return fmt.Errorf("cannot roll back %d of %d applied migrations", steps, len(applied))
 		}
 
-		migrationLogger.
-			WithContext(ctx).
-			WithField("migration", migration.Name).
-			Info("Migration applied successfully")
+		loaded := m.loaded()
+		reverted := make([]*Migration, 0, steps)
+		for i := len(applied) - 1; i >= len(applied)-steps; i-- {
+			migration, ok := loaded[applied[i].Name]
+			switch {
+			case !ok:
+				return fmt.Errorf("failed to roll back migration %s: %w", applied[i].Name, ErrMissing)
+			case migration.Down == "":
+				return fmt.Errorf("failed to roll back migration %s: %w", applied[i].Name, ErrIrreversible)
+			}
+			reverted = append(reverted, migration)
+		}
+
+		for _, migration := range reverted {
+			migrationLogger.
+				WithContext(ctx).
+				WithField("migration", migration.Name).
+				Info("Rolling back migration")
+
+			if err := m.execScript(ctx, tx, migration.Down); err != nil {
+				return fmt.Errorf("failed to roll back migration %s: %w", migration.Name, err)
+			}
+
+			_, err := tx.ExecContext(ctx, m.dialect.Rebind("DELETE FROM migrations WHERE name = ?"), migration.Name)
+			if err != nil {
+				return fmt.Errorf("failed to unrecord migration %s: %w", migration.Name, err)
+			}
+			names = append(names, migration.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Status reports every loaded or applied migration, ordered by name
+func (m *Manager) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.appliedMigrations(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*Migration, len(applied))
+	for _, migration := range applied {
+		byName[migration.Name] = migration
+	}
+
+	res := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		st := Status{Name: migration.Name, State: StatePending, Reversible: migration.Down != ""}
+		if a, ok := byName[migration.Name]; ok {
+			st.State, st.AppliedAt = StateApplied, a.AppliedAt
+			st.Drifted = drifted(a, migration)
+			delete(byName, migration.Name)
+		}
+		res = append(res, st)
+	}
+	for _, migration := range byName {
+		res = append(res, Status{Name: migration.Name, State: StateMissing, AppliedAt: migration.AppliedAt})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// inTx runs fn in a transaction, committed if fn succeeds
+func (m *Manager) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			migrationLogger.WithContext(ctx).WithError(rbErr).Error("Failed to rollback transaction")
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
 	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return nil
+}
 
+// execScript runs the statements of a migration script
+func (m *Manager) execScript(ctx context.Context, tx *sql.Tx, script string) error {
+	for _, stmt := range splitStatements(script, m.dialect) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // AddMigration adds a migration to the manager
 func (m *Manager) AddMigration(name, sql string) {
+	m.AddReversibleMigration(name, sql, "")
+}
+
+// AddReversibleMigration adds a migration reverted by down to the manager
+func (m *Manager) AddReversibleMigration(name, up, down string) {
 	m.migrations = append(m.migrations, &Migration{
-		Name: name,
-		SQL:  sql,
+		Name:     name,
+		SQL:      up,
+		Down:     down,
+		Checksum: checksum(up),
 	})
 }
 
+// checksum identifies the SQL of a migration
+func checksum(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
 // GetMigrations returns the list of migrations
 func (m *Manager) GetMigrations() []*Migration {
 	return m.migrations
 }
 
-// splitStatements splits a SQL script into individual statements
-func splitStatements(script string) []string {
-	// Simple implementation - split by semicolon
-	// This doesn't handle all edge cases (like semicolons in strings)
-	// but works for most simple migration scripts
-	statements := strings.Split(script, ";")
-
-	// Clean up statements
-	var result []string
-	for _, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt != "" {
-			result = append(result, stmt)
+// parseFileName returns the name a migration file is recorded under, the
+// dialect it is restricted to, if any, and whether it is a down script
+func parseFileName(file string) (string, string, bool) {
+	base := strings.TrimSuffix(file, ".sql")
+	d := ""
+	for _, dia := range dialect.Dialects {
+		if name, ok := strings.CutSuffix(base, "."+dia.Name()); ok {
+			base, d = name, dia.Name()
+			break
 		}
 	}
 
-	return result
+	if name, ok := strings.CutSuffix(base, ".down"); ok {
+		return name + ".sql", d, true
+	}
+	base = strings.TrimSuffix(base, ".up")
+	return base + ".sql", d, false
 }
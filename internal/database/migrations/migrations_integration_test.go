@@ -10,6 +10,7 @@ import (
 	"github.com/GoldenDeals/DepGit/internal/config"
 	"github.com/GoldenDeals/DepGit/internal/database"
 	"github.com/GoldenDeals/DepGit/internal/database/migrations"
+	schema "github.com/GoldenDeals/DepGit/migrations"
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -107,3 +108,83 @@ func TestMigrationsIntegration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 3, migrationCount)
 }
+
+func TestEmbeddedMigrations(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "test.db")
+
+	t.Run("Applies the embedded schema by default", func(t *testing.T) {
+		db := &database.DB{}
+		require.NoError(t, db.Init(&config.Configuration{DB: config.DBConfig{Path: dbPath}}))
+		defer db.Close()
+
+		embedded := migrations.New(db.DB())
+		require.NoError(t, embedded.LoadFromFS(context.Background(), schema.FS))
+		assert.NotEmpty(t, embedded.GetMigrations())
+
+		user := database.NewUser("embedded", "embedded@example.com")
+		assert.NoError(t, db.CreateUser(context.Background(), &user))
+
+		var count int
+		require.NoError(t, db.DB().QueryRow("SELECT count(*) FROM migrations").Scan(&count))
+		assert.Equal(t, len(embedded.GetMigrations()), count)
+	})
+
+	t.Run("Rolls back soft delete", func(t *testing.T) {
+		ctx := context.Background()
+		db := &database.DB{}
+		require.NoError(t, db.Init(&config.Configuration{DB: config.DBConfig{Path: filepath.Join(tempDir, "rollback.db")}}))
+		defer db.Close()
+
+		live := database.NewUser("live", "live@example.com")
+		require.NoError(t, db.CreateUser(ctx, &live))
+		deleted := database.NewUser("deleted", "deleted@example.com")
+		require.NoError(t, db.CreateUser(ctx, &deleted))
+		key := database.NewSShKey("laptop", database.SSH_ED25519, []byte("ssh-ed25519 AAAA"))
+		require.NoError(t, db.AddSshKey(ctx, deleted.ID, &key))
+		require.NoError(t, db.DeleteUser(ctx, deleted.ID))
+
+		manager := migrations.New(db.DB())
+		require.NoError(t, manager.LoadFromFS(ctx, schema.FS))
+		rolledBack, err := manager.Rollback(ctx, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"004_unique_keys.sql", "003_soft_delete.sql"}, rolledBack)
+
+		var ids []string
+		rows, err := db.DB().Query("SELECT id FROM users WHERE edited = '0001-01-01 00:00:00' AND deleted = '0001-01-01 00:00:00'")
+		require.NoError(t, err)
+		for rows.Next() {
+			var id string
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		require.NoError(t, rows.Close())
+		assert.Equal(t, []string{live.ID.String()}, ids, "Deleted users are removed, live ones get zero times")
+
+		var keys int
+		require.NoError(t, db.DB().QueryRow("SELECT count(*) FROM keys").Scan(&keys))
+		assert.Zero(t, keys)
+
+		require.NoError(t, manager.Apply(ctx))
+		_, err = db.GetUser(ctx, live.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Fails on an empty override directory", func(t *testing.T) {
+		db := &database.DB{}
+		err := db.Init(&config.Configuration{DB: config.DBConfig{
+			Path:           filepath.Join(tempDir, "empty.db"),
+			MigrationsPath: t.TempDir(),
+		}})
+		assert.Error(t, err)
+	})
+
+	t.Run("Fails on a missing override directory", func(t *testing.T) {
+		db := &database.DB{}
+		err := db.Init(&config.Configuration{DB: config.DBConfig{
+			Path:           filepath.Join(tempDir, "missing.db"),
+			MigrationsPath: filepath.Join(tempDir, "missing"),
+		}})
+		assert.Error(t, err)
+	})
+}
@@ -3,10 +3,17 @@ package migrations
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/GoldenDeals/DepGit/internal/database/dialect"
+	_ "github.com/lib/pq"           // PostgreSQL driver
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -78,6 +85,65 @@ func TestMigrationManager_Initialize(t *testing.T) {
 	assert.Equal(t, 1, count)
 }
 
+// postgresDSNEnv names the PostgreSQL server the tests also run against
+const postgresDSNEnv = "DEPGIT_TEST_POSTGRES_DSN"
+
+func TestMigrationManager_ConcurrentInitialize(t *testing.T) {
+	// initializeAll initializes the migrations of one database from several
+	// instances at once
+	initializeAll := func(t *testing.T, d dialect.Dialect, dsn string) {
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				db, err := sql.Open(d.Driver(), dsn)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				defer db.Close()
+				errs[i] = NewWithDialect(db, d).Initialize(context.Background())
+			}()
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+	}
+
+	t.Run("SQLite", func(t *testing.T) {
+		initializeAll(t, dialect.SQLite, dialect.SQLite.DSN(filepath.Join(t.TempDir(), "test.db")))
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		dsn := os.Getenv(postgresDSNEnv)
+		if dsn == "" {
+			t.Skip(postgresDSNEnv + " is not set")
+		}
+
+		admin, err := sql.Open("postgres", dsn)
+		require.NoError(t, err)
+		defer admin.Close()
+
+		schema := fmt.Sprintf("depgit_test_bootstrap_%d", time.Now().UnixNano())
+		_, err = admin.Exec("CREATE SCHEMA " + schema)
+		require.NoError(t, err)
+		defer admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		if !strings.Contains(dsn, "://") {
+			sep = " "
+		}
+		initializeAll(t, dialect.Postgres, dsn+sep+"search_path="+schema)
+	})
+}
+
 func TestMigrationManager_LoadFromDir(t *testing.T) {
 	db, dbCleanup := setupTestDb(t)
 	defer dbCleanup()
@@ -178,3 +244,308 @@ func TestMigrationManager_AddMigration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
 }
+
+func TestMigrationManager_LoadDialectSpecific(t *testing.T) {
+	db, dbCleanup := setupTestDb(t)
+	defer dbCleanup()
+
+	migrationDir, dirCleanup := createTestMigrationDir(t)
+	defer dirCleanup()
+
+	err := os.WriteFile(filepath.Join(migrationDir, "001_test_migration.postgres.sql"), []byte("CREATE TABLE test_table (id SERIAL PRIMARY KEY);"), 0644)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// SQLite ignores the PostgreSQL variant
+	manager := New(db)
+	require.NoError(t, manager.LoadFromDir(ctx, migrationDir))
+	migrations := manager.GetMigrations()
+	require.Len(t, migrations, 2)
+	assert.Contains(t, migrations[0].SQL, "INTEGER PRIMARY KEY")
+
+	// PostgreSQL replaces the generic migration, keeping its name
+	manager = NewWithDialect(db, dialect.Postgres)
+	require.NoError(t, manager.LoadFromDir(ctx, migrationDir))
+	migrations = manager.GetMigrations()
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "001_test_migration.sql", migrations[0].Name)
+	assert.Contains(t, migrations[0].SQL, "SERIAL")
+	assert.Equal(t, "002_test_migration.sql", migrations[1].Name)
+}
+
+func TestMigrationManager_LoadReversible(t *testing.T) {
+	db, dbCleanup := setupTestDb(t)
+	defer dbCleanup()
+
+	migrationDir, dirCleanup := createTestMigrationDir(t)
+	defer dirCleanup()
+
+	require.NoError(t, os.Rename(filepath.Join(migrationDir, "002_test_migration.sql"), filepath.Join(migrationDir, "002_test_migration.up.sql")))
+	files := map[string]string{
+		"002_test_migration.down.sql":          "DROP TABLE another_table;",
+		"002_test_migration.down.postgres.sql": "DROP TABLE another_table CASCADE;",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(migrationDir, name), []byte(content), 0644))
+	}
+
+	ctx := context.Background()
+	manager := New(db)
+	require.NoError(t, manager.LoadFromDir(ctx, migrationDir))
+	migrations := manager.GetMigrations()
+	require.Len(t, migrations, 2)
+	assert.Empty(t, migrations[0].Down)
+	assert.Equal(t, "002_test_migration.sql", migrations[1].Name)
+	assert.Contains(t, migrations[1].SQL, "CREATE TABLE another_table")
+	assert.Equal(t, "DROP TABLE another_table;", migrations[1].Down)
+
+	manager = NewWithDialect(db, dialect.Postgres)
+	require.NoError(t, manager.LoadFromDir(ctx, migrationDir))
+	assert.Equal(t, "DROP TABLE another_table CASCADE;", manager.GetMigrations()[1].Down)
+
+	t.Run("Rejects ambiguous migrations", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(migrationDir, "002_test_migration.sql"), []byte("SELECT 1;"), 0644))
+		defer os.Remove(filepath.Join(migrationDir, "002_test_migration.sql"))
+		assert.Error(t, New(db).LoadFromDir(ctx, migrationDir))
+	})
+
+	t.Run("Rejects down scripts without up script", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(migrationDir, "003_orphan.down.sql"), []byte("SELECT 1;"), 0644))
+		defer os.Remove(filepath.Join(migrationDir, "003_orphan.down.sql"))
+		assert.Error(t, New(db).LoadFromDir(ctx, migrationDir))
+	})
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?", name).Scan(&count)
+	require.NoError(t, err)
+	return count == 1
+}
+
+func TestMigrationManager_Rollback(t *testing.T) {
+	db, cleanup := setupTestDb(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	manager := New(db)
+	manager.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY);")
+	manager.AddReversibleMigration("002_second.sql", "CREATE TABLE second (id INTEGER PRIMARY KEY);", "DROP TABLE second;")
+	manager.AddReversibleMigration("003_third.sql", "CREATE TABLE third (id INTEGER PRIMARY KEY);", "DROP TABLE third;")
+	require.NoError(t, manager.Initialize(ctx))
+	require.NoError(t, manager.Apply(ctx))
+
+	t.Run("Rejects rolling back irreversible migrations", func(t *testing.T) {
+		_, err := manager.Rollback(ctx, 3)
+		assert.ErrorIs(t, err, ErrIrreversible)
+		assert.True(t, tableExists(t, db, "third"), "Nothing is rolled back")
+
+		_, err = manager.Rollback(ctx, 4)
+		assert.Error(t, err)
+		_, err = manager.Rollback(ctx, 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("Rolls back the newest migrations", func(t *testing.T) {
+		names, err := manager.Rollback(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"003_third.sql", "002_second.sql"}, names)
+		assert.False(t, tableExists(t, db, "second"))
+		assert.False(t, tableExists(t, db, "third"))
+		assert.True(t, tableExists(t, db, "first"))
+
+		applied, err := manager.GetAppliedMigrations(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"001_first.sql": true}, applied)
+	})
+
+	t.Run("Reapplies rolled back migrations", func(t *testing.T) {
+		require.NoError(t, manager.Apply(ctx))
+		assert.True(t, tableExists(t, db, "third"))
+	})
+
+	t.Run("Rejects rolling back missing migrations", func(t *testing.T) {
+		older := New(db)
+		older.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY);")
+		_, err := older.Rollback(ctx, 1)
+		assert.ErrorIs(t, err, ErrMissing)
+	})
+}
+
+func TestMigrationManager_Status(t *testing.T) {
+	db, cleanup := setupTestDb(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	manager := New(db)
+	manager.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY);")
+	manager.AddReversibleMigration("002_second.sql", "CREATE TABLE second (id INTEGER PRIMARY KEY);", "DROP TABLE second;")
+	require.NoError(t, manager.Initialize(ctx))
+	require.NoError(t, manager.Apply(ctx))
+
+	// A newer version added a migration, an older one removed one
+	current := New(db)
+	current.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY);")
+	current.AddMigration("003_third.sql", "CREATE TABLE third (id INTEGER PRIMARY KEY);")
+
+	status, err := current.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, 3)
+
+	assert.Equal(t, "001_first.sql", status[0].Name)
+	assert.Equal(t, StateApplied, status[0].State)
+	assert.False(t, status[0].AppliedAt.IsZero())
+	assert.False(t, status[0].Reversible)
+
+	assert.Equal(t, "002_second.sql", status[1].Name)
+	assert.Equal(t, StateMissing, status[1].State)
+	assert.False(t, status[1].AppliedAt.IsZero())
+
+	assert.Equal(t, "003_third.sql", status[2].Name)
+	assert.Equal(t, StatePending, status[2].State)
+	assert.True(t, status[2].AppliedAt.IsZero())
+}
+
+func TestMigrationManager_LoadFromFS(t *testing.T) {
+	db, cleanup := setupTestDb(t)
+	defer cleanup()
+
+	fsys := fstest.MapFS{
+		"001_first.up.sql":   {Data: []byte("CREATE TABLE first (id INTEGER PRIMARY KEY);")},
+		"001_first.down.sql": {Data: []byte("DROP TABLE first;")},
+		"002_second.sql":     {Data: []byte("CREATE TABLE second (id INTEGER PRIMARY KEY);")},
+		"README.md":          {Data: []byte("Not a migration")},
+		"nested/003_x.sql":   {Data: []byte("CREATE TABLE nested (id INTEGER PRIMARY KEY);")},
+	}
+
+	ctx := context.Background()
+	manager := New(db)
+	require.NoError(t, manager.LoadFromFS(ctx, fsys))
+
+	migrations := manager.GetMigrations()
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "001_first.sql", migrations[0].Name)
+	assert.Equal(t, "DROP TABLE first;", migrations[0].Down)
+	assert.Equal(t, "002_second.sql", migrations[1].Name)
+
+	require.NoError(t, manager.Initialize(ctx))
+	require.NoError(t, manager.Apply(ctx))
+	assert.True(t, tableExists(t, db, "second"))
+}
+
+func TestMigrationManager_Drift(t *testing.T) {
+	db, cleanup := setupTestDb(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	manager := New(db)
+	manager.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY);")
+	require.NoError(t, manager.Initialize(ctx))
+	require.NoError(t, manager.Apply(ctx))
+
+	// Someone edited the applied migration
+	edited := New(db)
+	edited.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY, name TEXT);")
+	edited.AddMigration("002_second.sql", "CREATE TABLE second (id INTEGER PRIMARY KEY);")
+
+	t.Run("Refuses to apply", func(t *testing.T) {
+		err := edited.Apply(ctx)
+		assert.ErrorIs(t, err, ErrDrift)
+		assert.ErrorContains(t, err, "001_first.sql")
+		assert.False(t, tableExists(t, db, "second"))
+	})
+
+	t.Run("Reports the changed migrations", func(t *testing.T) {
+		status, err := edited.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, status, 2)
+		assert.True(t, status[0].Drifted)
+		assert.False(t, status[1].Drifted)
+	})
+
+	t.Run("Applies when allowed", func(t *testing.T) {
+		edited.AllowDrift(true)
+		require.NoError(t, edited.Apply(ctx))
+		assert.True(t, tableExists(t, db, "second"))
+
+		// The recorded checksum is kept, the drift is still reported
+		status, err := edited.Status(ctx)
+		require.NoError(t, err)
+		assert.True(t, status[0].Drifted)
+	})
+}
+
+func TestMigrationManager_LegacyTable(t *testing.T) {
+	db, cleanup := setupTestDb(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// The migrations table before checksums were recorded
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE migrations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE first (id INTEGER PRIMARY KEY);
+		INSERT INTO migrations (name) VALUES ('001_first.sql');
+	`)
+	require.NoError(t, err)
+
+	manager := New(db)
+	manager.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY);")
+	require.NoError(t, manager.Initialize(ctx))
+	require.NoError(t, manager.Initialize(ctx), "Initialize is idempotent")
+	require.NoError(t, manager.Apply(ctx))
+
+	applied, err := manager.GetAppliedMigrations(ctx)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+
+	var sum string
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT checksum FROM migrations WHERE name = '001_first.sql'").Scan(&sum))
+	assert.Equal(t, manager.GetMigrations()[0].Checksum, sum, "The checksum is recorded for existing migrations")
+
+	edited := New(db)
+	edited.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY, name TEXT);")
+	assert.ErrorIs(t, edited.Apply(ctx), ErrDrift)
+}
+
+func TestMigrationManager_Lock(t *testing.T) {
+	db, cleanup := setupTestDb(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	require.NoError(t, New(db).Initialize(ctx))
+
+	// Instances starting at once, each with its own connection. The
+	// migration fails if it runs twice.
+	const instances = 4
+	errs := make(chan error, instances)
+	for range instances {
+		conn, err := sql.Open("sqlite3", dbPath(t, db))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		manager := New(conn)
+		manager.AddMigration("001_first.sql", "CREATE TABLE first (id INTEGER PRIMARY KEY);")
+		go func() { errs <- manager.Apply(ctx) }()
+	}
+	for range instances {
+		assert.NoError(t, <-errs)
+	}
+
+	var count int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM migrations").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+// dbPath returns the file of an SQLite database
+func dbPath(t *testing.T, db *sql.DB) string {
+	var seq int
+	var name, file string
+	require.NoError(t, db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file))
+	return file
+}
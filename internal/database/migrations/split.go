@@ -0,0 +1,239 @@
+package migrations
+
+import (
+	"strings"
+
+	"github.com/GoldenDeals/DepGit/internal/database/dialect"
+)
+
+// splitStatements splits a SQL script into individual statements at the
+// semicolons ending them. Semicolons are not ends of statements within:
+//
+//   - string literals ('...', E'...' with backslash escapes), quoted
+//     identifiers ("..." and `...`) and dollar-quoted strings ($$...$$,
+//     $tag$...$tag$) such as PostgreSQL function bodies
+//   - comments (-- to the end of the line, and /* ... */, which nest in
+//     PostgreSQL but not in SQLite)
+//   - the BEGIN ... END body of a CREATE TRIGGER, or of a CREATE FUNCTION
+//     or PROCEDURE using BEGIN ATOMIC, with their CASE ... END expressions
+//
+// Statements are trimmed and those holding only comments are dropped.
+func splitStatements(script string, d dialect.Dialect) []string {
+	s := splitter{script: script, nested: d == dialect.Postgres}
+	return s.split()
+}
+
+// splitter holds the state of splitStatements
+type splitter struct {
+	script string
+	pos    int
+	// nested is set if block comments nest
+	nested bool
+
+	// start is where the current statement begins
+	start int
+	// code is set once the current statement has more than comments
+	code bool
+	// words are the first words of the current statement, to detect blocks
+	words []string
+	// block is set within statements which may have BEGIN ... END bodies,
+	// depth counts their open BEGIN and CASE blocks
+	block bool
+	depth int
+
+	statements []string
+}
+
+func (s *splitter) split() []string {
+	for s.pos < len(s.script) {
+		c := s.script[s.pos]
+		switch {
+		case c == ';':
+			if s.depth > 0 {
+				s.pos++
+				continue
+			}
+			s.end(s.pos)
+			s.pos++
+			s.start = s.pos
+		case c == '-' && s.peek(1) == '-':
+			s.skipLineComment()
+		case c == '/' && s.peek(1) == '*':
+			s.skipBlockComment()
+		case c == '\'':
+			s.code = true
+			s.skipQuoted(c, s.escapes())
+		case c == '"' || c == '`':
+			s.code = true
+			s.skipQuoted(c, false)
+		case c == '$' && s.dollarQuote():
+			s.code = true
+		case isWordStart(c):
+			s.code = true
+			s.word()
+		default:
+			if !isSpace(c) {
+				s.code = true
+			}
+			s.pos++
+		}
+	}
+	s.end(len(s.script))
+	return s.statements
+}
+
+// end records the statement ending at pos
+func (s *splitter) end(pos int) {
+	if stmt := strings.TrimSpace(s.script[s.start:pos]); s.code && stmt != "" {
+		s.statements = append(s.statements, stmt)
+	}
+	s.code, s.words, s.block, s.depth = false, nil, false, 0
+}
+
+func (s *splitter) peek(n int) byte {
+	if s.pos+n < len(s.script) {
+		return s.script[s.pos+n]
+	}
+	return 0
+}
+
+func (s *splitter) skipLineComment() {
+	if i := strings.IndexByte(s.script[s.pos:], '\n'); i >= 0 {
+		s.pos += i + 1
+	} else {
+		s.pos = len(s.script)
+	}
+}
+
+// skipBlockComment skips a /* */ comment. If comments nest, nested ones
+// are closed separately, otherwise the first */ ends the comment.
+func (s *splitter) skipBlockComment() {
+	s.pos += 2
+	for nesting := 1; nesting > 0 && s.pos < len(s.script); {
+		switch {
+		case s.nested && s.script[s.pos] == '/' && s.peek(1) == '*':
+			nesting++
+			s.pos += 2
+		case s.script[s.pos] == '*' && s.peek(1) == '/':
+			nesting--
+			s.pos += 2
+		default:
+			s.pos++
+		}
+	}
+}
+
+// escapes reports whether the string literal at pos is an escape string,
+// E'...', in which backslashes escape quotes
+func (s *splitter) escapes() bool {
+	if s.pos == 0 || (s.script[s.pos-1] != 'E' && s.script[s.pos-1] != 'e') {
+		return false
+	}
+	return s.pos == 1 || !isWordPart(s.script[s.pos-2])
+}
+
+// skipQuoted skips text between quote characters, in which the quote is
+// escaped by doubling it, or by a backslash if backslash is set
+func (s *splitter) skipQuoted(quote byte, backslash bool) {
+	s.pos++
+	for s.pos < len(s.script) {
+		c := s.script[s.pos]
+		switch {
+		case backslash && c == '\\':
+			s.pos += 2
+		case c == quote && s.peek(1) == quote:
+			s.pos += 2
+		case c == quote:
+			s.pos++
+			return
+		default:
+			s.pos++
+		}
+	}
+}
+
+// dollarQuote skips a dollar-quoted string if one starts at pos and
+// reports whether it did. Positional parameters like $1 are not quotes.
+func (s *splitter) dollarQuote() bool {
+	if s.pos > 0 && isWordPart(s.script[s.pos-1]) {
+		return false
+	}
+	end := s.pos + 1
+	for end < len(s.script) && s.script[end] != '$' {
+		c := s.script[end]
+		if !isWordPart(c) || (end == s.pos+1 && isDigit(c)) {
+			return false
+		}
+		end++
+	}
+	if end >= len(s.script) {
+		return false
+	}
+
+	tag := s.script[s.pos : end+1]
+	if i := strings.Index(s.script[end+1:], tag); i >= 0 {
+		s.pos = end + 1 + i + len(tag)
+	} else {
+		s.pos = len(s.script)
+	}
+	return true
+}
+
+// word reads a keyword or identifier, tracking BEGIN ... END blocks
+func (s *splitter) word() {
+	begin := s.pos
+	for s.pos < len(s.script) && isWordPart(s.script[s.pos]) {
+		s.pos++
+	}
+	word := strings.ToUpper(s.script[begin:s.pos])
+
+	if !s.block && len(s.words) < 5 {
+		s.words = append(s.words, word)
+		s.block = hasBlocks(s.words)
+	}
+	if !s.block {
+		return
+	}
+	switch word {
+	case "BEGIN", "CASE":
+		s.depth++
+	case "END":
+		if s.depth > 0 {
+			s.depth--
+		}
+	}
+}
+
+// hasBlocks reports whether a statement starting with words creates a
+// trigger, function or procedure, whose body may be a BEGIN ... END block
+func hasBlocks(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	for _, word := range words[1:] {
+		switch word {
+		case "OR", "REPLACE", "TEMP", "TEMPORARY":
+		case "TRIGGER", "FUNCTION", "PROCEDURE":
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
@@ -0,0 +1,176 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoldenDeals/DepGit/internal/database/dialect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "Statements",
+			script: "CREATE TABLE a (id INTEGER);\n\nCREATE TABLE b (id INTEGER);  \n",
+			want:   []string{"CREATE TABLE a (id INTEGER)", "CREATE TABLE b (id INTEGER)"},
+		},
+		{
+			name:   "Last statement without semicolon",
+			script: "SELECT 1; SELECT 2",
+			want:   []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:   "Empty statements",
+			script: ";; ;\n",
+			want:   nil,
+		},
+		{
+			name:   "String literals",
+			script: "INSERT INTO t VALUES ('a;b', 'it''s; fine'); SELECT 1;",
+			want:   []string{"INSERT INTO t VALUES ('a;b', 'it''s; fine')", "SELECT 1"},
+		},
+		{
+			name:   "Escape strings",
+			script: `INSERT INTO t VALUES (E'it\'s; fine'); SELECT 1;`,
+			want:   []string{`INSERT INTO t VALUES (E'it\'s; fine')`, "SELECT 1"},
+		},
+		{
+			name:   "Backslashes in plain strings",
+			script: `INSERT INTO t VALUES ('C:\'); SELECT 1;`,
+			want:   []string{`INSERT INTO t VALUES ('C:\')`, "SELECT 1"},
+		},
+		{
+			name:   "Quoted identifiers",
+			script: "CREATE TABLE \"a;b\" (`c;d` TEXT); SELECT 1;",
+			want:   []string{"CREATE TABLE \"a;b\" (`c;d` TEXT)", "SELECT 1"},
+		},
+		{
+			name:   "Line comments",
+			script: "-- create a; then b\nCREATE TABLE a (id INTEGER); -- done;\nSELECT 1;\n-- trailing; comment",
+			want:   []string{"-- create a; then b\nCREATE TABLE a (id INTEGER)", "-- done;\nSELECT 1"},
+		},
+		{
+			name:   "Block comments",
+			script: "/* a; b; */ SELECT 1; /* only a comment; */",
+			want:   []string{"/* a; b; */ SELECT 1"},
+		},
+		{
+			name:   "Quotes in comments",
+			script: "-- it's\nSELECT 1; /* \"a */ SELECT 2;",
+			want:   []string{"-- it's\nSELECT 1", "/* \"a */ SELECT 2"},
+		},
+		{
+			name: "Trigger",
+			script: `CREATE TRIGGER t AFTER INSERT ON a
+BEGIN
+	UPDATE b SET n = CASE WHEN n IS NULL THEN 1 ELSE n + 1 END;
+	INSERT INTO log VALUES ('insert; a');
+END;
+CREATE TEMP TRIGGER IF NOT EXISTS u AFTER DELETE ON a BEGIN DELETE FROM b; END;
+SELECT CASE WHEN 1 THEN 2 END;`,
+			want: []string{
+				`CREATE TRIGGER t AFTER INSERT ON a
+BEGIN
+	UPDATE b SET n = CASE WHEN n IS NULL THEN 1 ELSE n + 1 END;
+	INSERT INTO log VALUES ('insert; a');
+END`,
+				"CREATE TEMP TRIGGER IF NOT EXISTS u AFTER DELETE ON a BEGIN DELETE FROM b; END",
+				"SELECT CASE WHEN 1 THEN 2 END",
+			},
+		},
+		{
+			name:   "Transaction statements",
+			script: "BEGIN; SELECT 1; END;",
+			want:   []string{"BEGIN", "SELECT 1", "END"},
+		},
+		{
+			name: "Dollar quotes",
+			script: `CREATE FUNCTION f() RETURNS trigger AS $$
+BEGIN
+	NEW.edited := now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+DO $body$ BEGIN PERFORM 'a;$$'; END $body$;
+SELECT $1;`,
+			want: []string{
+				`CREATE FUNCTION f() RETURNS trigger AS $$
+BEGIN
+	NEW.edited := now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql`,
+				"DO $body$ BEGIN PERFORM 'a;$$'; END $body$",
+				"SELECT $1",
+			},
+		},
+		{
+			name:   "Atomic function bodies",
+			script: "CREATE OR REPLACE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT 2; END; SELECT f();",
+			want:   []string{"CREATE OR REPLACE FUNCTION f() RETURNS int LANGUAGE sql BEGIN ATOMIC SELECT 1; SELECT 2; END", "SELECT f()"},
+		},
+		{
+			name:   "Unterminated quote",
+			script: "SELECT 1; SELECT 'a;b",
+			want:   []string{"SELECT 1", "SELECT 'a;b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, d := range dialect.Dialects {
+				assert.Equal(t, tt.want, splitStatements(tt.script, d), d.Name())
+			}
+		})
+	}
+}
+
+func TestSplitStatements_NestedComments(t *testing.T) {
+	script := "/* a /* b */ SELECT 1; /* c */ SELECT 2;"
+
+	// SQLite ends a comment at the first */
+	assert.Equal(t, []string{"/* a /* b */ SELECT 1", "/* c */ SELECT 2"}, splitStatements(script, dialect.SQLite))
+
+	// PostgreSQL nests them, the comment opened first is never closed
+	assert.Empty(t, splitStatements(script, dialect.Postgres))
+	assert.Equal(t,
+		[]string{"/* a; /* nested; */ b; */ SELECT 1"},
+		splitStatements("/* a; /* nested; */ b; */ SELECT 1; /* only a comment; */", dialect.Postgres))
+}
+
+func TestMigrationManager_ApplyTrigger(t *testing.T) {
+	db, cleanup := setupTestDb(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	manager := New(db)
+	manager.AddMigration("001_counter.sql", `
+		-- Counts the rows of items; kept by a trigger
+		CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE counter (n INTEGER NOT NULL);
+		INSERT INTO counter VALUES (0);
+
+		CREATE TRIGGER count_items AFTER INSERT ON items
+		BEGIN
+			UPDATE counter SET n = n + 1;
+		END;
+
+		INSERT INTO items (name) VALUES ('first; of many');
+	`)
+	require.NoError(t, manager.Initialize(ctx))
+	require.NoError(t, manager.Apply(ctx))
+
+	var n int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT n FROM counter").Scan(&n))
+	assert.Equal(t, 1, n)
+
+	var name string
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT name FROM items").Scan(&name))
+	assert.Equal(t, "first; of many", name)
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// DefaultPurgeInterval is how often Loop purges by default
+const DefaultPurgeInterval = time.Hour
+
+// Purger removes deleted records for good once their retention expired.
+type Purger struct {
+	Store Store
+
+	// Retention is how long deleted records stay restorable. Zero keeps
+	// them forever.
+	Retention time.Duration
+
+	// PurgeRepo, if set, removes the data of a purged repository, e.g. its
+	// storage namespace. It runs once the record purge is committed, so a
+	// slow deletion holds no transaction open; if it fails it is retried on
+	// the next run of the same Purger. Data is stored by repository name, so
+	// PurgeRepo is skipped while a live repository has the name of the
+	// purged one: the data is that repository's now.
+	PurgeRepo func(ctx context.Context, repo DeletedRecord) error
+
+	mu sync.Mutex
+	// pending holds the purged repositories whose data PurgeRepo failed to
+	// remove
+	pending map[IDT]DeletedRecord
+}
+
+// Run purges the records deleted longer than the retention ago and reports
+// how many it purged. Records failing to purge are skipped, their errors
+// are joined into the returned error.
+func (p *Purger) Run(ctx context.Context) (int, error) {
+	if p.Retention <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-p.Retention)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		purged int
+		errs   []error
+	)
+	for id, rec := range p.pending {
+		if err := p.purgeRepoData(ctx, rec); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(p.pending, id)
+	}
+
+	// Entities are ordered owners first, so the records deleted along with
+	// an owner are gone before their own entity is listed
+	for _, entity := range Entities {
+		records, err := p.Store.GetDeleted(ctx, entity)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, rec := range records {
+			if !rec.Deleted.Before(cutoff) {
+				break
+			}
+
+			err := p.Store.Purge(ctx, entity, rec.ID)
+			switch {
+			case err == nil:
+				purged++
+				if entity != EntityRepo {
+					break
+				}
+				if err := p.purgeRepoData(ctx, rec); err != nil {
+					if p.pending == nil {
+						p.pending = make(map[IDT]DeletedRecord)
+					}
+					p.pending[rec.ID] = rec
+					errs = append(errs, err)
+				}
+			case stderrors.Is(err, errors.ErrNotFound):
+				// Purged or restored meanwhile
+			default:
+				dbLogger.
+					WithContext(ctx).
+					WithField("entity", entity).
+					WithField("id", rec.ID).
+					WithError(err).
+					Warn("error purging record")
+				errs = append(errs, err)
+			}
+		}
+	}
+	return purged, stderrors.Join(errs...)
+}
+
+// purgeRepoData runs PurgeRepo for a purged repository unless its name is
+// in use again
+func (p *Purger) purgeRepoData(ctx context.Context, rec DeletedRecord) error {
+	if p.PurgeRepo == nil {
+		return nil
+	}
+	live, err := repoNameInUse(ctx, p.Store, rec.Name)
+	if err == nil && !live {
+		err = p.PurgeRepo(ctx, rec)
+	}
+	if err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("repo", rec.Name).
+			WithError(err).
+			Warn("error removing the data of a purged repository")
+	}
+	return err
+}
+
+// repoNameInUse reports whether a live repository is named name
+func repoNameInUse(ctx context.Context, st Store, name string) (bool, error) {
+	repos, err := st.GetRepos(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, repo := range repos {
+		if repo.Name == name {
+			dbLogger.
+				WithContext(ctx).
+				WithField("repo", name).
+				Info("Keeping the data of a purged repository, its name is in use")
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Loop runs the purger every interval until ctx is done. A non-positive
+// interval uses DefaultPurgeInterval.
+func (p *Purger) Loop(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := p.Run(ctx)
+		if err != nil {
+			dbLogger.WithContext(ctx).WithError(err).Error("Purge failed")
+		}
+		if purged > 0 {
+			dbLogger.WithContext(ctx).WithField("purged", purged).Info("Purged deleted records")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
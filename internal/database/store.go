@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+)
+
+// UserStore manages DepGit users.
+type UserStore interface {
+	CreateUser(ctx context.Context, user *User) error
+	EditUser(ctx context.Context, userid IDT, user *User) error
+	DeleteUser(ctx context.Context, userid IDT) error
+	GetUser(ctx context.Context, userid IDT) (User, error)
+	GetUsers(ctx context.Context) ([]User, error)
+	ListUsers(ctx context.Context, opts ListOptions) (Page[User], error)
+	UserByKey(ctx context.Context, key []byte) (User, error)
+}
+
+// KeyStore manages the SSH and OpenPGP keys registered by users.
+type KeyStore interface {
+	AddSshKey(ctx context.Context, userid IDT, key *SshKey) error
+	DeleteSshKey(ctx context.Context, keyid IDT) error
+	GetSshKeys(ctx context.Context, userID IDT) ([]SshKey, error)
+	ListSshKeys(ctx context.Context, userID IDT, opts ListOptions) (Page[SshKey], error)
+	GetKeysByType(ctx context.Context, types ...SSH_KEY_TYPE) ([]SshKey, error)
+}
+
+// RepoStore manages repositories.
+type RepoStore interface {
+	CreateRepo(ctx context.Context, repo *Repo) error
+	DeleteRepo(ctx context.Context, repoid IDT) error
+	UpdateRepo(ctx context.Context, repoid IDT, repo *Repo) error
+	GetRepo(ctx context.Context, repoid IDT) (Repo, error)
+	GetRepos(ctx context.Context) ([]Repo, error)
+	ListRepos(ctx context.Context, opts ListOptions) (Page[Repo], error)
+}
+
+// RoleStore manages the access roles of users on repositories.
+type RoleStore interface {
+	CreateAccessRole(ctx context.Context, ar *AccessRole) error
+	EditAccessRole(ctx context.Context, roleid IDT, ar *AccessRole) error
+	DeleteAccessRole(ctx context.Context, roleid IDT) error
+	GetAccessRole(ctx context.Context, roleid IDT) (AccessRole, error)
+	GetAccessRoles(ctx context.Context) ([]AccessRole, error)
+	ListAccessRoles(ctx context.Context, repoID IDT, opts ListOptions) (Page[AccessRole], error)
+	CheckPermissions(ctx context.Context, userid, repoid IDT, branch string) (bool, error)
+}
+
+// TrashStore manages soft-deleted records. Deleting a user, key,
+// repository or role only marks it deleted, hiding it from every other
+// method, until it is restored or purged for good.
+type TrashStore interface {
+	// GetDeleted lists the deleted records of an entity, oldest first.
+	GetDeleted(ctx context.Context, entity Entity) ([]DeletedRecord, error)
+
+	// Restore undeletes a record together with the records deleted along
+	// with it. A record whose owner, e.g. the user of a key, is deleted
+	// cannot be restored and fails with errors.ErrBadData.
+	Restore(ctx context.Context, entity Entity, id IDT) error
+
+	// Purge removes a deleted record and the records deleted along with it.
+	Purge(ctx context.Context, entity Entity, id IDT) error
+}
+
+// Store is the whole data layer. Consumers should depend on the narrow
+// interfaces above where they can.
+type Store interface {
+	UserStore
+	KeyStore
+	RepoStore
+	RoleStore
+	TrashStore
+
+	// WithTx runs fn atomically: either all changes made through tx are
+	// kept or, if fn fails, none of them.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
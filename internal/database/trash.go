@@ -0,0 +1,275 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Entity names a kind of record that is deleted softly.
+type Entity string
+
+const (
+	EntityUser Entity = "user"
+	EntityKey  Entity = "key"
+	EntityRepo Entity = "repo"
+	EntityRole Entity = "role"
+)
+
+// Entities lists every kind of soft-deleted record, owners before the
+// records referencing them.
+var Entities = []Entity{EntityUser, EntityKey, EntityRepo, EntityRole}
+
+// DeletedRecord is a record marked deleted but not purged yet.
+type DeletedRecord struct {
+	Entity Entity
+	ID     IDT
+	// Name of the user, key or repository, empty for roles
+	Name    string
+	Deleted time.Time
+}
+
+// trashRef is a column of table referencing another record by its id
+type trashRef struct {
+	table  string
+	column string
+}
+
+// trashTable describes how records of an entity are stored
+type trashTable struct {
+	table string
+	id    string
+	name  string
+
+	// owners are the records this one references. They must be live for it
+	// to be restored.
+	owners []trashRef
+
+	// dependents reference this record. Deleting it deleted them at the
+	// same time, so they are restored and purged along with it.
+	dependents []trashRef
+}
+
+var trashTables = map[Entity]trashTable{
+	EntityUser: {
+		table:      "users",
+		id:         "id",
+		name:       "name",
+		dependents: []trashRef{{"roles", "user_id"}, {"keys", "user_id"}},
+	},
+	EntityKey: {
+		table:  "keys",
+		id:     "id",
+		name:   "name",
+		owners: []trashRef{{"users", "user_id"}},
+	},
+	EntityRepo: {
+		table:      "permitions",
+		id:         "id",
+		name:       "name",
+		dependents: []trashRef{{"roles", "rep_id"}},
+	},
+	EntityRole: {
+		table:  "roles",
+		id:     "role_id",
+		owners: []trashRef{{"users", "user_id"}, {"permitions", "rep_id"}},
+	},
+}
+
+// dependentTable returns the description of the table holding dependents
+func dependentTable(table string) trashTable {
+	for _, t := range trashTables {
+		if t.table == table {
+			return t
+		}
+	}
+	return trashTable{table: table, id: "id"}
+}
+
+func (d *DB) GetDeleted(ctx context.Context, entity Entity) ([]DeletedRecord, error) {
+	records := make([]DeletedRecord, 0, 16)
+	if err := ctx.Err(); err != nil {
+		return records, err
+	}
+	t, ok := trashTables[entity]
+	if !ok {
+		return records, errors.ErrBadData
+	}
+
+	name := "''"
+	if t.name != "" {
+		name = t.name
+	}
+	rows, err := d.conn.QueryContext(ctx, "SELECT "+t.id+", "+name+", deleted FROM "+t.table+" WHERE deleted IS NOT NULL ORDER BY deleted, "+t.id)
+	if err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("entity", entity).
+			WithError(err).
+			Warn("error fetching deleted records")
+		return records, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := DeletedRecord{Entity: entity}
+		var idStr string
+		if err := rows.Scan(&idStr, &rec.Name, &rec.Deleted); err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithField("entity", entity).
+				WithError(err).
+				Warn("error scanning deleted record")
+			return records, err
+		}
+
+		rec.ID, err = uuid.Parse(idStr)
+		if err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithField("entity", entity).
+				WithField("id", idStr).
+				WithError(err).
+				Warn("error parsing record id")
+			return records, err
+		}
+		records = append(records, rec)
+	}
+
+	if err := rows.Err(); err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("entity", entity).
+			WithError(err).
+			Warn("error iterating deleted records")
+		return records, err
+	}
+	return records, nil
+}
+
+func (d *DB) Restore(ctx context.Context, entity Entity, id IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t, ok := trashTables[entity]
+	if !ok || id == uuid.Nil {
+		return errors.ErrBadData
+	}
+	return d.inTx(ctx, func(tx *DB) error {
+		return tx.restore(ctx, t, id)
+	})
+}
+
+func (d *DB) restore(ctx context.Context, t trashTable, id IDT) error {
+	if err := d.checkDeleted(ctx, t, id); err != nil {
+		return err
+	}
+
+	for _, owner := range t.owners {
+		var n int
+		query := "SELECT COUNT(id) FROM " + owner.table + " WHERE deleted IS NULL AND id = (SELECT " + owner.column + " FROM " + t.table + " WHERE " + t.id + " = ?)"
+		if err := d.conn.QueryRow(d.rebind(query), id.String()).Scan(&n); err != nil {
+			dbLogger.WithContext(ctx).WithError(err).Warn("error checking record owner")
+			return err
+		}
+		if n == 0 {
+			return errors.ErrBadData
+		}
+	}
+
+	// Dependents first, while the deletion time they share is still there
+	for _, ref := range t.dependents {
+		query := "UPDATE " + ref.table + " SET deleted = NULL WHERE " + ref.column + " = ? AND deleted = (SELECT deleted FROM " + t.table + " WHERE " + t.id + " = ?)"
+		for _, owner := range dependentTable(ref.table).owners {
+			if owner.table != t.table {
+				query += " AND " + owner.column + " IN (SELECT id FROM " + owner.table + " WHERE deleted IS NULL)"
+			}
+		}
+		if _, err := d.conn.ExecContext(ctx, d.rebind(query), id.String(), id.String()); err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithField("table", ref.table).
+				WithError(err).
+				Warn("error restoring dependents")
+			return err
+		}
+	}
+
+	_, err := d.conn.ExecContext(ctx, d.rebind("UPDATE "+t.table+" SET deleted = NULL WHERE "+t.id+" = ?"), id.String())
+	if err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("table", t.table).
+			WithField("id", id).
+			WithError(err).
+			Warn("error restoring record")
+		return err
+	}
+	logrus.Trace("Restored ", t.table, id)
+	return nil
+}
+
+func (d *DB) Purge(ctx context.Context, entity Entity, id IDT) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t, ok := trashTables[entity]
+	if !ok || id == uuid.Nil {
+		return errors.ErrBadData
+	}
+	return d.inTx(ctx, func(tx *DB) error {
+		return tx.purge(ctx, t, id)
+	})
+}
+
+func (d *DB) purge(ctx context.Context, t trashTable, id IDT) error {
+	if err := d.checkDeleted(ctx, t, id); err != nil {
+		return err
+	}
+
+	queries := make([]string, 0, len(t.dependents)+1)
+	for _, ref := range t.dependents {
+		queries = append(queries, "DELETE FROM "+ref.table+" WHERE "+ref.column+" = ?")
+	}
+	queries = append(queries, "DELETE FROM "+t.table+" WHERE "+t.id+" = ?")
+
+	for _, query := range queries {
+		if _, err := d.conn.ExecContext(ctx, d.rebind(query), id.String()); err != nil {
+			dbLogger.
+				WithContext(ctx).
+				WithField("table", t.table).
+				WithField("id", id).
+				WithError(err).
+				Warn("error purging record")
+			return err
+		}
+	}
+	logrus.Trace("Purged ", t.table, id)
+	return nil
+}
+
+// checkDeleted fails with errors.ErrNotFound unless the record is deleted
+func (d *DB) checkDeleted(ctx context.Context, t trashTable, id IDT) error {
+	var deleted sql.NullTime
+	err := d.conn.QueryRow(d.rebind("SELECT deleted FROM "+t.table+" WHERE "+t.id+" = ?"), id.String()).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		return errors.ErrNotFound
+	}
+	if err != nil {
+		dbLogger.
+			WithContext(ctx).
+			WithField("table", t.table).
+			WithField("id", id).
+			WithError(err).
+			Warn("error checking deleted record")
+		return err
+	}
+	if !deleted.Valid {
+		return errors.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/GoldenDeals/DepGit/internal/database"
+	dberror "github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/google/uuid"
+	ase "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createOwnedRepo creates a user with a key and a role on a new repository
+func createOwnedRepo(t *testing.T, db Store, name string) (User, SshKey, Repo, AccessRole) {
+	ctx := context.Background()
+
+	user := NewUser(name, name+"@example.com")
+	require.NoError(t, db.CreateUser(ctx, &user))
+
+	key := NewSShKey(name+"-key", SSH_RSA, []byte("ssh-rsa "+name))
+	require.NoError(t, db.AddSshKey(ctx, user.ID, &key))
+
+	repo := NewRepo(name + "-repo")
+	require.NoError(t, db.CreateRepo(ctx, &repo))
+
+	role := AccessRole{RoleID: uuid.New(), UserID: user.ID, RepoID: repo.ID, Created: time.Now()}
+	require.NoError(t, db.CreateAccessRole(ctx, &role))
+
+	return user, key, repo, role
+}
+
+func deletedIDs(t *testing.T, db Store, entity Entity) []IDT {
+	records, err := db.GetDeleted(context.Background(), entity)
+	require.NoError(t, err)
+
+	ids := make([]IDT, 0, len(records))
+	for _, rec := range records {
+		assert := ase.New(t)
+		assert.Equal(entity, rec.Entity)
+		assert.False(rec.Deleted.IsZero())
+		ids = append(ids, rec.ID)
+	}
+	return ids
+}
+
+func TestSoftDelete(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		ctx := context.Background()
+
+		t.Run("Deleted users are hidden and restorable", func(t *testing.T) {
+			assert := ase.New(t)
+			user, key, repo, role := createOwnedRepo(t, db, "hidden")
+
+			assert.Nil(db.DeleteUser(ctx, user.ID))
+			assert.Equal(dberror.ErrNotFound, db.DeleteUser(ctx, user.ID))
+
+			_, err := db.GetUser(ctx, user.ID)
+			assert.Equal(dberror.ErrNotFound, err)
+			_, err = db.UserByKey(ctx, key.Data)
+			assert.Equal(dberror.ErrNotFound, err)
+			users, err := db.GetUsers(ctx)
+			assert.Nil(err)
+			for _, u := range users {
+				assert.NotEqual(user.ID, u.ID)
+			}
+
+			assert.Contains(deletedIDs(t, db, EntityUser), user.ID)
+			assert.Contains(deletedIDs(t, db, EntityKey), key.ID)
+			assert.Contains(deletedIDs(t, db, EntityRole), role.RoleID)
+
+			assert.Nil(db.Restore(ctx, EntityUser, user.ID))
+			assert.Equal(dberror.ErrNotFound, db.Restore(ctx, EntityUser, user.ID))
+
+			restored, err := db.UserByKey(ctx, key.Data)
+			assert.Nil(err)
+			assert.Equal(user.ID, restored.ID)
+
+			allowed, err := db.CheckPermissions(ctx, user.ID, repo.ID, "")
+			assert.Nil(err)
+			assert.True(allowed)
+		})
+
+		t.Run("Records deleted on their own stay deleted", func(t *testing.T) {
+			assert := ase.New(t)
+			user, key, _, _ := createOwnedRepo(t, db, "separate")
+
+			assert.Nil(db.DeleteSshKey(ctx, key.ID))
+			assert.Equal(dberror.ErrNotFound, db.DeleteSshKey(ctx, key.ID))
+			time.Sleep(time.Second) // Deletion times have a precision of seconds
+			assert.Nil(db.DeleteUser(ctx, user.ID))
+
+			assert.Equal(dberror.ErrBadData, db.Restore(ctx, EntityKey, key.ID), "The owner of the key is deleted")
+			assert.Nil(db.Restore(ctx, EntityUser, user.ID))
+
+			keys, err := db.GetSshKeys(ctx, user.ID)
+			assert.Nil(err)
+			assert.Empty(keys)
+
+			assert.Nil(db.Restore(ctx, EntityKey, key.ID))
+			keys, err = db.GetSshKeys(ctx, user.ID)
+			assert.Nil(err)
+			assert.Len(keys, 1)
+		})
+
+		t.Run("Deleted repositories take their roles along", func(t *testing.T) {
+			assert := ase.New(t)
+			user, _, repo, role := createOwnedRepo(t, db, "repo-owner")
+
+			assert.Nil(db.DeleteRepo(ctx, repo.ID))
+			_, err := db.GetRepo(ctx, repo.ID)
+			assert.Equal(dberror.ErrNotFound, err)
+			_, err = db.GetAccessRole(ctx, role.RoleID)
+			assert.Equal(dberror.ErrBadData, err)
+
+			assert.Nil(db.Restore(ctx, EntityRepo, repo.ID))
+			allowed, err := db.CheckPermissions(ctx, user.ID, repo.ID, "")
+			assert.Nil(err)
+			assert.True(allowed)
+		})
+
+		t.Run("Purged records are gone", func(t *testing.T) {
+			assert := ase.New(t)
+			user, key, _, role := createOwnedRepo(t, db, "purged")
+
+			assert.Equal(dberror.ErrNotFound, db.Purge(ctx, EntityUser, user.ID), "Only deleted records are purged")
+
+			assert.Nil(db.DeleteUser(ctx, user.ID))
+			assert.Nil(db.Purge(ctx, EntityUser, user.ID))
+
+			assert.NotContains(deletedIDs(t, db, EntityUser), user.ID)
+			assert.NotContains(deletedIDs(t, db, EntityKey), key.ID)
+			assert.NotContains(deletedIDs(t, db, EntityRole), role.RoleID)
+			assert.Equal(dberror.ErrNotFound, db.Restore(ctx, EntityUser, user.ID))
+		})
+
+		t.Run("Rejects unknown entities", func(t *testing.T) {
+			_, err := db.GetDeleted(ctx, Entity("branch"))
+			ase.Equal(t, dberror.ErrBadData, err)
+		})
+	})
+}
+
+func TestPurger(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		_, _, kept, _ := createOwnedRepo(t, db, "kept")
+		_, _, failing, _ := createOwnedRepo(t, db, "failing")
+		user, _, repo, _ := createOwnedRepo(t, db, "expired")
+		assert.Nil(db.DeleteRepo(ctx, repo.ID))
+		assert.Nil(db.DeleteRepo(ctx, failing.ID))
+		assert.Nil(db.DeleteUser(ctx, user.ID))
+
+		purged, err := (&Purger{Store: db, Retention: time.Hour}).Run(ctx)
+		assert.Nil(err)
+		assert.Zero(purged, "Nothing expired yet")
+
+		failed := errors.New("storage unavailable")
+		fail := true
+		var removed []string
+		p := &Purger{
+			Store:     db,
+			Retention: time.Nanosecond,
+			PurgeRepo: func(_ context.Context, rec DeletedRecord) error {
+				if rec.ID == failing.ID && fail {
+					return failed
+				}
+				removed = append(removed, rec.Name)
+				return nil
+			},
+		}
+		time.Sleep(time.Second) // Deletion times have a precision of seconds
+		purged, err = p.Run(ctx)
+		assert.ErrorIs(err, failed)
+		assert.Equal(3, purged, "The user and both repositories, along with their roles")
+		assert.Equal([]string{"expired-repo"}, removed)
+
+		assert.Empty(deletedIDs(t, db, EntityRepo), "Records are purged before their data")
+		assert.Empty(deletedIDs(t, db, EntityUser))
+
+		purged, err = p.Run(ctx)
+		assert.ErrorIs(err, failed)
+		assert.Zero(purged)
+
+		fail = false
+		purged, err = p.Run(ctx)
+		assert.Nil(err)
+		assert.Zero(purged)
+		assert.Equal([]string{"expired-repo", "failing-repo"}, removed, "Failed data removals are retried")
+
+		_, err = p.Run(ctx)
+		assert.Nil(err)
+		assert.Len(removed, 2, "Data is removed once")
+
+		_, err = db.GetRepo(ctx, kept.ID)
+		assert.Nil(err)
+	})
+}
+
+func TestPurgerKeepsReusedNames(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		old := NewRepo("reused")
+		assert.Nil(db.CreateRepo(ctx, &old))
+		gone := NewRepo("gone")
+		assert.Nil(db.CreateRepo(ctx, &gone))
+		assert.Nil(db.DeleteRepo(ctx, old.ID))
+		assert.Nil(db.DeleteRepo(ctx, gone.ID))
+
+		// The name is taken again while the old record awaits purging
+		live := NewRepo("reused")
+		assert.Nil(db.CreateRepo(ctx, &live))
+
+		var removed []string
+		p := &Purger{
+			Store:     db,
+			Retention: time.Nanosecond,
+			PurgeRepo: func(_ context.Context, rec DeletedRecord) error {
+				removed = append(removed, rec.Name)
+				return nil
+			},
+		}
+		time.Sleep(time.Second) // Deletion times have a precision of seconds
+		purged, err := p.Run(ctx)
+		assert.Nil(err)
+		assert.Equal(2, purged)
+		assert.Equal([]string{"gone"}, removed, "The data of the live repository is kept")
+		assert.Empty(deletedIDs(t, db, EntityRepo))
+
+		_, err = db.GetRepo(ctx, live.ID)
+		assert.Nil(err)
+	})
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// querier runs statements, either directly on the database or within a
+// transaction
+type querier interface {
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+var (
+	_ querier = (*sql.DB)(nil)
+	_ querier = (*sql.Tx)(nil)
+)
+
+// WithTx runs fn within a transaction. Every call made on tx is part of
+// it: the transaction is committed when fn returns nil and rolled back
+// when it fails or panics. Calling WithTx on tx joins the transaction
+// already running instead of starting a new one.
+func (d *DB) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return d.inTx(ctx, func(tx *DB) error {
+		return fn(tx)
+	})
+}
+
+func (d *DB) inTx(ctx context.Context, fn func(tx *DB) error) (err error) {
+	if d.tx != nil {
+		return fn(d)
+	}
+
+	sqltx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		dbLogger.WithContext(ctx).WithError(err).Warn("error starting transaction")
+		return err
+	}
+
+	tx := *d
+	tx.conn = sqltx
+	tx.tx = sqltx
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqltx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			if rerr := sqltx.Rollback(); rerr != nil {
+				dbLogger.WithContext(ctx).WithError(rerr).Warn("error rolling back transaction")
+			}
+			return
+		}
+		if err = sqltx.Commit(); err != nil {
+			dbLogger.WithContext(ctx).WithError(err).Warn("error committing transaction")
+		}
+	}()
+
+	return fn(&tx)
+}
+
+// CreateRepoWithOwner creates repo and gives owner a role on it in a single
+// transaction. bootstrap, if set, runs last, e.g. to initialise the
+// storage of the repository; if it fails, nothing is created.
+func CreateRepoWithOwner(ctx context.Context, st Store, repo *Repo, owner IDT, bootstrap func(ctx context.Context) error) error {
+	return st.WithTx(ctx, func(tx Store) error {
+		if err := tx.CreateRepo(ctx, repo); err != nil {
+			return err
+		}
+
+		role := AccessRole{
+			RoleID:  uuid.New(),
+			UserID:  owner,
+			RepoID:  repo.ID,
+			Created: time.Now(),
+		}
+		if err := tx.CreateAccessRole(ctx, &role); err != nil {
+			return err
+		}
+
+		if bootstrap == nil {
+			return nil
+		}
+		return bootstrap(ctx)
+	})
+}
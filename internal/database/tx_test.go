@@ -0,0 +1,179 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/GoldenDeals/DepGit/internal/database"
+	dberror "github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/google/uuid"
+	ase "github.com/stretchr/testify/assert"
+)
+
+func TestWithTx(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		t.Run("Commits", func(t *testing.T) {
+			user := NewUser("Committed", "committed@example.com")
+			err := db.WithTx(ctx, func(tx Store) error {
+				return tx.CreateUser(ctx, &user)
+			})
+			assert.Nil(err)
+
+			_, err = db.GetUser(ctx, user.ID)
+			assert.Nil(err)
+		})
+
+		t.Run("Rolls back on error", func(t *testing.T) {
+			failed := errors.New("failed")
+			user := NewUser("RolledBack", "rolledback@example.com")
+			err := db.WithTx(ctx, func(tx Store) error {
+				if err := tx.CreateUser(ctx, &user); err != nil {
+					return err
+				}
+				return failed
+			})
+			assert.ErrorIs(err, failed)
+
+			_, err = db.GetUser(ctx, user.ID)
+			assert.Equal(dberror.ErrNotFound, err)
+		})
+
+		t.Run("Nested calls join the transaction", func(t *testing.T) {
+			failed := errors.New("failed")
+			user := NewUser("Nested", "nested@example.com")
+			err := db.WithTx(ctx, func(tx Store) error {
+				err := tx.WithTx(ctx, func(inner Store) error {
+					return inner.CreateUser(ctx, &user)
+				})
+				if err != nil {
+					return err
+				}
+				return failed
+			})
+			assert.ErrorIs(err, failed)
+
+			_, err = db.GetUser(ctx, user.ID)
+			assert.Equal(dberror.ErrNotFound, err)
+		})
+	})
+}
+
+func TestDeleteUserCascades(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		user := NewUser("Cascade", "cascade@example.com")
+		assert.Nil(db.CreateUser(ctx, &user))
+
+		key := NewSShKey("laptop", SSH_RSA, []byte("ssh-rsa AAAA"))
+		assert.Nil(db.AddSshKey(ctx, user.ID, &key))
+
+		repo := NewRepo("cascade-repo")
+		assert.Nil(db.CreateRepo(ctx, &repo))
+		role := AccessRole{RoleID: uuid.New(), UserID: user.ID, RepoID: repo.ID, Created: time.Now()}
+		assert.Nil(db.CreateAccessRole(ctx, &role))
+
+		assert.Nil(db.DeleteUser(ctx, user.ID))
+
+		keys, err := db.GetSshKeys(ctx, user.ID)
+		assert.Nil(err)
+		assert.Empty(keys)
+
+		allowed, err := db.CheckPermissions(ctx, user.ID, repo.ID, "")
+		assert.Nil(err)
+		assert.False(allowed)
+	})
+}
+
+func TestCreateRepoWithOwner(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		owner := NewUser("Owner", "owner@example.com")
+		assert.Nil(db.CreateUser(ctx, &owner))
+
+		t.Run("Creates the repository and its owner role", func(t *testing.T) {
+			repo := NewRepo("owned")
+			assert.Nil(CreateRepoWithOwner(ctx, db, &repo, owner.ID, nil))
+
+			_, err := db.GetRepo(ctx, repo.ID)
+			assert.Nil(err)
+
+			allowed, err := db.CheckPermissions(ctx, owner.ID, repo.ID, "")
+			assert.Nil(err)
+			assert.True(allowed)
+		})
+
+		t.Run("Rolls back when bootstrapping fails", func(t *testing.T) {
+			failed := errors.New("storage unavailable")
+			repo := NewRepo("unbootstrapped")
+			err := CreateRepoWithOwner(ctx, db, &repo, owner.ID, func(context.Context) error {
+				return failed
+			})
+			assert.ErrorIs(err, failed)
+
+			_, err = db.GetRepo(ctx, repo.ID)
+			assert.Equal(dberror.ErrNotFound, err)
+
+			allowed, err := db.CheckPermissions(ctx, owner.ID, repo.ID, "")
+			assert.Nil(err)
+			assert.False(allowed)
+		})
+	})
+}
+
+func TestConcurrentInserts(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db Store) {
+		assert := ase.New(t)
+		ctx := context.Background()
+
+		// count runs insert concurrently and returns how many succeeded,
+		// all others must fail as duplicates
+		count := func(insert func() error) int {
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				created int
+			)
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := insert()
+					mu.Lock()
+					defer mu.Unlock()
+					if err == nil {
+						created++
+					} else {
+						assert.ErrorIs(err, dberror.ErrAlreadyExists)
+					}
+				}()
+			}
+			wg.Wait()
+			return created
+		}
+
+		user := NewUser("Concurrent", "concurrent@example.com")
+		assert.Equal(1, count(func() error {
+			u := user
+			return db.CreateUser(ctx, &u)
+		}))
+
+		assert.Equal(1, count(func() error {
+			key := NewSShKey("laptop", SSH_ED25519, []byte("ssh-ed25519 AAAA"))
+			return db.AddSshKey(ctx, user.ID, &key)
+		}))
+
+		keys, err := db.GetSshKeys(ctx, user.ID)
+		assert.Nil(err)
+		assert.Len(keys, 1)
+	})
+}
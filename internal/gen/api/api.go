@@ -36,6 +36,20 @@ const (
 	Bearer AuthResponseTokenType = "bearer"
 )
 
+// Defines values for SignatureVerificationFormat.
+const (
+	Openpgp SignatureVerificationFormat = "openpgp"
+	Ssh     SignatureVerificationFormat = "ssh"
+)
+
+// Defines values for SignatureVerificationStatus.
+const (
+	BadSignature SignatureVerificationStatus = "bad_signature"
+	UnknownKey   SignatureVerificationStatus = "unknown_key"
+	Unsigned     SignatureVerificationStatus = "unsigned"
+	Verified     SignatureVerificationStatus = "verified"
+)
+
 // Defines values for SshKeyType.
 const (
 	N1 SshKeyType = 1
@@ -43,6 +57,16 @@ const (
 	N3 SshKeyType = 3
 	N4 SshKeyType = 4
 	N5 SshKeyType = 5
+	N6 SshKeyType = 6
+	N7 SshKeyType = 7
+)
+
+// Defines values for TrashEntity.
+const (
+	TrashEntityKey  TrashEntity = "key"
+	TrashEntityRepo TrashEntity = "repo"
+	TrashEntityRole TrashEntity = "role"
+	TrashEntityUser TrashEntity = "user"
 )
 
 // Defines values for UserRole.
@@ -59,6 +83,12 @@ const (
 	GetUsersParamsRoleViewer        GetUsersParamsRole = "Viewer"
 )
 
+// Defines values for VerifySignatureJSONBodyType.
+const (
+	Commit VerifySignatureJSONBodyType = "commit"
+	Tag    VerifySignatureJSONBodyType = "tag"
+)
+
 // AccessRole defines model for AccessRole.
 type AccessRole struct {
 	// Branch Branch pattern (supports glob syntax)
@@ -94,6 +124,26 @@ type AuthResponse struct {
 // AuthResponseTokenType Type of token
 type AuthResponseTokenType string
 
+// DeletedRecord defines model for DeletedRecord.
+type DeletedRecord struct {
+	DeletedAt time.Time `json:"deletedAt"`
+
+	// Entity Kind of soft-deleted record
+	Entity TrashEntity        `json:"entity"`
+	Id     openapi_types.UUID `json:"id"`
+
+	// Name Name of the user, key or repository
+	Name *string `json:"name,omitempty"`
+}
+
+// DownloadUrl defines model for DownloadUrl.
+type DownloadUrl struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// Url Signed URL to GET the object from, without further authentication
+	Url string `json:"url"`
+}
+
 // Error defines model for Error.
 type Error struct {
 	Code    *int32  `json:"code,omitempty"`
@@ -112,6 +162,39 @@ type Repo struct {
 	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
 }
 
+// SignatureVerification defines model for SignatureVerification.
+type SignatureVerification struct {
+	// Fingerprint Fingerprint of the signing key
+	Fingerprint *string `json:"fingerprint,omitempty"`
+
+	// Format Signature format, absent for unsigned objects
+	Format *SignatureVerificationFormat `json:"format,omitempty"`
+
+	// KeyId Registered key that made the signature
+	KeyId *openapi_types.UUID `json:"keyId,omitempty"`
+
+	// ObjectId Git object id of the commit or tag
+	ObjectId string `json:"objectId"`
+
+	// Reason Why the signature is not verified
+	Reason *string `json:"reason,omitempty"`
+
+	// Status Outcome of the verification
+	Status SignatureVerificationStatus `json:"status"`
+
+	// UserId Owner of the signing key
+	UserId *openapi_types.UUID `json:"userId,omitempty"`
+
+	// Verified True when the signature is valid and made by a registered key
+	Verified bool `json:"verified"`
+}
+
+// SignatureVerificationFormat Signature format, absent for unsigned objects
+type SignatureVerificationFormat string
+
+// SignatureVerificationStatus Outcome of the verification
+type SignatureVerificationStatus string
+
 // SshKey defines model for SshKey.
 type SshKey struct {
 	CreatedAt *time.Time          `json:"createdAt,omitempty"`
@@ -123,14 +206,17 @@ type SshKey struct {
 	// Name A descriptive name for the SSH key
 	Name string `json:"name"`
 
-	// Type SSH key type identifier
+	// Type SSH key type identifier (6 is an armored OpenPGP public key used for signature verification, 7 is ssh-ed25519)
 	Type   *SshKeyType        `json:"type,omitempty"`
 	UserId openapi_types.UUID `json:"userId"`
 }
 
-// SshKeyType SSH key type identifier
+// SshKeyType SSH key type identifier (6 is an armored OpenPGP public key used for signature verification, 7 is ssh-ed25519)
 type SshKeyType int
 
+// TrashEntity Kind of soft-deleted record
+type TrashEntity string
+
 // User defines model for User.
 type User struct {
 	CreatedAt *time.Time          `json:"createdAt,omitempty"`
@@ -177,15 +263,90 @@ type ResetPasswordJSONBody struct {
 	Token    string `json:"token"`
 }
 
+// GetReposParams defines parameters for GetRepos.
+type GetReposParams struct {
+	// Limit Maximum number of items to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor Continues the listing from the next cursor of a previous page
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+
+	// Q Keeps repositories whose name contains it, ignoring case
+	Q *string `form:"q,omitempty" json:"q,omitempty"`
+
+	// Sort Field to sort by, prefixed with "-" to sort in descending order
+	Sort *string `form:"sort,omitempty" json:"sort,omitempty"`
+}
+
+// GetAccessRolesParams defines parameters for GetAccessRoles.
+type GetAccessRolesParams struct {
+	// Limit Maximum number of items to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor Continues the listing from the next cursor of a previous page
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+
+	// Sort Field to sort by, prefixed with "-" to sort in descending order
+	Sort *string `form:"sort,omitempty" json:"sort,omitempty"`
+}
+
+// GetDownloadUrlParams defines parameters for GetDownloadUrl.
+type GetDownloadUrlParams struct {
+	// Object Name of the object within the repository storage
+	Object string `form:"object" json:"object"`
+
+	// ExpiresIn Validity of the URL in seconds
+	ExpiresIn *int `form:"expiresIn,omitempty" json:"expiresIn,omitempty"`
+}
+
+// VerifySignatureJSONBody defines parameters for VerifySignature.
+type VerifySignatureJSONBody struct {
+	// Object Raw object content without the git object header, base64 encoded
+	Object []byte `json:"object"`
+
+	// Type Git object type
+	Type VerifySignatureJSONBodyType `json:"type"`
+}
+
+// VerifySignatureJSONBodyType defines parameters for VerifySignature.
+type VerifySignatureJSONBodyType string
+
 // GetUsersParams defines parameters for GetUsers.
 type GetUsersParams struct {
 	// Role Filter users by role
 	Role *GetUsersParamsRole `form:"role,omitempty" json:"role,omitempty"`
+
+	// Limit Maximum number of items to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor Continues the listing from the next cursor of a previous page
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+
+	// Q Keeps users whose name or email contains it, ignoring case
+	Q *string `form:"q,omitempty" json:"q,omitempty"`
+
+	// Sort Field to sort by, prefixed with "-" to sort in descending order
+	Sort *string `form:"sort,omitempty" json:"sort,omitempty"`
 }
 
 // GetUsersParamsRole defines parameters for GetUsers.
 type GetUsersParamsRole string
 
+// GetSshKeysParams defines parameters for GetSshKeys.
+type GetSshKeysParams struct {
+	// Limit Maximum number of items to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor Continues the listing from the next cursor of a previous page
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+
+	// Q Keeps keys whose name contains it, ignoring case
+	Q *string `form:"q,omitempty" json:"q,omitempty"`
+
+	// Sort Field to sort by, prefixed with "-" to sort in descending order
+	Sort *string `form:"sort,omitempty" json:"sort,omitempty"`
+}
+
 // UpdateAccessRoleJSONRequestBody defines body for UpdateAccessRole for application/json ContentType.
 type UpdateAccessRoleJSONRequestBody = AccessRole
 
@@ -213,6 +374,9 @@ type UpdateRepoJSONRequestBody = Repo
 // CreateAccessRoleJSONRequestBody defines body for CreateAccessRole for application/json ContentType.
 type CreateAccessRoleJSONRequestBody = AccessRole
 
+// VerifySignatureJSONRequestBody defines body for VerifySignature for application/json ContentType.
+type VerifySignatureJSONRequestBody VerifySignatureJSONBody
+
 // CreateUserJSONRequestBody defines body for CreateUser for application/json ContentType.
 type CreateUserJSONRequestBody = User
 
@@ -230,6 +394,12 @@ type ServerInterface interface {
 	// Update access role
 	// (PUT /access-roles/{roleId})
 	UpdateAccessRole(ctx echo.Context, roleId openapi_types.UUID) error
+	// List deleted records
+	// (GET /admin/trash/{entity})
+	GetDeletedRecords(ctx echo.Context, entity TrashEntity) error
+	// Restore a deleted record
+	// (POST /admin/trash/{entity}/{recordId}/restore)
+	RestoreDeletedRecord(ctx echo.Context, entity TrashEntity, recordId openapi_types.UUID) error
 	// Request password reset
 	// (POST /auth/forgot-password)
 	ForgotPassword(ctx echo.Context) error
@@ -256,7 +426,7 @@ type ServerInterface interface {
 	GetGitInfo(ctx echo.Context) error
 	// Get all repositories
 	// (GET /repos)
-	GetRepos(ctx echo.Context) error
+	GetRepos(ctx echo.Context, params GetReposParams) error
 	// Create a new repository
 	// (POST /repos)
 	CreateRepo(ctx echo.Context) error
@@ -271,10 +441,16 @@ type ServerInterface interface {
 	UpdateRepo(ctx echo.Context, repoId openapi_types.UUID) error
 	// Get repository access roles
 	// (GET /repos/{repoId}/access-roles)
-	GetAccessRoles(ctx echo.Context, repoId openapi_types.UUID) error
+	GetAccessRoles(ctx echo.Context, repoId openapi_types.UUID, params GetAccessRolesParams) error
 	// Create access role for repository
 	// (POST /repos/{repoId}/access-roles)
 	CreateAccessRole(ctx echo.Context, repoId openapi_types.UUID) error
+	// Get a signed URL to download an object of a repository
+	// (GET /repos/{repoId}/download-url)
+	GetDownloadUrl(ctx echo.Context, repoId openapi_types.UUID, params GetDownloadUrlParams) error
+	// Verify the signature of a commit or tag
+	// (POST /signatures/verify)
+	VerifySignature(ctx echo.Context) error
 	// Delete SSH key
 	// (DELETE /ssh-keys/{keyId})
 	DeleteSshKey(ctx echo.Context, keyId openapi_types.UUID) error
@@ -295,7 +471,7 @@ type ServerInterface interface {
 	UpdateUser(ctx echo.Context, userId openapi_types.UUID) error
 	// Get user's SSH keys
 	// (GET /users/{userId}/ssh-keys)
-	GetSshKeys(ctx echo.Context, userId openapi_types.UUID) error
+	GetSshKeys(ctx echo.Context, userId openapi_types.UUID, params GetSshKeysParams) error
 	// Add SSH key for user
 	// (POST /users/{userId}/ssh-keys)
 	AddSshKey(ctx echo.Context, userId openapi_types.UUID) error
@@ -342,6 +518,50 @@ func (w *ServerInterfaceWrapper) UpdateAccessRole(ctx echo.Context) error {
 	return err
 }
 
+// GetDeletedRecords converts echo context to params.
+func (w *ServerInterfaceWrapper) GetDeletedRecords(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "entity" -------------
+	var entity TrashEntity
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "entity", runtime.ParamLocationPath, ctx.Param("entity"), &entity)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter entity: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetDeletedRecords(ctx, entity)
+	return err
+}
+
+// RestoreDeletedRecord converts echo context to params.
+func (w *ServerInterfaceWrapper) RestoreDeletedRecord(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "entity" -------------
+	var entity TrashEntity
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "entity", runtime.ParamLocationPath, ctx.Param("entity"), &entity)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter entity: %s", err))
+	}
+
+	// ------------- Path parameter "recordId" -------------
+	var recordId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "recordId", runtime.ParamLocationPath, ctx.Param("recordId"), &recordId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter recordId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.RestoreDeletedRecord(ctx, entity, recordId)
+	return err
+}
+
 // ForgotPassword converts echo context to params.
 func (w *ServerInterfaceWrapper) ForgotPassword(ctx echo.Context) error {
 	var err error
@@ -436,8 +656,38 @@ func (w *ServerInterfaceWrapper) GetRepos(ctx echo.Context) error {
 
 	ctx.Set(BearerAuthScopes, []string{})
 
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetReposParams
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", ctx.QueryParams(), &params.Cursor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter cursor: %s", err))
+	}
+
+	// ------------- Optional query parameter "q" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "q", ctx.QueryParams(), &params.Q)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter q: %s", err))
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", ctx.QueryParams(), &params.Sort)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sort: %s", err))
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.GetRepos(ctx)
+	err = w.Handler.GetRepos(ctx, params)
 	return err
 }
 
@@ -519,8 +769,31 @@ func (w *ServerInterfaceWrapper) GetAccessRoles(ctx echo.Context) error {
 
 	ctx.Set(BearerAuthScopes, []string{})
 
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetAccessRolesParams
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", ctx.QueryParams(), &params.Cursor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter cursor: %s", err))
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", ctx.QueryParams(), &params.Sort)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sort: %s", err))
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.GetAccessRoles(ctx, repoId)
+	err = w.Handler.GetAccessRoles(ctx, repoId, params)
 	return err
 }
 
@@ -542,6 +815,51 @@ func (w *ServerInterfaceWrapper) CreateAccessRole(ctx echo.Context) error {
 	return err
 }
 
+// GetDownloadUrl converts echo context to params.
+func (w *ServerInterfaceWrapper) GetDownloadUrl(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "repoId" -------------
+	var repoId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithLocation("simple", false, "repoId", runtime.ParamLocationPath, ctx.Param("repoId"), &repoId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter repoId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetDownloadUrlParams
+	// ------------- Required query parameter "object" -------------
+
+	err = runtime.BindQueryParameter("form", true, true, "object", ctx.QueryParams(), &params.Object)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter object: %s", err))
+	}
+
+	// ------------- Optional query parameter "expiresIn" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "expiresIn", ctx.QueryParams(), &params.ExpiresIn)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter expiresIn: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetDownloadUrl(ctx, repoId, params)
+	return err
+}
+
+// VerifySignature converts echo context to params.
+func (w *ServerInterfaceWrapper) VerifySignature(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.VerifySignature(ctx)
+	return err
+}
+
 // DeleteSshKey converts echo context to params.
 func (w *ServerInterfaceWrapper) DeleteSshKey(ctx echo.Context) error {
 	var err error
@@ -575,6 +893,34 @@ func (w *ServerInterfaceWrapper) GetUsers(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter role: %s", err))
 	}
 
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", ctx.QueryParams(), &params.Cursor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter cursor: %s", err))
+	}
+
+	// ------------- Optional query parameter "q" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "q", ctx.QueryParams(), &params.Q)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter q: %s", err))
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", ctx.QueryParams(), &params.Sort)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sort: %s", err))
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetUsers(ctx, params)
 	return err
@@ -658,8 +1004,38 @@ func (w *ServerInterfaceWrapper) GetSshKeys(ctx echo.Context) error {
 
 	ctx.Set(BearerAuthScopes, []string{})
 
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetSshKeysParams
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "cursor" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "cursor", ctx.QueryParams(), &params.Cursor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter cursor: %s", err))
+	}
+
+	// ------------- Optional query parameter "q" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "q", ctx.QueryParams(), &params.Q)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter q: %s", err))
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", ctx.QueryParams(), &params.Sort)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sort: %s", err))
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
-	err = w.Handler.GetSshKeys(ctx, userId)
+	err = w.Handler.GetSshKeys(ctx, userId, params)
 	return err
 }
 
@@ -711,6 +1087,8 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 
 	router.DELETE(baseURL+"/access-roles/:roleId", wrapper.DeleteAccessRole)
 	router.PUT(baseURL+"/access-roles/:roleId", wrapper.UpdateAccessRole)
+	router.GET(baseURL+"/admin/trash/:entity", wrapper.GetDeletedRecords)
+	router.POST(baseURL+"/admin/trash/:entity/:recordId/restore", wrapper.RestoreDeletedRecord)
 	router.POST(baseURL+"/auth/forgot-password", wrapper.ForgotPassword)
 	router.POST(baseURL+"/auth/login", wrapper.Login)
 	router.POST(baseURL+"/auth/logout", wrapper.Logout)
@@ -726,6 +1104,8 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 	router.PUT(baseURL+"/repos/:repoId", wrapper.UpdateRepo)
 	router.GET(baseURL+"/repos/:repoId/access-roles", wrapper.GetAccessRoles)
 	router.POST(baseURL+"/repos/:repoId/access-roles", wrapper.CreateAccessRole)
+	router.GET(baseURL+"/repos/:repoId/download-url", wrapper.GetDownloadUrl)
+	router.POST(baseURL+"/signatures/verify", wrapper.VerifySignature)
 	router.DELETE(baseURL+"/ssh-keys/:keyId", wrapper.DeleteSshKey)
 	router.GET(baseURL+"/users", wrapper.GetUsers)
 	router.POST(baseURL+"/users", wrapper.CreateUser)
@@ -740,51 +1120,74 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 // Base64 encoded, gzipped, json marshaled Swagger object
 var swaggerSpec = []string{
 
-	"H4sIAAAAAAAC/+xcbXPbNhL+KxhcZ9reUW9x0rnq08l24ihN01S205tLfDcQuRIRkwADgFYUj/77DQCS",
-	"IilKoq0X260+xaJAYLH77O6zCyi32OVhxBkwJXH3FkvXh5CYP3uuC1IOeAD6UyR4BEJRMN8NBWGur//y",
-	"YETiQOEu/jt2sAfSFTRSlDPcxcdmFIqIUiAY+kHGUcSFkmgc8CGSU6bI1x+xg+ErCSO9DA4JZdjBahrp",
-	"T1IJysZ45mBXAFHg9ZRecsRFSPSKHlHQUDQE7GABxPuNBVPcVSKGiimoV3g3jqlX5zUBEe9Xvro4NFFV",
-	"UQlWjSiAGwjQiAukfEB6VkkVF1O9fRaHuPvRyIIdPBFU6R0RL6QMX1UsFEsQtWQy8n+JqQBPL5C8l20q",
-	"EXm+BB9+BlfpJXqx8gcgI85khfmJ2dMFvwa2uOE3f1wgOwApPcJsuve+j0isfGCKusSMzNsdpm/84ZlL",
-	"f6Nv+pff+p13tC/7bPDCPen/1L+O/v3h5M3PzWazSuvwNaICZL9CEiMgMgPMkkhjBVGGJLiceTIvwtFP",
-	"7XY2O2UKxiCs/UcCpL9kr3aFWIKHFEd8qAhliCAGk6IKJj4wRBVKhN3W3s3kF+Zp3hOHQASIBXfUAxEf",
-	"WZFyuEuGL0Oanvs7ASPcxX9rzaNFKwkVrUs9RkNtAUQvheBiET0u96AAXsrU0TNcpf0QpCRjM3oR2gvr",
-	"DSDiFcttHjsKerzN2e5Xbe9IcC1B0alD8vUtsLHycfdFDlkbxyNGQijKkCzfIEHkk+LKHb1ySFn22cFJ",
-	"MMZd/N+PpPGt1/hPu/Hz/xpX//iuCmB8wiwAikjSJteCGDQVwhmyb+TxbeNYFbgibzPDlMKbUU1VMDuX",
-	"/i8w3Qky7mnFaytOyT99QOfnr1EUDwPqomuYIpczBUwV9Cml3xCSoF6v1zs+eveNnHSm7rOX+uNp7/fe",
-	"sX7c+/1kSchI8VNKUij7fAPImDZNVVoiLa5TgL17zPk1ei94ZVgqR6ROORQlkyI9ElFPZ4URtbixQanj",
-	"PHOOnOfOi6uqsLBxAjRasGaoAsxlEva2DhcICQ2K7vuZ+6zpcfhX8qjp8hA78/ntK1skNBlLSdPFqaYm",
-	"eqMLGUPr4XuJ5FQqCBsT6gEyr89zR087N5VKEMX1+/m5PlCYLMsrm7q+xcBiMNTa9DiUA3AhCh7VgUmC",
-	"EKv9ZRg5EWCwSwK5CJeISDnhomil7GEl1QwhHIL4tWifEQnkXAFDzgMgrKyB2hvKBFjc08zBEtxYUDU9",
-	"17k9ofqGHWg+OP/0Kt3Omz8uFkCj+V+vQPWaSIc2y4SoTGgSeGgkeGhiTEtTw1bAx5QhYF7EKVPNT8wu",
-	"00XHZlF0a2aYfWKfmJ5Pr2Pn1FGSUCbNXCMeBHxC2Ri5AaGh7H5iCDWQjIddpG2G+qf2SaoU+1j/ZZ9r",
-	"hCdD9Z/ohwLGHZRB3EEW4T/a9+Br1EUvi2TTfkP1JvpSxuAhopIvsGOLLcgTtsQivlIRnmmDUDbiFeH6",
-	"fd/E55AwMtZb1XuRzjwPU5BOGrmlgwjzUj6qt6T5p6LKOMwpRGdUaX6OHXwDQtoVOs12s20YQASMRBR3",
-	"8VGz3TwyCFK+QUbLTtkwU7Zu9T99b2alDUAZXGo9GXXocI1PzfNcYalhaosMM+Oz9vOlBZSxhZ3YQzI2",
-	"D0dxEEy1lM/bHcssbbrUJUoUBQn8Wp+l5W2Wsa7js5a1Gu2XoiHTMOWCfgPPLnq0+0VfcTGkngdMI9O6",
-	"tESGUs0rjDzzNHI9371ceaswrtCIx8wrxBDc/ViMHh+vZlcOlnEYEjHN0JAHpsYlGUuTWOzTgYHr1UzD",
-	"TpAQFAhpJqbMRFPlp9m8iy0AcT702dwx3+o6unDl4ChWi8C9NPmqBNwvMUh1zL3p1rSdW2BWjOF6I7MF",
-	"b2nvbOXlxk5S98EF/wwuaHFdzwVnDrZ5esTFmKtGnt9EXFZ4zSsz8P2c8tzXZ4rEKiPR6zhyiQUt43K1",
-	"Pa2o/nRfSIAEhczsSOr97AsAL82SJdNnth1YZaOoIGfevsV+XM7Choktt+tb8/VuQmCZVu87Dub7nlU+",
-	"V9BZLgjuLQSWJBgRGkDZ9LkxYLihYYBjUHPSLOsCgdt8uBQJ+vs67mKHPoTGiknjLuExkTnTIGU3JKAm",
-	"ZN5BiUkLuaHSHnK1Lgf5TvO2YuXq9nWyZFJGFesyW41xgSIBN5THEiVzrY2zhTU3CLf33PLKw4l3MEHl",
-	"A4oHOFVY17hf25qfVaq1StDEGg/heH3rLhpEVp9eJo1VfDlh2e/KtqnhYGMqVdKyW+JbyYi9cxBnbR8o",
-	"15n655o+1zZ7WqtbQTV8tLPVnF8ZuE0PJjFdZcnR3h+IKdOVYRmxVrbkyM+cltUDrIQ6BHqgx22dP28I",
-	"yCyNrQZc6r4bomw98S4HtvaDBLbqgKbly/j3hCr/DlEtpRpayjFUoONDMmDOGLaWQ+uf+zrYyFnowhc6",
-	"9lnDun7KohLZSfeVqLJl6YJh70YYU5PkmtMp8zYkkjLrcPb+w0oMjKlqpN3fSvOfgTqjqq+HbNX2vlLR",
-	"pQgW/e6YSECvLy7eo8vBW9N/PqMKZUIVbzXoWWS31cqdauktVSVIKf3l652fv66znJR+t6UXKB2jLSyW",
-	"tbjLq+nJJYgbECgdk1/gWfPoebOD70XEclPnAfD4658zUGiJ8HP0npvTwQS1pg22CrIDM2BDwFIFoVyn",
-	"D3MbZG4eIgSZVqnnLZUK8VHh8OSJ2IYEQVHsuVUG+cemf17JME7MmbZR1W76OdYK++WT8zXL5W52TSU5",
-	"y38UlPIv1zsvHKjqXJsdqdoW9J38wCI4YeCFK1hLXCELU61bew+yxpFl5iDrDitzEDucVVYY2xxWcPEY",
-	"jk9yptrsALMO6JyVCRHvsKNdIxgmW//rneE9bfhpBpC7eTmcov7pSgaw/gQ9uxe+oxP0R0E09upbh+Py",
-	"x3JcvrnDJafl9yMZhdtSqyqk+VWM/dRJ+asf9aulwnWyA57/DAmkfENwg5tY28kjKyrWR3cVq/MAV7EO",
-	"RewhAmwWAdLiOQeqka7SqjJcxXUwKf3GNUxl6/YaprUK6eSnOHVK6fQ3Ioc6ekUdzScs+4XOvmCXGmaz",
-	"qnn+u6KskXz+Gv0C07pJxkBusxyjMWyuzq/iY5dmwIJEJZPRQIGw9/B1JZZcpTRif4nBOFLumjLOi7nR",
-	"b2m0bnfPEdOzvrrs0Cr16TTR48TGKRStzde1zS/lRjdK6l2JeCTXMA5c41HF/g165KVbKinUs1jYurU/",
-	"k6yRzTMHWJfLDYQOiXypMfeUuY0ZNkvbS9DjrEyfu+xvr4xaD93ZfuQ2PUvvpZR7x7n8t5aH5f5Xkd00",
-	"jR9Fmt0TYA/t4jXFDnFdHu/xNzabuFfSL66dcLN6elU5Yivo/bSGk2r9DsQ//c3xAbNPFLNpSvhezm15",
-	"7xJ9S6mhsgTreV6umbT91JBif781WH7V6q4L8byHLsMKDaeDjz85H+95mQ1Nu7eUoHJOXmdaczfUhoBY",
-	"BLiLWySirZsOnl3N/h8AAP//LAUpDl9QAAA=",
+	"H4sIAAAAAAAC/+xde3cbt3L/KjjbnHOTdilSlp/s6Wnpl0LHcXxlKbmnlpoLcockol1gA2AtMSq/e88A",
+	"2BcXfEiUKLnmXxZ3sZgB5jeDwcwAvgqGIkkFB65V0L0KJkAjkObP94yf478RqKFkqWaCB13zlGhB9AQI",
+	"h0tNUjoGQhX552nW6RwMMxmbP+DfiYT4P04DbHQa/DMkdKCAayK4+Tamyn4bhIEaTiChSEtPUwi6gdKS",
+	"8XEwm4XBB7jUrzKphGzyYp8TMapzczNSs/ylGXxvOASljkQM+CuVIgWpGZh3A0n5cGLZGdEs1kE3+Ncg",
+	"nGPupWlFUqo1SE6+V1maCqkVGcdiQNSUa3r5QxAGcEmTFMkECWU8COcZC4OhBKoh6mkkORIyoUgxohpa",
+	"miU4Kgk0+oXH06CrZQaeLlhU+zbLWLTOZxJS0fd+2mzqpqo+CXYaSQxfICYjIY08sFfFtJBTHD7PkqD7",
+	"2fAShMGFZBpHRKOE8eDMQyhTINfiyfD/Z8YkREjAfVcMyrFckhCDP2CokUQv05MjUKngyiN+asZ0LM6B",
+	"Nwf87rdjYhsQjS3MoHsf+4RmegJcsyE1Latyh+m7yeBwyH5h7/onf/X3P7C+6vOjJ8NX/af98/Qfv756",
+	"92Jvb88363CZMgmq7+HEMEhMA0OSIFYI40TBUPBIVVk4eNrpFL0zrmEM0sp/JEFNFozVUsgURGgQxEBT",
+	"xgklHC7qU3AxAU6YJo7Z2xq76fzYPK1q4gCoBNlQR2xoLIUZS4k713wR0rDv7ySMgm7wL+3SVLadqWif",
+	"YJvZrPi6BNFriEFDdARDIaMmiiL7eolSN4XNNdPTVRwdS6omb2zThZrf6JvTxKO+H2gCuXnF2QjJOUyJ",
+	"kHUVXq53jmvDSVgZtk/xXosLHgsanci4OWMOPteZsUzGzUF9YmMOETk5eo+wPXxzbIZnmSAjKZKQXDA9",
+	"EZkmo0zqCcim8q4wNTIOwgq/vqG+kVLI5iCHIoLa+BjXB48Cn3ImoBQuat5ls0HvCFLhIbf50lKb3KuK",
+	"av+M5iCVwsxrDTAJvXwPfKwnQfdJp+Pp84bLVQ7ikgdHvkXjdELrlPeRcsJ48TsM3FoddIP/+Uxbf/Va",
+	"/91pvfi9dfZv3/nAJS44eJwStAi8ojflyIn9omr+7DLnQ24abSaYOUiaqfHhELWB6kzCryDZKEd4Aygj",
+	"xscgU8m4bg75bfkyH7ViY874GO2Fb3j5iHy6abghtkXhyuEymnFlVddyrypWXKTA03EahIFSE68xP4dp",
+	"P2oSPIIxUxokRMa06QnVJKERFIMw3AThagtqmfLROGQ6Ny8syidoKJIEn0ui6biGiYPB/nN4sv8oGtBn",
+	"L+Dx8PlBpxNFnecUBgfPRs/h2aPng+cR9TIhgSrhWap/m0zrIyJMES40+WKkDt7OlKY6U83Ofsn0UJQA",
+	"/1IFTimSSs+54Myf51xc8N8tLgY0+r2c5OXe3hwPqEp+sK2UVMFZ06ORGVh3pTFZX2jMIkJ5ZOExmBJK",
+	"ZA08JamBEDFQ3lDCAiJhdXbcNHuVU01+gumdmO0bmthzmHqmbQLk06cfSZoNYjY0ijQUXAPXNWArNWlJ",
+	"RUmv1+u9PPjwF321Px0+eoM/X/f+3nuJj3t/f7XA3fN7KD1S/P4CBNsU2wzkyIqluiYNXwpxTj5K4aOh",
+	"573J/Xk30nVKsCVhEXCNYpTk+6cIEsoJlYlAQPySAv94+LE6J8ZTRu5KYFV1JyTPsA+cJYgePXmy/+KH",
+	"Up32w0fhQfg4fBI+DZ+d+dyBjfdFZoKthH1YrPqVDTH8xLixbEqMdMv5eERa17cYgyHlKNhtWHMTVsri",
+	"xHnft458SCiL627CH2LC9yIB/+Ue7Q1FUrUk9pNb3FeLuI6z4DXukHGgjY0LzsPfFFFTpSFpXbAIiPm8",
+	"nNYeOhFMaUm1wO+rff3K4GLR9mZTF8Nirul04WxGAuYdvZq3dbAOLB0i7ez7MIlz80qCUUMaqyZcUqrU",
+	"hdt/FUMsHnoX0ASSAcif6/IZ0VhB077XZ2DtARUMNMeEyy4MM8n09BNu6FzEyWxSMSxR/nqbD+fdb8dB",
+	"6AlD9Gqblj2CVtpuyJlyu3W0R1Ikxly2cZPTjsWYcQI8SgXjeu+UWzJd8tIQJVemh9kpP+XYH9KxfaLB",
+	"p4wr09dIxLG4wCV5GFOWqO4pJ6RFVDboEpQZ6b+2T/JJsY/xL/scEe6a4p/k+xrGQ1JAPCQW4T/Y7+Ay",
+	"7ZI39ZiHfcNwEH2lMogI1e5FHhCEatzASWSidWrDgoyPhGfl+dg3xjyhnI5xqDgWFZb+PgMV5ouQCo3r",
+	"4MIiOCSFlJg2CvMaUvQRex/71jNQlsL+XmevY1zLFDhNGfqGe529A4MgPTHIaNsuW6bL9hX+049mZYAB",
+	"/8J5MtOBy4OLS1TimwhTG+syPT7qPF4YxzOyyM27yszDURbHJtLwuLNvd7B25e9eBTRNYwe/9h/OLy0D",
+	"sMuCGHZ3bGZ/zhpyhKmQ7C+ILNGDuyf6VsgBiyLgiEyr0oqYrVsZ6KrucA1fj++er6pUuMBNUsajmg0J",
+	"up/r1uPz2ewsDFSWJFROCzRUgRmEgaZjZRYW+/TIwPVshrCTNAFtEgSfrwLGjTXVk9x76AYWgEHV9Nm1",
+	"oxzqKvfkLAzSTDeBe2LWqzng/pmB0i9FNL212a4QmM1m8wOZNbSlc2eUFwvbLd07Ffz/oIIW1+up4CwM",
+	"2mbUbY3uePvKRleNwR+DJ6DyuuaLK6I0nRIJSgtJB4gkrlmMazaTRAJOFC6bNnRpt7t6AlNCJZA0k2OI",
+	"cG9W18tD0LVYtwo21BGmIVGrJFIjWe7dAiolnfokNTcTIRFxBEqTEZNKf4PKU/OoLOyuBdv3TGlS3+qp",
+	"KnBtAm+tRaNIESxeNNbOeMzOFulI+8py2Y9mbasBNrm3XfZCL4mcs40XTqG0L8hpRqtcPBpJES3GYHIb",
+	"mOuovFCFTGks+Ni+ZjoksDfeM+3QnTWWwficuOunxvdtWgZHt66q67ibtqmzU7t1zqeqW1rf6lazusQh",
+	"Ay/unoHjErISRiCBD3Hln7M8IbmYsOGEJJnSZAAlcpx1v45hc6ht0PAYN2NqcNs8EnIsdKsabshVsa4S",
+	"b03Dj2UE4qYu7FxuNI9prQpZzSdnF4RW1nZ868LKx4XzD5qY3onC8WwLr28MyTlPrCJaM9kkrfFZFWw9",
+	"0VuRsAmMLJbre/P6bnYk81GubW9LqtUwPhe4NmcVW701Sz3HwYiyGOZFX2ljaxnMEjYGXcaw1LpAEJle",
+	"igR8v4662Kb3MWP1te1abp/luZhBxk1mDGf1GpPoCotaOq8s8s/lUbX+6LZs5fKiJkfSDmYuTGqDo0KS",
+	"VMIXJjJFXF8r7WyN5gbm9oZDXlqy9gEuyHzZ2j3Umq0q51pZsDXzTquPUSeN+1C8vlUXBFG+yZZVxDUW",
+	"LPtuXjZrKJhNTS/TLddi6z5IuDItU0kUPV+RdrrNFNPyzMwaOrp/q2u+13CblEhZd9DcGXW2B2LG00w3",
+	"EGt5c4WgLuW7DmAVrONAH2G7W/efNwRksYwtB1yuvhuibLXjPW/YOvdi2PwGDfkr/G8bfFjbquWuRiXc",
+	"WUfHr65B6THc2hq6fjVwGBg+a0nxWgK9Wh+05pKVVyBtbaEqyLKGYK/nMOYiqeSKc8/bOJGYXUWFY2Il",
+	"BsZMt/JkrFf8h6APme5jk1uVPeaCT3y1xC+pAvLj8fFHU06M6WBT7JczVa91x15Ut92uFJngkLyld2qy",
+	"mB7mlNcgp9Sk20YCc1Utvno4xXxVg9i5AvkFJMnbVAk82jt4vLcf3MgRq3RdBcDD3/8cgiYLmC/R+8kU",
+	"6zjUmqzUMsgemQaNQHmd45/pJUuyhPAMy1Mw6mrSJDbtpTOJ5E1g+c8MTJG1iyzHLDEQKyes8K2Nv2S7",
+	"NeXQ1n1yP5su+ixsnL8SXDOeucByzJTGOoiiosQcyBoWZ7RouW1yh7F8/Nr2yw+FNarPAFJVK7wgFxOh",
+	"XD1gUZmCUWw25gK7IUOqFrHw5/Wov2UQmxMwSkhNBtMQxzlil+CWt9OgdRoU7xk3FYvAI+RCyAjkAjaw",
+	"uV9weRVcUCtZb/3n9/jl/7qXP3znTxHcfWoO8bxORs5kkMSoJrgg9B1B9FFzzdqmzSwM/tHCo4Kt8qzg",
+	"so8qpwpns227SEYlw0IzpAHGV2L6aBzPyys3ekfVxybx53XgXxl4HrnqzzsIl1r4bXe7VtKcjyYVhz+c",
+	"Wj6IHds3nkETsiwgvEHm2yLYbXDrJ+H8qlB4Ae0re/h0jQK9QkFW50oLiO0q8xakS1HiD6BYqCKqzcr1",
+	"1gFduNTfDO4wYbSGMazkkL+tirWvG37oAZSM4yGo/uulHsDqetHiMP4d1Ys+CEdjq7q1Kw59KMWhmyuc",
+	"qw29mZNROxuwLABRFh7vwhAbhCEeUCDg3mMA1Vr29SMBc+djdpGAna3+ap2jOSxvcqbmdnykJdGYB3eo",
+	"Zv8eDtXsAjQ7C7CZBcgDQxVQjRqXJS0+2DPnvUXuMqSWu8VokfdWvTRphfdWvc/J3UiCngnj8zfVmPNB",
+	"C50j++lSg7TSWTJpYaanOTuY0KwVjPkIlyVoXnfoRafqSj7tPH6+0pk8u8O9WlUwHryV91Btv0jDyt5e",
+	"U+Ty+ve5R9yudgtZTED1OMOTrZRVTCBXLjKgw3PgERlSjowoNuaIBnWDrAxRtUvNctNBKM+HanY+68UN",
+	"t+ORoMUrLkJRbXMTynRx3Ze5nmr6qXIj0+1Ufjlb1gyr04t86lzPxa1waK7G5Z1OdicRkgFV8PQxAT4U",
+	"kdmSFVMwmGpYft3MguuiTIOy9NXeGWVF5y+ArVWb2Y/dALdRdLxMI/z3jHk0pPqeSFBo2e/HPn4FKVmr",
+	"FXO3VRlVn79frChNyZvlXgfeN4RH+tpX5oq0NVJT7laqdZJT+XVJu8zUksyUuODFZVXbWg5zwWyWhyqv",
+	"2Crg9elH8hNM111IDOQ2X0fM1SvLfOQT02CFd/yWxRqkvccFcxvuKL7PEXWvSjY3uotpFu7CrNet9rJS",
+	"qpR5CenOG35r9V5m1Pdf9ZXXPq8b682cSu6CvA+83CsXVG7irS1dVeB1ojY6WrTe2ZgHch5nFzn8iu8x",
+	"qVVzzR1XyqFe+BjtK3td5hpecqEAq3xkA6Gdg3zfN10YMWzmDi9AT7jULb3LSqylVuu+a7AeuEwP8wNK",
+	"81VOlfVv5f6m8p+O3E1504NYZrcE2F1h04ogAh0ORbbFy1Y2US9X2bT2glvEqZZt821kalfEtPHuGid6",
+	"d4bqHnfTFsnX2U/nNw3vttS7peDrWgpyT+tvqgriG0aUb8nj8kY2elFUyX3cvseVK/12QxtVqv4kAY2i",
+	"+45u1PIjOx3/6nS8FxUytP+XU93vqyj5Ot2as/fWBJjiqKBNU9b+sh/Mzmb/NwD+uzGs0nQAAA==",
 }
 
 // GetSwagger returns the content of the embedded swagger specification file
@@ -3,6 +3,8 @@
 package git
 
 import (
+	"time"
+
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -11,8 +13,29 @@ import (
 // It includes settings like the SSH address to listen on.
 type Config struct {
 	Address string
+
+	// RequireSignedBranches lists branch name globs (e.g. "main", "release/*")
+	// that only accept commits signed by a key registered in DepGit.
+	// The policy is enforced only when a Verifier is set on the server.
+	RequireSignedBranches []string
+
+	// LockTimeout bounds how long a push waits for another push to the same
+	// repository to finish. Zero uses DefaultLockTimeout.
+	LockTimeout time.Duration
+
+	// Locks selects where repository locks are kept: "memory" (the default)
+	// serializes pushes to this node only, "storage" shares them with all
+	// nodes using the same storage, see NewStorageLocker.
+	Locks string
+
+	// LeaseTTL is how long a lock outlives its holder. Zero uses
+	// DefaultLeaseTTL.
+	LeaseTTL time.Duration
 }
 
+// DefaultLockTimeout is the Config.LockTimeout used when none is set.
+const DefaultLockTimeout = 10 * time.Second
+
 func keyAuthOption(ctx ssh.Context, pk ssh.PublicKey) bool {
 	log.
 		WithField("user", ctx.User()).
@@ -2,11 +2,20 @@ package git
 
 import (
 	"bufio"
+	"context"
+	stderrors "errors"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/GoldenDeals/DepGit/internal/database"
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
 	"github.com/gliderlabs/ssh"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage"
 )
 
 func (s *Server) handler(conn ssh.Session) {
@@ -43,19 +52,63 @@ func (s *Server) handler(conn ssh.Session) {
 	}
 }
 
-func (s *Server) handleReceivePack(conn ssh.Session, repoName string) {
+// RepoStore is the part of the data layer the server looks repositories up in
+type RepoStore interface {
+	GetRepos(ctx context.Context) ([]database.Repo, error)
+}
+
+// resolveRepo turns the path a client pushes to, such as "/name.git", into
+// the name of an existing repository, which is also its storage namespace
+func (s *Server) resolveRepo(ctx context.Context, path string) (string, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(path, "/"), ".git")
+	if err := stroage.CheckNamespace(name); err != nil {
+		return "", err
+	}
+	if s.repos == nil {
+		return name, nil
+	}
+
+	repos, err := s.repos.GetRepos(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !slices.ContainsFunc(repos, func(r database.Repo) bool { return r.Name == name }) {
+		return "", errors.ErrNotFound.Msg("repository " + strconv.Quote(name) + " does not exist")
+	}
+	return name, nil
+}
+
+func (s *Server) handleReceivePack(conn ssh.Session, repoPath string) {
 	log.
 		WithContext(conn.Context()).
 		WithField("user", conn.User()).
 		WithField("addr", conn.RemoteAddr()).
-		WithField("repo", repoName).
+		WithField("repo", repoPath).
 		Trace("Received git-receive-pack command")
 
+	// Nothing may be locked or stored under a name that is not an existing
+	// repository, such as "../x"
+	repoName, err := s.resolveRepo(conn.Context(), repoPath)
+	if err != nil {
+		log.
+			WithContext(conn.Context()).
+			WithField("user", conn.User()).
+			WithField("addr", conn.RemoteAddr()).
+			WithField("repo", repoPath).
+			WithError(err).
+			Warn("Rejected push to an unknown repository")
+
+		errorMsg := fmt.Sprintf("ERR %s\n", err)
+		conn.Write([]byte(fmt.Sprintf("%04x%s", len(errorMsg)+4, errorMsg)))
+		conn.Exit(1)
+		return
+	}
+
 	// Step 1: Advertise references - send list of refs to client
 	// In a real implementation, this would get actual refs from the repository
 	// For now, we'll just advertise a sample ref
 	capabilities := "report-status delete-refs side-band-64k"
-	ref := "refs/heads/main"
+	ref := DefaultBranch.String()
 	objID := "0000000000000000000000000000000000000000" // Zero hash
 
 	// Format: <len><objID> <ref>\0<capabilities>\n
@@ -176,22 +229,81 @@ func (s *Server) handleReceivePack(conn ssh.Session, repoName string) {
 			Debug("Parsed object")
 	}
 
-	// Step 4: Send success response
-	// In real implementation, we would process the commands here
-	// Format responses according to pkt-line
+	pack := make(map[plumbing.Hash]plumbing.EncodedObject, len(objs))
+	for _, obj := range objs {
+		pack[obj.Hash()] = obj
+	}
+
+	// Step 4: Store the objects. They are content addressed, so this needs
+	// no lock, and a push failing later only leaves unreferenced objects.
+	st := NewStorer(conn.Context(), s.storage, repoName)
+	for _, obj := range objs {
+		if _, err := st.SetEncodedObject(obj); err != nil {
+			log.
+				WithContext(conn.Context()).
+				WithField("repo", repoName).
+				WithField("objHash", obj.Hash()).
+				WithError(err).
+				Error("Failed to store object")
+
+			errorMsg := "unpack error: failed to store objects\n"
+			conn.Write([]byte(fmt.Sprintf("%04x%s", len(errorMsg)+4, errorMsg)))
+			conn.Write([]byte("0000"))
+			conn.Exit(1)
+			return
+		}
+	}
 
 	// Unpack OK message
 	unpackOk := "unpack ok\n"
 	conn.Write([]byte(fmt.Sprintf("%04x%s", len(unpackOk)+4, unpackOk)))
 
-	// Command status (for each reference update)
+	// Step 5: Check the policies of every reference update
+	var updates []refUpdate
+	var reasons []string
 	for _, cmd := range commands {
-		parts := strings.Fields(cmd)
-		if len(parts) >= 2 {
-			refName := parts[1]
-			status := fmt.Sprintf("ok %s\n", refName)
-			conn.Write([]byte(fmt.Sprintf("%04x%s", len(status)+4, status)))
+		upd, err := parseRefUpdate(strings.TrimSuffix(cmd, "\n"))
+		if err != nil {
+			log.
+				WithContext(conn.Context()).
+				WithField("command", cmd).
+				WithField("repo", repoName).
+				WithError(err).
+				Debug("Skipping malformed command")
+			continue
+		}
+
+		reason, err := s.checkSignedCommits(conn.Context(), upd, pack)
+		if err != nil {
+			reason = "signature verification failed"
+			log.
+				WithContext(conn.Context()).
+				WithField("repo", repoName).
+				WithField("ref", upd.Name).
+				WithError(err).
+				Error("Failed to verify commit signatures")
+		}
+		updates = append(updates, upd)
+		reasons = append(reasons, reason)
+	}
+
+	// Step 6: Apply the accepted updates under the repository lock
+	s.updateRefs(conn.Context(), st, repoName, updates, reasons)
+
+	// Command status (for each reference update)
+	for i, upd := range updates {
+		status := fmt.Sprintf("ok %s\n", upd.Name)
+		if reasons[i] != "" {
+			status = fmt.Sprintf("ng %s %s\n", upd.Name, reasons[i])
+			log.
+				WithContext(conn.Context()).
+				WithField("user", conn.User()).
+				WithField("repo", repoName).
+				WithField("ref", upd.Name).
+				WithField("reason", reasons[i]).
+				Info("Rejected ref update")
 		}
+		conn.Write([]byte(fmt.Sprintf("%04x%s", len(status)+4, status)))
 	}
 
 	// Send flush packet to end the response
@@ -216,3 +328,104 @@ func (s *Server) handleUploadPack(conn ssh.Session) {
 
 	panic("not implemented")
 }
+
+// updateRefs applies the updates of a push whose reason is still empty, all
+// under one hold of the repository lock, and records why any of them failed.
+func (s *Server) updateRefs(ctx context.Context, st *Storer, repo string, updates []refUpdate, reasons []string) {
+	pending := false
+	for _, reason := range reasons {
+		pending = pending || reason == ""
+	}
+	if !pending {
+		return
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, s.config.LockTimeout)
+	lease, err := s.locker.Lock(lockCtx, repo)
+	cancel()
+	if err != nil {
+		reason := "failed to lock repository"
+		if stderrors.Is(err, errors.ErrLocked) {
+			reason = "repository is locked by another push, try again"
+		} else {
+			log.
+				WithContext(ctx).
+				WithField("repo", repo).
+				WithError(err).
+				Error("Failed to lock repository")
+		}
+		for i := range reasons {
+			if reasons[i] == "" {
+				reasons[i] = reason
+			}
+		}
+		return
+	}
+	defer func() {
+		if err := lease.Unlock(); err != nil {
+			log.
+				WithContext(ctx).
+				WithField("repo", repo).
+				WithError(err).
+				Warn("Failed to unlock repository")
+		}
+	}()
+
+	for i, upd := range updates {
+		if reasons[i] != "" {
+			continue
+		}
+
+		select {
+		case <-lease.Lost():
+			reasons[i] = "repository lock lost"
+			continue
+		default:
+		}
+
+		reason, err := applyRefUpdate(st, upd)
+		if err != nil {
+			reason = "failed to update ref"
+			log.
+				WithContext(ctx).
+				WithField("repo", repo).
+				WithField("ref", upd.Name).
+				WithError(err).
+				Error("Failed to update ref")
+		}
+		reasons[i] = reason
+	}
+}
+
+// applyRefUpdate performs a single update, returning why it was refused if
+// the ref no longer has the value the client based it on.
+func applyRefUpdate(st *Storer, upd refUpdate) (string, error) {
+	current, err := st.Reference(upd.Name)
+	if stderrors.Is(err, plumbing.ErrReferenceNotFound) {
+		current = nil
+	} else if err != nil {
+		return "", err
+	}
+
+	switch {
+	case upd.Old.IsZero() && current != nil:
+		return "reference already exists", nil
+	case !upd.Old.IsZero() && (current == nil || current.Hash() != upd.Old):
+		return "stale old value, fetch first", nil
+	}
+
+	if upd.New.IsZero() {
+		return "", st.RemoveReference(upd.Name)
+	}
+
+	ref := plumbing.NewHashReference(upd.Name, upd.New)
+	if current == nil {
+		return "", st.SetReference(ref)
+	}
+
+	err = st.CheckAndSetReference(ref, current)
+	if stderrors.Is(err, storage.ErrReferenceHasChanged) || stderrors.Is(err, plumbing.ErrReferenceNotFound) {
+		return "stale old value, fetch first", nil
+	}
+	return "", err
+}
@@ -0,0 +1,41 @@
+package git
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoldenDeals/DepGit/internal/database"
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveRepo(t *testing.T) {
+	ctx := context.Background()
+
+	store := database.NewMemoryStore()
+	repo := database.NewRepo("project")
+	require.NoError(t, store.CreateRepo(ctx, &repo))
+	deleted := database.NewRepo("gone")
+	require.NoError(t, store.CreateRepo(ctx, &deleted))
+	require.NoError(t, store.DeleteRepo(ctx, deleted.ID))
+
+	s := &Server{}
+	s.SetRepos(store)
+
+	for _, path := range []string{"project", "/project", "project.git", "/project.git"} {
+		name, err := s.resolveRepo(ctx, path)
+		require.NoError(t, err, path)
+		assert.Equal(t, "project", name, path)
+	}
+
+	for _, path := range []string{"", "/", "../project", "/../project.git", "a/project", ".git"} {
+		_, err := s.resolveRepo(ctx, path)
+		assert.ErrorIs(t, err, errors.ErrBadData, path)
+	}
+
+	_, err := s.resolveRepo(ctx, "/missing.git")
+	assert.ErrorIs(t, err, errors.ErrNotFound, "pushing must not create repositories")
+	_, err = s.resolveRepo(ctx, "gone")
+	assert.ErrorIs(t, err, errors.ErrNotFound, "deleted repositories take no pushes")
+}
@@ -0,0 +1,383 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+)
+
+// DefaultLeaseTTL is how long a repository lock outlives its last renewal
+const DefaultLeaseTTL = 30 * time.Second
+
+// Polling bounds while waiting for a held lock
+const (
+	lockPollMin = 10 * time.Millisecond
+	lockPollMax = time.Second
+)
+
+// Locker hands out exclusive per-repository locks. Locks are leases: the
+// holder renews them in the background, and a lock whose holder stopped
+// renewing, e.g. because its node died, can be taken over once the lease
+// expired, so a crash never blocks a repository for good.
+type Locker interface {
+	// Lock waits until it holds the lock of repo. It fails with
+	// errors.ErrLocked if ctx is done first.
+	Lock(ctx context.Context, repo string) (Lease, error)
+}
+
+// Lease is a held repository lock.
+type Lease interface {
+	// Lost is closed when the lease was taken over or expired because it
+	// could not be renewed. Another holder may own the lock by then, so the
+	// work it guards must stop.
+	Lost() <-chan struct{}
+
+	// Unlock releases the lock.
+	Unlock() error
+}
+
+// leaseStore keeps the lease records of a LeaseLocker. Tokens identify a
+// single grant or renewal of a lease.
+type leaseStore interface {
+	// acquire takes the lock of repo for ttl unless another lease on it is
+	// still valid, in which case it reports false
+	acquire(ctx context.Context, repo string, ttl time.Duration) (token string, ok bool, err error)
+
+	// renew extends the lease granted as token, failing with
+	// errors.ErrLocked if it was taken over
+	renew(ctx context.Context, repo, token string, ttl time.Duration) (string, error)
+
+	release(ctx context.Context, repo, token string) error
+}
+
+// LeaseLocker implements Locker on top of a lease store.
+type LeaseLocker struct {
+	store leaseStore
+	ttl   time.Duration
+}
+
+// NewMemoryLocker creates a Locker for a single node, which keeps its
+// leases in memory. A non-positive ttl uses DefaultLeaseTTL.
+func NewMemoryLocker(ttl time.Duration) *LeaseLocker {
+	return newLeaseLocker(&memoryLeases{held: make(map[string]memoryLease)}, ttl)
+}
+
+// NewStorageLocker creates a Locker shared by all nodes using st. Leases
+// are objects written with conditional puts, so st must be shared between
+// the nodes and honour PutCondition atomically. Expiry is judged by the
+// local clock, so the ttl must exceed the clock skew between nodes.
+// A non-positive ttl uses DefaultLeaseTTL.
+func NewStorageLocker(st stroage.Storage, ttl time.Duration) *LeaseLocker {
+	owner, _ := os.Hostname()
+	owner += ":" + strconv.Itoa(os.Getpid())
+	return newLeaseLocker(&storageLeases{storage: st, owner: owner}, ttl)
+}
+
+func newLeaseLocker(store leaseStore, ttl time.Duration) *LeaseLocker {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &LeaseLocker{store: store, ttl: ttl}
+}
+
+// Lock waits until it holds the lock of repo, polling with backoff.
+func (l *LeaseLocker) Lock(ctx context.Context, repo string) (Lease, error) {
+	delay := lockPollMin
+	for {
+		token, ok, err := l.store.acquire(ctx, repo, l.ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			le := &lease{
+				locker:  l,
+				repo:    repo,
+				token:   token,
+				expires: time.Now().Add(l.ttl),
+				lost:    make(chan struct{}),
+				done:    make(chan struct{}),
+			}
+			go le.keep()
+			return le, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.ErrLocked
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, lockPollMax)
+	}
+}
+
+type lease struct {
+	locker *LeaseLocker
+	repo   string
+
+	// Guards token against concurrent renewal and release
+	mu       sync.Mutex
+	token    string
+	released bool
+
+	// expires is when the lease runs out without further renewal, by the
+	// local clock at the start of the last successful renewal
+	expires time.Time
+
+	lost chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (le *lease) Lost() <-chan struct{} {
+	return le.lost
+}
+
+// keep renews the lease until it is released or lost. Failed renewals are
+// retried more often, the lease is only lost once it was taken over or
+// would expire before the next attempt.
+func (le *lease) keep() {
+	interval := le.locker.ttl / 3
+	retry := interval / 4
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-le.done:
+			return
+		case <-ticker.C:
+		}
+
+		le.mu.Lock()
+		if le.released {
+			le.mu.Unlock()
+			return
+		}
+		// Renewing after the lease expired could race with a takeover
+		start := time.Now()
+		deadline := start.Add(interval)
+		if le.expires.Before(deadline) {
+			deadline = le.expires
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		token, err := le.locker.store.renew(ctx, le.repo, le.token, le.locker.ttl)
+		cancel()
+		if err == nil {
+			le.token = token
+			le.expires = start.Add(le.locker.ttl)
+		}
+		expires := le.expires
+		le.mu.Unlock()
+
+		switch {
+		case err == nil:
+			ticker.Reset(interval)
+		case !stderrors.Is(err, errors.ErrLocked) && time.Now().Add(retry).Before(expires):
+			log.
+				WithField("repo", le.repo).
+				WithError(err).
+				Warn("Renewing repository lock failed, retrying")
+			ticker.Reset(retry)
+		default:
+			log.
+				WithField("repo", le.repo).
+				WithError(err).
+				Warn("Repository lock lost")
+			close(le.lost)
+			return
+		}
+	}
+}
+
+func (le *lease) Unlock() error {
+	le.once.Do(func() { close(le.done) })
+
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	if le.released {
+		return nil
+	}
+	le.released = true
+
+	select {
+	case <-le.lost:
+		return errors.ErrLocked
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), le.locker.ttl)
+	defer cancel()
+	return le.locker.store.release(ctx, le.repo, le.token)
+}
+
+// memoryLeases keeps the leases of a single process
+type memoryLeases struct {
+	mu   sync.Mutex
+	next uint64
+	held map[string]memoryLease
+}
+
+type memoryLease struct {
+	token   string
+	expires time.Time
+}
+
+func (m *memoryLeases) acquire(_ context.Context, repo string, ttl time.Duration) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.held[repo]; ok && time.Now().Before(current.expires) {
+		return "", false, nil
+	}
+
+	m.next++
+	token := strconv.FormatUint(m.next, 10)
+	m.held[repo] = memoryLease{token: token, expires: time.Now().Add(ttl)}
+	return token, true, nil
+}
+
+func (m *memoryLeases) renew(_ context.Context, repo, token string, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.held[repo]; !ok || current.token != token {
+		return "", errors.ErrLocked
+	}
+	m.held[repo] = memoryLease{token: token, expires: time.Now().Add(ttl)}
+	return token, nil
+}
+
+func (m *memoryLeases) release(_ context.Context, repo, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.held[repo]; !ok || current.token != token {
+		return errors.ErrLocked
+	}
+	delete(m.held, repo)
+	return nil
+}
+
+// lockNamespace holds the lease objects of storage-backed locks
+const lockNamespace = ".locks"
+
+// storageLeases keeps leases as objects in a shared storage. The token of
+// a lease is the checksum of its object, so every renewal and release is
+// conditional on nobody having taken the lock over in between.
+type storageLeases struct {
+	storage stroage.Storage
+	owner   string
+}
+
+// leaseRecord is the content of a lease object. The nonce keeps records
+// of different grants apart even when everything else matches.
+type leaseRecord struct {
+	Owner   string    `json:"owner"`
+	Nonce   string    `json:"nonce"`
+	Expires time.Time `json:"expires"`
+}
+
+func leaseObject(repo string) string {
+	return repo + ".lock"
+}
+
+func (s *storageLeases) put(ctx context.Context, repo string, expires time.Time, cond stroage.PutCondition) (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(leaseRecord{Owner: s.owner, Nonce: hex.EncodeToString(nonce), Expires: expires})
+	if err != nil {
+		return "", err
+	}
+
+	info, err := s.storage.PutIf(ctx, lockNamespace, leaseObject(repo), bytes.NewReader(data), cond)
+	if err != nil {
+		return "", err
+	}
+	return info.Checksum, nil
+}
+
+func (s *storageLeases) acquire(ctx context.Context, repo string, ttl time.Duration) (string, bool, error) {
+	cond := stroage.PutCondition{IfAbsent: true}
+
+	// Stat before reading: if the lease changes in between, the checksum no
+	// longer matches and the conditional write below fails
+	info, err := s.storage.Stat(ctx, lockNamespace, leaseObject(repo))
+	switch {
+	case err == nil:
+		current, err := s.read(ctx, repo)
+		if stroage.IsNotExist(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if time.Now().Before(current.Expires) {
+			return "", false, nil
+		}
+		cond = stroage.PutCondition{IfMatch: info.Checksum}
+	case !stroage.IsNotExist(err):
+		return "", false, err
+	}
+
+	token, err := s.put(ctx, repo, time.Now().Add(ttl), cond)
+	if stderrors.Is(err, errors.ErrPreconditionFailed) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+func (s *storageLeases) read(ctx context.Context, repo string) (leaseRecord, error) {
+	r, _, err := s.storage.Get(ctx, lockNamespace, leaseObject(repo))
+	if err != nil {
+		return leaseRecord{}, err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return leaseRecord{}, err
+	}
+
+	var rec leaseRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return leaseRecord{}, errors.ErrBadData.Msg(fmt.Sprintf("malformed lease of %s: %s", repo, err))
+	}
+	return rec, nil
+}
+
+func (s *storageLeases) renew(ctx context.Context, repo, token string, ttl time.Duration) (string, error) {
+	token, err := s.put(ctx, repo, time.Now().Add(ttl), stroage.PutCondition{IfMatch: token})
+	if stderrors.Is(err, errors.ErrPreconditionFailed) {
+		return "", errors.ErrLocked
+	}
+	return token, err
+}
+
+// release marks the lease expired. The object is kept, as storages offer
+// no conditional delete that would leave a lease taken over meanwhile alone.
+func (s *storageLeases) release(ctx context.Context, repo, token string) error {
+	_, err := s.put(ctx, repo, time.Time{}, stroage.PutCondition{IfMatch: token})
+	if stderrors.Is(err, errors.ErrPreconditionFailed) {
+		return errors.ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,263 @@
+package git
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockers(t *testing.T) {
+	lockers := map[string]func(ttl time.Duration) *LeaseLocker{
+		"Memory": NewMemoryLocker,
+		"Storage": func(ttl time.Duration) *LeaseLocker {
+			return NewStorageLocker(stroage.NewMemoryStorage(), ttl)
+		},
+	}
+
+	for name, newLocker := range lockers {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("Locks are exclusive per repository", func(t *testing.T) {
+				l := newLocker(time.Minute)
+
+				lease, err := l.Lock(ctx, "repo")
+				require.NoError(t, err)
+
+				other, err := l.Lock(ctx, "other/repo")
+				require.NoError(t, err)
+				require.NoError(t, other.Unlock())
+
+				timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+				defer cancel()
+				_, err = l.Lock(timeout, "repo")
+				assert.ErrorIs(t, err, errors.ErrLocked)
+
+				require.NoError(t, lease.Unlock())
+				require.NoError(t, lease.Unlock(), "unlocking twice is a no-op")
+
+				lease, err = l.Lock(ctx, "repo")
+				require.NoError(t, err)
+				require.NoError(t, lease.Unlock())
+			})
+
+			t.Run("Waiters get the lock once it is released", func(t *testing.T) {
+				l := newLocker(time.Minute)
+
+				var (
+					mu      sync.Mutex
+					holders int
+					wg      sync.WaitGroup
+				)
+				for range 5 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						lease, err := l.Lock(ctx, "repo")
+						if !assert.NoError(t, err) {
+							return
+						}
+
+						mu.Lock()
+						holders++
+						assert.Equal(t, 1, holders)
+						mu.Unlock()
+
+						time.Sleep(5 * time.Millisecond)
+
+						mu.Lock()
+						holders--
+						mu.Unlock()
+						assert.NoError(t, lease.Unlock())
+					}()
+				}
+				wg.Wait()
+			})
+
+			t.Run("Held leases are renewed", func(t *testing.T) {
+				l := newLocker(60 * time.Millisecond)
+
+				lease, err := l.Lock(ctx, "repo")
+				require.NoError(t, err)
+				defer lease.Unlock()
+
+				time.Sleep(150 * time.Millisecond)
+				select {
+				case <-lease.Lost():
+					t.Fatal("Lease was lost")
+				default:
+				}
+
+				timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+				defer cancel()
+				_, err = l.Lock(timeout, "repo")
+				assert.ErrorIs(t, err, errors.ErrLocked)
+			})
+
+			t.Run("Expired leases are taken over", func(t *testing.T) {
+				l := newLocker(20 * time.Millisecond)
+
+				// A holder that died without renewing
+				_, ok, err := l.store.acquire(ctx, "repo", l.ttl)
+				require.NoError(t, err)
+				require.True(t, ok)
+
+				timeout, cancel := context.WithTimeout(ctx, time.Second)
+				defer cancel()
+				lease, err := l.Lock(timeout, "repo")
+				require.NoError(t, err)
+				require.NoError(t, lease.Unlock())
+			})
+		})
+	}
+
+	t.Run("Storage leases are shared between lockers", func(t *testing.T) {
+		ctx := context.Background()
+		st := stroage.NewMemoryStorage()
+		first := NewStorageLocker(st, time.Minute)
+		second := NewStorageLocker(st, time.Minute)
+
+		lease, err := first.Lock(ctx, "repo")
+		require.NoError(t, err)
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = second.Lock(timeout, "repo")
+		assert.ErrorIs(t, err, errors.ErrLocked)
+
+		require.NoError(t, lease.Unlock())
+		lease, err = second.Lock(ctx, "repo")
+		require.NoError(t, err)
+		require.NoError(t, lease.Unlock())
+	})
+
+	t.Run("Failed renewals are retried until the lease expires", func(t *testing.T) {
+		ctx := context.Background()
+		st := &flakyStorage{Storage: stroage.NewMemoryStorage()}
+		l := NewStorageLocker(st, 90*time.Millisecond)
+
+		lease, err := l.Lock(ctx, "repo")
+		require.NoError(t, err)
+
+		st.failing.Store(true)
+		time.Sleep(50 * time.Millisecond)
+		st.failing.Store(false)
+		time.Sleep(150 * time.Millisecond)
+		select {
+		case <-lease.Lost():
+			t.Fatal("Lease was lost")
+		default:
+		}
+		require.NoError(t, lease.Unlock())
+
+		lease, err = l.Lock(ctx, "repo")
+		require.NoError(t, err)
+		st.failing.Store(true)
+		select {
+		case <-lease.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("Expected the lease to be lost")
+		}
+		assert.ErrorIs(t, lease.Unlock(), errors.ErrLocked)
+	})
+
+	t.Run("Leases taken over are lost", func(t *testing.T) {
+		ctx := context.Background()
+		st := stroage.NewMemoryStorage()
+		l := NewStorageLocker(st, 30*time.Millisecond)
+
+		lease, err := l.Lock(ctx, "repo")
+		require.NoError(t, err)
+
+		_, err = st.PutIf(ctx, lockNamespace, leaseObject("repo"), strings.NewReader(`{"owner":"intruder"}`), stroage.PutCondition{})
+		require.NoError(t, err)
+
+		select {
+		case <-lease.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("Expected the lease to be lost")
+		}
+		assert.ErrorIs(t, lease.Unlock(), errors.ErrLocked)
+	})
+}
+
+// flakyStorage fails writes while failing is set
+type flakyStorage struct {
+	stroage.Storage
+	failing atomic.Bool
+}
+
+func (s *flakyStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond stroage.PutCondition) (stroage.ObjectInfo, error) {
+	if s.failing.Load() {
+		return stroage.ObjectInfo{}, stderrors.New("storage unavailable")
+	}
+	return s.Storage.PutIf(ctx, namespace, objname, obj, cond)
+}
+
+func TestUpdateRefs(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{
+		config: Config{LockTimeout: 50 * time.Millisecond},
+		locker: NewMemoryLocker(time.Minute),
+	}
+	st, _ := newTestStorer(t)
+
+	_, first := storeBlob(t, st, "first\n")
+	_, second := storeBlob(t, st, "second\n")
+	main := plumbing.NewBranchReferenceName("main")
+
+	update := func(old, new plumbing.Hash) string {
+		reasons := []string{""}
+		s.updateRefs(ctx, st, "repo", []refUpdate{{Old: old, New: new, Name: main}}, reasons)
+		return reasons[0]
+	}
+
+	t.Run("Applies updates", func(t *testing.T) {
+		assert.Empty(t, update(plumbing.ZeroHash, first))
+		assert.Empty(t, update(first, second))
+
+		ref, err := st.Reference(main)
+		require.NoError(t, err)
+		assert.Equal(t, second, ref.Hash())
+	})
+
+	t.Run("Refuses stale updates", func(t *testing.T) {
+		assert.Equal(t, "reference already exists", update(plumbing.ZeroHash, first))
+		assert.Equal(t, "stale old value, fetch first", update(first, second))
+	})
+
+	t.Run("Skips refused updates", func(t *testing.T) {
+		reasons := []string{"signature verification failed"}
+		s.updateRefs(ctx, st, "repo", []refUpdate{{Old: second, New: first, Name: main}}, reasons)
+		assert.Equal(t, "signature verification failed", reasons[0])
+
+		ref, err := st.Reference(main)
+		require.NoError(t, err)
+		assert.Equal(t, second, ref.Hash())
+	})
+
+	t.Run("Reports contended locks", func(t *testing.T) {
+		lease, err := s.locker.Lock(ctx, "repo")
+		require.NoError(t, err)
+		defer lease.Unlock()
+
+		assert.Equal(t, "repository is locked by another push, try again", update(second, first))
+	})
+
+	t.Run("Deletes refs", func(t *testing.T) {
+		assert.Empty(t, update(second, plumbing.ZeroHash))
+		_, err := st.Reference(main)
+		assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	})
+}
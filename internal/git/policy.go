@@ -0,0 +1,95 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// refUpdate is a single "<old> <new> <ref>" command sent by git-receive-pack.
+type refUpdate struct {
+	Old  plumbing.Hash
+	New  plumbing.Hash
+	Name plumbing.ReferenceName
+}
+
+// parseRefUpdate parses a receive-pack command line. The first line of a
+// push also carries the client capabilities after a NUL byte.
+func parseRefUpdate(line string) (refUpdate, error) {
+	if i := strings.IndexByte(line, 0); i >= 0 {
+		line = line[:i]
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !plumbing.IsHash(parts[0]) || !plumbing.IsHash(parts[1]) {
+		return refUpdate{}, errors.ErrBadData.Msg("malformed ref update")
+	}
+
+	return refUpdate{
+		Old:  plumbing.NewHash(parts[0]),
+		New:  plumbing.NewHash(parts[1]),
+		Name: plumbing.ReferenceName(parts[2]),
+	}, nil
+}
+
+// requiresSignedCommits reports whether ref is covered by the signed
+// branches policy.
+func (s *Server) requiresSignedCommits(ref plumbing.ReferenceName) bool {
+	if s.verifier == nil || !ref.IsBranch() {
+		return false
+	}
+
+	for _, g := range s.signedBranches {
+		if g.Match(ref.Short()) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignedCommits walks the commits a ref update introduces and returns
+// a rejection reason for the first one that is not signed by a registered
+// key. Commits that are not part of the received pack were accepted by an
+// earlier push and are not checked again. An empty reason means the
+// update is allowed.
+func (s *Server) checkSignedCommits(ctx context.Context, upd refUpdate, pack map[plumbing.Hash]plumbing.EncodedObject) (string, error) {
+	if !s.requiresSignedCommits(upd.Name) || upd.New.IsZero() {
+		return "", nil
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	queue := []plumbing.Hash{upd.New}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+
+		obj, ok := pack[h]
+		if !ok || obj.Type() != plumbing.CommitObject {
+			continue
+		}
+
+		res, err := s.verifier.Verify(ctx, obj)
+		if err != nil {
+			return "", err
+		}
+		if !res.Verified() {
+			return fmt.Sprintf("commit %s is not signed by a registered key (%s)", h, res.Status), nil
+		}
+
+		commit := new(object.Commit)
+		if err := commit.Decode(obj); err != nil {
+			return "", err
+		}
+		queue = append(queue, commit.ParentHashes...)
+	}
+
+	return "", nil
+}
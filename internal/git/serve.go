@@ -8,6 +8,7 @@ import (
 	"github.com/GoldenDeals/DepGit/internal/share/logger"
 	"github.com/GoldenDeals/DepGit/internal/stroage"
 	"github.com/gliderlabs/ssh"
+	"github.com/gobwas/glob"
 	gossh "golang.org/x/crypto/ssh"
 )
 
@@ -19,6 +20,13 @@ type Server struct {
 	config  Config
 	srv     ssh.Server
 	storage stroage.Storage
+
+	verifier       *Verifier
+	signedBranches []glob.Glob
+
+	repos RepoStore
+
+	locker Locker
 }
 
 // Init creates and initializes a new Git SSH server with the given configuration
@@ -37,6 +45,34 @@ func Init(c Config, stroag stroage.Storage) (*Server, error) {
 
 	s.config = c
 	s.storage = stroag
+	switch c.Locks {
+	case "", "memory":
+		s.locker = NewMemoryLocker(c.LeaseTTL)
+	case "storage":
+		s.locker = NewStorageLocker(stroag, c.LeaseTTL)
+	default:
+		log.
+			WithField("locks", c.Locks).
+			Error("Unknown repository lock kind")
+		return nil, errors.ErrBadData
+	}
+
+	if s.config.LockTimeout <= 0 {
+		s.config.LockTimeout = DefaultLockTimeout
+	}
+
+	for _, pattern := range c.RequireSignedBranches {
+		g, err := glob.Compile(pattern, '/')
+		if err != nil {
+			log.
+				WithField("pattern", pattern).
+				WithError(err).
+				Error("Invalid signed branch pattern")
+			return nil, errors.ErrBadData
+		}
+		s.signedBranches = append(s.signedBranches, g)
+	}
+
 	s.srv = ssh.Server{
 		Addr:   c.Address,
 		Banner: "---------------- DepGit ----------------\n",
@@ -80,6 +116,23 @@ func (s *Server) Serve(ctx context.Context) error {
 	}
 }
 
+// SetVerifier enables signature verification for pushes to the branches
+// listed in Config.RequireSignedBranches.
+func (s *Server) SetVerifier(v *Verifier) {
+	s.verifier = v
+}
+
+// SetRepos makes pushes to repositories missing from repos fail. Without
+// it any valid repository name is accepted.
+func (s *Server) SetRepos(repos RepoStore) {
+	s.repos = repos
+}
+
+// SetLocker replaces the repository locks selected by Config.Locks.
+func (s *Server) SetLocker(l Locker) {
+	s.locker = l
+}
+
 func (s *Server) Close() error {
 	log.Warn("Closing git ssh server")
 	return s.srv.Close()
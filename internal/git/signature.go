@@ -0,0 +1,324 @@
+package git
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/GoldenDeals/DepGit/internal/database"
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/uuid"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// SignatureStatus is the outcome of a signature verification.
+type SignatureStatus string
+
+const (
+	// SignatureVerified means the signature is valid and made by a registered key.
+	SignatureVerified SignatureStatus = "verified"
+	// SignatureUnsigned means the object carries no signature at all.
+	SignatureUnsigned SignatureStatus = "unsigned"
+	// SignatureUnknownKey means the signature could not be matched to any registered key.
+	SignatureUnknownKey SignatureStatus = "unknown_key"
+	// SignatureBad means the signature is malformed or does not match the content.
+	SignatureBad SignatureStatus = "bad_signature"
+)
+
+// SignatureFormat identifies how an object was signed.
+type SignatureFormat string
+
+const (
+	SignatureFormatOpenPGP SignatureFormat = "openpgp"
+	SignatureFormatSSH     SignatureFormat = "ssh"
+)
+
+const sshSignatureArmorHeader = "-----BEGIN SSH SIGNATURE-----"
+
+// Verification describes the signature state of a single commit or tag.
+type Verification struct {
+	ObjectID plumbing.Hash
+	Status   SignatureStatus
+	Format   SignatureFormat
+
+	// Set only when the signing key is registered in DepGit.
+	KeyID  uuid.UUID
+	UserID uuid.UUID
+
+	Fingerprint string
+	Reason      string
+}
+
+// Verified reports whether the object is signed by a registered key.
+func (v Verification) Verified() bool {
+	return v.Status == SignatureVerified
+}
+
+// KeyStore provides the keys that signatures are checked against.
+// *database.DB satisfies it.
+type KeyStore interface {
+	GetKeysByType(ctx context.Context, types ...database.SSH_KEY_TYPE) ([]database.SshKey, error)
+}
+
+// VerifierCacheSize is the number of results a Verifier keeps. Objects
+// verified through the API are chosen by clients, so the cache is bounded.
+const VerifierCacheSize = 4096
+
+// Verifier checks OpenPGP and SSH signatures on commits and tags against
+// the keys users registered in DepGit. The results of the most recently
+// used objects are cached per object id; call Invalidate whenever the set
+// of registered keys changes.
+type Verifier struct {
+	keys KeyStore
+
+	mu       sync.Mutex
+	capacity int
+	// cache holds the elements of order, whose values are Verifications,
+	// most recently used first
+	cache map[plumbing.Hash]*list.Element
+	order *list.List
+}
+
+// NewVerifier creates a signature verifier backed by the given key store.
+func NewVerifier(keys KeyStore) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		capacity: VerifierCacheSize,
+		cache:    make(map[plumbing.Hash]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Invalidate drops all cached results.
+func (v *Verifier) Invalidate() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.cache = make(map[plumbing.Hash]*list.Element)
+	v.order.Init()
+}
+
+// cached returns the cached result for an object
+func (v *Verifier) cached(id plumbing.Hash) (Verification, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	el, ok := v.cache[id]
+	if !ok {
+		return Verification{}, false
+	}
+	v.order.MoveToFront(el)
+	return el.Value.(Verification), true
+}
+
+// remember caches a result, evicting the least recently used ones
+func (v *Verifier) remember(res Verification) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if el, ok := v.cache[res.ObjectID]; ok {
+		el.Value = res
+		v.order.MoveToFront(el)
+		return
+	}
+	v.cache[res.ObjectID] = v.order.PushFront(res)
+	for v.order.Len() > v.capacity {
+		oldest := v.order.Back()
+		v.order.Remove(oldest)
+		delete(v.cache, oldest.Value.(Verification).ObjectID)
+	}
+}
+
+// Verify checks the signature of a commit or tag object. Objects of other
+// types are rejected with errors.ErrBadData. A returned Verification with
+// a non-verified status is not an error.
+func (v *Verifier) Verify(ctx context.Context, obj plumbing.EncodedObject) (Verification, error) {
+	if obj == nil {
+		return Verification{}, errors.ErrBadData
+	}
+
+	id := obj.Hash()
+	if cached, ok := v.cached(id); ok {
+		return cached, nil
+	}
+
+	signature, payload, err := signedPayload(obj)
+	if err != nil {
+		return Verification{}, err
+	}
+
+	res := Verification{ObjectID: id}
+	if signature == "" {
+		res.Status = SignatureUnsigned
+	} else if strings.HasPrefix(strings.TrimSpace(signature), sshSignatureArmorHeader) {
+		res.Format = SignatureFormatSSH
+		err = v.verifySSH(ctx, &res, signature, payload)
+	} else {
+		res.Format = SignatureFormatOpenPGP
+		err = v.verifyOpenPGP(ctx, &res, signature, payload)
+	}
+	if err != nil {
+		return Verification{}, err
+	}
+
+	v.remember(res)
+
+	log.
+		WithField("object", id).
+		WithField("status", res.Status).
+		WithField("format", res.Format).
+		Trace("Signature verified")
+	return res, nil
+}
+
+// signedPayload extracts the armored signature and the bytes it covers.
+func signedPayload(obj plumbing.EncodedObject) (string, []byte, error) {
+	var (
+		signature string
+		encoder   interface {
+			EncodeWithoutSignature(plumbing.EncodedObject) error
+		}
+	)
+
+	switch obj.Type() {
+	case plumbing.CommitObject:
+		commit := new(object.Commit)
+		if err := commit.Decode(obj); err != nil {
+			log.WithError(err).Debug("Malformed commit")
+			return "", nil, errors.ErrBadData
+		}
+		signature, encoder = commit.PGPSignature, commit
+	case plumbing.TagObject:
+		tag := new(object.Tag)
+		if err := tag.Decode(obj); err != nil {
+			log.WithError(err).Debug("Malformed tag")
+			return "", nil, errors.ErrBadData
+		}
+		signature, encoder = tag.PGPSignature, tag
+	default:
+		return "", nil, errors.ErrBadData
+	}
+
+	if signature == "" {
+		return "", nil, nil
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := encoder.EncodeWithoutSignature(encoded); err != nil {
+		return "", nil, err
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+
+	payload, err := io.ReadAll(reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return signature, payload, nil
+}
+
+func (v *Verifier) verifySSH(ctx context.Context, res *Verification, signature string, payload []byte) error {
+	sig, err := parseSSHSignature([]byte(signature))
+	if err != nil {
+		res.Status = SignatureBad
+		res.Reason = err.Error()
+		return nil
+	}
+	res.Fingerprint = gossh.FingerprintSHA256(sig.PublicKey)
+
+	if err := sig.verify(payload); err != nil {
+		res.Status = SignatureBad
+		res.Reason = err.Error()
+		return nil
+	}
+
+	keys, err := v.keys.GetKeysByType(ctx,
+		database.SSH_KEY_TYPE_RSA,
+		database.RSA_SHA2_256,
+		database.RSA_SHA2_512,
+		database.SSH_RSA,
+		database.ECDSA_SHA2_NISTP256,
+		database.SSH_ED25519,
+	)
+	if err != nil {
+		return err
+	}
+
+	signer := sig.PublicKey.Marshal()
+	for _, key := range keys {
+		pub, _, _, _, err := gossh.ParseAuthorizedKey(key.Data)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(pub.Marshal(), signer) {
+			res.Status = SignatureVerified
+			res.KeyID = key.ID
+			res.UserID = key.UserID
+			return nil
+		}
+	}
+
+	res.Status = SignatureUnknownKey
+	res.Reason = "signing key is not registered"
+	return nil
+}
+
+func (v *Verifier) verifyOpenPGP(ctx context.Context, res *Verification, signature string, payload []byte) error {
+	keys, err := v.keys.GetKeysByType(ctx, database.OPENPGP_KEY)
+	if err != nil {
+		return err
+	}
+
+	// Map every primary key of every registered key ring back to its owner
+	var keyring openpgp.EntityList
+	owners := make(map[uint64]database.SshKey)
+	for _, key := range keys {
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key.Data))
+		if err != nil {
+			continue
+		}
+		for _, entity := range entities {
+			owners[entity.PrimaryKey.KeyId] = key
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(payload), strings.NewReader(signature), nil)
+	switch {
+	case err == nil:
+	case stderrors.Is(err, pgperrors.ErrUnknownIssuer):
+		res.Status = SignatureUnknownKey
+		res.Reason = "signing key is not registered"
+		return nil
+	default:
+		res.Status = SignatureBad
+		res.Reason = err.Error()
+		return nil
+	}
+
+	res.Fingerprint = strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint))
+	key, ok := owners[entity.PrimaryKey.KeyId]
+	if !ok {
+		res.Status = SignatureUnknownKey
+		res.Reason = "signing key is not registered"
+		return nil
+	}
+
+	res.Status = SignatureVerified
+	res.KeyID = key.ID
+	res.UserID = key.UserID
+	return nil
+}
@@ -0,0 +1,312 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/database"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gobwas/glob"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// fakeKeyStore serves keys from memory and counts lookups
+type fakeKeyStore struct {
+	keys    []database.SshKey
+	lookups int
+}
+
+func (f *fakeKeyStore) GetKeysByType(_ context.Context, types ...database.SSH_KEY_TYPE) ([]database.SshKey, error) {
+	f.lookups++
+	var res []database.SshKey
+	for _, k := range f.keys {
+		for _, t := range types {
+			if k.Type == t {
+				res = append(res, k)
+			}
+		}
+	}
+	return res, nil
+}
+
+func newSSHSigner(t *testing.T) (gossh.Signer, []byte) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := gossh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer, gossh.MarshalAuthorizedKey(signer.PublicKey())
+}
+
+func sshSign(t *testing.T, signer gossh.Signer, payload []byte) string {
+	data, err := sshSignedData(sshSigNamespace, "sha512", payload)
+	require.NoError(t, err)
+	sig, err := signer.Sign(rand.Reader, data)
+	require.NoError(t, err)
+
+	blob := gossh.Marshal(struct {
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature []byte
+	}{sshSigVersion, signer.PublicKey().Marshal(), sshSigNamespace, "", "sha512", gossh.Marshal(sig)})
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  sshSigPEMType,
+		Bytes: append([]byte(sshSigMagic), blob...),
+	}))
+}
+
+func newPGPEntity(t *testing.T) (*openpgp.Entity, []byte) {
+	entity, err := openpgp.NewEntity("Signer", "", "signer@example.com", nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return entity, buf.Bytes()
+}
+
+func pgpSign(t *testing.T, entity *openpgp.Entity, payload []byte) string {
+	var buf bytes.Buffer
+	require.NoError(t, openpgp.ArmoredDetachSign(&buf, entity, bytes.NewReader(payload), nil))
+	return buf.String()
+}
+
+// newCommit encodes a commit, signing its payload with sign if given
+func newCommit(t *testing.T, msg string, parents []plumbing.Hash, sign func([]byte) string) plumbing.EncodedObject {
+	sig := object.Signature{Name: "Dev", Email: "dev@example.com", When: time.Unix(1700000000, 0).UTC()}
+	commit := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      msg,
+		TreeHash:     plumbing.NewHash("4b825dc642cb6eb9a060e54bf8d69288fbee4904"),
+		ParentHashes: parents,
+	}
+
+	if sign != nil {
+		unsigned := &plumbing.MemoryObject{}
+		require.NoError(t, commit.EncodeWithoutSignature(unsigned))
+		r, err := unsigned.Reader()
+		require.NoError(t, err)
+		payload, err := io.ReadAll(r)
+		require.NoError(t, err)
+		commit.PGPSignature = sign(payload)
+	}
+
+	obj := &plumbing.MemoryObject{}
+	require.NoError(t, commit.Encode(obj))
+	return obj
+}
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+
+	signer, authorized := newSSHSigner(t)
+	sshKey := database.SshKey{ID: uuid.New(), UserID: userID, Type: database.SSH_ED25519, Data: authorized}
+
+	entity, armored := newPGPEntity(t)
+	pgpKey := database.SshKey{ID: uuid.New(), UserID: userID, Type: database.OPENPGP_KEY, Data: armored}
+
+	t.Run("Unsigned commit", func(t *testing.T) {
+		v := NewVerifier(&fakeKeyStore{})
+		res, err := v.Verify(ctx, newCommit(t, "unsigned", nil, nil))
+		require.NoError(t, err)
+		assert.Equal(t, SignatureUnsigned, res.Status)
+		assert.False(t, res.Verified())
+	})
+
+	t.Run("SSH signature by registered key", func(t *testing.T) {
+		v := NewVerifier(&fakeKeyStore{keys: []database.SshKey{sshKey}})
+		obj := newCommit(t, "ssh", nil, func(p []byte) string { return sshSign(t, signer, p) })
+
+		res, err := v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureVerified, res.Status)
+		assert.Equal(t, SignatureFormatSSH, res.Format)
+		assert.Equal(t, sshKey.ID, res.KeyID)
+		assert.Equal(t, userID, res.UserID)
+		assert.Equal(t, obj.Hash(), res.ObjectID)
+	})
+
+	t.Run("SSH signature by unknown key", func(t *testing.T) {
+		v := NewVerifier(&fakeKeyStore{})
+		obj := newCommit(t, "ssh", nil, func(p []byte) string { return sshSign(t, signer, p) })
+
+		res, err := v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureUnknownKey, res.Status)
+		assert.NotEmpty(t, res.Fingerprint)
+	})
+
+	t.Run("SSH signature over other content", func(t *testing.T) {
+		v := NewVerifier(&fakeKeyStore{keys: []database.SshKey{sshKey}})
+		obj := newCommit(t, "tampered", nil, func([]byte) string { return sshSign(t, signer, []byte("something else")) })
+
+		res, err := v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureBad, res.Status)
+	})
+
+	t.Run("OpenPGP signature by registered key", func(t *testing.T) {
+		v := NewVerifier(&fakeKeyStore{keys: []database.SshKey{pgpKey}})
+		obj := newCommit(t, "pgp", nil, func(p []byte) string { return pgpSign(t, entity, p) })
+
+		res, err := v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureVerified, res.Status)
+		assert.Equal(t, SignatureFormatOpenPGP, res.Format)
+		assert.Equal(t, pgpKey.ID, res.KeyID)
+	})
+
+	t.Run("OpenPGP signature by unknown key", func(t *testing.T) {
+		v := NewVerifier(&fakeKeyStore{keys: []database.SshKey{sshKey}})
+		obj := newCommit(t, "pgp", nil, func(p []byte) string { return pgpSign(t, entity, p) })
+
+		res, err := v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureUnknownKey, res.Status)
+	})
+
+	t.Run("Results are cached until invalidated", func(t *testing.T) {
+		keys := &fakeKeyStore{}
+		v := NewVerifier(keys)
+		obj := newCommit(t, "cached", nil, func(p []byte) string { return sshSign(t, signer, p) })
+
+		res, err := v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureUnknownKey, res.Status)
+
+		keys.keys = append(keys.keys, sshKey)
+		res, err = v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureUnknownKey, res.Status)
+		assert.Equal(t, 1, keys.lookups)
+
+		v.Invalidate()
+		res, err = v.Verify(ctx, obj)
+		require.NoError(t, err)
+		assert.Equal(t, SignatureVerified, res.Status)
+	})
+
+	t.Run("Cache keeps the most recently used results", func(t *testing.T) {
+		keys := &fakeKeyStore{}
+		v := NewVerifier(keys)
+		v.capacity = 2
+
+		objs := make([]plumbing.EncodedObject, 3)
+		for i := range objs {
+			objs[i] = newCommit(t, string(rune('a'+i)), nil, func(p []byte) string { return sshSign(t, signer, p) })
+		}
+		verify := func(obj plumbing.EncodedObject) {
+			_, err := v.Verify(ctx, obj)
+			require.NoError(t, err)
+		}
+
+		verify(objs[0])
+		verify(objs[1])
+		verify(objs[0]) // Cached, now the most recently used
+		verify(objs[2]) // Evicts objs[1]
+		assert.Equal(t, 3, keys.lookups)
+		assert.Len(t, v.cache, 2)
+
+		verify(objs[0])
+		assert.Equal(t, 3, keys.lookups)
+		verify(objs[1])
+		assert.Equal(t, 4, keys.lookups)
+	})
+
+	t.Run("Blobs are rejected", func(t *testing.T) {
+		v := NewVerifier(&fakeKeyStore{})
+		obj := &plumbing.MemoryObject{}
+		obj.SetType(plumbing.BlobObject)
+		_, err := v.Verify(ctx, obj)
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckSignedCommits(t *testing.T) {
+	ctx := context.Background()
+	signer, authorized := newSSHSigner(t)
+	keys := &fakeKeyStore{keys: []database.SshKey{{ID: uuid.New(), UserID: uuid.New(), Type: database.SSH_ED25519, Data: authorized}}}
+	sign := func(p []byte) string { return sshSign(t, signer, p) }
+
+	s := &Server{
+		verifier:       NewVerifier(keys),
+		signedBranches: []glob.Glob{glob.MustCompile("main", '/'), glob.MustCompile("release/*", '/')},
+	}
+
+	signedParent := newCommit(t, "parent", nil, sign)
+	unsignedParent := newCommit(t, "parent", nil, nil)
+	signedOnUnsigned := newCommit(t, "tip", []plumbing.Hash{unsignedParent.Hash()}, sign)
+	signedOnSigned := newCommit(t, "tip", []plumbing.Hash{signedParent.Hash()}, sign)
+
+	pack := map[plumbing.Hash]plumbing.EncodedObject{}
+	for _, obj := range []plumbing.EncodedObject{signedParent, unsignedParent, signedOnUnsigned, signedOnSigned} {
+		pack[obj.Hash()] = obj
+	}
+
+	tests := []struct {
+		name   string
+		ref    plumbing.ReferenceName
+		tip    plumbing.Hash
+		reject bool
+	}{
+		{"Signed history on protected branch", "refs/heads/main", signedOnSigned.Hash(), false},
+		{"Unsigned ancestor on protected branch", "refs/heads/main", signedOnUnsigned.Hash(), true},
+		{"Glob protected branch", "refs/heads/release/1.0", unsignedParent.Hash(), true},
+		{"Unprotected branch", "refs/heads/feature", unsignedParent.Hash(), false},
+		{"Tags are not branches", "refs/tags/main", unsignedParent.Hash(), false},
+		{"Branch deletion", "refs/heads/main", plumbing.ZeroHash, false},
+		{"Tip already in repository", "refs/heads/main", plumbing.NewHash("1111111111111111111111111111111111111111"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := s.checkSignedCommits(ctx, refUpdate{Name: tt.ref, New: tt.tip}, pack)
+			require.NoError(t, err)
+			if tt.reject {
+				assert.NotEmpty(t, reason)
+			} else {
+				assert.Empty(t, reason)
+			}
+		})
+	}
+
+	t.Run("Policy disabled without verifier", func(t *testing.T) {
+		s := &Server{signedBranches: []glob.Glob{glob.MustCompile("main", '/')}}
+		reason, err := s.checkSignedCommits(ctx, refUpdate{Name: "refs/heads/main", New: unsignedParent.Hash()}, pack)
+		require.NoError(t, err)
+		assert.Empty(t, reason)
+	})
+}
+
+func TestParseRefUpdate(t *testing.T) {
+	old := "0000000000000000000000000000000000000000"
+	updated := "3b18e512dba79e4c8300dd08aeb37f8e728b8dad"
+
+	upd, err := parseRefUpdate(old + " " + updated + " refs/heads/main\x00report-status side-band-64k")
+	require.NoError(t, err)
+	assert.True(t, upd.Old.IsZero())
+	assert.Equal(t, updated, upd.New.String())
+	assert.Equal(t, plumbing.ReferenceName("refs/heads/main"), upd.Name)
+
+	_, err = parseRefUpdate("garbage")
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"hash"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Constants of the SSHSIG format, see PROTOCOL.sshsig in the OpenSSH sources.
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigVersion   = 1
+	sshSigPEMType   = "SSH SIGNATURE"
+	sshSigNamespace = "git"
+)
+
+// sshSignature is the decoded body of an armored SSH signature.
+type sshSignature struct {
+	PublicKey gossh.PublicKey
+	Namespace string
+	HashAlg   string
+	Signature *gossh.Signature
+}
+
+// parseSSHSignature decodes an armored "-----BEGIN SSH SIGNATURE-----" block.
+func parseSSHSignature(armored []byte) (*sshSignature, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != sshSigPEMType {
+		return nil, errors.ErrBadData.Msg("malformed ssh signature armor")
+	}
+
+	if !bytes.HasPrefix(block.Bytes, []byte(sshSigMagic)) {
+		return nil, errors.ErrBadData.Msg("ssh signature magic mismatch")
+	}
+
+	var blob struct {
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature []byte
+	}
+	if err := gossh.Unmarshal(block.Bytes[len(sshSigMagic):], &blob); err != nil {
+		return nil, errors.ErrBadData.Msg("malformed ssh signature").Err(err)
+	}
+	if blob.Version != sshSigVersion {
+		return nil, errors.ErrBadData.Msg("unsupported ssh signature version")
+	}
+
+	pub, err := gossh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, errors.ErrBadData.Msg("malformed ssh signature public key").Err(err)
+	}
+
+	sig := new(gossh.Signature)
+	if err := gossh.Unmarshal(blob.Signature, sig); err != nil {
+		return nil, errors.ErrBadData.Msg("malformed ssh signature blob").Err(err)
+	}
+
+	return &sshSignature{
+		PublicKey: pub,
+		Namespace: blob.Namespace,
+		HashAlg:   blob.HashAlg,
+		Signature: sig,
+	}, nil
+}
+
+// sshSignedData builds the blob that is actually signed for message.
+func sshSignedData(namespace, hashAlg string, message []byte) ([]byte, error) {
+	var h hash.Hash
+	switch hashAlg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, errors.ErrBadData.Msg("unsupported ssh signature hash algorithm")
+	}
+	h.Write(message)
+
+	data := gossh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      []byte
+	}{namespace, "", hashAlg, h.Sum(nil)})
+
+	return append([]byte(sshSigMagic), data...), nil
+}
+
+// verify checks that the signature covers message in the git namespace.
+func (s *sshSignature) verify(message []byte) error {
+	if s.Namespace != sshSigNamespace {
+		return errors.ErrBadData.Msg("ssh signature namespace is not git")
+	}
+
+	data, err := sshSignedData(s.Namespace, s.HashAlg, message)
+	if err != nil {
+		return err
+	}
+
+	return s.PublicKey.Verify(data, s.Signature)
+}
@@ -0,0 +1,559 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	stderrors "errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// Object names used inside a repository namespace. They mirror the layout
+// of a bare git repository so the data stays recognisable when browsed.
+const (
+	storerObjectsDir = "objects"
+	storerRefsDir    = "refs"
+	storerModulesDir = "modules"
+	storerConfigFile = "config"
+	storerIndexFile  = "index"
+	storerShallow    = "shallow"
+)
+
+// DefaultBranch is the branch HEAD of a new repository points at
+const DefaultBranch = plumbing.ReferenceName("refs/heads/main")
+
+var _ storage.Storer = (*Storer)(nil)
+
+// Storer adapts a stroage.Storage namespace to go-git's storage.Storer, so
+// go-git can operate directly on repositories kept in any backend.
+//
+// Objects are stored loose and zlib-compressed under objects/xx/yyyy, refs
+// as plain files under their full name. Go-git's interfaces carry no
+// context, so the one given to NewStorer is used for every backend call.
+type Storer struct {
+	ctx       context.Context
+	storage   stroage.Storage
+	namespace string
+	prefix    string
+
+	// Serializes read-modify-write sequences of this process
+	mu *sync.Mutex
+}
+
+// NewStorer creates a go-git storer keeping the repository in namespace.
+func NewStorer(ctx context.Context, st stroage.Storage, namespace string) *Storer {
+	return &Storer{
+		ctx:       ctx,
+		storage:   st,
+		namespace: namespace,
+		mu:        new(sync.Mutex),
+	}
+}
+
+// InitRepository prepares namespace for a new repository by pointing its
+// HEAD at DefaultBranch. A HEAD already stored is kept.
+func InitRepository(ctx context.Context, st stroage.Storage, namespace string) error {
+	if err := stroage.CheckNamespace(namespace); err != nil {
+		return err
+	}
+
+	data, err := encodeReference(plumbing.NewSymbolicReference(plumbing.HEAD, DefaultBranch))
+	if err != nil {
+		return err
+	}
+	_, err = st.PutIf(ctx, namespace, plumbing.HEAD.String(), bytes.NewReader(data), stroage.PutCondition{IfAbsent: true})
+	if stderrors.Is(err, errors.ErrPreconditionFailed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Storer) read(name string) ([]byte, error) {
+	r, _, err := s.storage.Get(s.ctx, s.namespace, s.prefix+name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func (s *Storer) create(name string, data []byte) error {
+	return s.storage.Put(s.ctx, s.namespace, s.prefix+name, bytes.NewReader(data))
+}
+
+// replace writes data to name, overwriting any previous content.
+func (s *Storer) replace(name string, data []byte) error {
+	_, err := s.storage.PutIf(s.ctx, s.namespace, s.prefix+name, bytes.NewReader(data), stroage.PutCondition{})
+	return err
+}
+
+func (s *Storer) remove(name string) error {
+	err := s.storage.Delete(s.ctx, s.namespace, s.prefix+name)
+	if stroage.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// list returns the names below dir, relative to the storer root.
+func (s *Storer) list(dir string) ([]string, error) {
+	var res []string
+	for name, err := range stroage.Objects(s.ctx, s.storage, s.namespace, s.prefix+dir+"/") {
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, strings.TrimPrefix(name, s.prefix))
+	}
+	return res, nil
+}
+
+func objectName(h plumbing.Hash) string {
+	hex := h.String()
+	return path.Join(storerObjectsDir, hex[:2], hex[2:])
+}
+
+// NewEncodedObject returns a new in-memory object to be filled and stored.
+func (s *Storer) NewEncodedObject() plumbing.EncodedObject {
+	return &plumbing.MemoryObject{}
+}
+
+// SetEncodedObject stores obj as a loose object. Storing an object that is
+// already present is a no-op.
+func (s *Storer) SetEncodedObject(obj plumbing.EncodedObject) (plumbing.Hash, error) {
+	switch obj.Type() {
+	case plumbing.CommitObject, plumbing.TreeObject, plumbing.BlobObject, plumbing.TagObject:
+	default:
+		return plumbing.ZeroHash, plumbing.ErrInvalidType
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	w := objfile.NewWriter(&buf)
+	if err := w.WriteHeader(obj.Type(), obj.Size()); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	h := w.Hash()
+	err = s.create(objectName(h), buf.Bytes())
+	if err != nil && !stderrors.Is(err, os.ErrExist) {
+		log.
+			WithField("namespace", s.namespace).
+			WithField("object", h).
+			WithError(err).
+			Error("Failed to store object")
+		return plumbing.ZeroHash, err
+	}
+
+	return h, nil
+}
+
+// openObject reads and decompresses the object header of h.
+func (s *Storer) openObject(h plumbing.Hash) (*objfile.Reader, plumbing.ObjectType, int64, error) {
+	data, err := s.read(objectName(h))
+	if err != nil {
+		if stroage.IsNotExist(err) {
+			return nil, plumbing.InvalidObject, 0, plumbing.ErrObjectNotFound
+		}
+		return nil, plumbing.InvalidObject, 0, err
+	}
+
+	r, err := objfile.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, plumbing.InvalidObject, 0, err
+	}
+
+	t, size, err := r.Header()
+	if err != nil {
+		r.Close()
+		return nil, plumbing.InvalidObject, 0, err
+	}
+
+	return r, t, size, nil
+}
+
+// EncodedObject returns the object h. plumbing.ErrObjectNotFound is returned
+// when it does not exist or is not of type t.
+func (s *Storer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	r, typ, size, err := s.openObject(h)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if t != plumbing.AnyObject && t != typ {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	obj := s.NewEncodedObject()
+	obj.SetType(typ)
+	obj.SetSize(size)
+	w, err := obj.Writer()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+
+	if r.Hash() != h {
+		log.
+			WithField("namespace", s.namespace).
+			WithField("object", h).
+			WithField("actual", r.Hash()).
+			Error("Stored object is corrupted")
+		return nil, errors.ErrBadData.Msg("object hash mismatch")
+	}
+
+	return obj, nil
+}
+
+// objectHashes lists the hashes of all stored objects.
+func (s *Storer) objectHashes() ([]plumbing.Hash, error) {
+	names, err := s.list(storerObjectsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []plumbing.Hash
+	for _, name := range names {
+		hex := strings.ReplaceAll(strings.TrimPrefix(name, storerObjectsDir+"/"), "/", "")
+		if plumbing.IsHash(hex) {
+			hashes = append(hashes, plumbing.NewHash(hex))
+		}
+	}
+	return hashes, nil
+}
+
+// IterEncodedObjects iterates over all stored objects of type t.
+func (s *Storer) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	hashes, err := s.objectHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectIter{storer: s, t: t, hashes: hashes}, nil
+}
+
+// HasEncodedObject returns plumbing.ErrObjectNotFound if h is not stored.
+func (s *Storer) HasEncodedObject(h plumbing.Hash) error {
+	exists, err := s.storage.Exists(s.ctx, s.namespace, s.prefix+objectName(h))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return plumbing.ErrObjectNotFound
+	}
+	return nil
+}
+
+// EncodedObjectSize returns the uncompressed size of object h.
+func (s *Storer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	r, _, size, err := s.openObject(h)
+	if err != nil {
+		return 0, err
+	}
+	return size, r.Close()
+}
+
+// AddAlternate is not supported, objects are never shared between namespaces.
+func (s *Storer) AddAlternate(string) error {
+	return errors.ErrNotSupported.Msg("alternates are not supported")
+}
+
+// objectIter lazily loads objects, skipping those of other types.
+type objectIter struct {
+	storer *Storer
+	t      plumbing.ObjectType
+	hashes []plumbing.Hash
+}
+
+func (it *objectIter) Next() (plumbing.EncodedObject, error) {
+	for len(it.hashes) > 0 {
+		h := it.hashes[0]
+		it.hashes = it.hashes[1:]
+
+		obj, err := it.storer.EncodedObject(it.t, h)
+		if stderrors.Is(err, plumbing.ErrObjectNotFound) {
+			continue
+		}
+		return obj, err
+	}
+	return nil, io.EOF
+}
+
+func (it *objectIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	return storer.ForEachIterator(it, cb)
+}
+
+func (it *objectIter) Close() {
+	it.hashes = nil
+}
+
+// encodeReference renders ref in the loose ref file format.
+func encodeReference(ref *plumbing.Reference) ([]byte, error) {
+	var content string
+	switch ref.Type() {
+	case plumbing.SymbolicReference:
+		content = "ref: " + ref.Target().String() + "\n"
+	case plumbing.HashReference:
+		content = ref.Hash().String() + "\n"
+	default:
+		return nil, plumbing.ErrInvalidType
+	}
+
+	return []byte(content), nil
+}
+
+// SetReference stores ref, replacing any previous value.
+func (s *Storer) SetReference(ref *plumbing.Reference) error {
+	data, err := encodeReference(ref)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.replace(ref.Name().String(), data)
+}
+
+// CheckAndSetReference stores ref if the current value still matches old.
+// A nil old stores ref unconditionally. When both name the same reference
+// the write is conditional on the stored checksum, so concurrent updates
+// through other processes sharing the backend are detected as well.
+func (s *Storer) CheckAndSetReference(ref, old *plumbing.Reference) error {
+	data, err := encodeReference(ref)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old == nil {
+		return s.replace(ref.Name().String(), data)
+	}
+
+	// Stat before reading: if the ref changes in between, the checksum no
+	// longer matches and the conditional write below fails
+	info, err := s.storage.Stat(s.ctx, s.namespace, s.prefix+old.Name().String())
+	if err != nil {
+		if stroage.IsNotExist(err) {
+			return plumbing.ErrReferenceNotFound
+		}
+		return err
+	}
+
+	current, err := s.Reference(old.Name())
+	if err != nil {
+		return err
+	}
+	if current.Hash() != old.Hash() || current.Target() != old.Target() {
+		return storage.ErrReferenceHasChanged
+	}
+
+	if ref.Name() != old.Name() {
+		return s.replace(ref.Name().String(), data)
+	}
+
+	_, err = s.storage.PutIf(s.ctx, s.namespace, s.prefix+ref.Name().String(), bytes.NewReader(data), stroage.PutCondition{IfMatch: info.Checksum})
+	if stderrors.Is(err, errors.ErrPreconditionFailed) {
+		return storage.ErrReferenceHasChanged
+	}
+	return err
+}
+
+// Reference returns the reference called name.
+func (s *Storer) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	data, err := s.read(name.String())
+	if err != nil {
+		if stroage.IsNotExist(err) {
+			return nil, plumbing.ErrReferenceNotFound
+		}
+		return nil, err
+	}
+
+	return plumbing.NewReferenceFromStrings(name.String(), strings.TrimSpace(string(data))), nil
+}
+
+// IterReferences iterates over HEAD and every reference under refs/.
+func (s *Storer) IterReferences() (storer.ReferenceIter, error) {
+	names, err := s.list(storerRefsDir)
+	if err != nil {
+		return nil, err
+	}
+	names = append([]string{plumbing.HEAD.String()}, names...)
+
+	var refs []*plumbing.Reference
+	for _, name := range names {
+		ref, err := s.Reference(plumbing.ReferenceName(name))
+		if stderrors.Is(err, plumbing.ErrReferenceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+
+	return storer.NewReferenceSliceIter(refs), nil
+}
+
+// RemoveReference deletes the reference called name, if present.
+func (s *Storer) RemoveReference(name plumbing.ReferenceName) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remove(name.String())
+}
+
+// CountLooseRefs returns the number of references under refs/.
+func (s *Storer) CountLooseRefs() (int, error) {
+	names, err := s.list(storerRefsDir)
+	return len(names), err
+}
+
+// PackRefs is a no-op, references are always kept loose.
+func (s *Storer) PackRefs() error {
+	return nil
+}
+
+// SetShallow records the shallow boundary commits.
+func (s *Storer) SetShallow(commits []plumbing.Hash) error {
+	var buf bytes.Buffer
+	for _, h := range commits {
+		buf.WriteString(h.String())
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.replace(storerShallow, buf.Bytes())
+}
+
+// Shallow returns the shallow boundary commits, if any.
+func (s *Storer) Shallow() ([]plumbing.Hash, error) {
+	data, err := s.read(storerShallow)
+	if err != nil {
+		if stroage.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var commits []plumbing.Hash
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			commits = append(commits, plumbing.NewHash(line))
+		}
+	}
+	return commits, scanner.Err()
+}
+
+// SetIndex stores the staging index.
+func (s *Storer) SetIndex(idx *index.Index) error {
+	var buf bytes.Buffer
+	if err := index.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.replace(storerIndexFile, buf.Bytes())
+}
+
+// Index returns the staging index, or an empty one if none was stored.
+func (s *Storer) Index() (*index.Index, error) {
+	idx := &index.Index{Version: 2}
+
+	data, err := s.read(storerIndexFile)
+	if err != nil {
+		if stroage.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, err
+	}
+
+	if err := index.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Config returns the repository configuration, or a default one if none was
+// stored.
+func (s *Storer) Config() (*config.Config, error) {
+	cfg := config.NewConfig()
+
+	data, err := s.read(storerConfigFile)
+	if err != nil {
+		if stroage.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := cfg.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SetConfig validates and stores the repository configuration.
+func (s *Storer) SetConfig(cfg *config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	data, err := cfg.Marshal()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.replace(storerConfigFile, data)
+}
+
+// Module returns the storer of submodule name, kept below modules/ in the
+// same namespace.
+func (s *Storer) Module(name string) (storage.Storer, error) {
+	return &Storer{
+		ctx:       s.ctx,
+		storage:   s.storage,
+		namespace: s.namespace,
+		prefix:    s.prefix + path.Join(storerModulesDir, name) + "/",
+		mu:        s.mu,
+	}, nil
+}
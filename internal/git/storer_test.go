@@ -0,0 +1,251 @@
+package git
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorer(t *testing.T) (*Storer, stroage.Storage) {
+	st := stroage.NewMemoryStorage()
+	return NewStorer(context.Background(), st, "repo"), st
+}
+
+func storeObject(t *testing.T, s *Storer, obj interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	enc := s.NewEncodedObject()
+	require.NoError(t, obj.Encode(enc))
+	h, err := s.SetEncodedObject(enc)
+	require.NoError(t, err)
+	return h
+}
+
+func storeBlob(t *testing.T, s *Storer, content string) (plumbing.EncodedObject, plumbing.Hash) {
+	blob := s.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	require.NoError(t, err)
+	_, err = w.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	h, err := s.SetEncodedObject(blob)
+	require.NoError(t, err)
+	return blob, h
+}
+
+func TestStorerObjects(t *testing.T) {
+	s, _ := newTestStorer(t)
+
+	blob, h := storeBlob(t, s, "hello\n")
+	assert.Equal(t, "ce013625030ba8dba906f756967f9e9ca394464a", h.String())
+
+	t.Run("Storing twice is a no-op", func(t *testing.T) {
+		h2, err := s.SetEncodedObject(blob)
+		require.NoError(t, err)
+		assert.Equal(t, h, h2)
+	})
+
+	t.Run("Read back", func(t *testing.T) {
+		obj, err := s.EncodedObject(plumbing.AnyObject, h)
+		require.NoError(t, err)
+		assert.Equal(t, plumbing.BlobObject, obj.Type())
+		assert.Equal(t, h, obj.Hash())
+
+		size, err := s.EncodedObjectSize(h)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), size)
+		assert.NoError(t, s.HasEncodedObject(h))
+	})
+
+	t.Run("Wrong type or missing", func(t *testing.T) {
+		_, err := s.EncodedObject(plumbing.CommitObject, h)
+		assert.ErrorIs(t, err, plumbing.ErrObjectNotFound)
+
+		missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+		assert.ErrorIs(t, s.HasEncodedObject(missing), plumbing.ErrObjectNotFound)
+	})
+
+	t.Run("Iterate by type", func(t *testing.T) {
+		tree := &object.Tree{Entries: []object.TreeEntry{{Name: "hello", Mode: filemode.Regular, Hash: h}}}
+		treeHash := storeObject(t, s, tree)
+
+		count := func(typ plumbing.ObjectType) []plumbing.Hash {
+			iter, err := s.IterEncodedObjects(typ)
+			require.NoError(t, err)
+			var hashes []plumbing.Hash
+			require.NoError(t, iter.ForEach(func(o plumbing.EncodedObject) error {
+				hashes = append(hashes, o.Hash())
+				return nil
+			}))
+			return hashes
+		}
+
+		assert.ElementsMatch(t, []plumbing.Hash{h}, count(plumbing.BlobObject))
+		assert.ElementsMatch(t, []plumbing.Hash{treeHash}, count(plumbing.TreeObject))
+		assert.ElementsMatch(t, []plumbing.Hash{h, treeHash}, count(plumbing.AnyObject))
+	})
+}
+
+func TestStorerReferences(t *testing.T) {
+	s, _ := newTestStorer(t)
+	main := plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("1111111111111111111111111111111111111111"))
+	next := plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("2222222222222222222222222222222222222222"))
+
+	require.NoError(t, s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main")))
+	require.NoError(t, s.SetReference(main))
+
+	ref, err := s.Reference("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, main.Hash(), ref.Hash())
+
+	head, err := s.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.SymbolicReference, head.Type())
+	assert.Equal(t, plumbing.ReferenceName("refs/heads/main"), head.Target())
+
+	t.Run("Compare and swap", func(t *testing.T) {
+		stale := plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("3333333333333333333333333333333333333333"))
+		assert.ErrorIs(t, s.CheckAndSetReference(next, stale), storage.ErrReferenceHasChanged)
+
+		require.NoError(t, s.CheckAndSetReference(next, main))
+		ref, err := s.Reference("refs/heads/main")
+		require.NoError(t, err)
+		assert.Equal(t, next.Hash(), ref.Hash())
+	})
+
+	t.Run("Iterate and remove", func(t *testing.T) {
+		require.NoError(t, s.SetReference(plumbing.NewHashReference("refs/tags/v1", main.Hash())))
+
+		count, err := s.CountLooseRefs()
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		iter, err := s.IterReferences()
+		require.NoError(t, err)
+		var names []plumbing.ReferenceName
+		require.NoError(t, iter.ForEach(func(r *plumbing.Reference) error {
+			names = append(names, r.Name())
+			return nil
+		}))
+		assert.ElementsMatch(t, []plumbing.ReferenceName{plumbing.HEAD, "refs/heads/main", "refs/tags/v1"}, names)
+
+		require.NoError(t, s.RemoveReference("refs/tags/v1"))
+		_, err = s.Reference("refs/tags/v1")
+		assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+		assert.NoError(t, s.RemoveReference("refs/tags/v1"))
+	})
+}
+
+func TestStorerRepositoryState(t *testing.T) {
+	s, _ := newTestStorer(t)
+
+	t.Run("Defaults", func(t *testing.T) {
+		cfg, err := s.Config()
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Remotes)
+
+		idx, err := s.Index()
+		require.NoError(t, err)
+		assert.Empty(t, idx.Entries)
+
+		shallow, err := s.Shallow()
+		require.NoError(t, err)
+		assert.Empty(t, shallow)
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		cfg := config.NewConfig()
+		cfg.Core.IsBare = true
+		cfg.Remotes["origin"] = &config.RemoteConfig{Name: "origin", URLs: []string{"ssh://git@example.com/repo"}}
+		require.NoError(t, s.SetConfig(cfg))
+		cfg.Core.IsBare = false
+		require.NoError(t, s.SetConfig(cfg))
+
+		got, err := s.Config()
+		require.NoError(t, err)
+		assert.False(t, got.Core.IsBare)
+		assert.Equal(t, []string{"ssh://git@example.com/repo"}, got.Remotes["origin"].URLs)
+	})
+
+	t.Run("Shallow and index", func(t *testing.T) {
+		boundary := []plumbing.Hash{plumbing.NewHash("1111111111111111111111111111111111111111")}
+		require.NoError(t, s.SetShallow(boundary))
+		got, err := s.Shallow()
+		require.NoError(t, err)
+		assert.Equal(t, boundary, got)
+
+		idx := &index.Index{Version: 2, Entries: []*index.Entry{{Name: "README", Hash: boundary[0], Mode: filemode.Regular}}}
+		require.NoError(t, s.SetIndex(idx))
+		gotIdx, err := s.Index()
+		require.NoError(t, err)
+		require.Len(t, gotIdx.Entries, 1)
+		assert.Equal(t, "README", gotIdx.Entries[0].Name)
+	})
+
+	t.Run("Modules are isolated", func(t *testing.T) {
+		mod, err := s.Module("lib")
+		require.NoError(t, err)
+		require.NoError(t, mod.SetReference(plumbing.NewHashReference("refs/heads/main", plumbing.NewHash("4444444444444444444444444444444444444444"))))
+
+		count, err := s.CountLooseRefs()
+		require.NoError(t, err)
+		modCount, err := mod.CountLooseRefs()
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+		assert.Equal(t, 1, modCount)
+	})
+}
+
+func TestStorerWithGoGit(t *testing.T) {
+	s, st := newTestStorer(t)
+
+	repo, err := gogit.Init(s, nil)
+	require.NoError(t, err)
+
+	_, blobHash := storeBlob(t, s, "content\n")
+
+	treeHash := storeObject(t, s, &object.Tree{Entries: []object.TreeEntry{{Name: "file", Mode: filemode.Regular, Hash: blobHash}}})
+
+	sig := object.Signature{Name: "Dev", Email: "dev@example.com", When: time.Unix(1700000000, 0).UTC()}
+	first := storeObject(t, s, &object.Commit{Author: sig, Committer: sig, Message: "first\n", TreeHash: treeHash})
+	second := storeObject(t, s, &object.Commit{Author: sig, Committer: sig, Message: "second\n", TreeHash: treeHash, ParentHashes: []plumbing.Hash{first}})
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, second)))
+
+	// Reopen from the same backend to make sure nothing lives only in memory
+	reopened, err := gogit.Open(NewStorer(context.Background(), st, "repo"), nil)
+	require.NoError(t, err)
+
+	head, err := reopened.Head()
+	require.NoError(t, err)
+	assert.Equal(t, second, head.Hash())
+
+	iter, err := reopened.Log(&gogit.LogOptions{From: head.Hash()})
+	require.NoError(t, err)
+	var messages []string
+	require.NoError(t, iter.ForEach(func(c *object.Commit) error {
+		messages = append(messages, c.Message)
+		return nil
+	}))
+	assert.Equal(t, []string{"second\n", "first\n"}, messages)
+
+	commit, err := reopened.CommitObject(second)
+	require.NoError(t, err)
+	file, err := commit.File("file")
+	require.NoError(t, err)
+	content, err := file.Contents()
+	require.NoError(t, err)
+	assert.Equal(t, "content\n", content)
+}
@@ -14,6 +14,9 @@ type Error struct {
 	message string
 	source  string
 	err     error
+
+	// base is the error this one was derived from with Src, Msg or Err
+	base *Error
 }
 
 // New creates a new Error instance with the provided arguments.
@@ -45,6 +48,7 @@ func (e *Error) Src(s string) *Error {
 	ne.err = e.err
 	ne.message = e.message
 	ne.source = s
+	ne.base = e.root()
 
 	return ne
 }
@@ -56,6 +60,7 @@ func (e *Error) Msg(s string) *Error {
 	ne.err = e.err
 	ne.message = s
 	ne.source = e.source
+	ne.base = e.root()
 
 	return ne
 }
@@ -67,10 +72,26 @@ func (e *Error) Err(s error) *Error {
 	ne.err = s
 	ne.message = e.message
 	ne.source = e.source
+	ne.base = e.root()
 
 	return ne
 }
 
+// root returns the error e was derived from, or e itself.
+func (e *Error) root() *Error {
+	if e.base != nil {
+		return e.base
+	}
+	return e
+}
+
+// Is reports whether e and target were derived from the same error, so
+// errors.Is(ErrBadData.Msg("..."), ErrBadData) holds.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.root() == e.root()
+}
+
 // Error implements the error interface.
 func (e *Error) Error() string {
 	format := strings.Builder{}
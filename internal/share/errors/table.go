@@ -4,7 +4,13 @@ var (
 	//      ERR NAME		  message 		   source	  list of parent errors
 	// ERR_NOT_FOUND = New("example error", "errtable", io.EOF, io.EOF, io.EOF)
 
-	ErrNotFound      = New("not found")
-	ErrBadData       = New("bad input data")
-	ErrAlreadyExists = New("already exists")
+	ErrNotFound           = New("not found")
+	ErrBadData            = New("bad input data")
+	ErrAlreadyExists      = New("already exists")
+	ErrNotSupported       = New("operation not supported")
+	ErrInvalidRange       = New("invalid range")
+	ErrPreconditionFailed = New("precondition failed")
+	ErrQuorumNotReached   = New("write quorum not reached")
+	ErrChecksumMismatch   = New("checksum mismatch")
+	ErrLocked             = New("resource is locked")
 )
@@ -0,0 +1,475 @@
+package stroage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// CacheOptions configures a CachedStorage. A tier with a zero size is
+// disabled. Objects larger than an eighth of a tier are not kept in it, so
+// a single large read cannot flush the whole tier.
+type CacheOptions struct {
+	// MemoryBytes bounds the in-memory tier.
+	MemoryBytes int64
+
+	// DiskPath is a directory owned by the cache. DiskBytes bounds the
+	// content kept there. The disk tier is emptied on start.
+	DiskPath  string
+	DiskBytes int64
+
+	// Immutable reports whether an object never changes once written.
+	// Cached immutable objects are served without contacting the backend,
+	// other objects are revalidated against the backend checksum on every
+	// read. A nil Immutable treats all objects as mutable.
+	Immutable func(namespace, objname string) bool
+}
+
+// CacheStats counts cache lookups since the cache was created.
+type CacheStats struct {
+	Hits       uint64
+	MemoryHits uint64
+	DiskHits   uint64
+	Misses     uint64
+	Evictions  uint64
+}
+
+// diskFilePrefix names cached files, anything else in DiskPath is left alone
+const diskFilePrefix = "obj-"
+
+// cacheEntry is a cached object, held in memory or in a file on disk
+type cacheEntry struct {
+	key  string
+	info ObjectInfo
+	data []byte
+	path string
+}
+
+// lruTier is a byte bounded least recently used set of entries. It is not
+// safe for concurrent use.
+type lruTier struct {
+	capacity int64
+	used     int64
+	order    *list.List
+	entries  map[string]*list.Element
+
+	// onRemove releases the resources of a dropped entry
+	onRemove func(e *cacheEntry, evicted bool)
+}
+
+func newLRUTier(capacity int64, onRemove func(*cacheEntry, bool)) *lruTier {
+	return &lruTier{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		onRemove: onRemove,
+	}
+}
+
+func (t *lruTier) fits(size int64) bool {
+	return t.capacity > 0 && size <= t.capacity/8
+}
+
+func (t *lruTier) get(key string) (*cacheEntry, bool) {
+	el, ok := t.entries[key]
+	if !ok {
+		return nil, false
+	}
+	t.order.MoveToFront(el)
+	return el.Value.(*cacheEntry), true
+}
+
+func (t *lruTier) add(e *cacheEntry) {
+	t.remove(e.key)
+
+	for t.used+e.info.Size > t.capacity && t.order.Len() > 0 {
+		t.drop(t.order.Back(), true)
+	}
+
+	t.entries[e.key] = t.order.PushFront(e)
+	t.used += e.info.Size
+}
+
+func (t *lruTier) remove(key string) {
+	if el, ok := t.entries[key]; ok {
+		t.drop(el, false)
+	}
+}
+
+func (t *lruTier) drop(el *list.Element, evicted bool) {
+	e := t.order.Remove(el).(*cacheEntry)
+	delete(t.entries, e.key)
+	t.used -= e.info.Size
+	if t.onRemove != nil {
+		t.onRemove(e, evicted)
+	}
+}
+
+// CachedStorage is a read-through cache in front of a slow Storage such as
+// MinioStorage. Reads fill an in-memory and an on-disk LRU tier, writes made
+// through the cache invalidate the affected entries.
+type CachedStorage struct {
+	Storage
+	stagedUploads
+	opts CacheOptions
+
+	mu     sync.Mutex
+	memory *lruTier
+	disk   *lruTier
+
+	hits, memoryHits, diskHits, misses, evictions atomic.Uint64
+}
+
+// NewCachedStorage wraps backend with a read-through cache.
+func NewCachedStorage(backend Storage, opts CacheOptions) (*CachedStorage, error) {
+	s := &CachedStorage{Storage: backend, opts: opts}
+	s.stagedUploads = stagedUploads{s}
+
+	s.memory = newLRUTier(opts.MemoryBytes, func(_ *cacheEntry, evicted bool) {
+		if evicted {
+			s.evictions.Add(1)
+		}
+	})
+
+	diskBytes := opts.DiskBytes
+	if opts.DiskPath == "" {
+		diskBytes = 0
+	}
+	s.disk = newLRUTier(diskBytes, func(e *cacheEntry, evicted bool) {
+		if evicted {
+			s.evictions.Add(1)
+		}
+		os.Remove(e.path)
+	})
+
+	if diskBytes > 0 {
+		if err := os.MkdirAll(opts.DiskPath, 0o750); err != nil {
+			return nil, err
+		}
+
+		// The index lives in memory, files of a previous run are unknown
+		stale, err := filepath.Glob(filepath.Join(opts.DiskPath, diskFilePrefix+"*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range stale {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// ContentAddressed is an Immutable predicate for objects named after the
+// hash of their content and split into a fanout directory, like git loose
+// objects ("objects/ab/cdef...").
+func ContentAddressed(_ string, objname string) bool {
+	parts := strings.Split(objname, "/")
+	if len(parts) < 2 {
+		return false
+	}
+
+	dir, file := parts[len(parts)-2], parts[len(parts)-1]
+	if len(dir) != 2 || (len(file) != 38 && len(file) != 62) {
+		return false
+	}
+	return isHex(dir) && isHex(file)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats returns the lookup counters.
+func (s *CachedStorage) Stats() CacheStats {
+	return CacheStats{
+		Hits:       s.hits.Load(),
+		MemoryHits: s.memoryHits.Load(),
+		DiskHits:   s.diskHits.Load(),
+		Misses:     s.misses.Load(),
+		Evictions:  s.evictions.Load(),
+	}
+}
+
+func cacheKey(namespace, objname string) string {
+	return namespace + "\x00" + objname
+}
+
+func (s *CachedStorage) immutable(namespace, objname string) bool {
+	return s.opts.Immutable != nil && s.opts.Immutable(namespace, objname)
+}
+
+func (s *CachedStorage) invalidate(namespace, objname string) {
+	key := cacheKey(namespace, objname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory.remove(key)
+	s.disk.remove(key)
+}
+
+// lookup returns the cached content of key. An empty current checksum
+// accepts any cached content, otherwise it must match.
+func (s *CachedStorage) lookup(key, current string) ([]byte, ObjectInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.memory.get(key); ok {
+		if current == "" || e.info.Checksum == current {
+			s.hits.Add(1)
+			s.memoryHits.Add(1)
+			return e.data, e.info, true
+		}
+		s.memory.remove(key)
+	}
+
+	e, ok := s.disk.get(key)
+	if !ok {
+		return nil, ObjectInfo{}, false
+	}
+	if current != "" && e.info.Checksum != current {
+		s.disk.remove(key)
+		return nil, ObjectInfo{}, false
+	}
+
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		s.disk.remove(key)
+		return nil, ObjectInfo{}, false
+	}
+
+	s.hits.Add(1)
+	s.diskHits.Add(1)
+	if s.memory.fits(e.info.Size) {
+		s.memory.add(&cacheEntry{key: key, info: e.info, data: data})
+	}
+	return data, e.info, true
+}
+
+// fill stores an object read from the backend in the tiers it fits in
+func (s *CachedStorage) fill(key string, info ObjectInfo, data []byte) {
+	var path string
+	if s.disk.fits(info.Size) {
+		f, err := os.CreateTemp(s.opts.DiskPath, diskFilePrefix+"*")
+		if err == nil {
+			_, err = f.Write(data)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(f.Name())
+			} else {
+				path = f.Name()
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.memory.fits(info.Size) {
+		s.memory.add(&cacheEntry{key: key, info: info, data: data})
+	}
+	if path != "" {
+		s.disk.add(&cacheEntry{key: key, info: info, path: path})
+	}
+}
+
+// Get serves an object from the cache, reading it from the backend on a
+// miss. Mutable objects cost a Stat on the backend to revalidate.
+func (s *CachedStorage) Get(ctx context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	key := cacheKey(namespace, objname)
+
+	var current string
+	if !s.immutable(namespace, objname) {
+		stat, err := s.Storage.Stat(ctx, namespace, objname)
+		if err != nil {
+			s.invalidate(namespace, objname)
+			return nil, ObjectInfo{}, err
+		}
+		current = stat.Checksum
+	}
+
+	if data, info, ok := s.lookup(key, current); ok {
+		return memoryReader{bytes.NewReader(data)}, info, nil
+	}
+	s.misses.Add(1)
+
+	reader, info, err := s.Storage.Get(ctx, namespace, objname)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	if !s.memory.fits(info.Size) && !s.disk.fits(info.Size) {
+		return reader, info, nil
+	}
+
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	if current != "" {
+		info.Checksum = current
+	}
+	s.fill(key, info, data)
+	return memoryReader{bytes.NewReader(data)}, info, nil
+}
+
+// GetRange reads part of an object through the cache
+func (s *CachedStorage) GetRange(ctx context.Context, namespace, objname string, offset, length int64) (io.ReadCloser, error) {
+	return getRange(ctx, s, namespace, objname, offset, length)
+}
+
+// cachedInfo returns the metadata of a cached immutable object
+func (s *CachedStorage) cachedInfo(namespace, objname string) (ObjectInfo, bool) {
+	if !s.immutable(namespace, objname) {
+		return ObjectInfo{}, false
+	}
+
+	key := cacheKey(namespace, objname)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.memory.get(key); ok {
+		return e.info, true
+	}
+	if e, ok := s.disk.get(key); ok {
+		return e.info, true
+	}
+	return ObjectInfo{}, false
+}
+
+// Stat answers from the cache for immutable objects
+func (s *CachedStorage) Stat(ctx context.Context, namespace, objname string) (ObjectInfo, error) {
+	// Get does not always report a checksum, Stat has to
+	if info, ok := s.cachedInfo(namespace, objname); ok && info.Checksum != "" {
+		return info, nil
+	}
+	return s.Storage.Stat(ctx, namespace, objname)
+}
+
+// Exists answers from the cache for immutable objects
+func (s *CachedStorage) Exists(ctx context.Context, namespace, objname string) (bool, error) {
+	if _, ok := s.cachedInfo(namespace, objname); ok {
+		return true, nil
+	}
+	return s.Storage.Exists(ctx, namespace, objname)
+}
+
+// Put stores an object in the backend
+func (s *CachedStorage) Put(ctx context.Context, namespace, objname string, obj io.Reader) error {
+	defer s.invalidate(namespace, objname)
+	return s.Storage.Put(ctx, namespace, objname, obj)
+}
+
+// PutIf stores an object in the backend if cond holds
+func (s *CachedStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	defer s.invalidate(namespace, objname)
+	return s.Storage.PutIf(ctx, namespace, objname, obj, cond)
+}
+
+// Delete removes an object from the backend and the cache
+func (s *CachedStorage) Delete(ctx context.Context, namespace, objname string) error {
+	defer s.invalidate(namespace, objname)
+	return s.Storage.Delete(ctx, namespace, objname)
+}
+
+// Copy duplicates an object in the backend
+func (s *CachedStorage) Copy(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	defer s.invalidate(dstNamespace, dstName)
+	return s.Storage.Copy(ctx, srcNamespace, srcName, dstNamespace, dstName)
+}
+
+// Move renames an object in the backend
+func (s *CachedStorage) Move(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	defer s.invalidate(srcNamespace, srcName)
+	defer s.invalidate(dstNamespace, dstName)
+	return s.Storage.Move(ctx, srcNamespace, srcName, dstNamespace, dstName)
+}
+
+// CreateUpload starts a multipart upload with the backend, or staged through
+// the cache for backends without multipart uploads
+func (s *CachedStorage) CreateUpload(ctx context.Context, namespace, objname, checksum string) (string, error) {
+	if mp, ok := s.Storage.(MultipartStorage); ok {
+		return mp.CreateUpload(ctx, namespace, objname, checksum)
+	}
+	return s.stagedUploads.CreateUpload(ctx, namespace, objname, checksum)
+}
+
+// UploadPart uploads one part of a multipart upload
+func (s *CachedStorage) UploadPart(ctx context.Context, namespace, objname, uploadID string, number int, part io.Reader, size int64) (PartInfo, error) {
+	if mp, ok := s.Storage.(MultipartStorage); ok {
+		return mp.UploadPart(ctx, namespace, objname, uploadID, number, part, size)
+	}
+	return s.stagedUploads.UploadPart(ctx, namespace, objname, uploadID, number, part, size)
+}
+
+// ListParts returns the parts of a multipart upload
+func (s *CachedStorage) ListParts(ctx context.Context, namespace, objname, uploadID string) ([]PartInfo, error) {
+	if mp, ok := s.Storage.(MultipartStorage); ok {
+		return mp.ListParts(ctx, namespace, objname, uploadID)
+	}
+	return s.stagedUploads.ListParts(ctx, namespace, objname, uploadID)
+}
+
+// CompleteUpload joins the parts of a multipart upload into the object
+func (s *CachedStorage) CompleteUpload(ctx context.Context, namespace, objname, uploadID string, parts []PartInfo) (ObjectInfo, error) {
+	defer s.invalidate(namespace, objname)
+	if mp, ok := s.Storage.(MultipartStorage); ok {
+		return mp.CompleteUpload(ctx, namespace, objname, uploadID, parts)
+	}
+	return s.stagedUploads.CompleteUpload(ctx, namespace, objname, uploadID, parts)
+}
+
+// AbortUpload ends a multipart upload and discards its parts
+func (s *CachedStorage) AbortUpload(ctx context.Context, namespace, objname, uploadID string) error {
+	if mp, ok := s.Storage.(MultipartStorage); ok {
+		return mp.AbortUpload(ctx, namespace, objname, uploadID)
+	}
+	return s.stagedUploads.AbortUpload(ctx, namespace, objname, uploadID)
+}
+
+// SignedURL signs a URL with the backend, downloads bypass the cache
+func (s *CachedStorage) SignedURL(ctx context.Context, namespace, objname string, expiry time.Duration) (string, error) {
+	signer, ok := s.Storage.(URLSigner)
+	if !ok {
+		return "", errors.ErrNotSupported.Msg("storage backend cannot sign URLs")
+	}
+	return signer.SignedURL(ctx, namespace, objname, expiry)
+}
+
+// DownloadHandler returns the backend handler serving signed URLs, if any
+func (s *CachedStorage) DownloadHandler() http.Handler {
+	if ds, ok := s.Storage.(DownloadServer); ok {
+		return ds.DownloadHandler()
+	}
+	return nil
+}
+
+// DownloadPath returns the path the backend handler is mounted at, if any
+func (s *CachedStorage) DownloadPath() string {
+	if ds, ok := s.Storage.(DownloadServer); ok {
+		return ds.DownloadPath()
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package stroage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingStorage counts the reads reaching a backend
+type countingStorage struct {
+	Storage
+	gets int
+}
+
+func (s *countingStorage) Get(ctx context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	s.gets++
+	return s.Storage.Get(ctx, namespace, objname)
+}
+
+func TestCachedStorage(t *testing.T) {
+	ctx := context.Background()
+
+	newCache := func(t *testing.T, opts CacheOptions) (*CachedStorage, *countingStorage) {
+		backend := &countingStorage{Storage: NewMemoryStorage()}
+		cache, err := NewCachedStorage(backend, opts)
+		if err != nil {
+			t.Fatalf("NewCachedStorage failed: %v", err)
+		}
+		return cache, backend
+	}
+
+	t.Run("Immutable objects are served from the cache", func(t *testing.T) {
+		cache, backend := newCache(t, CacheOptions{MemoryBytes: 1 << 20, Immutable: ContentAddressed})
+		name := "objects/ce/013625030ba8dba906f756967f9e9ca394464a"
+		if err := cache.Put(ctx, "repo", name, strings.NewReader("blob")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		for range 3 {
+			assertContent(t, cache, "repo", name, "blob")
+		}
+
+		if backend.gets != 1 {
+			t.Errorf("Expected 1 backend read, got %d", backend.gets)
+		}
+		if stats := cache.Stats(); stats.Hits != 2 || stats.MemoryHits != 2 || stats.Misses != 1 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Mutable objects are revalidated", func(t *testing.T) {
+		cache, backend := newCache(t, CacheOptions{MemoryBytes: 1 << 20})
+		if err := cache.Put(ctx, "repo", "refs/heads/main", strings.NewReader("one")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		assertContent(t, cache, "repo", "refs/heads/main", "one")
+		assertContent(t, cache, "repo", "refs/heads/main", "one")
+
+		// Written behind the cache's back, like another instance would
+		if _, err := backend.PutIf(ctx, "repo", "refs/heads/main", strings.NewReader("two"), PutCondition{}); err != nil {
+			t.Fatalf("PutIf failed: %v", err)
+		}
+		assertContent(t, cache, "repo", "refs/heads/main", "two")
+
+		if backend.gets != 2 {
+			t.Errorf("Expected 2 backend reads, got %d", backend.gets)
+		}
+	})
+
+	t.Run("Disk tier", func(t *testing.T) {
+		dir := t.TempDir()
+		stale := filepath.Join(dir, diskFilePrefix+"stale")
+		if err := os.WriteFile(stale, []byte("old"), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+
+		cache, backend := newCache(t, CacheOptions{DiskPath: dir, DiskBytes: 800, Immutable: func(string, string) bool { return true }})
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Error("Expected files of a previous run to be removed")
+		}
+
+		// Each object takes an eighth of the tier, the first one gets evicted
+		content := strings.Repeat("x", 100)
+		for i := range 9 {
+			name := string(rune('a' + i))
+			if err := cache.Put(ctx, "ns", name, strings.NewReader(content)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			assertContent(t, cache, "ns", name, content)
+		}
+
+		files, _ := filepath.Glob(filepath.Join(dir, diskFilePrefix+"*"))
+		if len(files) != 8 {
+			t.Errorf("Expected 8 cached files, got %d", len(files))
+		}
+
+		assertContent(t, cache, "ns", "i", content)
+		assertContent(t, cache, "ns", "a", content)
+		stats := cache.Stats()
+		if stats.DiskHits != 1 || stats.Evictions != 2 || backend.gets != 10 {
+			t.Errorf("Unexpected stats %+v after %d backend reads", stats, backend.gets)
+		}
+	})
+
+	t.Run("Large objects bypass the cache", func(t *testing.T) {
+		cache, backend := newCache(t, CacheOptions{MemoryBytes: 80, Immutable: func(string, string) bool { return true }})
+		content := strings.Repeat("x", 11)
+		if err := cache.Put(ctx, "ns", "large", strings.NewReader(content)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		assertContent(t, cache, "ns", "large", content)
+		assertContent(t, cache, "ns", "large", content)
+		if backend.gets != 2 {
+			t.Errorf("Expected 2 backend reads, got %d", backend.gets)
+		}
+	})
+}
+
+func TestContentAddressed(t *testing.T) {
+	for name, want := range map[string]bool{
+		"objects/ce/013625030ba8dba906f756967f9e9ca394464a":             true,
+		"modules/lib/objects/ce/013625030ba8dba906f756967f9e9ca394464a": true,
+		"objects/ce/013625030ba8dba906f756967f9e9ca39446":               false,
+		"objects/CE/013625030ba8dba906f756967f9e9ca394464a":             false,
+		"refs/heads/main": false,
+		"config":          false,
+	} {
+		if got := ContentAddressed("repo", name); got != want {
+			t.Errorf("ContentAddressed(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
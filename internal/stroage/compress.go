@@ -0,0 +1,335 @@
+package stroage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Layout of a compressed object:
+//
+//	magic | codec | compressed stream | uncompressed size (8 bytes, big endian)
+//
+// Objects without the magic are returned as stored, so compression can be
+// enabled on a backend that already holds data.
+const (
+	compMagic      = "DGC1"
+	compHeaderSize = len(compMagic) + 1
+	compTrailerLen = 8
+)
+
+// Codec identifies a compression algorithm in the object header.
+type Codec byte
+
+const (
+	CodecGzip Codec = 1
+	CodecZstd Codec = 2
+)
+
+// ParseCodec returns the codec named "gzip" or "zstd".
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "gzip":
+		return CodecGzip, nil
+	case "zstd":
+		return CodecZstd, nil
+	default:
+		return 0, errors.ErrNotSupported.Msg("unknown compression codec " + name)
+	}
+}
+
+func (c Codec) String() string {
+	switch c {
+	case CodecGzip:
+		return "gzip"
+	case CodecZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+func (c Codec) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, errors.ErrNotSupported
+	}
+}
+
+func (c Codec) newReader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, errors.ErrBadData.Msg("unknown compression codec")
+	}
+}
+
+// CompressedStorage compresses objects before handing them to the wrapped
+// Storage. Sizes are reported uncompressed, checksums are those of the
+// stored data. Seeking backwards in a reader decompresses from the start.
+type CompressedStorage struct {
+	Storage
+	stagedUploads
+	codec Codec
+}
+
+// NewCompressedStorage wraps backend, compressing new objects with codec.
+// Objects written with another codec remain readable.
+func NewCompressedStorage(backend Storage, codec Codec) *CompressedStorage {
+	s := &CompressedStorage{Storage: backend, codec: codec}
+	s.stagedUploads = stagedUploads{s}
+	return s
+}
+
+// compressed holds the result of a compressing write once it is done
+type compressed struct {
+	size int64
+}
+
+// compress starts compressing obj in the background. The returned reader
+// must be consumed or closed.
+func (s *CompressedStorage) compress(obj io.Reader) (*io.PipeReader, *compressed, error) {
+	pr, pw := io.Pipe()
+	w, err := s.codec.newWriter(pw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &compressed{}
+	go func() {
+		if _, err := pw.Write(append([]byte(compMagic), byte(s.codec))); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		n, err := io.Copy(w, obj)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		trailer := make([]byte, compTrailerLen)
+		binary.BigEndian.PutUint64(trailer, uint64(n))
+		_, err = pw.Write(trailer)
+		result.size = n
+		pw.CloseWithError(err)
+	}()
+
+	return pr, result, nil
+}
+
+// Put compresses and stores an object
+func (s *CompressedStorage) Put(ctx context.Context, namespace, objname string, obj io.Reader) error {
+	pr, _, err := s.compress(obj)
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+
+	return s.Storage.Put(ctx, namespace, objname, pr)
+}
+
+// PutIf compresses and stores an object if cond holds
+func (s *CompressedStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	pr, result, err := s.compress(obj)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer pr.Close()
+
+	info, err := s.Storage.PutIf(ctx, namespace, objname, pr, cond)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	// The backend read the pipe to the end, so the writer is done
+	info.Size = result.size
+	return info, nil
+}
+
+// Get retrieves and decompresses an object
+func (s *CompressedStorage) Get(ctx context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	src, info, err := s.Storage.Get(ctx, namespace, objname)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	codec, size, err := readCompHeader(src, info.Size)
+	if err != nil {
+		src.Close()
+		return nil, ObjectInfo{}, err
+	}
+	if codec == 0 {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			src.Close()
+			return nil, ObjectInfo{}, err
+		}
+		return src, info, nil
+	}
+
+	stored := info.Size
+	info.Size = size
+	return &decompressReader{
+		src:    src,
+		codec:  codec,
+		length: stored - int64(compHeaderSize) - compTrailerLen,
+		size:   size,
+	}, info, nil
+}
+
+// readCompHeader returns the codec and uncompressed size of a stored object,
+// or a zero codec if it was stored uncompressed
+func readCompHeader(src io.ReadSeeker, stored int64) (Codec, int64, error) {
+	if stored < int64(compHeaderSize+compTrailerLen) {
+		return 0, stored, nil
+	}
+
+	header := make([]byte, compHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return 0, 0, err
+	}
+	if !bytes.Equal(header[:len(compMagic)], []byte(compMagic)) {
+		return 0, stored, nil
+	}
+
+	trailer := make([]byte, compTrailerLen)
+	if _, err := src.Seek(stored-compTrailerLen, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	if _, err := io.ReadFull(src, trailer); err != nil {
+		return 0, 0, err
+	}
+
+	return Codec(header[len(compMagic)]), int64(binary.BigEndian.Uint64(trailer)), nil
+}
+
+// GetRange decompresses part of an object
+func (s *CompressedStorage) GetRange(ctx context.Context, namespace, objname string, offset, length int64) (io.ReadCloser, error) {
+	return getRange(ctx, s, namespace, objname, offset, length)
+}
+
+// Stat returns the metadata of an object with its uncompressed size
+func (s *CompressedStorage) Stat(ctx context.Context, namespace, objname string) (ObjectInfo, error) {
+	src, info, err := s.Storage.Get(ctx, namespace, objname)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer src.Close()
+
+	// Get may not report a checksum, Stat has to
+	stat, err := s.Storage.Stat(ctx, namespace, objname)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	_, size, err := readCompHeader(src, info.Size)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat.Size = size
+	return stat, nil
+}
+
+// decompressReader decompresses a stored stream, restarting it to seek
+// backwards
+type decompressReader struct {
+	src    io.ReadSeekCloser
+	codec  Codec
+	length int64
+	size   int64
+
+	dec    io.ReadCloser
+	decPos int64
+	pos    int64
+}
+
+func (r *decompressReader) restart() error {
+	if r.dec != nil {
+		r.dec.Close()
+		r.dec = nil
+	}
+	if _, err := r.src.Seek(int64(compHeaderSize), io.SeekStart); err != nil {
+		return err
+	}
+
+	dec, err := r.codec.newReader(io.LimitReader(r.src, r.length))
+	if err != nil {
+		return errors.ErrBadData.Msg("compressed object is corrupted").Err(err)
+	}
+	r.dec = dec
+	r.decPos = 0
+	return nil
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.dec == nil || r.pos < r.decPos {
+		if err := r.restart(); err != nil {
+			return 0, err
+		}
+	}
+	if r.pos > r.decPos {
+		n, err := io.CopyN(io.Discard, r.dec, r.pos-r.decPos)
+		r.decPos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	p = p[:min(int64(len(p)), r.size-r.pos)]
+	n, err := r.dec.Read(p)
+	r.pos += int64(n)
+	r.decPos += int64(n)
+	if err == io.EOF && r.pos < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if r.pos >= r.size && (err == nil || err == io.EOF) {
+		if ferr := r.finish(); ferr != nil {
+			return n, ferr
+		}
+	}
+	return n, err
+}
+
+// finish reads the compressed stream and the stored object to their ends,
+// so the codec and a backend checksum verify them
+func (r *decompressReader) finish() error {
+	if _, err := io.Copy(io.Discard, r.dec); err != nil {
+		return err
+	}
+	_, err := io.Copy(io.Discard, r.src)
+	return err
+}
+
+func (r *decompressReader) Seek(offset int64, whence int) (int64, error) {
+	return seekPosition(&r.pos, r.size, offset, whence)
+}
+
+func (r *decompressReader) Close() error {
+	if r.dec != nil {
+		r.dec.Close()
+	}
+	return r.src.Close()
+}
@@ -0,0 +1,73 @@
+package stroage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompressedStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStorage()
+	content := strings.Repeat("compressible content ", 1000)
+
+	for _, codec := range []Codec{CodecGzip, CodecZstd} {
+		t.Run(codec.String(), func(t *testing.T) {
+			storage := NewCompressedStorage(backend, codec)
+			if err := storage.Put(ctx, codec.String(), "obj", strings.NewReader(content)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+
+			stored, err := backend.Stat(ctx, codec.String(), "obj")
+			if err != nil {
+				t.Fatalf("Stat failed: %v", err)
+			}
+			if stored.Size >= int64(len(content))/10 {
+				t.Errorf("Expected the object to shrink, stored %d bytes", stored.Size)
+			}
+
+			info, err := storage.Stat(ctx, codec.String(), "obj")
+			if err != nil {
+				t.Fatalf("Stat failed: %v", err)
+			}
+			if info.Size != int64(len(content)) {
+				t.Errorf("Expected size %d, got %d", len(content), info.Size)
+			}
+		})
+	}
+
+	t.Run("Objects of any codec are readable", func(t *testing.T) {
+		storage := NewCompressedStorage(backend, CodecZstd)
+		assertContent(t, storage, "gzip", "obj", content)
+		assertContent(t, storage, "zstd", "obj", content)
+	})
+
+	t.Run("Uncompressed objects are passed through", func(t *testing.T) {
+		if err := backend.Put(ctx, "plain", "obj", strings.NewReader("stored before compression")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		assertContent(t, NewCompressedStorage(backend, CodecGzip), "plain", "obj", "stored before compression")
+	})
+
+	t.Run("Seeking backwards", func(t *testing.T) {
+		reader, _, err := NewCompressedStorage(backend, CodecGzip).Get(ctx, "zstd", "obj")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer reader.Close()
+
+		for _, offset := range []int64{5000, 21, 15000} {
+			if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+				t.Fatalf("Seek failed: %v", err)
+			}
+			buf := make([]byte, 21)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if string(buf) != content[offset:offset+21] {
+				t.Errorf("Wrong content at offset %d: %q", offset, buf)
+			}
+		}
+	})
+}
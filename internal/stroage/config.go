@@ -0,0 +1,164 @@
+package stroage
+
+import (
+	"bytes"
+	"context"
+	"os"
+
+	"github.com/GoldenDeals/DepGit/internal/config"
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// FromConfig builds the configured backend and wraps it. Data is compressed
+// before it is encrypted, as ciphertext does not compress, and the cache
+// sits below encryption so nothing is cached in the clear. A configured
+// secondary backend is built the same way and receives a copy of all
+// writes. Background work such as replica repair stops with ctx.
+func FromConfig(ctx context.Context, cfg config.StorageConfig) (Storage, error) {
+	st, err := fromConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Secondary == nil || cfg.Secondary.Backend == "" {
+		return st, nil
+	}
+
+	secondary, err := fromConfig(ctx, *cfg.Secondary)
+	if err != nil {
+		return nil, err
+	}
+	return NewDualWriteStorage(st, secondary), nil
+}
+
+// SecondaryFromConfig builds only the secondary backend, which is the
+// destination of a migration.
+func SecondaryFromConfig(ctx context.Context, cfg config.StorageConfig) (Storage, error) {
+	if cfg.Secondary == nil || cfg.Secondary.Backend == "" {
+		return nil, errors.ErrBadData.Msg("no secondary storage configured")
+	}
+	return fromConfig(ctx, *cfg.Secondary)
+}
+
+// ReplicatedFromConfig builds the replicas of a "replicated" backend,
+// without its wrappers or background repair.
+func ReplicatedFromConfig(ctx context.Context, cfg config.StorageConfig) (*ReplicatedStorage, error) {
+	if cfg.Backend != "replicated" {
+		return nil, errors.ErrBadData.Msg("storage backend is not replicated")
+	}
+
+	replicas := make([]Storage, 0, len(cfg.Replicas))
+	for _, rc := range cfg.Replicas {
+		st, err := fromConfig(ctx, rc)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, st)
+	}
+
+	return NewReplicatedStorage(replicas, ReplicaOptions{WriteQuorum: cfg.WriteQuorum})
+}
+
+// EncryptedFromConfig builds the encrypted backend without the compression
+// above it or a secondary backend, e.g. to rotate its keys.
+func EncryptedFromConfig(ctx context.Context, cfg config.StorageConfig) (*EncryptedStorage, error) {
+	if cfg.EncryptionKeyFile == "" {
+		return nil, errors.ErrBadData.Msg("storage is not encrypted")
+	}
+
+	cfg.Compression = ""
+	cfg.RepairInterval = 0
+	st, err := fromConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return st.(*EncryptedStorage), nil
+}
+
+// fromConfig builds a single backend with its wrappers
+func fromConfig(ctx context.Context, cfg config.StorageConfig) (Storage, error) {
+	var (
+		st  Storage
+		err error
+	)
+
+	// Signed URLs serve objects as stored
+	if cfg.SignedURLs.BaseURL != "" && (cfg.EncryptionKeyFile != "" || cfg.Compression != "") {
+		return nil, errors.ErrBadData.Msg("signed URLs cannot be used with encryption or compression")
+	}
+
+	switch cfg.Backend {
+	case "", "file":
+		var fs *FileStorage
+		if fs, err = NewFileStorageWithOptions(cfg.Path, FileOptions{Fanout: cfg.Fanout}); err == nil {
+			err = enableSignedURLs(fs, cfg.SignedURLs)
+			st = fs
+		}
+	case "minio":
+		m := cfg.Minio
+		st, err = NewMinioStorage(m.Endpoint, m.AccessKey, m.SecretKey, m.Bucket, m.UseSSL)
+	case "memory":
+		st = NewMemoryStorage()
+	case "replicated":
+		var rs *ReplicatedStorage
+		if rs, err = ReplicatedFromConfig(ctx, cfg); err == nil {
+			if cfg.RepairInterval > 0 {
+				go rs.RunRepair(ctx, cfg.RepairInterval)
+			}
+			st = rs
+		}
+	default:
+		return nil, errors.ErrNotSupported.Msg("unknown storage backend " + cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c := cfg.Cache; c.MemoryBytes > 0 || c.DiskBytes > 0 {
+		st, err = NewCachedStorage(st, CacheOptions{
+			MemoryBytes: c.MemoryBytes,
+			DiskPath:    c.DiskPath,
+			DiskBytes:   c.DiskBytes,
+			Immutable:   ContentAddressed,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.EncryptionKeyFile != "" {
+		keys, err := LoadKeyring(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		st = NewEncryptedStorageWithOptions(st, keys, EncryptionOptions{
+			AllowPlaintext: cfg.EncryptionAllowPlaintext,
+		})
+	}
+
+	if cfg.Compression != "" {
+		codec, err := ParseCodec(cfg.Compression)
+		if err != nil {
+			return nil, err
+		}
+		st = NewCompressedStorage(st, codec)
+	}
+
+	return st, nil
+}
+
+// enableSignedURLs configures signed URLs for a file backend if requested
+func enableSignedURLs(fs *FileStorage, cfg config.SignedURLConfig) error {
+	if cfg.BaseURL == "" {
+		return nil
+	}
+	if cfg.KeyFile == "" {
+		return errors.ErrBadData.Msg("signed URLs need a key file")
+	}
+
+	key, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	return fs.EnableSignedURLs(cfg.BaseURL, bytes.TrimSpace(key))
+}
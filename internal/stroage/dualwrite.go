@@ -0,0 +1,210 @@
+package stroage
+
+import (
+	"context"
+	"io"
+
+	"github.com/GoldenDeals/DepGit/internal/share/logger"
+)
+
+var dualWriteLog = logger.New("stroage_dualwrite")
+
+// DualWriteStorage keeps a secondary Storage in sync with a primary one
+// while moving between backends. Writes go to both, reads are served by
+// the primary and fall back to the secondary for objects the primary lacks.
+// Listings only cover the primary.
+//
+// The primary decides the outcome of a write. A failed mirror write is
+// logged and left for the next Migrator run to repair, so an outage of the
+// secondary does not take writes down.
+type DualWriteStorage struct {
+	Storage
+	stagedUploads
+	secondary Storage
+}
+
+// NewDualWriteStorage mirrors writes to primary into secondary.
+func NewDualWriteStorage(primary, secondary Storage) *DualWriteStorage {
+	s := &DualWriteStorage{Storage: primary, secondary: secondary}
+	s.stagedUploads = stagedUploads{s}
+	return s
+}
+
+// mirrorReader copies what the primary reads into the secondary's pipe
+type mirrorReader struct {
+	src    io.Reader
+	pipe   *io.PipeWriter
+	failed bool
+	eof    bool
+}
+
+func (r *mirrorReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 && !r.failed {
+		// Stop mirroring once the secondary gave up
+		if _, werr := r.pipe.Write(p[:n]); werr != nil {
+			r.failed = true
+		}
+	}
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// mirror streams obj into both writes at once. The secondary write only
+// completes if the primary one succeeded and consumed the whole content.
+func (s *DualWriteStorage) mirror(op, namespace, objname string, obj io.Reader, primary, secondary func(io.Reader) error) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := secondary(pr)
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	reader := &mirrorReader{src: obj, pipe: pw}
+	err := primary(reader)
+	switch {
+	case err != nil:
+		pw.CloseWithError(err)
+	case !reader.eof:
+		pw.CloseWithError(io.ErrUnexpectedEOF)
+	default:
+		pw.Close()
+	}
+
+	serr := <-done
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		s.logFailure(op, namespace, objname, serr)
+	}
+	return nil
+}
+
+func (s *DualWriteStorage) logFailure(op, namespace, objname string, err error) {
+	dualWriteLog.WithError(err).
+		WithField("op", op).
+		WithField("namespace", namespace).
+		WithField("object", objname).
+		Warn("Mirroring to secondary storage failed")
+}
+
+// Put stores an object in both backends
+func (s *DualWriteStorage) Put(ctx context.Context, namespace, objname string, obj io.Reader) error {
+	return s.mirror("put", namespace, objname, obj,
+		func(r io.Reader) error {
+			return s.Storage.Put(ctx, namespace, objname, r)
+		},
+		func(r io.Reader) error {
+			// The secondary follows the primary, whatever it held before
+			_, err := s.secondary.PutIf(ctx, namespace, objname, r, PutCondition{})
+			return err
+		})
+}
+
+// PutIf stores an object in both backends if cond holds on the primary
+func (s *DualWriteStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := s.mirror("put", namespace, objname, obj,
+		func(r io.Reader) error {
+			var err error
+			info, err = s.Storage.PutIf(ctx, namespace, objname, r, cond)
+			return err
+		},
+		func(r io.Reader) error {
+			_, err := s.secondary.PutIf(ctx, namespace, objname, r, PutCondition{})
+			return err
+		})
+	return info, err
+}
+
+// Get reads an object from the primary, or the secondary if it is missing
+func (s *DualWriteStorage) Get(ctx context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	reader, info, err := s.Storage.Get(ctx, namespace, objname)
+	if IsNotExist(err) {
+		return s.secondary.Get(ctx, namespace, objname)
+	}
+	return reader, info, err
+}
+
+// GetRange reads part of an object from the primary, or the secondary if
+// it is missing
+func (s *DualWriteStorage) GetRange(ctx context.Context, namespace, objname string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := s.Storage.GetRange(ctx, namespace, objname, offset, length)
+	if IsNotExist(err) {
+		return s.secondary.GetRange(ctx, namespace, objname, offset, length)
+	}
+	return reader, err
+}
+
+// Stat returns the metadata from the primary, or the secondary if the
+// object is missing
+func (s *DualWriteStorage) Stat(ctx context.Context, namespace, objname string) (ObjectInfo, error) {
+	info, err := s.Storage.Stat(ctx, namespace, objname)
+	if IsNotExist(err) {
+		return s.secondary.Stat(ctx, namespace, objname)
+	}
+	return info, err
+}
+
+// Exists reports whether either backend holds the object
+func (s *DualWriteStorage) Exists(ctx context.Context, namespace, objname string) (bool, error) {
+	exists, err := s.Storage.Exists(ctx, namespace, objname)
+	if err != nil || exists {
+		return exists, err
+	}
+	return s.secondary.Exists(ctx, namespace, objname)
+}
+
+// Delete removes an object from both backends. It reports the object
+// missing only if neither holds it, as reads fall back to the secondary.
+func (s *DualWriteStorage) Delete(ctx context.Context, namespace, objname string) error {
+	err := s.Storage.Delete(ctx, namespace, objname)
+	if err != nil && !IsNotExist(err) {
+		return err
+	}
+	primaryHad := err == nil
+
+	err = s.secondary.Delete(ctx, namespace, objname)
+	switch {
+	case err == nil:
+		return nil
+	case IsNotExist(err) && !primaryHad:
+		return err
+	case IsNotExist(err):
+		return nil
+	case !primaryHad:
+		// The object is only in the secondary and still readable from it
+		return err
+	}
+
+	s.logFailure("delete", namespace, objname, err)
+	return nil
+}
+
+// Copy duplicates an object in both backends
+func (s *DualWriteStorage) Copy(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	if err := s.Storage.Copy(ctx, srcNamespace, srcName, dstNamespace, dstName); err != nil {
+		return err
+	}
+
+	if err := s.secondary.Copy(ctx, srcNamespace, srcName, dstNamespace, dstName); err != nil {
+		s.logFailure("copy", dstNamespace, dstName, err)
+	}
+	return nil
+}
+
+// Move renames an object in both backends
+func (s *DualWriteStorage) Move(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	if err := s.Storage.Move(ctx, srcNamespace, srcName, dstNamespace, dstName); err != nil {
+		return err
+	}
+
+	if err := s.secondary.Move(ctx, srcNamespace, srcName, dstNamespace, dstName); err != nil {
+		s.logFailure("move", dstNamespace, dstName, err)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package stroage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoldenDeals/DepGit/internal/config"
+)
+
+// testKeyring returns a keyring with the given key ids. Keys are derived
+// from the ids, so rings sharing an id can decrypt each other's objects.
+func testKeyring(t *testing.T, primary string, ids ...string) *Keyring {
+	t.Helper()
+
+	keys := map[string][]byte{}
+	for _, id := range append(ids, primary) {
+		key := sha256.Sum256([]byte(id))
+		keys[id] = key[:]
+	}
+
+	ring, err := NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	return ring
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStorage()
+	storage := NewEncryptedStorage(backend, testKeyring(t, "k1"))
+
+	// Spans several chunks and ends in a partial one
+	large := make([]byte, 3*encChunkSize+123)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatalf("rand failed: %v", err)
+	}
+	if err := storage.Put(ctx, "ns", "large", bytes.NewReader(large)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	t.Run("Content is not stored in the clear", func(t *testing.T) {
+		if err := storage.Put(ctx, "ns", "secret", strings.NewReader("top secret content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		reader, _, err := backend.Get(ctx, "ns", "secret")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer reader.Close()
+
+		raw, _ := io.ReadAll(reader)
+		if bytes.Contains(raw, []byte("secret content")) {
+			t.Error("Expected the stored object to be encrypted")
+		}
+		assertContent(t, storage, "ns", "secret", "top secret content")
+	})
+
+	t.Run("Seeking across chunks", func(t *testing.T) {
+		reader, info, err := storage.Get(ctx, "ns", "large")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer reader.Close()
+
+		if info.Size != int64(len(large)) {
+			t.Errorf("Expected size %d, got %d", len(large), info.Size)
+		}
+
+		for _, offset := range []int64{2*encChunkSize - 10, 5, int64(len(large)) - 50} {
+			if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+				t.Fatalf("Seek failed: %v", err)
+			}
+			buf := make([]byte, 50)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				t.Fatalf("Read at %d failed: %v", offset, err)
+			}
+			if !bytes.Equal(buf, large[offset:offset+50]) {
+				t.Errorf("Wrong content at offset %d", offset)
+			}
+		}
+	})
+
+	t.Run("Tampering is detected", func(t *testing.T) {
+		reader, _, err := backend.Get(ctx, "ns", "large")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		raw, _ := io.ReadAll(reader)
+		reader.Close()
+
+		flipped := append([]byte(nil), raw...)
+		flipped[len(flipped)-100] ^= 1
+		truncated := raw[:len(raw)-encChunkSize]
+
+		for name, data := range map[string][]byte{"flipped": flipped, "truncated": truncated} {
+			if _, err := backend.PutIf(ctx, "tampered", name, bytes.NewReader(data), PutCondition{}); err != nil {
+				t.Fatalf("PutIf failed: %v", err)
+			}
+
+			reader, _, err := storage.Get(ctx, "tampered", name)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if _, err := io.ReadAll(reader); err == nil {
+				t.Errorf("Expected reading the %s object to fail", name)
+			}
+			reader.Close()
+		}
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		rotated := NewEncryptedStorage(backend, testKeyring(t, "k2", "k1"))
+		if err := rotated.Put(ctx, "ns", "new", strings.NewReader("new key")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		count, err := rotated.Rotate(ctx, "ns")
+		if err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+		if count != 2 {
+			t.Errorf("Expected 2 rotated objects, got %d", count)
+		}
+
+		// The old key is no longer needed
+		retired := NewEncryptedStorage(backend, testKeyring(t, "k2"))
+		assertContent(t, retired, "ns", "secret", "top secret content")
+		assertContent(t, retired, "ns", "new", "new key")
+		assertContent(t, retired, "ns", "large", string(large))
+
+		if _, _, err := storage.Get(ctx, "ns", "new"); err == nil {
+			t.Error("Expected a keyring without k2 to fail")
+		}
+	})
+}
+
+func TestEncryptedStorage_Plaintext(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStorage()
+	for name, content := range map[string]string{"old": "stored in the clear", "tiny": "DG", "empty": ""} {
+		if err := backend.Put(ctx, "ns", name, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	t.Run("Rejected by default", func(t *testing.T) {
+		storage := NewEncryptedStorage(backend, testKeyring(t, "k1"))
+		if _, _, err := storage.Get(ctx, "ns", "old"); err == nil {
+			t.Error("Expected reading a plaintext object to fail")
+		}
+	})
+
+	t.Run("Passed through when allowed", func(t *testing.T) {
+		storage := NewEncryptedStorageWithOptions(backend, testKeyring(t, "k1"), EncryptionOptions{AllowPlaintext: true})
+		assertContent(t, storage, "ns", "old", "stored in the clear")
+		assertContent(t, storage, "ns", "tiny", "DG")
+		assertContent(t, storage, "ns", "empty", "")
+
+		info, err := storage.Stat(ctx, "ns", "old")
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Size != int64(len("stored in the clear")) {
+			t.Errorf("Expected the stored size, got %d", info.Size)
+		}
+
+		// New objects are still encrypted
+		if err := storage.Put(ctx, "ns", "new", strings.NewReader("new content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		assertContent(t, storage, "ns", "new", "new content")
+		assertContent(t, NewEncryptedStorage(backend, testKeyring(t, "k1")), "ns", "new", "new content")
+
+		info, err = storage.Stat(ctx, "ns", "new")
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Size != int64(len("new content")) {
+			t.Errorf("Expected the plaintext size, got %d", info.Size)
+		}
+
+		rotated := NewEncryptedStorageWithOptions(backend, testKeyring(t, "k2", "k1"), EncryptionOptions{AllowPlaintext: true})
+		count, err := rotated.Rotate(ctx, "ns")
+		if err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected only the encrypted object to be rotated, got %d", count)
+		}
+	})
+
+	t.Run("Migrated to an encrypted storage", func(t *testing.T) {
+		encrypted := NewMemoryStorage()
+		storage := NewEncryptedStorage(encrypted, testKeyring(t, "k1"))
+		m := &Migrator{Src: backend, Dst: storage, Namespaces: []string{"ns"}}
+		if _, err := m.Run(ctx); err != nil {
+			t.Fatalf("Migrate failed: %v", err)
+		}
+		assertContent(t, storage, "ns", "old", "stored in the clear")
+
+		reader, _, err := encrypted.Get(ctx, "ns", "old")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer reader.Close()
+		if raw, _ := io.ReadAll(reader); bytes.Contains(raw, []byte("in the clear")) {
+			t.Error("Expected the migrated object to be encrypted")
+		}
+	})
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, encKeySize))
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		return path
+	}
+
+	ring, err := LoadKeyring(write("good.json", `{"primary": "2025", "keys": {"2025": "`+key+`"}}`))
+	if err != nil {
+		t.Fatalf("LoadKeyring failed: %v", err)
+	}
+	if ring.Primary() != "2025" {
+		t.Errorf("Expected primary 2025, got %s", ring.Primary())
+	}
+
+	for name, content := range map[string]string{
+		"missing-primary.json": `{"primary": "2024", "keys": {"2025": "` + key + `"}}`,
+		"short-key.json":       `{"primary": "2025", "keys": {"2025": "c2hvcnQ="}}`,
+		"not-json.json":        `primary: 2025`,
+	} {
+		if _, err := LoadKeyring(write(name, content)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestEncryptedFromConfig(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keys.json")
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, encKeySize))
+	if err := os.WriteFile(keyFile, []byte(`{"primary": "2025", "keys": {"2025": "`+key+`"}}`), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	cfg := config.StorageConfig{Backend: "file", Path: filepath.Join(dir, "objects"), Compression: "gzip"}
+	if _, err := EncryptedFromConfig(ctx, cfg); err == nil {
+		t.Error("Expected a storage without encryption to be rejected")
+	}
+
+	cfg.EncryptionKeyFile = keyFile
+	st, err := EncryptedFromConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("EncryptedFromConfig failed: %v", err)
+	}
+	if _, err := st.Rotate(ctx, "ns"); err != nil {
+		t.Errorf("Rotate failed: %v", err)
+	}
+}
@@ -2,73 +2,317 @@ package stroage
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
 )
 
 // FileStorage implements the Storage interface using the local filesystem
 type FileStorage struct {
 	basePath string
+	fanout   int
+
+	// Serializes conditional writes. Other processes writing to the same
+	// directory are not covered.
+	mu sync.Mutex
+
+	// signing is set by EnableSignedURLs
+	signing *urlSigning
 }
 
-// NewFileStorage creates a new file storage client
+// FileOptions configures a FileStorage.
+type FileOptions struct {
+	// Fanout spreads the objects of a directory over subdirectories named
+	// after the first Fanout characters of their names, like .git/objects
+	// does with 2. Zero keeps all objects of a directory together.
+	Fanout int
+}
+
+// NewFileStorage creates a new file storage client with a flat layout
 func NewFileStorage(basePath string) (*FileStorage, error) {
+	return NewFileStorageWithOptions(basePath, FileOptions{})
+}
+
+// NewFileStorageWithOptions creates a new file storage client. Data written
+// with another fan-out is moved to the configured layout first.
+func NewFileStorageWithOptions(basePath string, opts FileOptions) (*FileStorage, error) {
+	if opts.Fanout < 0 || opts.Fanout > maxFanout {
+		return nil, errors.ErrBadData.Msg("fanout out of range")
+	}
+
 	// Create base directory if it doesn't exist
 	if err := os.MkdirAll(basePath, 0o750); err != nil {
 		return nil, err
 	}
 
-	return &FileStorage{
+	s := &FileStorage{
 		basePath: basePath,
-	}, nil
+		fanout:   opts.Fanout,
+	}
+	if err := s.relayout(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// tempPrefix marks in-flight writes, List never reports them
+const tempPrefix = ".tmp-"
+
+// checksumPrefix marks the file next to an object recording its SHA-256,
+// List never reports them
+const checksumPrefix = ".sha256-"
+
+// checksumPath returns the path of the checksum recorded for filePath
+func checksumPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), checksumPrefix+filepath.Base(filePath))
+}
+
+// uploadPrefix marks the directory holding the parts of a multipart upload
+const uploadPrefix = ".upload-"
+
+// hiddenFile reports whether name is a bookkeeping file or directory rather
+// than an object
+func hiddenFile(name string) bool {
+	return strings.HasPrefix(name, tempPrefix) || strings.HasPrefix(name, checksumPrefix) || strings.HasPrefix(name, uploadPrefix)
 }
 
-// Put stores an object in the filesystem
+// Put stores an object in the filesystem. The content becomes visible
+// atomically once fully written and synced.
 func (s *FileStorage) Put(_ context.Context, namespace, objname string, obj io.Reader) error {
-	// Create directory for namespace if it doesn't exist
-	dir := filepath.Join(s.basePath, namespace)
-	if err := os.MkdirAll(dir, 0o750); err != nil {
+	if err := checkObjectName(objname); err != nil {
 		return err
 	}
+	filePath := s.objectPath(namespace, objname)
 
-	// Check if file already exists
-	filePath := filepath.Join(dir, objname)
+	// Check if file already exists before streaming the content
 	if _, err := os.Stat(filePath); err == nil {
 		return os.ErrExist
 	}
 
+	tmpPath, checksum, err := s.writeTemp(filePath, obj)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(tmpPath, filePath, checksum, false)
+}
+
+// PutIf stores an object if cond holds, replacing any previous content
+func (s *FileStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	if err := checkObjectName(objname); err != nil {
+		return ObjectInfo{}, err
+	}
+	filePath := s.objectPath(namespace, objname)
+
+	tmpPath, checksum, err := s.writeTemp(filePath, obj)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	// Checking the condition and renaming must not interleave with other
+	// conditional writes of this process
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cond.IfMatch != "" {
+		current, err := s.Stat(ctx, namespace, objname)
+		if err != nil && !os.IsNotExist(err) {
+			os.Remove(tmpPath)
+			return ObjectInfo{}, err
+		}
+		if err != nil || current.Checksum != cond.IfMatch {
+			os.Remove(tmpPath)
+			return ObjectInfo{}, errors.ErrPreconditionFailed
+		}
+	}
+
+	if err := s.commit(tmpPath, filePath, checksum, !cond.IfAbsent); err != nil {
+		if err == os.ErrExist {
+			return ObjectInfo{}, errors.ErrPreconditionFailed
+		}
+		return ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Checksum: checksum,
+	}, nil
+}
+
+// writeTemp streams obj into a synced temporary file in the directory of
+// filePath and returns its path and SHA-256 checksum
+func (s *FileStorage) writeTemp(filePath string, obj io.Reader) (string, string, error) {
 	// Create parent directories for the file if needed
-	fileDir := filepath.Dir(filePath)
-	if err := os.MkdirAll(fileDir, 0o750); err != nil {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o750); err != nil {
+		return "", "", err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), tempPrefix+"*")
+	if err != nil {
+		return "", "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), obj)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		log.Printf("Error closing file: %v", closeErr)
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", err
+	}
+
+	return file.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// commit moves a temporary file to its final path and records its checksum.
+// Without overwrite it fails with os.ErrExist if the target already exists.
+func (s *FileStorage) commit(tmpPath, filePath, checksum string, overwrite bool) error {
+	if overwrite {
+		// The old checksum must not be checked against the new content
+		if err := os.Remove(checksumPath(filePath)); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmpPath)
+			return err
+		}
+
+		if err := os.Rename(tmpPath, filePath); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	} else {
+		// Linking fails if the target exists, unlike rename
+		err := os.Link(tmpPath, filePath)
+		os.Remove(tmpPath)
+		if os.IsExist(err) {
+			return os.ErrExist
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.writeChecksum(filePath, checksum); err != nil {
 		return err
 	}
 
-	// Create file
-	file, err := os.Create(filePath)
+	// Persist the directory entries as well
+	dir, err := os.Open(filepath.Dir(filePath))
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil {
-			log.Printf("Error closing file: %v", closeErr)
-			if err == nil {
-				err = closeErr
-			}
-		}
-	}()
+	defer dir.Close()
 
-	// Copy data to file
-	_, err = io.Copy(file, obj)
-	return err
+	return dir.Sync()
 }
 
-// Get retrieves an object from the filesystem
-func (s *FileStorage) Get(_ context.Context, namespace, objname string) (io.Reader, error) {
-	filePath := filepath.Join(s.basePath, namespace, objname)
-	return os.Open(filePath)
+// writeChecksum atomically records the checksum of the object at filePath
+func (s *FileStorage) writeChecksum(filePath, checksum string) error {
+	tmpPath, _, err := s.writeTemp(filePath, strings.NewReader(checksum+"\n"))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, checksumPath(filePath)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// readChecksum returns the checksum recorded for the object at filePath, or
+// an empty string for objects written before checksums were recorded
+func readChecksum(filePath string) (string, error) {
+	data, err := os.ReadFile(checksumPath(filePath))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Get retrieves an object from the filesystem. Reading it to the end fails
+// with errors.ErrChecksumMismatch if the content does not match the checksum
+// recorded when it was written.
+func (s *FileStorage) Get(_ context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	filePath := s.objectPath(namespace, objname)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, ObjectInfo{}, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+
+	// Read after opening, a checksum of newer content is caught by replaced
+	checksum, err := readChecksum(filePath)
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	if checksum == "" {
+		return file, ObjectInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+
+	replaced := func() bool {
+		current, err := os.Stat(filePath)
+		return err != nil || !os.SameFile(info, current)
+	}
+	return newVerifyingReader(file, sha256.New(), checksum, info.Size(), replaced), ObjectInfo{Size: info.Size(), ModTime: info.ModTime(), Checksum: checksum}, nil
+}
+
+// GetRange reads part of an object from the filesystem
+func (s *FileStorage) GetRange(ctx context.Context, namespace, objname string, offset, length int64) (io.ReadCloser, error) {
+	file, info, err := s.Get(ctx, namespace, objname)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err = checkRange(info.Size, offset, length)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return rangeReader{Reader: io.LimitReader(file, length), Closer: file}, nil
 }
 
 // List returns all objects in a namespace from the filesystem
@@ -86,16 +330,509 @@ func (s *FileStorage) List(_ context.Context, namespace string) ([]string, error
 			return err
 		}
 
-		// Skip directories
+		// Skip in-flight writes, checksums and uploads
+		if hiddenFile(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if info.IsDir() {
 			return nil
 		}
 
 		// Remove the base path and namespace prefix
 		relativePath := strings.TrimPrefix(path, filepath.Join(s.basePath, namespace)+string(filepath.Separator))
-		objects = append(objects, relativePath)
+		objects = append(objects, objectName(relativePath))
 		return nil
 	})
 
 	return objects, err
 }
+
+// Namespaces returns the directories below the base path in lexical order
+func (s *FileStorage) Namespaces(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			namespaces = append(namespaces, entry.Name())
+		}
+	}
+
+	return namespaces, nil
+}
+
+// errPageFull stops a listing walk once a page is complete
+var errPageFull = stderrors.New("page full")
+
+// ListPage returns one page of a listing, walking only the directories that
+// can contain entries of the page
+func (s *FileStorage) ListPage(ctx context.Context, namespace string, opts ListOptions) (ListResult, error) {
+	b, err := newPageBuilder(opts)
+	if err != nil {
+		return ListResult{}, err
+	}
+
+	err = s.walkPage(ctx, b, filepath.Join(s.basePath, namespace), "")
+	if err != nil && err != errPageFull && !os.IsNotExist(err) {
+		return ListResult{}, err
+	}
+
+	return b.result(), nil
+}
+
+// walkEntry is a directory entry of a listing walk. Shards stand for the
+// objects they hold until the walk reaches them.
+type walkEntry struct {
+	// key sorts the entry: its name, followed by "/" for directories
+	key   string
+	path  string
+	dir   bool
+	shard bool
+}
+
+// walkEntries reads dir, skipping bookkeeping files
+func walkEntries(dir string) ([]walkEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]walkEntry, 0, len(entries))
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name())
+		switch {
+		case e.IsDir() && strings.HasPrefix(e.Name(), shardPrefix):
+			res = append(res, walkEntry{key: strings.TrimPrefix(e.Name(), shardPrefix), path: p, shard: true})
+		case hiddenFile(e.Name()):
+		case e.IsDir():
+			// Directories sort as if their name ended in "/" so the walk
+			// matches the lexical order of full object names ("a-b" < "a/b")
+			res = append(res, walkEntry{key: e.Name() + "/", path: p, dir: true})
+		default:
+			res = append(res, walkEntry{key: e.Name(), path: p})
+		}
+	}
+	return res, nil
+}
+
+func sortWalkEntries(entries []walkEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+}
+
+// walkPage feeds the names below dir to b in lexical order. key is the
+// object name prefix corresponding to dir.
+func (s *FileStorage) walkPage(ctx context.Context, b *pageBuilder, dir, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	entries, err := walkEntries(dir)
+	if err != nil {
+		return err
+	}
+	sortWalkEntries(entries)
+
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		name := key + entry.key
+
+		switch {
+		case entry.shard:
+			if !b.wants(name) {
+				continue
+			}
+
+			// The objects of a shard sort after its prefix, so reading it
+			// only now keeps the order while sparing shards beyond the page
+			objects, err := walkEntries(entry.path)
+			if err != nil {
+				return err
+			}
+			rest := append(objects, entries[i+1:]...)
+			sortWalkEntries(rest)
+			entries = append(entries[:i], rest...)
+			i--
+
+		case !entry.dir:
+			if !b.add(name) {
+				return errPageFull
+			}
+
+		case !b.wants(name):
+
+		default:
+			// The whole directory collapses into one common prefix
+			if prefix, grouped := b.commonPrefix(name); grouped && prefix == name {
+				if !b.add(name) {
+					return errPageFull
+				}
+				continue
+			}
+
+			if err := s.walkPage(ctx, b, entry.path, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete removes an object from the filesystem, pruning directories left empty
+func (s *FileStorage) Delete(_ context.Context, namespace, objname string) error {
+	filePath := s.objectPath(namespace, objname)
+	if _, err := os.Stat(filePath); err != nil {
+		return err
+	}
+
+	// Remove the checksum first, an object without one is still readable
+	if err := os.Remove(checksumPath(filePath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil {
+		return err
+	}
+
+	s.pruneDirs(filepath.Dir(filePath), filepath.Join(s.basePath, namespace))
+	return nil
+}
+
+// pruneDirs removes empty directories from dir up to, but excluding, stop
+func (s *FileStorage) pruneDirs(dir, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop+string(filepath.Separator)) {
+		// Fails on non-empty directories, which ends the walk
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Stat returns the object metadata with the recorded checksum, hashing the
+// content of objects without one
+func (s *FileStorage) Stat(_ context.Context, namespace, objname string) (ObjectInfo, error) {
+	filePath := s.objectPath(namespace, objname)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, &os.PathError{Op: "stat", Path: filePath, Err: os.ErrNotExist}
+	}
+
+	checksum, err := readChecksum(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if checksum != "" {
+		return ObjectInfo{Size: info.Size(), ModTime: info.ModTime(), Checksum: checksum}, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// Exists reports whether an object is present in the filesystem
+func (s *FileStorage) Exists(_ context.Context, namespace, objname string) (bool, error) {
+	info, err := os.Stat(s.objectPath(namespace, objname))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !info.IsDir(), nil
+}
+
+// Copy duplicates an object, failing with os.ErrExist if the target exists.
+// Corrupted sources are not copied.
+func (s *FileStorage) Copy(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	if err := checkObjectName(dstName); err != nil {
+		return err
+	}
+
+	src, _, err := s.Get(ctx, srcNamespace, srcName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dstPath := s.objectPath(dstNamespace, dstName)
+	if _, err := os.Stat(dstPath); err == nil {
+		return os.ErrExist
+	}
+
+	tmpPath, checksum, err := s.writeTemp(dstPath, src)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(tmpPath, dstPath, checksum, false)
+}
+
+// Move renames an object, failing with os.ErrExist if the target exists
+func (s *FileStorage) Move(_ context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	if err := checkObjectName(dstName); err != nil {
+		return err
+	}
+
+	srcPath := s.objectPath(srcNamespace, srcName)
+	if _, err := os.Stat(srcPath); err != nil {
+		return err
+	}
+
+	dstPath := s.objectPath(dstNamespace, dstName)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o750); err != nil {
+		return err
+	}
+
+	// Link and unlink instead of rename, which would replace an existing target
+	if err := os.Link(srcPath, dstPath); err != nil {
+		if os.IsExist(err) {
+			return os.ErrExist
+		}
+		return err
+	}
+
+	// Carry the checksum over before the source disappears
+	if err := os.Rename(checksumPath(srcPath), checksumPath(dstPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(srcPath); err != nil {
+		return err
+	}
+
+	s.pruneDirs(filepath.Dir(srcPath), filepath.Join(s.basePath, srcNamespace))
+	return nil
+}
+
+// fileUpload is the state of a multipart upload kept next to its parts
+type fileUpload struct {
+	Object   string `json:"object"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+const uploadStateFile = "upload.json"
+
+// uploadDir returns the directory of an upload, validating its ID
+func (s *FileStorage) uploadDir(namespace, objname, uploadID string) (string, fileUpload, error) {
+	var state fileUpload
+
+	id, err := hex.DecodeString(uploadID)
+	if err != nil || len(id) != 16 {
+		return "", state, &os.PathError{Op: "open", Path: uploadID, Err: os.ErrNotExist}
+	}
+
+	dir := filepath.Join(s.basePath, namespace, uploadPrefix+uploadID)
+	data, err := os.ReadFile(filepath.Join(dir, uploadStateFile))
+	if err != nil {
+		return "", state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return "", state, errors.ErrBadData.Msg("malformed upload state").Err(err)
+	}
+	if state.Object != objname {
+		return "", state, &os.PathError{Op: "open", Path: uploadID, Err: os.ErrNotExist}
+	}
+
+	return dir, state, nil
+}
+
+// partPath returns the path of part number within an upload directory
+func partPath(dir string, number int) string {
+	return filepath.Join(dir, fmt.Sprintf("part-%05d", number))
+}
+
+// CreateUpload starts a multipart upload kept in a hidden directory of the
+// namespace
+func (s *FileStorage) CreateUpload(_ context.Context, namespace, objname, checksum string) (string, error) {
+	if err := checkObjectName(objname); err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	data, err := json.Marshal(fileUpload{Object: objname, Checksum: checksum})
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(s.basePath, namespace, uploadPrefix+uploadID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, uploadStateFile), data, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// UploadPart stores one part of a multipart upload
+func (s *FileStorage) UploadPart(_ context.Context, namespace, objname, uploadID string, number int, part io.Reader, size int64) (PartInfo, error) {
+	if number < 1 || number > maxPartNumber {
+		return PartInfo{}, errors.ErrBadData.Msg("invalid part number")
+	}
+
+	dir, _, err := s.uploadDir(namespace, objname, uploadID)
+	if err != nil {
+		return PartInfo{}, err
+	}
+
+	tmpPath, checksum, err := s.writeTemp(partPath(dir, number), io.LimitReader(part, size+1))
+	if err != nil {
+		return PartInfo{}, err
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err == nil && info.Size() != size {
+		err = errors.ErrBadData.Msg("part size does not match the content")
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, partPath(dir, number))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return PartInfo{}, err
+	}
+
+	return PartInfo{Number: number, Size: size, ETag: checksum}, nil
+}
+
+// ListParts returns the parts of a multipart upload, hashing each for its
+// ETag
+func (s *FileStorage) ListParts(_ context.Context, namespace, objname, uploadID string) ([]PartInfo, error) {
+	dir, _, err := s.uploadDir(namespace, objname, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []PartInfo
+	for _, entry := range entries {
+		var number int
+		if _, err := fmt.Sscanf(entry.Name(), "part-%05d", &number); err != nil || entry.Name() != filepath.Base(partPath(dir, number)) {
+			continue
+		}
+
+		file, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		size, err := io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, PartInfo{Number: number, Size: size, ETag: hex.EncodeToString(hash.Sum(nil))})
+	}
+
+	// Zero padded names are read in order
+	return parts, nil
+}
+
+// CompleteUpload concatenates the parts of a multipart upload into the
+// object and removes the upload
+func (s *FileStorage) CompleteUpload(ctx context.Context, namespace, objname, uploadID string, parts []PartInfo) (ObjectInfo, error) {
+	dir, state, err := s.uploadDir(namespace, objname, uploadID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for i, p := range parts {
+		if i > 0 && p.Number <= parts[i-1].Number {
+			return ObjectInfo{}, errors.ErrBadData.Msg("parts are not ordered by number")
+		}
+
+		file, err := os.Open(partPath(dir, p.Number))
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		defer file.Close()
+
+		// The part may have been uploaded again since, possibly with the same
+		// size
+		hash := sha256.New()
+		size, err := io.Copy(hash, file)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		if size != p.Size || hex.EncodeToString(hash.Sum(nil)) != p.ETag {
+			return ObjectInfo{}, errors.ErrBadData.Msg(fmt.Sprintf("part %d does not match the uploaded part", p.Number))
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return ObjectInfo{}, err
+		}
+		readers = append(readers, file)
+	}
+
+	filePath := s.objectPath(namespace, objname)
+	tmpPath, checksum, err := s.writeTemp(filePath, io.MultiReader(readers...))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if state.Checksum != "" && state.Checksum != checksum {
+		os.Remove(tmpPath)
+		return ObjectInfo{}, errors.ErrChecksumMismatch
+	}
+
+	// Do not interleave with conditional writes of the object
+	s.mu.Lock()
+	err = s.commit(tmpPath, filePath, checksum, true)
+	s.mu.Unlock()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Error removing upload %s: %v", uploadID, err)
+	}
+
+	return s.Stat(ctx, namespace, objname)
+}
+
+// AbortUpload removes a multipart upload with its parts
+func (s *FileStorage) AbortUpload(_ context.Context, namespace, objname, uploadID string) error {
+	dir, _, err := s.uploadDir(namespace, objname, uploadID)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
@@ -2,6 +2,7 @@ package stroage
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -44,10 +45,15 @@ func TestFileStorage(t *testing.T) {
 		}
 
 		// Get the object
-		result, err := storage.Get(ctx, namespace, objName)
+		result, info, err := storage.Get(ctx, namespace, objName)
 		if err != nil {
 			t.Fatalf("Get failed: %v", err)
 		}
+		defer result.Close()
+
+		if info.Size != int64(len(content)) {
+			t.Errorf("Expected size %d, got %d", len(content), info.Size)
+		}
 
 		// Read and verify content
 		data, err := io.ReadAll(result)
@@ -85,7 +91,7 @@ func TestFileStorage(t *testing.T) {
 	t.Run("Get non-existent file", func(t *testing.T) {
 		ctx := context.Background()
 		// Try to get a file that doesn't exist
-		_, err := storage.Get(ctx, "non-existent", "file.txt")
+		_, _, err := storage.Get(ctx, "non-existent", "file.txt")
 		if err == nil {
 			t.Errorf("Expected error when getting non-existent file, got nil")
 		}
@@ -144,4 +150,65 @@ func TestFileStorage(t *testing.T) {
 			t.Errorf("Expected empty list, got %d items", len(listed))
 		}
 	})
+
+	t.Run("Delete prunes empty directories", func(t *testing.T) {
+		ctx := context.Background()
+		namespace := "prune-test"
+
+		for _, file := range []string{"a/b/one.txt", "a/two.txt"} {
+			if err := storage.Put(ctx, namespace, file, strings.NewReader("x")); err != nil {
+				t.Fatalf("Failed to put test file %s: %v", file, err)
+			}
+		}
+
+		if err := storage.Delete(ctx, namespace, "a/b/one.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, namespace, "a", "b")); !os.IsNotExist(err) {
+			t.Errorf("Expected empty directory to be removed, got %v", err)
+		}
+
+		if err := storage.Delete(ctx, namespace, "a/two.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, namespace)); err != nil {
+			t.Errorf("Expected namespace directory to be kept, got %v", err)
+		}
+	})
+
+	t.Run("Failed write leaves nothing behind", func(t *testing.T) {
+		ctx := context.Background()
+		namespace := "atomic-test"
+
+		err := storage.Put(ctx, namespace, "broken.txt", io.MultiReader(strings.NewReader("partial"), failingReader{}))
+		if err == nil {
+			t.Fatalf("Expected Put to fail")
+		}
+
+		if exists, _ := storage.Exists(ctx, namespace, "broken.txt"); exists {
+			t.Errorf("Expected no object after failed write")
+		}
+
+		listed, err := storage.List(ctx, namespace)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(listed) != 0 {
+			t.Errorf("Expected no leftover files, got %v", listed)
+		}
+
+		entries, err := os.ReadDir(filepath.Join(tempDir, namespace))
+		if err != nil {
+			t.Fatalf("ReadDir failed: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("Expected temporary file to be removed, found %d entries", len(entries))
+		}
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
 }
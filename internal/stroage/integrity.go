@@ -0,0 +1,141 @@
+package stroage
+
+import (
+	"context"
+	"encoding/hex"
+	stderrors "errors"
+	"hash"
+	"io"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// verifyingReader checks content against the checksum recorded when it was
+// written, a hex digest of hash. It hashes the content read from the start, whatever the caller
+// seeks in between, and checks it once the end was read. A mismatch is
+// returned as errors.ErrChecksumMismatch along with the last bytes, and
+// again by the next Read unless the caller seeks first. Objects the caller
+// does not read up to the end from the start, like ranged reads, are not
+// verified.
+type verifyingReader struct {
+	io.ReadSeekCloser
+	want string
+	size int64
+	hash hash.Hash
+
+	pos int64
+	// hashed is the length of the content hashed, from the start
+	hashed int64
+	done   bool
+	failed bool
+
+	// replaced reports whether the object was replaced while being read,
+	// so the recorded checksum belongs to newer content
+	replaced func() bool
+}
+
+func newVerifyingReader(r io.ReadSeekCloser, hash hash.Hash, want string, size int64, replaced func() bool) *verifyingReader {
+	return &verifyingReader{ReadSeekCloser: r, want: want, size: size, hash: hash, replaced: replaced}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.failed {
+		return 0, errors.ErrChecksumMismatch
+	}
+
+	start := r.pos
+	n, err := r.ReadSeekCloser.Read(p)
+	r.pos += int64(n)
+	if r.done {
+		return n, err
+	}
+
+	// Hash what extends the hashed content, read again or for the first time
+	if start <= r.hashed && r.pos > r.hashed {
+		r.hash.Write(p[r.hashed-start : n])
+		r.hashed = r.pos
+	}
+
+	if r.hashed == r.pos && (r.hashed >= r.size || err == io.EOF) {
+		r.done = true
+		if hex.EncodeToString(r.hash.Sum(nil)) != r.want && (r.replaced == nil || !r.replaced()) {
+			r.failed = true
+			return n, errors.ErrChecksumMismatch
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeekCloser.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	r.pos = pos
+	r.failed = false
+	return pos, nil
+}
+
+// ScrubStats summarizes a scrub.
+type ScrubStats struct {
+	Checked   int
+	Corrupted int
+
+	// Failed counts objects that could not be read for another reason
+	Failed int
+}
+
+// Scrub reads every object of st, which verifies the checksums recorded by
+// the backend, and calls report for each object failing the check or the
+// read. Objects without a recorded checksum cannot be checked and pass.
+func Scrub(ctx context.Context, st Storage, report func(namespace, objname string, err error)) (ScrubStats, error) {
+	var stats ScrubStats
+
+	namespaces, err := st.Namespaces(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, ns := range namespaces {
+		for name, err := range Objects(ctx, st, ns, "") {
+			if err != nil {
+				return stats, err
+			}
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+
+			stats.Checked++
+			err := scrubObject(ctx, st, ns, name)
+			if err == nil {
+				continue
+			}
+
+			if stderrors.Is(err, errors.ErrChecksumMismatch) {
+				stats.Corrupted++
+			} else {
+				stats.Failed++
+			}
+			if report != nil {
+				report(ns, name, err)
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+func scrubObject(ctx context.Context, st Storage, ns, name string) error {
+	reader, _, err := st.Get(ctx, ns, name)
+	if IsNotExist(err) {
+		// Deleted since it was listed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
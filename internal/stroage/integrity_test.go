@@ -0,0 +1,199 @@
+package stroage
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	serrors "github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+func TestFileStorageChecksums(t *testing.T) {
+	ctx := context.Background()
+
+	newStorage := func(t *testing.T) (*FileStorage, string) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir)
+		if err != nil {
+			t.Fatalf("NewFileStorage failed: %v", err)
+		}
+		return storage, dir
+	}
+
+	// corrupt flips a byte of an object without touching its checksum
+	corrupt := func(t *testing.T, path string) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		data[0] ^= 1
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	t.Run("Corruption is detected", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		corrupt(t, filepath.Join(dir, "ns", "obj"))
+
+		reader, _, err := storage.Get(ctx, "ns", "obj")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer reader.Close()
+
+		if _, err := io.ReadAll(reader); err != serrors.ErrChecksumMismatch {
+			t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+		}
+
+		// Ranged reads cannot be verified
+		if _, err := reader.Seek(2, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		if _, err := io.ReadAll(reader); err != nil {
+			t.Errorf("Expected the ranged read to pass, got %v", err)
+		}
+	})
+
+	t.Run("Rewinding verifies again", func(t *testing.T) {
+		storage, _ := newStorage(t)
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		reader, info, err := storage.Get(ctx, "ns", "obj")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer reader.Close()
+
+		if _, err := reader.Seek(3, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		if _, err := reader.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil || string(data) != "content" {
+			t.Errorf("Expected verified content, got %q, %v", data, err)
+		}
+
+		stat, err := storage.Stat(ctx, "ns", "obj")
+		if err != nil || stat.Checksum != info.Checksum {
+			t.Errorf("Expected Stat to report the recorded checksum %q, got %q, %v", info.Checksum, stat.Checksum, err)
+		}
+	})
+
+	t.Run("Checksums follow objects", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := storage.Move(ctx, "ns", "obj", "ns", "moved"); err != nil {
+			t.Fatalf("Move failed: %v", err)
+		}
+		if err := storage.Copy(ctx, "ns", "moved", "other", "copied"); err != nil {
+			t.Fatalf("Copy failed: %v", err)
+		}
+		if _, err := storage.PutIf(ctx, "ns", "moved", strings.NewReader("replaced"), PutCondition{}); err != nil {
+			t.Fatalf("PutIf failed: %v", err)
+		}
+
+		assertContent(t, storage, "ns", "moved", "replaced")
+		assertContent(t, storage, "other", "copied", "content")
+
+		if err := storage.Delete(ctx, "other", "copied"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := os.Stat(checksumPath(filepath.Join(dir, "other", "copied"))); !os.IsNotExist(err) {
+			t.Errorf("Expected the checksum to be removed with the object, got %v", err)
+		}
+
+		listed, err := storage.List(ctx, "ns")
+		if err != nil || len(listed) != 1 || listed[0] != "moved" {
+			t.Errorf("Expected only the object to be listed, got %v, %v", listed, err)
+		}
+	})
+
+	t.Run("Objects without a checksum are readable", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		if err := os.MkdirAll(filepath.Join(dir, "ns"), 0o750); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "ns", "legacy"), []byte("old content"), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+
+		assertContent(t, storage, "ns", "legacy", "old content")
+	})
+
+	t.Run("Wrapped storages verify objects", func(t *testing.T) {
+		backend, dir := newStorage(t)
+		storage := NewCompressedStorage(NewEncryptedStorage(backend, testKeyring(t, "k1")), CodecGzip)
+
+		// Incompressible content spans several encrypted chunks
+		large := make([]byte, 3*encChunkSize)
+		rand.NewChaCha8([32]byte{}).Read(large)
+		objects := map[string]string{"small": "content", "large": string(large)}
+
+		for name, content := range objects {
+			if err := storage.Put(ctx, "ns", name, strings.NewReader(content)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			assertContent(t, storage, "ns", name, content)
+
+			// The stored content is intact for the wrappers, only the checksum fails
+			if err := backend.writeChecksum(filepath.Join(dir, "ns", name), strings.Repeat("0", 64)); err != nil {
+				t.Fatalf("writeChecksum failed: %v", err)
+			}
+
+			reader, _, err := storage.Get(ctx, "ns", name)
+			if err == nil {
+				_, err = io.ReadAll(reader)
+				reader.Close()
+			}
+			if err != serrors.ErrChecksumMismatch {
+				t.Errorf("Expected ErrChecksumMismatch for %s, got %v", name, err)
+			}
+		}
+
+		stats, err := Scrub(ctx, storage, nil)
+		if err != nil {
+			t.Fatalf("Scrub failed: %v", err)
+		}
+		if stats.Checked != 2 || stats.Corrupted != 2 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Scrub reports corrupted objects", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		for _, name := range []string{"a", "b/c", "d"} {
+			if err := storage.Put(ctx, "ns", name, strings.NewReader("content of "+name)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		corrupt(t, filepath.Join(dir, "ns", "b", "c"))
+
+		var reported []string
+		stats, err := Scrub(ctx, storage, func(ns, name string, err error) {
+			reported = append(reported, ns+"/"+name)
+		})
+		if err != nil {
+			t.Fatalf("Scrub failed: %v", err)
+		}
+		if stats.Checked != 3 || stats.Corrupted != 1 || stats.Failed != 0 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+		if len(reported) != 1 || reported[0] != "ns/b/c" {
+			t.Errorf("Expected ns/b/c to be reported, got %v", reported)
+		}
+	})
+}
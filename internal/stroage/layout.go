@@ -0,0 +1,253 @@
+package stroage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/GoldenDeals/DepGit/internal/share/logger"
+)
+
+var layoutLog = logger.New("stroage_layout")
+
+// shardPrefix marks a fan-out directory, which holds the objects of its
+// parent directory whose names start with the rest of its name
+const shardPrefix = ".fan-"
+
+// layoutFile records the fan-out of the data below a FileStorage base path.
+// Without it the data is flat.
+const layoutFile = ".layout"
+
+// maxFanout bounds FileOptions.Fanout
+const maxFanout = 8
+
+// objectPath returns where an object is stored. With a fan-out of n, an
+// object a/bcdef lives at a/.fan-bc/bcdef for n = 2, so no directory holds
+// more than the objects sharing a name prefix. Names shorter than the
+// fan-out stay in their directory. Shards group names by prefix, so walking
+// them in order still yields names in lexical order.
+func (s *FileStorage) objectPath(namespace, objname string) string {
+	dir, base := path.Split(objname)
+	if s.fanout == 0 || len(base) < s.fanout {
+		return filepath.Join(s.basePath, namespace, objname)
+	}
+	return filepath.Join(s.basePath, namespace, dir, shardPrefix+base[:s.fanout], base)
+}
+
+// objectName returns the name of the object at a path relative to its
+// namespace directory, whichever layout it was written with
+func objectName(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	name := parts[:0]
+	for _, part := range parts {
+		if !strings.HasPrefix(part, shardPrefix) {
+			name = append(name, part)
+		}
+	}
+	return strings.Join(name, "/")
+}
+
+// checkObjectName rejects names whose components would be taken for
+// fan-out directories or bookkeeping files, so objects are listed under
+// the name they were written with
+func checkObjectName(objname string) error {
+	for _, part := range strings.Split(objname, "/") {
+		if strings.HasPrefix(part, shardPrefix) || hiddenFile(part) {
+			return errors.ErrBadData.Msg("object name " + objname + " has a reserved component")
+		}
+	}
+	return nil
+}
+
+// readLayout returns the fan-out recorded below the base path
+func (s *FileStorage) readLayout() (int, error) {
+	data, err := os.ReadFile(filepath.Join(s.basePath, layoutFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var fanout int
+	if _, err := fmt.Sscanf(string(data), "fanout %d", &fanout); err != nil || fanout < 0 || fanout > maxFanout {
+		return 0, errors.ErrBadData.Msg("malformed storage layout file")
+	}
+	return fanout, nil
+}
+
+// writeLayout records the fan-out of s atomically
+func (s *FileStorage) writeLayout() error {
+	file, err := os.CreateTemp(s.basePath, tempPrefix+"layout-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteString("fanout " + strconv.Itoa(s.fanout) + "\n")
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(s.basePath, layoutFile))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// relayout moves all objects written with another fan-out to their place
+// in the layout of s. Moves are renames, so objects are never copied and a
+// crashed run can simply be repeated. It must run before s is used.
+func (s *FileStorage) relayout() error {
+	current, err := s.readLayout()
+	if err != nil {
+		return err
+	}
+	if current == s.fanout {
+		return nil
+	}
+
+	namespaces, err := os.ReadDir(s.basePath)
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, ns := range namespaces {
+		if !ns.IsDir() {
+			continue
+		}
+
+		n, err := s.relayoutNamespace(ns.Name())
+		if err != nil {
+			return err
+		}
+		moved += n
+	}
+
+	layoutLog.WithField("path", s.basePath).
+		WithField("from", current).
+		WithField("to", s.fanout).
+		WithField("moved", moved).
+		Info("Storage layout migrated")
+
+	return s.writeLayout()
+}
+
+func (s *FileStorage) relayoutNamespace(namespace string) (int, error) {
+	root := filepath.Join(s.basePath, namespace)
+
+	// Collect first, moving while walking would revisit objects
+	type move struct{ from, to string }
+	var moves []move
+	var shards []string
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), uploadPrefix) {
+				return filepath.SkipDir
+			}
+			if strings.HasPrefix(d.Name(), shardPrefix) {
+				shards = append(shards, p)
+			}
+			return nil
+		}
+		if hiddenFile(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if to := s.objectPath(namespace, objectName(rel)); to != p {
+			moves = append(moves, move{from: p, to: to})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range moves {
+		if _, err := os.Stat(m.to); err == nil {
+			// A crashed run or a write during the migration left both
+			// copies. Get serves the migrated one, so the old one goes
+			// once the migrated one matches its checksum, and otherwise
+			// replaces it.
+			intact, err := intactFile(m.to)
+			if err != nil {
+				return 0, err
+			}
+			if intact {
+				layoutLog.WithField("object", m.from).Warn("Object exists in both layouts, removing the old copy")
+				if err := os.Remove(checksumPath(m.from)); err != nil && !os.IsNotExist(err) {
+					return 0, err
+				}
+				if err := os.Remove(m.from); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			layoutLog.WithField("object", m.to).Warn("Migrated copy does not match its checksum, replacing it")
+		}
+
+		if err := os.MkdirAll(filepath.Dir(m.to), 0o750); err != nil {
+			return 0, err
+		}
+		// The old copy may have no checksum to replace the one of a
+		// corrupted migrated copy
+		if err := os.Remove(checksumPath(m.to)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		if err := os.Rename(m.from, m.to); err != nil {
+			return 0, err
+		}
+		if err := os.Rename(checksumPath(m.from), checksumPath(m.to)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+
+	// Deepest first, so nested shards are gone before their parents
+	for i := len(shards) - 1; i >= 0; i-- {
+		s.pruneDirs(shards[i], root)
+	}
+
+	return len(moves), nil
+}
+
+// intactFile reports whether the object at filePath matches its recorded
+// checksum. Objects without a checksum cannot be checked and are intact.
+func intactFile(filePath string) (bool, error) {
+	want, err := readChecksum(filePath)
+	if err != nil || want == "" {
+		return err == nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == want, nil
+}
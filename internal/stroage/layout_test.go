@@ -0,0 +1,238 @@
+package stroage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFileStorageLayout(t *testing.T) {
+	ctx := context.Background()
+	names := []string{"a", "ab", "ab-c", "abc/d", "abcdef", "b", "objects/12/3456", "objects/12/78", "objects/pack/x.pack"}
+
+	put := func(t *testing.T, storage *FileStorage) {
+		for _, name := range names {
+			if err := storage.Put(ctx, "ns", name, strings.NewReader("content of "+name)); err != nil {
+				t.Fatalf("Put %s failed: %v", name, err)
+			}
+		}
+	}
+
+	// assertObjects checks that all objects are readable and listed in order
+	assertObjects := func(t *testing.T, storage *FileStorage) {
+		for _, name := range names {
+			assertContent(t, storage, "ns", name, "content of "+name)
+		}
+
+		var listed []string
+		for name, err := range Objects(ctx, storage, "ns", "") {
+			if err != nil {
+				t.Fatalf("Listing failed: %v", err)
+			}
+			listed = append(listed, name)
+		}
+		if !slices.Equal(listed, names) {
+			t.Errorf("Expected %v, got %v", names, listed)
+		}
+
+		all, err := storage.List(ctx, "ns")
+		slices.Sort(all)
+		if err != nil || !slices.Equal(all, names) {
+			t.Errorf("Expected List to return %v, got %v, %v", names, all, err)
+		}
+	}
+
+	t.Run("Objects are fanned out", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := NewFileStorageWithOptions(dir, FileOptions{Fanout: 2})
+		if err != nil {
+			t.Fatalf("NewFileStorageWithOptions failed: %v", err)
+		}
+		put(t, storage)
+
+		for _, p := range []string{".fan-ab/abcdef", "a", "abc/d", "objects/12/.fan-34/3456"} {
+			if _, err := os.Stat(filepath.Join(dir, "ns", p)); err != nil {
+				t.Errorf("Expected %s on disk: %v", p, err)
+			}
+		}
+		assertObjects(t, storage)
+
+		page, err := storage.ListPage(ctx, "ns", ListOptions{PageSize: 3, Cursor: "ab", Delimiter: "/"})
+		if err != nil {
+			t.Fatalf("ListPage failed: %v", err)
+		}
+		if !slices.Equal(page.Objects, []string{"ab-c", "abcdef"}) || !slices.Equal(page.Prefixes, []string{"abc/"}) {
+			t.Errorf("Unexpected page %+v", page)
+		}
+
+		if err := storage.Delete(ctx, "ns", "abcdef"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if err := storage.Delete(ctx, "ns", "ab-c"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if err := storage.Delete(ctx, "ns", "ab"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "ns", ".fan-ab")); !os.IsNotExist(err) {
+			t.Errorf("Expected the empty shard to be removed, got %v", err)
+		}
+	})
+
+	t.Run("Existing data is migrated", func(t *testing.T) {
+		dir := t.TempDir()
+		flat, err := NewFileStorage(dir)
+		if err != nil {
+			t.Fatalf("NewFileStorage failed: %v", err)
+		}
+		put(t, flat)
+
+		sharded, err := NewFileStorageWithOptions(dir, FileOptions{Fanout: 2})
+		if err != nil {
+			t.Fatalf("NewFileStorageWithOptions failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "ns", ".fan-ab", "abcdef")); err != nil {
+			t.Errorf("Expected the object to be moved: %v", err)
+		}
+		if _, err := os.Stat(checksumPath(filepath.Join(dir, "ns", ".fan-ab", "abcdef"))); err != nil {
+			t.Errorf("Expected the checksum to be moved: %v", err)
+		}
+		assertObjects(t, sharded)
+
+		wider, err := NewFileStorageWithOptions(dir, FileOptions{Fanout: 3})
+		if err != nil {
+			t.Fatalf("NewFileStorageWithOptions failed: %v", err)
+		}
+		assertObjects(t, wider)
+
+		flat, err = NewFileStorage(dir)
+		if err != nil {
+			t.Fatalf("NewFileStorage failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "ns", "abcdef")); err != nil {
+			t.Errorf("Expected the object to be moved back: %v", err)
+		}
+		if matches, _ := filepath.Glob(filepath.Join(dir, "ns", shardPrefix+"*")); len(matches) != 0 {
+			t.Errorf("Expected no shards left, got %v", matches)
+		}
+		assertObjects(t, flat)
+	})
+
+	t.Run("Copies in both layouts are merged", func(t *testing.T) {
+		dir := t.TempDir()
+		flat, err := NewFileStorage(dir)
+		if err != nil {
+			t.Fatalf("NewFileStorage failed: %v", err)
+		}
+		for _, name := range []string{"abcdef", "abcxyz"} {
+			if err := flat.Put(ctx, "ns", name, strings.NewReader("content of "+name)); err != nil {
+				t.Fatalf("Put %s failed: %v", name, err)
+			}
+		}
+
+		// Leave migrated copies behind as an interrupted run would, one
+		// of them corrupted
+		shard := filepath.Join(dir, "ns", ".fan-ab")
+		if err := os.MkdirAll(shard, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range map[string]string{"abcdef": "content of abcdef", "abcxyz": "corrupted"} {
+			checksum, err := readChecksum(filepath.Join(dir, "ns", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(shard, name), []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(checksumPath(filepath.Join(shard, name)), []byte(checksum+"\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sharded, err := NewFileStorageWithOptions(dir, FileOptions{Fanout: 2})
+		if err != nil {
+			t.Fatalf("NewFileStorageWithOptions failed: %v", err)
+		}
+		for _, name := range []string{"abcdef", "abcxyz"} {
+			if _, err := os.Stat(filepath.Join(dir, "ns", name)); !os.IsNotExist(err) {
+				t.Errorf("Expected the old copy of %s to be gone, got %v", name, err)
+			}
+			assertContent(t, sharded, "ns", name, "content of "+name)
+		}
+
+		listed, err := sharded.List(ctx, "ns")
+		slices.Sort(listed)
+		if err != nil || !slices.Equal(listed, []string{"abcdef", "abcxyz"}) {
+			t.Errorf("Expected each object listed once, got %v, %v", listed, err)
+		}
+	})
+
+	t.Run("Checksums of replaced copies are dropped", func(t *testing.T) {
+		dir := t.TempDir()
+		flat, err := NewFileStorage(dir)
+		if err != nil {
+			t.Fatalf("NewFileStorage failed: %v", err)
+		}
+		for name, content := range map[string]string{"abcdef": "content of abcdef", "other": "other content"} {
+			if err := flat.Put(ctx, "ns", name, strings.NewReader(content)); err != nil {
+				t.Fatalf("Put %s failed: %v", name, err)
+			}
+		}
+		checksum, err := readChecksum(filepath.Join(dir, "ns", "other"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The old copy was stored before checksums were, the migrated one
+		// is corrupted
+		if err := os.Remove(checksumPath(filepath.Join(dir, "ns", "abcdef"))); err != nil {
+			t.Fatal(err)
+		}
+		migrated := filepath.Join(dir, "ns", ".fan-ab", "abcdef")
+		if err := os.MkdirAll(filepath.Dir(migrated), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(migrated, []byte("corrupted"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(checksumPath(migrated), []byte(checksum+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		sharded, err := NewFileStorageWithOptions(dir, FileOptions{Fanout: 2})
+		if err != nil {
+			t.Fatalf("NewFileStorageWithOptions failed: %v", err)
+		}
+		if _, err := os.Stat(checksumPath(migrated)); !os.IsNotExist(err) {
+			t.Errorf("Expected the checksum of the corrupted copy to be gone, got %v", err)
+		}
+		assertContent(t, sharded, "ns", "abcdef", "content of abcdef")
+	})
+
+	t.Run("Reserved names are rejected", func(t *testing.T) {
+		storage, err := NewFileStorageWithOptions(t.TempDir(), FileOptions{Fanout: 2})
+		if err != nil {
+			t.Fatalf("NewFileStorageWithOptions failed: %v", err)
+		}
+		for _, name := range []string{".fan-ab/abcdef", "a/.fan-x/b", ".sha256-a", "a/.tmp-b"} {
+			if err := storage.Put(ctx, "ns", name, strings.NewReader("x")); err == nil {
+				t.Errorf("Expected Put %s to fail", name)
+			}
+			if _, err := storage.CreateUpload(ctx, "ns", name, ""); err == nil {
+				t.Errorf("Expected CreateUpload %s to fail", name)
+			}
+		}
+		if err := storage.Put(ctx, "ns", "a.fan-b/.fanout", strings.NewReader("x")); err != nil {
+			t.Errorf("Put failed: %v", err)
+		}
+	})
+
+	t.Run("Invalid fanout", func(t *testing.T) {
+		if _, err := NewFileStorageWithOptions(t.TempDir(), FileOptions{Fanout: maxFanout + 1}); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package stroage
+
+import (
+	"context"
+	"iter"
+	"strconv"
+	"strings"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// DefaultPageSize is used when ListOptions.PageSize is not set.
+const DefaultPageSize = 1000
+
+// ListOptions controls a paginated listing.
+type ListOptions struct {
+	// Prefix restricts the listing to names starting with it.
+	Prefix string
+
+	// Delimiter is either empty, listing names recursively, or "/", grouping
+	// names below the next "/" after Prefix into ListResult.Prefixes, like
+	// directories.
+	Delimiter string
+
+	// PageSize caps the number of entries (objects and prefixes) per page.
+	PageSize int
+
+	// Cursor continues a listing from a previous ListResult.NextCursor.
+	Cursor string
+}
+
+// ListResult is one page of a listing. Entries are in lexical order.
+type ListResult struct {
+	Objects  []string
+	Prefixes []string
+
+	// NextCursor is empty on the last page. It is opaque to callers.
+	NextCursor string
+}
+
+// pageBuilder assembles a page from names fed in lexical order. Backends
+// share it so cursor and delimiter handling stay identical.
+type pageBuilder struct {
+	opts       ListOptions
+	res        ListResult
+	count      int
+	last       string
+	lastPrefix string
+}
+
+func newPageBuilder(opts ListOptions) (*pageBuilder, error) {
+	if opts.Delimiter != "" && opts.Delimiter != "/" {
+		return nil, errors.ErrBadData
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+
+	return &pageBuilder{opts: opts}, nil
+}
+
+// skip reports whether name was already returned by a previous page
+func (b *pageBuilder) skip(name string) bool {
+	cursor := b.opts.Cursor
+	if cursor == "" {
+		return false
+	}
+	if name <= cursor {
+		return true
+	}
+
+	// A cursor ending in the delimiter was a prefix, which covers its contents
+	return b.opts.Delimiter != "" && strings.HasSuffix(cursor, b.opts.Delimiter) && strings.HasPrefix(name, cursor)
+}
+
+// wants reports whether a directory-like prefix dir (ending in "/") can
+// contain names this page still needs, so walks can prune subtrees
+func (b *pageBuilder) wants(dir string) bool {
+	// Either side may be the longer one: "a/" is needed for prefix "a/b"
+	if !strings.HasPrefix(dir, b.opts.Prefix) && !strings.HasPrefix(b.opts.Prefix, dir) {
+		return false
+	}
+
+	// Everything below dir sorts before a cursor past dir
+	cursor := b.opts.Cursor
+	return cursor == "" || cursor < dir || strings.HasPrefix(cursor, dir)
+}
+
+// commonPrefix returns the prefix name is grouped under, if any
+func (b *pageBuilder) commonPrefix(name string) (string, bool) {
+	if b.opts.Delimiter == "" || !strings.HasPrefix(name, b.opts.Prefix) {
+		return "", false
+	}
+
+	rest := name[len(b.opts.Prefix):]
+	i := strings.Index(rest, b.opts.Delimiter)
+	if i < 0 {
+		return "", false
+	}
+	return b.opts.Prefix + rest[:i+len(b.opts.Delimiter)], true
+}
+
+// add records name and reports false once the page is full
+func (b *pageBuilder) add(name string) bool {
+	if !strings.HasPrefix(name, b.opts.Prefix) || b.skip(name) {
+		return true
+	}
+
+	prefix, grouped := b.commonPrefix(name)
+	if grouped && prefix == b.lastPrefix {
+		return true
+	}
+
+	if b.count == b.opts.PageSize {
+		b.res.NextCursor = b.last
+		return false
+	}
+	b.count++
+
+	if grouped {
+		b.lastPrefix = prefix
+		b.last = prefix
+		b.res.Prefixes = append(b.res.Prefixes, prefix)
+	} else {
+		b.last = name
+		b.res.Objects = append(b.res.Objects, name)
+	}
+	return true
+}
+
+func (b *pageBuilder) result() ListResult {
+	return b.res
+}
+
+// Objects iterates over all names in a namespace starting with prefix,
+// fetching them page by page.
+func Objects(ctx context.Context, st Storage, namespace, prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		opts := ListOptions{Prefix: prefix}
+		for {
+			page, err := st.ListPage(ctx, namespace, opts)
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			for _, name := range page.Objects {
+				if !yield(name, nil) {
+					return
+				}
+			}
+
+			if page.NextCursor == "" {
+				return
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+}
+
+// CheckNamespace rejects namespaces that could not be removed on their own:
+// empty ones, those spanning several path components, and hidden ones,
+// which are kept for bookkeeping such as locks and staged uploads.
+func CheckNamespace(namespace string) error {
+	if namespace == "" || strings.HasPrefix(namespace, ".") || strings.ContainsAny(namespace, "/\\") {
+		return errors.ErrBadData.Msg("invalid namespace " + strconv.Quote(namespace))
+	}
+	return nil
+}
+
+// DeleteNamespace deletes every object in a namespace, which must pass
+// CheckNamespace. Objects that vanish meanwhile are not an error.
+func DeleteNamespace(ctx context.Context, st Storage, namespace string) error {
+	if err := CheckNamespace(namespace); err != nil {
+		return err
+	}
+	for name, err := range Objects(ctx, st, namespace, "") {
+		if err != nil {
+			return err
+		}
+		if err := st.Delete(ctx, namespace, name); err != nil && !IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
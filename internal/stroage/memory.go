@@ -0,0 +1,290 @@
+package stroage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// memoryObject is an immutable stored object. Writes replace it whole.
+type memoryObject struct {
+	data     []byte
+	modTime  time.Time
+	checksum string
+}
+
+func (o *memoryObject) info() ObjectInfo {
+	return ObjectInfo{
+		Size:     int64(len(o.data)),
+		ModTime:  o.modTime,
+		Checksum: o.checksum,
+	}
+}
+
+// memoryReader serves an object from memory
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+// MemoryStorage implements the Storage interface in memory. It is safe for
+// concurrent use and meant for tests and ephemeral instances; nothing
+// survives the process.
+type MemoryStorage struct {
+	mu         sync.RWMutex
+	namespaces map[string]map[string]*memoryObject
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		namespaces: make(map[string]map[string]*memoryObject),
+	}
+}
+
+func memoryNotExist(op, namespace, objname string) error {
+	return &os.PathError{Op: op, Path: namespace + "/" + objname, Err: os.ErrNotExist}
+}
+
+func newMemoryObject(obj io.Reader) (*memoryObject, error) {
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	return &memoryObject{
+		data:     data,
+		modTime:  time.Now(),
+		checksum: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// lookup returns an object, the caller must hold the lock
+func (s *MemoryStorage) lookup(namespace, objname string) (*memoryObject, bool) {
+	obj, ok := s.namespaces[namespace][objname]
+	return obj, ok
+}
+
+// store saves an object, the caller must hold the write lock
+func (s *MemoryStorage) store(namespace, objname string, obj *memoryObject) {
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		ns = make(map[string]*memoryObject)
+		s.namespaces[namespace] = ns
+	}
+	ns[objname] = obj
+}
+
+// remove deletes an object, the caller must hold the write lock
+func (s *MemoryStorage) remove(namespace, objname string) {
+	delete(s.namespaces[namespace], objname)
+	if len(s.namespaces[namespace]) == 0 {
+		delete(s.namespaces, namespace)
+	}
+}
+
+// Put stores an object in memory
+func (s *MemoryStorage) Put(_ context.Context, namespace, objname string, obj io.Reader) error {
+	object, err := newMemoryObject(obj)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(namespace, objname); ok {
+		return os.ErrExist
+	}
+
+	s.store(namespace, objname, object)
+	return nil
+}
+
+// PutIf stores an object in memory if cond holds
+func (s *MemoryStorage) PutIf(_ context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	object, err := newMemoryObject(obj)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.lookup(namespace, objname)
+	if cond.IfAbsent && ok {
+		return ObjectInfo{}, errors.ErrPreconditionFailed
+	}
+	if cond.IfMatch != "" && (!ok || current.checksum != cond.IfMatch) {
+		return ObjectInfo{}, errors.ErrPreconditionFailed
+	}
+
+	s.store(namespace, objname, object)
+	return object.info(), nil
+}
+
+// Get retrieves an object from memory
+func (s *MemoryStorage) Get(_ context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.lookup(namespace, objname)
+	if !ok {
+		return nil, ObjectInfo{}, memoryNotExist("get", namespace, objname)
+	}
+
+	return memoryReader{bytes.NewReader(obj.data)}, obj.info(), nil
+}
+
+// GetRange reads part of an object from memory
+func (s *MemoryStorage) GetRange(_ context.Context, namespace, objname string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.lookup(namespace, objname)
+	if !ok {
+		return nil, memoryNotExist("get", namespace, objname)
+	}
+
+	length, err := checkRange(int64(len(obj.data)), offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	return memoryReader{bytes.NewReader(obj.data[offset : offset+length])}, nil
+}
+
+// List returns all objects in a namespace in lexical order
+func (s *MemoryStorage) List(_ context.Context, namespace string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects := make([]string, 0, len(s.namespaces[namespace]))
+	for name := range s.namespaces[namespace] {
+		objects = append(objects, name)
+	}
+	sort.Strings(objects)
+
+	return objects, nil
+}
+
+// Namespaces returns the namespaces in memory in lexical order
+func (s *MemoryStorage) Namespaces(_ context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	namespaces := make([]string, 0, len(s.namespaces))
+	for ns := range s.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces, nil
+}
+
+// ListPage returns one page of a listing from memory
+func (s *MemoryStorage) ListPage(ctx context.Context, namespace string, opts ListOptions) (ListResult, error) {
+	b, err := newPageBuilder(opts)
+	if err != nil {
+		return ListResult{}, err
+	}
+
+	names, err := s.List(ctx, namespace)
+	if err != nil {
+		return ListResult{}, err
+	}
+
+	for _, name := range names {
+		if !b.add(name) {
+			break
+		}
+	}
+	return b.result(), nil
+}
+
+// Delete removes an object from memory
+func (s *MemoryStorage) Delete(_ context.Context, namespace, objname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(namespace, objname); !ok {
+		return memoryNotExist("delete", namespace, objname)
+	}
+
+	s.remove(namespace, objname)
+	return nil
+}
+
+// Stat returns the metadata of an object in memory
+func (s *MemoryStorage) Stat(_ context.Context, namespace, objname string) (ObjectInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.lookup(namespace, objname)
+	if !ok {
+		return ObjectInfo{}, memoryNotExist("stat", namespace, objname)
+	}
+
+	return obj.info(), nil
+}
+
+// Exists reports whether an object is present in memory
+func (s *MemoryStorage) Exists(_ context.Context, namespace, objname string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.lookup(namespace, objname)
+	return ok, nil
+}
+
+// Copy duplicates an object, failing with os.ErrExist if the target exists
+func (s *MemoryStorage) Copy(_ context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.lookup(srcNamespace, srcName)
+	if !ok {
+		return memoryNotExist("copy", srcNamespace, srcName)
+	}
+	if _, ok := s.lookup(dstNamespace, dstName); ok {
+		return os.ErrExist
+	}
+
+	// Objects are immutable, so the copy can share the content
+	s.store(dstNamespace, dstName, &memoryObject{
+		data:     obj.data,
+		modTime:  time.Now(),
+		checksum: obj.checksum,
+	})
+	return nil
+}
+
+// Move renames an object, failing with os.ErrExist if the target exists
+func (s *MemoryStorage) Move(_ context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.lookup(srcNamespace, srcName)
+	if !ok {
+		return memoryNotExist("move", srcNamespace, srcName)
+	}
+	if _, ok := s.lookup(dstNamespace, dstName); ok {
+		return os.ErrExist
+	}
+
+	s.remove(srcNamespace, srcName)
+	s.store(dstNamespace, dstName, obj)
+	return nil
+}
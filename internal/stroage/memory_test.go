@@ -0,0 +1,63 @@
+package stroage
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+
+	t.Run("Copies survive source overwrite", func(t *testing.T) {
+		if err := storage.Put(ctx, "ns", "src.txt", strings.NewReader("original")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := storage.Copy(ctx, "ns", "src.txt", "ns", "dst.txt"); err != nil {
+			t.Fatalf("Copy failed: %v", err)
+		}
+		if _, err := storage.PutIf(ctx, "ns", "src.txt", strings.NewReader("changed"), PutCondition{}); err != nil {
+			t.Fatalf("PutIf failed: %v", err)
+		}
+
+		assertContent(t, storage, "ns", "src.txt", "changed")
+		assertContent(t, storage, "ns", "dst.txt", "original")
+	})
+
+	t.Run("Open readers are not affected by writes", func(t *testing.T) {
+		reader, _, err := storage.Get(ctx, "ns", "dst.txt")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer reader.Close()
+
+		if err := storage.Delete(ctx, "ns", "dst.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		buf := make([]byte, len("original"))
+		if _, err := reader.Read(buf); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(buf) != "original" {
+			t.Errorf("Expected %q, got %q", "original", string(buf))
+		}
+	})
+
+	t.Run("List is sorted", func(t *testing.T) {
+		for _, name := range []string{"c", "a/b", "b"} {
+			if err := storage.Put(ctx, "sorted", name, strings.NewReader(name)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+
+		listed, err := storage.List(ctx, "sorted")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if strings.Join(listed, ",") != "a/b,b,c" {
+			t.Errorf("Expected sorted listing, got %v", listed)
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package stroage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// MigrateAction is what a migration did, or would do, with one object.
+type MigrateAction int
+
+const (
+	// MigrateCopied means the object was missing in the destination
+	MigrateCopied MigrateAction = iota
+	// MigrateReplaced means the destination held different content
+	MigrateReplaced
+	// MigrateSkipped means the destination already held the same content
+	MigrateSkipped
+)
+
+func (a MigrateAction) String() string {
+	switch a {
+	case MigrateCopied:
+		return "copied"
+	case MigrateReplaced:
+		return "replaced"
+	default:
+		return "skipped"
+	}
+}
+
+// MigrationStats summarizes a migration run.
+type MigrationStats struct {
+	Copied   int
+	Replaced int
+	Skipped  int
+
+	// Bytes counts the content copied, or to be copied in a dry run
+	Bytes int64
+}
+
+// Migrator copies objects from one Storage to another. Every copy is
+// verified by reading it back and comparing SHA-256 digests, as backends
+// do not share a checksum format. Objects already present with the same
+// content are skipped, so a migration can be repeated to catch up with
+// writes made meanwhile.
+type Migrator struct {
+	Src Storage
+	Dst Storage
+
+	// Namespaces restricts the migration, nil migrates all of Src.
+	Namespaces []string
+
+	// DryRun compares the backends without writing anything.
+	DryRun bool
+
+	// CheckpointPath records progress after every page, so an interrupted
+	// run resumes where it stopped. It is removed once the run completes.
+	CheckpointPath string
+
+	// Report is called for every object, if set.
+	Report func(namespace, objname string, action MigrateAction)
+}
+
+// migrationCheckpoint is the progress stored at Migrator.CheckpointPath
+type migrationCheckpoint struct {
+	Namespace string `json:"namespace"`
+	Cursor    string `json:"cursor"`
+	Done      bool   `json:"done"`
+}
+
+// Run migrates all objects and returns what was done.
+func (m *Migrator) Run(ctx context.Context) (MigrationStats, error) {
+	var stats MigrationStats
+
+	namespaces := m.Namespaces
+	if namespaces == nil {
+		var err error
+		if namespaces, err = m.Src.Namespaces(ctx); err != nil {
+			return stats, err
+		}
+	}
+	namespaces = append([]string(nil), namespaces...)
+	sort.Strings(namespaces)
+
+	cp, err := m.loadCheckpoint()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, ns := range namespaces {
+		if ns < cp.Namespace || (ns == cp.Namespace && cp.Done) {
+			continue
+		}
+
+		opts := ListOptions{}
+		if ns == cp.Namespace {
+			opts.Cursor = cp.Cursor
+		}
+
+		if err := m.migrateNamespace(ctx, ns, opts, &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	if m.CheckpointPath != "" && !m.DryRun {
+		if err := os.Remove(m.CheckpointPath); err != nil && !os.IsNotExist(err) {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func (m *Migrator) migrateNamespace(ctx context.Context, ns string, opts ListOptions, stats *MigrationStats) error {
+	for {
+		page, err := m.Src.ListPage(ctx, ns, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range page.Objects {
+			if err := m.migrateObject(ctx, ns, name, stats); err != nil {
+				return err
+			}
+		}
+
+		cp := migrationCheckpoint{Namespace: ns, Cursor: page.NextCursor, Done: page.NextCursor == ""}
+		if err := m.saveCheckpoint(cp); err != nil {
+			return err
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func (m *Migrator) migrateObject(ctx context.Context, ns, name string, stats *MigrationStats) error {
+	action, size, err := m.compare(ctx, ns, name)
+	if err != nil {
+		return err
+	}
+
+	if action != MigrateSkipped && !m.DryRun {
+		if size, err = m.copy(ctx, ns, name); err != nil {
+			return err
+		}
+	}
+
+	switch action {
+	case MigrateCopied:
+		stats.Copied++
+		stats.Bytes += size
+	case MigrateReplaced:
+		stats.Replaced++
+		stats.Bytes += size
+	default:
+		stats.Skipped++
+	}
+
+	if m.Report != nil {
+		m.Report(ns, name, action)
+	}
+	return nil
+}
+
+// compare decides what to do with an object, returning its source size
+func (m *Migrator) compare(ctx context.Context, ns, name string) (MigrateAction, int64, error) {
+	src, err := m.Src.Stat(ctx, ns, name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dst, err := m.Dst.Stat(ctx, ns, name)
+	if IsNotExist(err) {
+		return MigrateCopied, src.Size, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if dst.Size != src.Size {
+		return MigrateReplaced, src.Size, nil
+	}
+
+	srcSum, err := digest(ctx, m.Src, ns, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	dstSum, err := digest(ctx, m.Dst, ns, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	if srcSum != dstSum {
+		return MigrateReplaced, src.Size, nil
+	}
+	return MigrateSkipped, src.Size, nil
+}
+
+// copy streams an object to the destination and verifies the result
+func (m *Migrator) copy(ctx context.Context, ns, name string) (int64, error) {
+	reader, _, err := m.Src.Get(ctx, ns, name)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	counter := &countingReader{Reader: io.TeeReader(reader, h)}
+	if _, err := m.Dst.PutIf(ctx, ns, name, counter, PutCondition{}); err != nil {
+		return 0, err
+	}
+
+	written, err := digest(ctx, m.Dst, ns, name)
+	if err != nil {
+		return 0, err
+	}
+	if written != hex.EncodeToString(h.Sum(nil)) {
+		return 0, errors.ErrChecksumMismatch.Src(ns + "/" + name)
+	}
+	return counter.n, nil
+}
+
+// digest returns the hex SHA-256 of an object's content
+func digest(ctx context.Context, st Storage, ns, name string) (string, error) {
+	reader, _, err := st.Get(ctx, ns, name)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (m *Migrator) loadCheckpoint() (migrationCheckpoint, error) {
+	var cp migrationCheckpoint
+	if m.CheckpointPath == "" {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(m.CheckpointPath)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, errors.ErrBadData.Msg("malformed migration checkpoint").Err(err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint replaces the checkpoint file atomically
+func (m *Migrator) saveCheckpoint(cp migrationCheckpoint) error {
+	if m.CheckpointPath == "" || m.DryRun {
+		return nil
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.CheckpointPath), tempPrefix+"checkpoint-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.CheckpointPath)
+}
@@ -0,0 +1,223 @@
+package stroage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// corruptingStorage flips the first byte of everything written to it
+type corruptingStorage struct {
+	Storage
+}
+
+func (s corruptingStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(data) > 0 {
+		data[0] ^= 1
+	}
+	return s.Storage.PutIf(ctx, namespace, objname, strings.NewReader(string(data)), cond)
+}
+
+// failingStorage fails all writes
+type failingStorage struct {
+	Storage
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (failingStorage) Put(context.Context, string, string, io.Reader) error {
+	return errWriteFailed
+}
+
+func (failingStorage) PutIf(context.Context, string, string, io.Reader, PutCondition) (ObjectInfo, error) {
+	return ObjectInfo{}, errWriteFailed
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	newSource := func(t *testing.T) *MemoryStorage {
+		src := NewMemoryStorage()
+		for _, obj := range []struct{ ns, name string }{
+			{"repo-a", "objects/aa/1"}, {"repo-a", "objects/bb/2"}, {"repo-a", "refs/heads/main"},
+			{"repo-b", "config"}, {"repo-b", "objects/cc/3"},
+		} {
+			if err := src.Put(ctx, obj.ns, obj.name, strings.NewReader(obj.ns+"/"+obj.name)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		return src
+	}
+
+	t.Run("Copies and verifies everything", func(t *testing.T) {
+		src, dst := newSource(t), NewMemoryStorage()
+		if err := dst.Put(ctx, "repo-a", "objects/aa/1", strings.NewReader("repo-a/objects/aa/1")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := dst.Put(ctx, "repo-a", "refs/heads/main", strings.NewReader("repo-a/refs/heads/MAIN")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		stats, err := (&Migrator{Src: src, Dst: dst}).Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if stats.Copied != 3 || stats.Replaced != 1 || stats.Skipped != 1 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+
+		assertContent(t, dst, "repo-a", "refs/heads/main", "repo-a/refs/heads/main")
+		assertContent(t, dst, "repo-b", "objects/cc/3", "repo-b/objects/cc/3")
+	})
+
+	t.Run("Dry run writes nothing", func(t *testing.T) {
+		src, dst := newSource(t), NewMemoryStorage()
+		var reported []string
+
+		m := &Migrator{Src: src, Dst: dst, DryRun: true, Report: func(ns, name string, action MigrateAction) {
+			reported = append(reported, ns+"/"+name+" "+action.String())
+		}}
+		stats, err := m.Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if stats.Copied != 5 || len(reported) != 5 || reported[0] != "repo-a/objects/aa/1 copied" {
+			t.Errorf("Unexpected stats %+v, reported %v", stats, reported)
+		}
+
+		namespaces, _ := dst.Namespaces(ctx)
+		if len(namespaces) != 0 {
+			t.Errorf("Expected an empty destination, got %v", namespaces)
+		}
+	})
+
+	t.Run("Resumes from a checkpoint", func(t *testing.T) {
+		src, dst := newSource(t), NewMemoryStorage()
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+		// Stop after the first object of repo-b
+		if err := os.WriteFile(checkpoint, []byte(`{"namespace": "repo-b", "cursor": "config"}`), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+
+		stats, err := (&Migrator{Src: src, Dst: dst, CheckpointPath: checkpoint}).Run(ctx)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if stats.Copied != 1 {
+			t.Errorf("Expected only the rest of repo-b to be copied, got %+v", stats)
+		}
+		if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+			t.Error("Expected the checkpoint to be removed after a complete run")
+		}
+	})
+
+	t.Run("Interrupted run leaves a checkpoint", func(t *testing.T) {
+		src := newSource(t)
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+		m := &Migrator{Src: src, Dst: failingStorage{NewMemoryStorage()}, Namespaces: []string{"repo-b"}, CheckpointPath: checkpoint}
+		if _, err := m.Run(ctx); !errors.Is(err, errWriteFailed) {
+			t.Fatalf("Expected the write error, got %v", err)
+		}
+		if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+			t.Error("Expected no checkpoint before the first page completed")
+		}
+	})
+
+	t.Run("Detects corrupted copies", func(t *testing.T) {
+		m := &Migrator{Src: newSource(t), Dst: corruptingStorage{NewMemoryStorage()}}
+		if _, err := m.Run(ctx); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Errorf("Expected a checksum mismatch, got %v", err)
+		}
+	})
+}
+
+func TestDualWriteStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Writes are mirrored", func(t *testing.T) {
+		primary, secondary := NewMemoryStorage(), NewMemoryStorage()
+		storage := NewDualWriteStorage(primary, secondary)
+
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := storage.Move(ctx, "ns", "obj", "ns", "moved"); err != nil {
+			t.Fatalf("Move failed: %v", err)
+		}
+		assertContent(t, secondary, "ns", "moved", "content")
+
+		if err := storage.Delete(ctx, "ns", "moved"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if exists, _ := secondary.Exists(ctx, "ns", "moved"); exists {
+			t.Error("Expected the delete to be mirrored")
+		}
+	})
+
+	t.Run("Failed primary writes are not mirrored", func(t *testing.T) {
+		primary, secondary := NewMemoryStorage(), NewMemoryStorage()
+		storage := NewDualWriteStorage(primary, secondary)
+		if err := primary.Put(ctx, "ns", "obj", strings.NewReader("old")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("new")); !errors.Is(err, os.ErrExist) {
+			t.Fatalf("Expected os.ErrExist, got %v", err)
+		}
+		if exists, _ := secondary.Exists(ctx, "ns", "obj"); exists {
+			t.Error("Expected nothing in the secondary")
+		}
+	})
+
+	t.Run("Secondary failures do not fail writes", func(t *testing.T) {
+		primary := NewMemoryStorage()
+		storage := NewDualWriteStorage(primary, failingStorage{NewMemoryStorage()})
+
+		if _, err := storage.PutIf(ctx, "ns", "obj", strings.NewReader("content"), PutCondition{}); err != nil {
+			t.Fatalf("PutIf failed: %v", err)
+		}
+		assertContent(t, primary, "ns", "obj", "content")
+	})
+
+	t.Run("Reads fall back to the secondary", func(t *testing.T) {
+		secondary := NewMemoryStorage()
+		storage := NewDualWriteStorage(NewMemoryStorage(), secondary)
+		if err := secondary.Put(ctx, "ns", "old", strings.NewReader("not migrated yet")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		assertContent(t, storage, "ns", "old", "not migrated yet")
+		if exists, err := storage.Exists(ctx, "ns", "old"); err != nil || !exists {
+			t.Errorf("Expected the object to exist, got %v, %v", exists, err)
+		}
+	})
+
+	t.Run("Deletes reach objects only in the secondary", func(t *testing.T) {
+		secondary := NewMemoryStorage()
+		storage := NewDualWriteStorage(NewMemoryStorage(), secondary)
+		if err := secondary.Put(ctx, "ns", "old", strings.NewReader("not migrated yet")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		if err := storage.Delete(ctx, "ns", "old"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if exists, _ := storage.Exists(ctx, "ns", "old"); exists {
+			t.Error("Expected the object to be gone from both backends")
+		}
+
+		if err := storage.Delete(ctx, "ns", "old"); !IsNotExist(err) {
+			t.Errorf("Expected deleting a missing object to fail with not found, got %v", err)
+		}
+	})
+}
@@ -2,10 +2,21 @@ package stroage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	stderrors "errors"
+	"hash"
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
@@ -22,6 +33,8 @@ func NewMinioStorage(endpoint, accessKeyID, secretAccessKey, bucketName string,
 	client, err := minio.New(endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
 		Secure: useSSL,
+		// Needed to send the checksums of uploads
+		TrailingHeaders: true,
 	})
 	if err != nil {
 		return nil, err
@@ -60,24 +73,160 @@ func (s *MinioStorage) Put(ctx context.Context, namespace, objname string, obj i
 	}
 
 	// Upload the object
-	_, err = s.client.PutObject(ctx, s.bucketName, objectKey, obj, -1,
-		minio.PutObjectOptions{})
+	_, err = s.putObject(ctx, objectKey, obj, minio.PutObjectOptions{})
 	return err
 }
 
-// Get retrieves an object from Minio
-func (s *MinioStorage) Get(ctx context.Context, namespace, objname string) (io.Reader, error) {
+// checksumMeta is the user metadata holding the SHA-256 of objects written
+// before the checksum was left to S3
+const checksumMeta = "Sha256"
+
+// minioPartSize is the size of the parts objects are uploaded in, which
+// bounds the memory used by an upload
+const minioPartSize = 16 << 20
+
+// putObject streams obj as a multipart upload, with the SHA-256 of every
+// part checked by the server and recorded with the object
+func (s *MinioStorage) putObject(ctx context.Context, objectKey string, obj io.Reader, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	opts.Checksum = minio.ChecksumSHA256
+	opts.PartSize = minioPartSize
+	return s.client.PutObject(ctx, s.bucketName, objectKey, obj, -1, opts)
+}
+
+// objectChecksum returns the hash to verify an object with and the digest
+// it must have, or a nil hash if the object has no checksum it can be
+// verified with
+func objectChecksum(info minio.ObjectInfo) (hash.Hash, string) {
+	if checksum := info.Metadata.Get("X-Amz-Meta-" + checksumMeta); checksum != "" {
+		return sha256.New(), checksum
+	}
+
+	encoded, parts, multipart := strings.Cut(info.ChecksumSHA256, "-")
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(digest) != sha256.Size {
+		return nil, ""
+	}
+	if !multipart {
+		return sha256.New(), hex.EncodeToString(digest)
+	}
+
+	// Only parts of the size putObject uploads can be hashed again
+	count, err := strconv.ParseInt(parts, 10, 64)
+	if err != nil || count != max(1, (info.Size+minioPartSize-1)/minioPartSize) {
+		return nil, ""
+	}
+	return newPartsHash(minioPartSize), hex.EncodeToString(digest)
+}
+
+// partsHash computes the checksum S3 records for multipart uploads, the
+// SHA-256 of the SHA-256 digests of the parts
+type partsHash struct {
+	size    int64
+	part    hash.Hash
+	written int64
+	digests []byte
+}
+
+func newPartsHash(size int64) *partsHash {
+	return &partsHash{size: size, part: sha256.New()}
+}
+
+func (h *partsHash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p[:min(int64(len(p)), h.size-h.written)]
+		h.part.Write(chunk)
+		h.written += int64(len(chunk))
+		p = p[len(chunk):]
+
+		if h.written == h.size {
+			h.digests = h.part.Sum(h.digests)
+			h.part.Reset()
+			h.written = 0
+		}
+	}
+	return n, nil
+}
+
+func (h *partsHash) Sum(b []byte) []byte {
+	digests := h.digests
+	if h.written > 0 || len(digests) == 0 {
+		digests = h.part.Sum(digests[:len(digests):len(digests)])
+	}
+	sum := sha256.Sum256(digests)
+	return append(b, sum[:]...)
+}
+
+func (h *partsHash) Reset() {
+	h.part.Reset()
+	h.written = 0
+	h.digests = nil
+}
+
+func (h *partsHash) Size() int { return sha256.Size }
+
+func (h *partsHash) BlockSize() int { return sha256.BlockSize }
+
+// Get retrieves an object from Minio. Reading it to the end fails with
+// errors.ErrChecksumMismatch if the content does not match the checksum
+// recorded when it was written.
+func (s *MinioStorage) Get(ctx context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
 	// Combine namespace and objname to create the object key
 	objectKey := namespace + "/" + objname
 
 	// Get object
-	obj, err := s.client.GetObject(ctx, s.bucketName, objectKey, minio.GetObjectOptions{})
+	obj, err := s.client.GetObject(ctx, s.bucketName, objectKey, minio.GetObjectOptions{Checksum: true})
+	if err != nil {
+		return nil, ObjectInfo{}, notExist(err)
+	}
+
+	// GetObject is lazy, Stat performs the request and reports missing objects
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, ObjectInfo{}, notExist(err)
+	}
+
+	var reader io.ReadSeekCloser = obj
+	if hash, checksum := objectChecksum(info); hash != nil {
+		// Reads after the first one are pinned to the ETag, so the content
+		// cannot be replaced while reading
+		reader = newVerifyingReader(obj, hash, checksum, info.Size, nil)
+	}
+
+	return reader, ObjectInfo{
+		Size:     info.Size,
+		ModTime:  info.LastModified,
+		Checksum: info.ETag,
+	}, nil
+}
+
+// GetRange reads part of an object from Minio with a ranged request
+func (s *MinioStorage) GetRange(ctx context.Context, namespace, objname string, offset, length int64) (io.ReadCloser, error) {
+	info, err := s.Stat(ctx, namespace, objname)
 	if err != nil {
 		return nil, err
 	}
 
-	// obj is already an io.Reader, so we can return it directly
-	return obj, nil
+	length, err = checkRange(info.Size, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucketName, namespace+"/"+objname, opts)
+	if err != nil {
+		return nil, notExist(err)
+	}
+
+	return rangeReader{Reader: io.LimitReader(obj, length), Closer: obj}, nil
 }
 
 // List returns all objects in a namespace from Minio
@@ -103,3 +252,249 @@ func (s *MinioStorage) List(ctx context.Context, namespace string) ([]string, er
 
 	return objects, nil
 }
+
+// Namespaces returns the top-level prefixes of the bucket in lexical order
+func (s *MinioStorage) Namespaces(ctx context.Context) ([]string, error) {
+	var namespaces []string
+
+	for object := range s.client.ListObjects(ctx, s.bucketName, minio.ListObjectsOptions{}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		// Without Recursive only prefixes end in a slash
+		if strings.HasSuffix(object.Key, "/") {
+			namespaces = append(namespaces, strings.TrimSuffix(object.Key, "/"))
+		}
+	}
+
+	return namespaces, nil
+}
+
+// ListPage returns one page of a listing using native S3 pagination
+func (s *MinioStorage) ListPage(ctx context.Context, namespace string, opts ListOptions) (ListResult, error) {
+	b, err := newPageBuilder(opts)
+	if err != nil {
+		return ListResult{}, err
+	}
+
+	core := minio.Core{Client: s.client}
+	root := namespace + "/"
+	startAfter := ""
+	if opts.Cursor != "" {
+		startAfter = root + opts.Cursor
+	}
+
+	token := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return ListResult{}, err
+		}
+
+		res, err := core.ListObjectsV2(s.bucketName, root+opts.Prefix, startAfter, token, opts.Delimiter, min(b.opts.PageSize+1, 1000))
+		if err != nil {
+			return ListResult{}, err
+		}
+
+		// A response lists objects before prefixes, merge them back in order
+		names := make([]string, 0, len(res.Contents)+len(res.CommonPrefixes))
+		for _, object := range res.Contents {
+			names = append(names, object.Key[len(root):])
+		}
+		for _, prefix := range res.CommonPrefixes {
+			names = append(names, prefix.Prefix[len(root):])
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if !b.add(name) {
+				return b.result(), nil
+			}
+		}
+
+		if !res.IsTruncated {
+			return b.result(), nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// notExist maps a missing object reported by Minio to os.ErrNotExist
+func notExist(err error) error {
+	if IsNotExist(err) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// Delete removes an object from Minio
+func (s *MinioStorage) Delete(ctx context.Context, namespace, objname string) error {
+	objectKey := namespace + "/" + objname
+
+	// RemoveObject succeeds for missing keys, so check first
+	if _, err := s.client.StatObject(ctx, s.bucketName, objectKey, minio.StatObjectOptions{}); err != nil {
+		return notExist(err)
+	}
+
+	return s.client.RemoveObject(ctx, s.bucketName, objectKey, minio.RemoveObjectOptions{})
+}
+
+// Stat returns the object metadata from Minio, using the ETag as checksum
+func (s *MinioStorage) Stat(ctx context.Context, namespace, objname string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucketName, namespace+"/"+objname, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, notExist(err)
+	}
+
+	return ObjectInfo{
+		Size:     info.Size,
+		ModTime:  info.LastModified,
+		Checksum: info.ETag,
+	}, nil
+}
+
+// Exists reports whether an object is present in Minio
+func (s *MinioStorage) Exists(ctx context.Context, namespace, objname string) (bool, error) {
+	_, err := s.Stat(ctx, namespace, objname)
+	if stderrors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Copy duplicates an object server-side with its recorded checksum, failing
+// with os.ErrExist if the target exists
+func (s *MinioStorage) Copy(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	exists, err := s.Exists(ctx, dstNamespace, dstName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return os.ErrExist
+	}
+
+	_, err = s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucketName, Object: dstNamespace + "/" + dstName},
+		minio.CopySrcOptions{Bucket: s.bucketName, Object: srcNamespace + "/" + srcName},
+	)
+	return notExist(err)
+}
+
+// Move copies an object server-side and removes the source
+func (s *MinioStorage) Move(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	if err := s.Copy(ctx, srcNamespace, srcName, dstNamespace, dstName); err != nil {
+		return err
+	}
+
+	return s.client.RemoveObject(ctx, s.bucketName, srcNamespace+"/"+srcName, minio.RemoveObjectOptions{})
+}
+
+// PutIf stores an object if cond holds, using conditional request headers
+func (s *MinioStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	opts := minio.PutObjectOptions{}
+	if cond.IfAbsent {
+		opts.SetMatchETagExcept("*")
+	}
+	if cond.IfMatch != "" {
+		opts.SetMatchETag(cond.IfMatch)
+	}
+
+	info, err := s.putObject(ctx, namespace+"/"+objname, obj, opts)
+	if err != nil {
+		code := minio.ToErrorResponse(err).Code
+		if code == "PreconditionFailed" || (cond.IfMatch != "" && code == "NoSuchKey") {
+			return ObjectInfo{}, errors.ErrPreconditionFailed
+		}
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{
+		Size:     info.Size,
+		ModTime:  info.LastModified,
+		Checksum: info.ETag,
+	}, nil
+}
+
+// CreateUpload starts a native S3 multipart upload
+func (s *MinioStorage) CreateUpload(ctx context.Context, namespace, objname, checksum string) (string, error) {
+	opts := minio.PutObjectOptions{}
+	if checksum != "" {
+		opts.UserMetadata = map[string]string{checksumMeta: checksum}
+	}
+
+	return minio.Core{Client: s.client}.NewMultipartUpload(ctx, s.bucketName, namespace+"/"+objname, opts)
+}
+
+// UploadPart uploads one part of a multipart upload
+func (s *MinioStorage) UploadPart(ctx context.Context, namespace, objname, uploadID string, number int, part io.Reader, size int64) (PartInfo, error) {
+	p, err := minio.Core{Client: s.client}.PutObjectPart(ctx, s.bucketName, namespace+"/"+objname, uploadID, number, part, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return PartInfo{}, notExist(err)
+	}
+
+	return PartInfo{Number: p.PartNumber, Size: p.Size, ETag: p.ETag}, nil
+}
+
+// ListParts returns the parts of a multipart upload
+func (s *MinioStorage) ListParts(ctx context.Context, namespace, objname, uploadID string) ([]PartInfo, error) {
+	core := minio.Core{Client: s.client}
+	var parts []PartInfo
+
+	marker := 0
+	for {
+		res, err := core.ListObjectParts(ctx, s.bucketName, namespace+"/"+objname, uploadID, marker, 1000)
+		if err != nil {
+			return nil, notExist(err)
+		}
+
+		for _, p := range res.ObjectParts {
+			parts = append(parts, PartInfo{Number: p.PartNumber, Size: p.Size, ETag: p.ETag})
+		}
+
+		if !res.IsTruncated {
+			return parts, nil
+		}
+		marker = res.NextPartNumberMarker
+	}
+}
+
+// CompleteUpload joins the parts of a multipart upload server-side
+func (s *MinioStorage) CompleteUpload(ctx context.Context, namespace, objname, uploadID string, parts []PartInfo) (ObjectInfo, error) {
+	complete := make([]minio.CompletePart, len(parts))
+	for i, p := range parts {
+		complete[i] = minio.CompletePart{PartNumber: p.Number, ETag: p.ETag}
+	}
+
+	_, err := minio.Core{Client: s.client}.CompleteMultipartUpload(ctx, s.bucketName, namespace+"/"+objname, uploadID, complete, minio.PutObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, notExist(err)
+	}
+
+	// The response lacks the size
+	return s.Stat(ctx, namespace, objname)
+}
+
+// AbortUpload aborts a multipart upload, letting the server discard its parts
+func (s *MinioStorage) AbortUpload(ctx context.Context, namespace, objname, uploadID string) error {
+	err := minio.Core{Client: s.client}.AbortMultipartUpload(ctx, s.bucketName, namespace+"/"+objname, uploadID)
+	return notExist(err)
+}
+
+// SignedURL returns a native pre-signed GET URL
+func (s *MinioStorage) SignedURL(ctx context.Context, namespace, objname string, expiry time.Duration) (string, error) {
+	if err := checkExpiry(expiry); err != nil {
+		return "", err
+	}
+
+	// Presigning works offline, check that there is something to download
+	if _, err := s.Stat(ctx, namespace, objname); err != nil {
+		return "", err
+	}
+
+	u, err := s.client.PresignedGetObject(ctx, s.bucketName, namespace+"/"+objname, expiry, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -3,6 +3,9 @@ package stroage
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"io"
 	"os"
@@ -370,3 +373,86 @@ func TestMinioStorage_List(t *testing.T) {
 		mockClient.AssertExpectations(t)
 	})
 }
+
+func TestMinioObjectChecksum(t *testing.T) {
+	content := []byte("abcdefghij")
+	sum := func(parts ...[]byte) []byte {
+		var digests []byte
+		for _, part := range parts {
+			digest := sha256.Sum256(part)
+			digests = append(digests, digest[:]...)
+		}
+		digest := sha256.Sum256(digests)
+		return digest[:]
+	}
+
+	t.Run("Parts hash", func(t *testing.T) {
+		h := newPartsHash(4)
+		h.Write(content[:3])
+		h.Write(content[3:])
+		if got, want := h.Sum(nil), sum(content[:4], content[4:8], content[8:]); !bytes.Equal(got, want) {
+			t.Errorf("Expected %x, got %x", want, got)
+		}
+		// Sum does not change the state
+		if got, want := h.Sum(nil), sum(content[:4], content[4:8], content[8:]); !bytes.Equal(got, want) {
+			t.Errorf("Expected %x after Sum, got %x", want, got)
+		}
+
+		h.Reset()
+		if got, want := h.Sum(nil), sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("Expected the checksum of an empty part %x, got %x", want, got)
+		}
+	})
+
+	t.Run("Checksums", func(t *testing.T) {
+		full := sha256.Sum256(content)
+		tests := []struct {
+			name  string
+			info  minio.ObjectInfo
+			want  []byte
+			parts bool
+		}{
+			{
+				name: "Metadata",
+				info: minio.ObjectInfo{Metadata: map[string][]string{"X-Amz-Meta-Sha256": {hex.EncodeToString(full[:])}}},
+				want: full[:],
+			},
+			{
+				name: "Single part",
+				info: minio.ObjectInfo{ChecksumSHA256: base64.StdEncoding.EncodeToString(full[:])},
+				want: full[:],
+			},
+			{
+				name:  "Multipart",
+				info:  minio.ObjectInfo{Size: int64(len(content)), ChecksumSHA256: base64.StdEncoding.EncodeToString(sum(content)) + "-1"},
+				want:  sum(content),
+				parts: true,
+			},
+			{
+				name: "Other part size",
+				info: minio.ObjectInfo{Size: int64(len(content)), ChecksumSHA256: base64.StdEncoding.EncodeToString(sum(content)) + "-3"},
+			},
+			{
+				name: "None",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				h, checksum := objectChecksum(tt.info)
+				if tt.want == nil {
+					if h != nil {
+						t.Errorf("Expected no checksum, got %q", checksum)
+					}
+					return
+				}
+				if h == nil || checksum != hex.EncodeToString(tt.want) {
+					t.Fatalf("Expected checksum %x, got %q", tt.want, checksum)
+				}
+				if _, ok := h.(*partsHash); ok != tt.parts {
+					t.Errorf("Expected parts hash %v, got %T", tt.parts, h)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package stroage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/GoldenDeals/DepGit/internal/share/logger"
+)
+
+var multipartLog = logger.New("stroage_multipart")
+
+// PartInfo describes an uploaded part of a multipart upload.
+type PartInfo struct {
+	Number int
+	Size   int64
+
+	// ETag identifies the part content, its format is backend specific
+	ETag string
+}
+
+// MultipartStorage is implemented by storages accepting an object in parts
+// that are uploaded separately and joined once all are present. Uploads
+// survive restarts, so an interrupted upload can be resumed. Wrapping
+// storages which must see the whole content store the parts as objects of
+// their own and write them joined on completion. See MultipartUpload for a
+// helper working with any Storage.
+type MultipartStorage interface {
+	Storage
+
+	// CreateUpload starts an upload and returns its ID. A non-empty checksum
+	// is the hex SHA-256 of the complete content, recorded with the object.
+	CreateUpload(ctx context.Context, namespace, objname, checksum string) (string, error)
+
+	// UploadPart stores part number of an upload, replacing a previous part
+	// with the same number. Numbers start at 1.
+	UploadPart(ctx context.Context, namespace, objname, uploadID string, number int, part io.Reader, size int64) (PartInfo, error)
+
+	// ListParts returns the parts uploaded so far, ordered by number.
+	ListParts(ctx context.Context, namespace, objname, uploadID string) ([]PartInfo, error)
+
+	// CompleteUpload joins parts, ordered by number, into the object,
+	// replacing any previous content, and ends the upload.
+	CompleteUpload(ctx context.Context, namespace, objname, uploadID string, parts []PartInfo) (ObjectInfo, error)
+
+	// AbortUpload ends an upload and discards its parts.
+	AbortUpload(ctx context.Context, namespace, objname, uploadID string) error
+}
+
+const (
+	// DefaultPartSize is the part size used by MultipartUpload if unset
+	DefaultPartSize = 16 << 20
+
+	// maxPartNumber is the highest part number S3 accepts
+	maxPartNumber = 10000
+
+	// abortTimeout bounds aborting an upload after its context was canceled
+	abortTimeout = 30 * time.Second
+)
+
+// partRetryDelay is the wait before the first retry of a part, doubling
+// with every further retry
+var partRetryDelay = time.Second
+
+// MultipartUpload streams one object of arbitrary size to a Storage, one
+// part at a time. Storages not implementing MultipartStorage receive the
+// content with a single PutIf, which can neither be retried nor resumed.
+type MultipartUpload struct {
+	Storage   Storage
+	Namespace string
+	Name      string
+
+	// PartSize is the size of every part but the last, DefaultPartSize if
+	// zero. One part is buffered in memory. S3 requires at least 5 MiB and
+	// at most 10000 parts.
+	PartSize int64
+
+	// Checksum is the hex SHA-256 of the content, if known in advance. It is
+	// recorded with the object, and the upload fails with
+	// errors.ErrChecksumMismatch if the content does not match.
+	Checksum string
+
+	// Retries is how often the upload of a failed part is retried.
+	Retries int
+
+	// UploadID identifies the upload. Run sets it when starting an upload;
+	// set it to resume an interrupted one with the same content. Parts
+	// already uploaded with the expected size are skipped.
+	UploadID string
+
+	// Resumable keeps the uploaded parts if Run fails or is canceled, so the
+	// upload can be resumed with UploadID. Otherwise the upload is aborted.
+	Resumable bool
+}
+
+// Run uploads the content read from src and returns the stored object.
+func (u *MultipartUpload) Run(ctx context.Context, src io.Reader) (ObjectInfo, error) {
+	mp, ok := u.Storage.(MultipartStorage)
+	if !ok {
+		return u.Storage.PutIf(ctx, u.Namespace, u.Name, newCheckedReader(src, u.Checksum), PutCondition{})
+	}
+
+	partSize := u.PartSize
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < 0 {
+		return ObjectInfo{}, errors.ErrBadData.Msg("negative part size")
+	}
+
+	uploaded := map[int]PartInfo{}
+	if u.UploadID == "" {
+		id, err := mp.CreateUpload(ctx, u.Namespace, u.Name, u.Checksum)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		u.UploadID = id
+	} else {
+		parts, err := mp.ListParts(ctx, u.Namespace, u.Name, u.UploadID)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		for _, p := range parts {
+			uploaded[p.Number] = p
+		}
+	}
+
+	info, err := u.upload(ctx, mp, src, partSize, uploaded)
+	if err != nil && !u.Resumable {
+		// Clean up even if ctx was canceled
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+		defer cancel()
+
+		if abortErr := mp.AbortUpload(abortCtx, u.Namespace, u.Name, u.UploadID); abortErr != nil {
+			multipartLog.WithField("namespace", u.Namespace).
+				WithField("object", u.Name).
+				WithField("upload", u.UploadID).
+				WithError(abortErr).
+				Warn("Aborting upload failed")
+		}
+		u.UploadID = ""
+	}
+	return info, err
+}
+
+func (u *MultipartUpload) upload(ctx context.Context, mp MultipartStorage, src io.Reader, partSize int64, uploaded map[int]PartInfo) (ObjectInfo, error) {
+	checked := newCheckedReader(src, u.Checksum)
+	buf := make([]byte, partSize)
+	var parts []PartInfo
+
+	for number := 1; ; number++ {
+		if err := ctx.Err(); err != nil {
+			return ObjectInfo{}, err
+		}
+
+		n, err := io.ReadFull(checked, buf)
+		if err == io.EOF && number > 1 {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return ObjectInfo{}, err
+		}
+		if number > maxPartNumber {
+			return ObjectInfo{}, errors.ErrBadData.Msg("too many parts, increase the part size")
+		}
+
+		part, ok := uploaded[number]
+		if !ok || part.Size != int64(n) {
+			if part, err = u.uploadPart(ctx, mp, number, buf[:n]); err != nil {
+				return ObjectInfo{}, err
+			}
+		}
+		parts = append(parts, part)
+
+		if int64(n) < partSize {
+			break
+		}
+	}
+
+	return mp.CompleteUpload(ctx, u.Namespace, u.Name, u.UploadID, parts)
+}
+
+func (u *MultipartUpload) uploadPart(ctx context.Context, mp MultipartStorage, number int, data []byte) (PartInfo, error) {
+	delay := partRetryDelay
+	for attempt := 0; ; attempt++ {
+		part, err := mp.UploadPart(ctx, u.Namespace, u.Name, u.UploadID, number, bytes.NewReader(data), int64(len(data)))
+		if err == nil || attempt >= u.Retries || ctx.Err() != nil {
+			return part, err
+		}
+
+		multipartLog.WithField("namespace", u.Namespace).
+			WithField("object", u.Name).
+			WithField("part", number).
+			WithField("attempt", attempt+1).
+			WithError(err).
+			Warn("Uploading part failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return PartInfo{}, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// checkedReader fails with errors.ErrChecksumMismatch instead of io.EOF if
+// the content does not have the expected SHA-256
+type checkedReader struct {
+	r    io.Reader
+	want string
+	hash hash.Hash
+}
+
+func newCheckedReader(r io.Reader, want string) *checkedReader {
+	return &checkedReader{r: r, want: want, hash: sha256.New()}
+}
+
+func (r *checkedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && r.want != "" && hex.EncodeToString(r.hash.Sum(nil)) != r.want {
+		return n, errors.ErrChecksumMismatch
+	}
+	return n, err
+}
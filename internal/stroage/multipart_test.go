@@ -0,0 +1,292 @@
+package stroage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/config"
+	serrors "github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// partCountingStorage counts part uploads and fails the next failParts
+type partCountingStorage struct {
+	*FileStorage
+	uploaded  int
+	failParts int
+}
+
+var errPartFailed = errors.New("part failed")
+
+func (s *partCountingStorage) UploadPart(ctx context.Context, namespace, objname, uploadID string, number int, part io.Reader, size int64) (PartInfo, error) {
+	if s.failParts > 0 {
+		s.failParts--
+		return PartInfo{}, errPartFailed
+	}
+	s.uploaded++
+	return s.FileStorage.UploadPart(ctx, namespace, objname, uploadID, number, part, size)
+}
+
+// cutReader fails after n bytes, like an interrupted connection
+type cutReader struct {
+	r io.Reader
+	n int
+}
+
+var errConnectionLost = errors.New("connection lost")
+
+func (r *cutReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errConnectionLost
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.r.Read(p)
+	r.n -= n
+	return n, err
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	content := strings.Repeat("0123456789", 10)
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	newStorage := func(t *testing.T) (*partCountingStorage, string) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir)
+		if err != nil {
+			t.Fatalf("NewFileStorage failed: %v", err)
+		}
+		return &partCountingStorage{FileStorage: storage}, dir
+	}
+
+	// assertNoUploads checks that no upload was left behind
+	assertNoUploads := func(t *testing.T, dir string) {
+		matches, _ := filepath.Glob(filepath.Join(dir, "ns", uploadPrefix+"*"))
+		if len(matches) != 0 {
+			t.Errorf("Expected no uploads left, got %v", matches)
+		}
+	}
+
+	t.Run("Uploads in parts", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		u := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 30, Checksum: checksum}
+
+		info, err := u.Run(ctx, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if storage.uploaded != 4 || info.Size != int64(len(content)) || info.Checksum != checksum {
+			t.Errorf("Unexpected upload of %d parts, %+v", storage.uploaded, info)
+		}
+
+		assertContent(t, storage, "ns", "big", content)
+		assertNoUploads(t, dir)
+
+		listed, err := storage.List(ctx, "ns")
+		if err != nil || len(listed) != 1 {
+			t.Errorf("Expected only the object to be listed, got %v, %v", listed, err)
+		}
+	})
+
+	t.Run("Content ending at a part boundary", func(t *testing.T) {
+		storage, _ := newStorage(t)
+		u := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 50}
+
+		if _, err := u.Run(ctx, strings.NewReader(content)); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if storage.uploaded != 2 {
+			t.Errorf("Expected 2 parts, got %d", storage.uploaded)
+		}
+		assertContent(t, storage, "ns", "big", content)
+	})
+
+	t.Run("Checksum mismatch aborts", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		u := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 30, Checksum: checksum}
+
+		if _, err := u.Run(ctx, strings.NewReader(content+"!")); err != serrors.ErrChecksumMismatch {
+			t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+		}
+		if exists, _ := storage.Exists(ctx, "ns", "big"); exists {
+			t.Error("Expected no object")
+		}
+		assertNoUploads(t, dir)
+	})
+
+	t.Run("Failed parts are retried", func(t *testing.T) {
+		defer func(delay time.Duration) { partRetryDelay = delay }(partRetryDelay)
+		partRetryDelay = 0
+		storage, _ := newStorage(t)
+		storage.failParts = 2
+
+		u := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 30, Retries: 1}
+		if _, err := u.Run(ctx, strings.NewReader(content)); !errors.Is(err, errPartFailed) {
+			t.Fatalf("Expected the part error, got %v", err)
+		}
+
+		storage.failParts = 1
+		if _, err := u.Run(ctx, strings.NewReader(content)); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		assertContent(t, storage, "ns", "big", content)
+	})
+
+	t.Run("Interrupted uploads resume", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		u := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 30, Checksum: checksum, Resumable: true}
+
+		if _, err := u.Run(ctx, &cutReader{r: strings.NewReader(content), n: 65}); !errors.Is(err, errConnectionLost) {
+			t.Fatalf("Expected the read error, got %v", err)
+		}
+		if u.UploadID == "" || storage.uploaded != 2 {
+			t.Fatalf("Expected a resumable upload with 2 parts, got %q with %d", u.UploadID, storage.uploaded)
+		}
+
+		resumed := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 30, Checksum: checksum, UploadID: u.UploadID}
+		if _, err := resumed.Run(ctx, strings.NewReader(content)); err != nil {
+			t.Fatalf("Resumed Run failed: %v", err)
+		}
+		if storage.uploaded != 4 {
+			t.Errorf("Expected only the missing parts to be uploaded, got %d parts", storage.uploaded)
+		}
+		assertContent(t, storage, "ns", "big", content)
+		assertNoUploads(t, dir)
+	})
+
+	t.Run("Canceled uploads are aborted", func(t *testing.T) {
+		storage, dir := newStorage(t)
+		cancelCtx, cancel := context.WithCancel(ctx)
+		u := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 30}
+
+		src := io.MultiReader(strings.NewReader(content[:40]), readerFunc(func([]byte) (int, error) {
+			cancel()
+			return 0, cancelCtx.Err()
+		}))
+		if _, err := u.Run(cancelCtx, src); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		if u.UploadID != "" {
+			t.Error("Expected the upload ID to be cleared")
+		}
+		assertNoUploads(t, dir)
+		if _, err := os.Stat(filepath.Join(dir, "ns", "big")); !os.IsNotExist(err) {
+			t.Errorf("Expected no object, got %v", err)
+		}
+	})
+
+	t.Run("Parts replaced since are rejected", func(t *testing.T) {
+		storage, _ := newStorage(t)
+		id, err := storage.CreateUpload(ctx, "ns", "big", "")
+		if err != nil {
+			t.Fatalf("CreateUpload failed: %v", err)
+		}
+		first, err := storage.UploadPart(ctx, "ns", "big", id, 1, strings.NewReader("first"), 5)
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		second, err := storage.UploadPart(ctx, "ns", "big", id, 1, strings.NewReader("other"), 5)
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+
+		if _, err := storage.CompleteUpload(ctx, "ns", "big", id, []PartInfo{first}); !errors.Is(err, serrors.ErrBadData) {
+			t.Fatalf("Expected ErrBadData, got %v", err)
+		}
+		if _, err := storage.CompleteUpload(ctx, "ns", "big", id, []PartInfo{second}); err != nil {
+			t.Fatalf("CompleteUpload failed: %v", err)
+		}
+		assertContent(t, storage, "ns", "big", "other")
+	})
+
+	t.Run("Other storages receive one write", func(t *testing.T) {
+		storage := NewMemoryStorage()
+		u := &MultipartUpload{Storage: storage, Namespace: "ns", Name: "big", PartSize: 30, Checksum: checksum}
+
+		if _, err := u.Run(ctx, strings.NewReader(content)); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		assertContent(t, storage, "ns", "big", content)
+
+		if _, err := u.Run(ctx, strings.NewReader("other")); err != serrors.ErrChecksumMismatch {
+			t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+		}
+		assertContent(t, storage, "ns", "big", content)
+	})
+}
+
+func TestMultipartUpload_FromConfig(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	content := strings.Repeat("0123456789", 100)
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	keyFile := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(keyFile, []byte(`{"primary": "k1", "keys": {"k1": "`+key+`"}}`), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	st, err := FromConfig(ctx, config.StorageConfig{
+		Backend: "replicated",
+		Replicas: []config.StorageConfig{
+			{Backend: "file", Path: filepath.Join(dir, "a")},
+			{Backend: "file", Path: filepath.Join(dir, "b")},
+		},
+		Compression:       "gzip",
+		EncryptionKeyFile: keyFile,
+		Cache:             config.CacheConfig{MemoryBytes: 1 << 20},
+		Secondary:         &config.StorageConfig{Backend: "file", Path: filepath.Join(dir, "secondary")},
+	})
+	if err != nil {
+		t.Fatalf("FromConfig failed: %v", err)
+	}
+	if _, ok := st.(MultipartStorage); !ok {
+		t.Fatalf("Expected %T to take multipart uploads", st)
+	}
+
+	u := &MultipartUpload{Storage: st, Namespace: "ns", Name: "big", PartSize: 300, Resumable: true}
+	if _, err := u.Run(ctx, &cutReader{r: strings.NewReader(content), n: 650}); !errors.Is(err, errConnectionLost) {
+		t.Fatalf("Expected the read error, got %v", err)
+	}
+	parts, err := st.(MultipartStorage).ListParts(ctx, "ns", "big", u.UploadID)
+	if err != nil || len(parts) != 2 || parts[1].Size != 300 {
+		t.Fatalf("Expected 2 staged parts, got %+v, %v", parts, err)
+	}
+
+	if _, err := u.Run(ctx, strings.NewReader(content)); err != nil {
+		t.Fatalf("Resumed Run failed: %v", err)
+	}
+	assertContent(t, st, "ns", "big", content)
+
+	// Staged parts are stored like objects and removed once completed
+	for _, replica := range []string{"a", "b", "secondary"} {
+		backend, err := NewFileStorage(filepath.Join(dir, replica))
+		if err != nil {
+			t.Fatalf("NewFileStorage failed: %v", err)
+		}
+		if staged, err := backend.List(ctx, stagedNamespace); err != nil || len(staged) != 0 {
+			t.Errorf("Expected no staged objects in %s, got %v, %v", replica, staged, err)
+		}
+		if exists, err := backend.Exists(ctx, "ns", "big"); err != nil || !exists {
+			t.Errorf("Expected the object in %s, got %v, %v", replica, exists, err)
+		}
+	}
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,781 @@
+package stroage
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/GoldenDeals/DepGit/internal/share/logger"
+)
+
+var replicaLog = logger.New("stroage_replicated")
+
+const (
+	// healthRetry is how long reads avoid a replica after it failed
+	healthRetry = 30 * time.Second
+
+	// maxDirty bounds the objects remembered for repair. Past it the next
+	// repair scans everything.
+	maxDirty = 100000
+
+	// spoolMemory is the content size kept in memory while fanning out a
+	// write, larger objects are spooled to a temporary file
+	spoolMemory = 1 << 20
+)
+
+// ReplicaOptions configures a ReplicatedStorage.
+type ReplicaOptions struct {
+	// WriteQuorum is the number of replicas a write has to reach. Zero
+	// requires all of them.
+	WriteQuorum int
+}
+
+// replica is a child backend with its read health
+type replica struct {
+	Storage
+	index int
+
+	mu       sync.Mutex
+	failedAt time.Time
+}
+
+func (r *replica) healthy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failedAt.IsZero() || time.Since(r.failedAt) > healthRetry
+}
+
+func (r *replica) markFailed(op string, err error) {
+	r.mu.Lock()
+	r.failedAt = time.Now()
+	r.mu.Unlock()
+
+	replicaLog.WithError(err).
+		WithField("replica", r.index).
+		WithField("op", op).
+		Warn("Replica failed")
+}
+
+func (r *replica) markHealthy() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failedAt = time.Time{}
+}
+
+// isReplicaFailure tells a broken replica apart from an answer about the
+// object, like it missing or a failed condition
+func isReplicaFailure(err error) bool {
+	return err != nil &&
+		!IsNotExist(err) &&
+		!stderrors.Is(err, os.ErrExist) &&
+		!stderrors.Is(err, errors.ErrPreconditionFailed) &&
+		!stderrors.Is(err, errors.ErrInvalidRange) &&
+		!stderrors.Is(err, errors.ErrBadData)
+}
+
+type objectKey struct {
+	namespace string
+	name      string
+}
+
+// ReplicatedStorage keeps a copy of every object on each of its replicas.
+// Writes are sent to all replicas at once and succeed once WriteQuorum of
+// them did. Reads are served by the first healthy replica, falling through
+// to the next ones for objects it lacks.
+//
+// Objects a write did not reach on every replica are remembered and fixed
+// by RepairDirty, Repair compares everything. A write that missed its quorum
+// may still have reached some replicas and is then completed by repair.
+// Deletes are remembered as such, so repair completes them rather than
+// restoring the object. They are only remembered in memory: after a
+// restart, Repair restores objects a delete did not remove everywhere.
+type ReplicatedStorage struct {
+	stagedUploads
+	replicas []*replica
+	quorum   int
+
+	// writing is held by writes, and exclusively while completing deletes
+	// so that no write of the object is in flight
+	writing sync.RWMutex
+
+	mu sync.Mutex
+	// dirty holds the objects to repair, true marks deleted ones
+	dirty    map[objectKey]bool
+	overflow bool
+}
+
+// NewReplicatedStorage replicates objects across replicas, the first one
+// being preferred for reads.
+func NewReplicatedStorage(replicas []Storage, opts ReplicaOptions) (*ReplicatedStorage, error) {
+	if len(replicas) == 0 {
+		return nil, errors.ErrBadData.Msg("no replicas configured")
+	}
+
+	quorum := opts.WriteQuorum
+	if quorum == 0 {
+		quorum = len(replicas)
+	}
+	if quorum < 0 || quorum > len(replicas) {
+		return nil, errors.ErrBadData.Msg("write quorum exceeds the number of replicas")
+	}
+
+	s := &ReplicatedStorage{quorum: quorum, dirty: make(map[objectKey]bool)}
+	s.stagedUploads = stagedUploads{s}
+	for i, st := range replicas {
+		s.replicas = append(s.replicas, &replica{Storage: st, index: i})
+	}
+	return s, nil
+}
+
+// readOrder returns the healthy replicas first, keeping their order
+func (s *ReplicatedStorage) readOrder() []*replica {
+	order := make([]*replica, 0, len(s.replicas))
+	var failed []*replica
+	for _, r := range s.replicas {
+		if r.healthy() {
+			order = append(order, r)
+		} else {
+			failed = append(failed, r)
+		}
+	}
+	return append(order, failed...)
+}
+
+// readFrom asks replicas in read order until one answers. A missing object
+// falls through to the next replica, as replicas lag behind until repaired.
+func readFrom[T any](s *ReplicatedStorage, op string, fn func(Storage) (T, error)) (T, error) {
+	var (
+		zero     T
+		notExist error
+		failure  error
+	)
+
+	for _, r := range s.readOrder() {
+		v, err := fn(r.Storage)
+		switch {
+		case err == nil:
+			r.markHealthy()
+			return v, nil
+		case IsNotExist(err):
+			notExist = err
+		case isReplicaFailure(err):
+			r.markFailed(op, err)
+			failure = err
+		default:
+			return zero, err
+		}
+	}
+
+	if notExist != nil {
+		return zero, notExist
+	}
+	return zero, failure
+}
+
+// markDirty remembers objects for the next repair, deleted or written. Past
+// maxDirty written objects are left to a full repair, which needs to know
+// about deleted ones, so these are kept instead.
+func (s *ReplicatedStorage) markDirty(deleted bool, keys ...objectKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := s.dirty[key]; ok {
+			s.dirty[key] = deleted
+			continue
+		}
+
+		if len(s.dirty) >= maxDirty {
+			s.overflow = true
+			if !deleted {
+				continue
+			}
+			for k, del := range s.dirty {
+				if !del {
+					delete(s.dirty, k)
+				}
+			}
+			if len(s.dirty) >= maxDirty {
+				replicaLog.WithField("namespace", key.namespace).
+					WithField("object", key.name).
+					Warn("Too many pending deletes, repair may restore the object")
+				continue
+			}
+		}
+		s.dirty[key] = deleted
+	}
+}
+
+// requeue remembers written objects for the next repair again, unless they
+// were marked since
+func (s *ReplicatedStorage) requeue(keys ...objectKey) {
+	s.mu.Lock()
+	var fresh []objectKey
+	for _, key := range keys {
+		if _, ok := s.dirty[key]; !ok {
+			fresh = append(fresh, key)
+		}
+	}
+	s.mu.Unlock()
+
+	s.markDirty(false, fresh...)
+}
+
+// forget drops objects every replica agrees on from the next repair
+func (s *ReplicatedStorage) forget(keys ...objectKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.dirty, key)
+	}
+}
+
+// write runs fn on all replicas concurrently and checks the quorum. It
+// writes the objects of written and removes those of deleted.
+func (s *ReplicatedStorage) write(op string, written, deleted []objectKey, fn func(r *replica) error) error {
+	s.writing.RLock()
+	defer s.writing.RUnlock()
+
+	errs := make([]error, len(s.replicas))
+	var wg sync.WaitGroup
+	for i, r := range s.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(r)
+		}()
+	}
+	wg.Wait()
+
+	return s.settle(op, written, deleted, errs)
+}
+
+// settle counts the replicas a write reached. When the quorum is missed,
+// an answer about the object wins over replica failures.
+func (s *ReplicatedStorage) settle(op string, written, deleted []objectKey, errs []error) error {
+	ok := 0
+	diverged := false
+	for i, err := range errs {
+		if err == nil {
+			ok++
+			continue
+		}
+		if isReplicaFailure(err) {
+			s.replicas[i].markFailed(op, err)
+			diverged = true
+		}
+	}
+	switch {
+	case diverged || (ok > 0 && ok < len(errs)):
+		s.markDirty(false, written...)
+		s.markDirty(true, deleted...)
+	case ok == len(errs):
+		s.forget(written...)
+		s.forget(deleted...)
+	}
+
+	if ok >= s.quorum {
+		return nil
+	}
+	for _, err := range errs {
+		if err != nil && !isReplicaFailure(err) {
+			return err
+		}
+	}
+	return errors.ErrQuorumNotReached
+}
+
+// spool buffers content so every replica can read it
+type spool struct {
+	data []byte
+	file *os.File
+	size int64
+}
+
+func newSpool(obj io.Reader) (*spool, error) {
+	data, err := io.ReadAll(io.LimitReader(obj, spoolMemory+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= spoolMemory {
+		return &spool{data: data, size: int64(len(data))}, nil
+	}
+
+	file, err := os.CreateTemp("", "depgit-replica-*")
+	if err != nil {
+		return nil, err
+	}
+	sp := &spool{file: file}
+
+	n, err := io.Copy(file, io.MultiReader(bytes.NewReader(data), obj))
+	if err != nil {
+		sp.Close()
+		return nil, err
+	}
+	sp.size = n
+	return sp, nil
+}
+
+func (sp *spool) reader() io.Reader {
+	if sp.file == nil {
+		return bytes.NewReader(sp.data)
+	}
+	return io.NewSectionReader(sp.file, 0, sp.size)
+}
+
+func (sp *spool) Close() {
+	if sp.file != nil {
+		sp.file.Close()
+		os.Remove(sp.file.Name())
+	}
+}
+
+// Put stores an object on all replicas
+func (s *ReplicatedStorage) Put(ctx context.Context, namespace, objname string, obj io.Reader) error {
+	sp, err := newSpool(obj)
+	if err != nil {
+		return err
+	}
+	defer sp.Close()
+
+	key := objectKey{namespace, objname}
+	return s.write("put", []objectKey{key}, nil, func(r *replica) error {
+		return r.Put(ctx, namespace, objname, sp.reader())
+	})
+}
+
+// PutIf stores an object on all replicas if cond holds. Checksums differ
+// between backends, so the condition is checked on the first healthy
+// replica, the one Stat answers from, and the others follow it.
+func (s *ReplicatedStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	sp, err := newSpool(obj)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer sp.Close()
+
+	s.writing.RLock()
+	defer s.writing.RUnlock()
+
+	key := objectKey{namespace, objname}
+	errs := make([]error, len(s.replicas))
+	infos := make([]ObjectInfo, len(s.replicas))
+
+	lead := -1
+	if cond != (PutCondition{}) {
+		for _, r := range s.readOrder() {
+			infos[r.index], errs[r.index] = r.PutIf(ctx, namespace, objname, sp.reader(), cond)
+			if !isReplicaFailure(errs[r.index]) {
+				lead = r.index
+				break
+			}
+			r.markFailed("put", errs[r.index])
+		}
+		if lead < 0 {
+			s.markDirty(false, key)
+			return ObjectInfo{}, errors.ErrQuorumNotReached
+		}
+		if errs[lead] != nil {
+			return ObjectInfo{}, errs[lead]
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, r := range s.replicas {
+		if i == lead || errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			infos[i], errs[i] = r.PutIf(ctx, namespace, objname, sp.reader(), PutCondition{})
+		}()
+	}
+	wg.Wait()
+
+	if err := s.settle("put", []objectKey{key}, nil, errs); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	if lead >= 0 {
+		return infos[lead], nil
+	}
+	for _, r := range s.readOrder() {
+		if errs[r.index] == nil {
+			return infos[r.index], nil
+		}
+	}
+	return ObjectInfo{}, errors.ErrQuorumNotReached
+}
+
+type getResult struct {
+	reader io.ReadSeekCloser
+	info   ObjectInfo
+}
+
+// Get reads an object from the first healthy replica holding it
+func (s *ReplicatedStorage) Get(ctx context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	res, err := readFrom(s, "get", func(st Storage) (getResult, error) {
+		reader, info, err := st.Get(ctx, namespace, objname)
+		return getResult{reader, info}, err
+	})
+	return res.reader, res.info, err
+}
+
+// GetRange reads part of an object from the first healthy replica holding it
+func (s *ReplicatedStorage) GetRange(ctx context.Context, namespace, objname string, offset, length int64) (io.ReadCloser, error) {
+	return readFrom(s, "get", func(st Storage) (io.ReadCloser, error) {
+		return st.GetRange(ctx, namespace, objname, offset, length)
+	})
+}
+
+// List returns the objects of a namespace on the first healthy replica
+func (s *ReplicatedStorage) List(ctx context.Context, namespace string) ([]string, error) {
+	return readFrom(s, "list", func(st Storage) ([]string, error) {
+		return st.List(ctx, namespace)
+	})
+}
+
+// Namespaces returns the namespaces on the first healthy replica
+func (s *ReplicatedStorage) Namespaces(ctx context.Context) ([]string, error) {
+	return readFrom(s, "list", func(st Storage) ([]string, error) {
+		return st.Namespaces(ctx)
+	})
+}
+
+// ListPage returns one page of a listing from the first healthy replica
+func (s *ReplicatedStorage) ListPage(ctx context.Context, namespace string, opts ListOptions) (ListResult, error) {
+	return readFrom(s, "list", func(st Storage) (ListResult, error) {
+		return st.ListPage(ctx, namespace, opts)
+	})
+}
+
+// Stat returns the metadata from the first healthy replica holding the object
+func (s *ReplicatedStorage) Stat(ctx context.Context, namespace, objname string) (ObjectInfo, error) {
+	return readFrom(s, "stat", func(st Storage) (ObjectInfo, error) {
+		return st.Stat(ctx, namespace, objname)
+	})
+}
+
+// Exists reports whether any replica holds the object
+func (s *ReplicatedStorage) Exists(ctx context.Context, namespace, objname string) (bool, error) {
+	exists, err := readFrom(s, "stat", func(st Storage) (bool, error) {
+		exists, err := st.Exists(ctx, namespace, objname)
+		if err == nil && !exists {
+			return false, os.ErrNotExist
+		}
+		return exists, err
+	})
+	if IsNotExist(err) {
+		return false, nil
+	}
+	return exists, err
+}
+
+// Delete removes an object from all replicas
+func (s *ReplicatedStorage) Delete(ctx context.Context, namespace, objname string) error {
+	var missing atomic.Int32
+	err := s.write("delete", nil, []objectKey{{namespace, objname}}, func(r *replica) error {
+		err := r.Delete(ctx, namespace, objname)
+		if IsNotExist(err) {
+			missing.Add(1)
+			return nil
+		}
+		return err
+	})
+	if err == nil && int(missing.Load()) == len(s.replicas) {
+		return &os.PathError{Op: "delete", Path: namespace + "/" + objname, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+// Copy duplicates an object on all replicas
+func (s *ReplicatedStorage) Copy(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	keys := []objectKey{{dstNamespace, dstName}}
+	return s.write("copy", keys, nil, func(r *replica) error {
+		return r.Copy(ctx, srcNamespace, srcName, dstNamespace, dstName)
+	})
+}
+
+// Move renames an object on all replicas
+func (s *ReplicatedStorage) Move(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error {
+	written := []objectKey{{dstNamespace, dstName}}
+	deleted := []objectKey{{srcNamespace, srcName}}
+	return s.write("move", written, deleted, func(r *replica) error {
+		return r.Move(ctx, srcNamespace, srcName, dstNamespace, dstName)
+	})
+}
+
+// RepairStats summarizes a repair pass.
+type RepairStats struct {
+	Checked  int
+	Repaired int
+}
+
+// Repair completes the deletes known to have missed replicas, then
+// compares every object across replicas and copies the version held by
+// most replicas, or the first one on a tie, to the others. Other objects
+// missing from some replicas are restored rather than deleted, since a
+// failed write and a failed delete look the same.
+func (s *ReplicatedStorage) Repair(ctx context.Context) (RepairStats, error) {
+	var stats RepairStats
+
+	s.mu.Lock()
+	var deleted []objectKey
+	for key, del := range s.dirty {
+		if del {
+			deleted = append(deleted, key)
+		} else {
+			delete(s.dirty, key)
+		}
+	}
+	s.overflow = false
+	s.mu.Unlock()
+
+	for _, key := range deleted {
+		if err := s.finishDelete(ctx, key, &stats); err != nil {
+			return stats, err
+		}
+	}
+
+	namespaces := map[string]struct{}{}
+	for _, r := range s.replicas {
+		list, err := r.Namespaces(ctx)
+		if err != nil {
+			return stats, err
+		}
+		for _, ns := range list {
+			namespaces[ns] = struct{}{}
+		}
+	}
+
+	for _, ns := range sortedKeys(namespaces) {
+		names := map[string]struct{}{}
+		for _, r := range s.replicas {
+			for name, err := range Objects(ctx, r, ns, "") {
+				if err != nil {
+					return stats, err
+				}
+				names[name] = struct{}{}
+			}
+		}
+
+		for _, name := range sortedKeys(names) {
+			key := objectKey{ns, name}
+			if s.isDeleted(key) {
+				continue
+			}
+			if err := s.repairObject(ctx, key, &stats); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// RepairDirty repairs the objects that writes did not reach on every
+// replica. Objects that cannot be repaired yet stay dirty.
+func (s *ReplicatedStorage) RepairDirty(ctx context.Context) (RepairStats, error) {
+	s.mu.Lock()
+	if s.overflow {
+		s.mu.Unlock()
+		return s.Repair(ctx)
+	}
+	// Deleted objects stay dirty until finishDelete completed them
+	dirty := make(map[objectKey]bool, len(s.dirty))
+	for key, del := range s.dirty {
+		dirty[key] = del
+		if !del {
+			delete(s.dirty, key)
+		}
+	}
+	s.mu.Unlock()
+
+	var stats RepairStats
+	for key, del := range dirty {
+		var err error
+		if del {
+			err = s.finishDelete(ctx, key, &stats)
+		} else {
+			err = s.repairObject(ctx, key, &stats)
+		}
+		if err != nil {
+			for rest, del := range dirty {
+				if !del {
+					s.requeue(rest)
+				}
+			}
+			return stats, err
+		}
+		delete(dirty, key)
+	}
+	return stats, nil
+}
+
+// isDeleted reports whether an object awaits the completion of its delete
+func (s *ReplicatedStorage) isDeleted(key objectKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirty[key]
+}
+
+// finishDelete removes a deleted object from the replicas a delete did not
+// reach. Writes are held meanwhile, so one written since is kept.
+func (s *ReplicatedStorage) finishDelete(ctx context.Context, key objectKey, stats *RepairStats) error {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+
+	if !s.isDeleted(key) {
+		return nil
+	}
+
+	stats.Checked++
+	for _, r := range s.replicas {
+		err := r.Delete(ctx, key.namespace, key.name)
+		if IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stats.Repaired++
+	}
+
+	s.forget(key)
+	return nil
+}
+
+// RunRepair repairs dirty objects every interval until ctx is done.
+func (s *ReplicatedStorage) RunRepair(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := s.RepairDirty(ctx)
+		if err != nil {
+			replicaLog.WithError(err).Warn("Repair failed")
+		}
+		if stats.Repaired > 0 {
+			replicaLog.WithField("checked", stats.Checked).
+				WithField("repaired", stats.Repaired).
+				Info("Repaired replicas")
+		}
+	}
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *ReplicatedStorage) repairObject(ctx context.Context, key objectKey, stats *RepairStats) error {
+	stats.Checked++
+
+	n := len(s.replicas)
+	infos := make([]ObjectInfo, n)
+	present := make([]bool, n)
+	for i, r := range s.replicas {
+		info, err := r.Stat(ctx, key.namespace, key.name)
+		if IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		infos[i], present[i] = info, true
+	}
+
+	// Replicas of the same backend type can be compared by checksum
+	consistent := true
+	for i := range s.replicas {
+		if !present[i] || infos[i].Checksum == "" || infos[i].Checksum != infos[0].Checksum {
+			consistent = false
+			break
+		}
+	}
+	if consistent {
+		return nil
+	}
+
+	digests := make([]string, n)
+	for i, r := range s.replicas {
+		if !present[i] {
+			continue
+		}
+		d, err := digest(ctx, r, key.namespace, key.name)
+		if IsNotExist(err) {
+			present[i] = false
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		digests[i] = d
+	}
+
+	src, votes := -1, 0
+	for i, d := range digests {
+		if !present[i] {
+			continue
+		}
+		count := 0
+		for j := range digests {
+			if present[j] && digests[j] == d {
+				count++
+			}
+		}
+		if count > votes {
+			src, votes = i, count
+		}
+	}
+	if src < 0 || votes == n {
+		return nil
+	}
+
+	for i, r := range s.replicas {
+		if present[i] && digests[i] == digests[src] {
+			continue
+		}
+
+		// Do not overwrite a write that happened since the comparison
+		cond := PutCondition{IfAbsent: true}
+		if present[i] {
+			cond = PutCondition{IfMatch: infos[i].Checksum}
+		}
+
+		reader, _, err := s.replicas[src].Get(ctx, key.namespace, key.name)
+		if err != nil {
+			return err
+		}
+		_, err = r.PutIf(ctx, key.namespace, key.name, reader, cond)
+		reader.Close()
+		if stderrors.Is(err, errors.ErrPreconditionFailed) {
+			s.requeue(key)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stats.Repaired++
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package stroage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	serrors "github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// flakyStorage fails every operation while down
+type flakyStorage struct {
+	Storage
+	down atomic.Bool
+}
+
+var errReplicaDown = errors.New("replica down")
+
+func (s *flakyStorage) Put(ctx context.Context, namespace, objname string, obj io.Reader) error {
+	if s.down.Load() {
+		return errReplicaDown
+	}
+	return s.Storage.Put(ctx, namespace, objname, obj)
+}
+
+func (s *flakyStorage) PutIf(ctx context.Context, namespace, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error) {
+	if s.down.Load() {
+		return ObjectInfo{}, errReplicaDown
+	}
+	return s.Storage.PutIf(ctx, namespace, objname, obj, cond)
+}
+
+func (s *flakyStorage) Get(ctx context.Context, namespace, objname string) (io.ReadSeekCloser, ObjectInfo, error) {
+	if s.down.Load() {
+		return nil, ObjectInfo{}, errReplicaDown
+	}
+	return s.Storage.Get(ctx, namespace, objname)
+}
+
+func (s *flakyStorage) Stat(ctx context.Context, namespace, objname string) (ObjectInfo, error) {
+	if s.down.Load() {
+		return ObjectInfo{}, errReplicaDown
+	}
+	return s.Storage.Stat(ctx, namespace, objname)
+}
+
+func (s *flakyStorage) Delete(ctx context.Context, namespace, objname string) error {
+	if s.down.Load() {
+		return errReplicaDown
+	}
+	return s.Storage.Delete(ctx, namespace, objname)
+}
+
+func TestReplicatedStorage(t *testing.T) {
+	ctx := context.Background()
+
+	newReplicated := func(t *testing.T, quorum int) (*ReplicatedStorage, []*flakyStorage) {
+		replicas := []*flakyStorage{{Storage: NewMemoryStorage()}, {Storage: NewMemoryStorage()}, {Storage: NewMemoryStorage()}}
+		storage, err := NewReplicatedStorage([]Storage{replicas[0], replicas[1], replicas[2]}, ReplicaOptions{WriteQuorum: quorum})
+		if err != nil {
+			t.Fatalf("NewReplicatedStorage failed: %v", err)
+		}
+		return storage, replicas
+	}
+
+	t.Run("Writes reach every replica", func(t *testing.T) {
+		storage, replicas := newReplicated(t, 0)
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		for _, r := range replicas {
+			assertContent(t, r, "ns", "obj", "content")
+		}
+	})
+
+	t.Run("Quorum", func(t *testing.T) {
+		storage, replicas := newReplicated(t, 2)
+		replicas[0].down.Store(true)
+
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put with one replica down failed: %v", err)
+		}
+		assertContent(t, storage, "ns", "obj", "content")
+
+		replicas[1].down.Store(true)
+		err := storage.Put(ctx, "ns", "other", strings.NewReader("content"))
+		if !errors.Is(err, serrors.ErrQuorumNotReached) {
+			t.Errorf("Expected ErrQuorumNotReached, got %v", err)
+		}
+	})
+
+	t.Run("Reads skip failed replicas", func(t *testing.T) {
+		storage, replicas := newReplicated(t, 0)
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		replicas[0].down.Store(true)
+		assertContent(t, storage, "ns", "obj", "content")
+		if storage.replicas[0].healthy() {
+			t.Error("Expected the failed replica to be marked unhealthy")
+		}
+	})
+
+	t.Run("Reads fall through to replicas holding the object", func(t *testing.T) {
+		storage, replicas := newReplicated(t, 0)
+		if err := replicas[2].Put(ctx, "ns", "lagging", strings.NewReader("only here")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		assertContent(t, storage, "ns", "lagging", "only here")
+	})
+
+	t.Run("Dirty objects are repaired", func(t *testing.T) {
+		storage, replicas := newReplicated(t, 1)
+		replicas[1].down.Store(true)
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		replicas[1].down.Store(false)
+
+		stats, err := storage.RepairDirty(ctx)
+		if err != nil {
+			t.Fatalf("RepairDirty failed: %v", err)
+		}
+		if stats.Repaired != 1 {
+			t.Errorf("Expected 1 repaired replica, got %+v", stats)
+		}
+		assertContent(t, replicas[1], "ns", "obj", "content")
+
+		if stats, _ := storage.RepairDirty(ctx); stats.Checked != 0 {
+			t.Errorf("Expected nothing left to repair, got %+v", stats)
+		}
+	})
+
+	t.Run("Deletes missing replicas are completed by repair", func(t *testing.T) {
+		for name, repair := range map[string]func(*ReplicatedStorage) (RepairStats, error){
+			"dirty": func(s *ReplicatedStorage) (RepairStats, error) { return s.RepairDirty(ctx) },
+			"full":  func(s *ReplicatedStorage) (RepairStats, error) { return s.Repair(ctx) },
+		} {
+			t.Run(name, func(t *testing.T) {
+				storage, replicas := newReplicated(t, 1)
+				if err := storage.Put(ctx, "ns", "obj", strings.NewReader("content")); err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+
+				replicas[1].down.Store(true)
+				if err := storage.Delete(ctx, "ns", "obj"); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+				replicas[1].down.Store(false)
+
+				stats, err := repair(storage)
+				if err != nil {
+					t.Fatalf("Repair failed: %v", err)
+				}
+				if stats.Repaired != 1 {
+					t.Errorf("Expected 1 repaired replica, got %+v", stats)
+				}
+				for i, r := range replicas {
+					if exists, _ := r.Exists(ctx, "ns", "obj"); exists {
+						t.Errorf("Expected the object to be deleted from replica %d", i)
+					}
+				}
+
+				if stats, _ := storage.Repair(ctx); stats.Checked != 0 {
+					t.Errorf("Expected nothing left to repair, got %+v", stats)
+				}
+			})
+		}
+	})
+
+	t.Run("Writes after a failed delete are kept", func(t *testing.T) {
+		storage, replicas := newReplicated(t, 1)
+		if err := storage.Put(ctx, "ns", "obj", strings.NewReader("old")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		replicas[1].down.Store(true)
+		if err := storage.Delete(ctx, "ns", "obj"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		replicas[1].down.Store(false)
+		if _, err := storage.PutIf(ctx, "ns", "obj", strings.NewReader("new"), PutCondition{}); err != nil {
+			t.Fatalf("PutIf failed: %v", err)
+		}
+
+		if _, err := storage.RepairDirty(ctx); err != nil {
+			t.Fatalf("RepairDirty failed: %v", err)
+		}
+		for _, r := range replicas {
+			assertContent(t, r, "ns", "obj", "new")
+		}
+	})
+
+	t.Run("Full repair uses the majority version", func(t *testing.T) {
+		storage, replicas := newReplicated(t, 0)
+		for i, content := range []string{"stale", "current", "current"} {
+			if err := replicas[i].Put(ctx, "ns", "diverged", strings.NewReader(content)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := replicas[0].Put(ctx, "other", "missing", strings.NewReader("restore me")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		stats, err := storage.Repair(ctx)
+		if err != nil {
+			t.Fatalf("Repair failed: %v", err)
+		}
+		if stats.Checked != 2 || stats.Repaired != 3 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+
+		for _, r := range replicas {
+			assertContent(t, r, "ns", "diverged", "current")
+			assertContent(t, r, "other", "missing", "restore me")
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package stroage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// URLSigner is implemented by storages that can hand out expiring URLs to
+// download an object directly, so large content does not pass through the
+// server. Storages transforming content, such as EncryptedStorage, do not
+// implement it. Downloads through a signed URL are not verified against
+// the recorded checksum.
+type URLSigner interface {
+	// SignedURL returns a URL to GET the object, valid for expiry, which
+	// must not exceed MaxURLExpiry.
+	SignedURL(ctx context.Context, namespace, objname string, expiry time.Duration) (string, error)
+}
+
+// DownloadServer is implemented by storages whose signed URLs point to the
+// web server rather than to the backend. DownloadHandler may return nil
+// if URL signing is not enabled.
+type DownloadServer interface {
+	DownloadHandler() http.Handler
+
+	// DownloadPath is the URL path signed URLs point below, where the web
+	// server mounts DownloadHandler with the path stripped.
+	DownloadPath() string
+}
+
+// MaxURLExpiry is the longest validity of a signed URL, the limit of S3
+const MaxURLExpiry = 7 * 24 * time.Hour
+
+// minSigningKey is the shortest accepted HMAC key
+const minSigningKey = 32
+
+func checkExpiry(expiry time.Duration) error {
+	if expiry <= 0 || expiry > MaxURLExpiry {
+		return errors.ErrBadData.Msg("signed URL expiry out of range")
+	}
+	return nil
+}
+
+// urlSigning holds the settings of FileStorage signed URLs
+type urlSigning struct {
+	baseURL *url.URL
+	key     []byte
+}
+
+// EnableSignedURLs makes s a URLSigner. URLs point below baseURL, where
+// the web server is expected to mount DownloadHandler, and are signed with
+// key, which must be shared by all servers handling downloads. Call it
+// before the storage is used.
+func (s *FileStorage) EnableSignedURLs(baseURL string, key []byte) error {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.ErrBadData.Msg("invalid signed URL base " + baseURL)
+	}
+	if u.Path == "" {
+		// Downloads cannot take over every path of the web server
+		return errors.ErrBadData.Msg("signed URL base " + baseURL + " has no path")
+	}
+	if len(key) < minSigningKey {
+		return errors.ErrBadData.Msg("signed URL key is shorter than 32 bytes")
+	}
+
+	s.signing = &urlSigning{baseURL: u, key: key}
+	return nil
+}
+
+// SignedURL returns an HMAC-signed URL served by DownloadHandler
+func (s *FileStorage) SignedURL(ctx context.Context, namespace, objname string, expiry time.Duration) (string, error) {
+	if s.signing == nil {
+		return "", errors.ErrNotSupported.Msg("signed URLs are not enabled")
+	}
+	if err := checkExpiry(expiry); err != nil {
+		return "", err
+	}
+
+	exists, err := s.Exists(ctx, namespace, objname)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", &os.PathError{Op: "sign", Path: namespace + "/" + objname, Err: os.ErrNotExist}
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+
+	u := *s.signing.baseURL
+	u.Path += "/" + namespace + "/" + objname
+	u.RawPath = ""
+	u.RawQuery = url.Values{
+		"expires":   {expires},
+		"signature": {s.signing.sign(namespace, objname, expires)},
+	}.Encode()
+
+	return u.String(), nil
+}
+
+func (sg *urlSigning) sign(namespace, objname, expires string) string {
+	mac := hmac.New(sha256.New, sg.key)
+	mac.Write([]byte(namespace + "/" + objname + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DownloadPath returns the path of the signed URL base, or an empty string
+// if signed URLs are not enabled
+func (s *FileStorage) DownloadPath() string {
+	if s.signing == nil {
+		return ""
+	}
+	return s.signing.baseURL.Path
+}
+
+// DownloadHandler serves objects requested with URLs from SignedURL, or
+// returns nil if signed URLs are not enabled. It expects paths of the form
+// /namespace/objname, so mount it with the base URL path stripped. Byte
+// ranges are supported.
+func (s *FileStorage) DownloadHandler() http.Handler {
+	if s.signing == nil {
+		return nil
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		namespace, objname, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if !ok || namespace == "" || objname == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		expires := query.Get("expires")
+		signature, err := hex.DecodeString(query.Get("signature"))
+		want, _ := hex.DecodeString(s.signing.sign(namespace, objname, expires))
+		if err != nil || !hmac.Equal(signature, want) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+
+		deadline, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().Unix() > deadline {
+			http.Error(w, "URL expired", http.StatusForbidden)
+			return
+		}
+
+		obj, info, err := s.Get(r.Context(), namespace, objname)
+		if IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to open object", http.StatusInternalServerError)
+			return
+		}
+		defer obj.Close()
+
+		if info.Checksum != "" {
+			w.Header().Set("ETag", `"`+info.Checksum+`"`)
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", info.ModTime, obj)
+	})
+}
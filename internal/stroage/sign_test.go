@@ -0,0 +1,137 @@
+package stroage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStorageSignedURLs(t *testing.T) {
+	ctx := context.Background()
+	key := []byte(strings.Repeat("k", 32))
+
+	storage, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v", err)
+	}
+	if _, err := storage.SignedURL(ctx, "ns", "obj", time.Minute); !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("Expected signing to be disabled, got %v", err)
+	}
+	if storage.DownloadHandler() != nil {
+		t.Error("Expected no download handler")
+	}
+
+	if err := storage.EnableSignedURLs("https://depgit.example/downloads/", key[:16]); err == nil {
+		t.Error("Expected a short key to be rejected")
+	}
+	if err := storage.EnableSignedURLs("https://depgit.example/", key); err == nil {
+		t.Error("Expected a base URL without a path to be rejected")
+	}
+
+	server := httptest.NewServer(nil)
+	defer server.Close()
+	if err := storage.EnableSignedURLs(server.URL+"/downloads", key); err != nil {
+		t.Fatalf("EnableSignedURLs failed: %v", err)
+	}
+	if path := storage.DownloadPath(); path != "/downloads" {
+		t.Errorf("Expected the download path of the base URL, got %q", path)
+	}
+	server.Config.Handler = http.StripPrefix(storage.DownloadPath(), storage.DownloadHandler())
+
+	if err := storage.Put(ctx, "ns", "dir/release 1.0.tar", strings.NewReader("release content")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	get := func(t *testing.T, rawURL string, header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	signed, err := storage.SignedURL(ctx, "ns", "dir/release 1.0.tar", time.Minute)
+	if err != nil {
+		t.Fatalf("SignedURL failed: %v", err)
+	}
+
+	t.Run("Downloads the object", func(t *testing.T) {
+		resp, body := get(t, signed, nil)
+		if resp.StatusCode != http.StatusOK || body != "release content" {
+			t.Errorf("Expected the content, got %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Supports ranges", func(t *testing.T) {
+		resp, body := get(t, signed, http.Header{"Range": {"bytes=8-"}})
+		if resp.StatusCode != http.StatusPartialContent || body != "content" {
+			t.Errorf("Expected the range, got %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Rejects tampered URLs", func(t *testing.T) {
+		u, _ := url.Parse(signed)
+		u.Path = strings.Replace(u.Path, "release", "other", 1)
+		if resp, _ := get(t, u.String(), nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403 for another object, got %d", resp.StatusCode)
+		}
+
+		u, _ = url.Parse(signed)
+		query := u.Query()
+		query.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		u.RawQuery = query.Encode()
+		if resp, _ := get(t, u.String(), nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403 for an extended expiry, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Rejects expired URLs", func(t *testing.T) {
+		expires := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+		query := url.Values{"expires": {expires}, "signature": {storage.signing.sign("ns", "dir/release 1.0.tar", expires)}}
+		if resp, _ := get(t, server.URL+"/downloads/ns/dir/release%201.0.tar?"+query.Encode(), nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Validates requests", func(t *testing.T) {
+		if _, err := storage.SignedURL(ctx, "ns", "missing", time.Minute); !IsNotExist(err) {
+			t.Errorf("Expected a not-exist error, got %v", err)
+		}
+		if _, err := storage.SignedURL(ctx, "ns", "dir/release 1.0.tar", MaxURLExpiry+time.Second); err == nil {
+			t.Error("Expected an expiry beyond MaxURLExpiry to be rejected")
+		}
+	})
+
+	t.Run("Signing passes through the cache", func(t *testing.T) {
+		cached, err := NewCachedStorage(storage, CacheOptions{MemoryBytes: 1 << 20})
+		if err != nil {
+			t.Fatalf("NewCachedStorage failed: %v", err)
+		}
+		if _, err := cached.SignedURL(ctx, "ns", "dir/release 1.0.tar", time.Minute); err != nil {
+			t.Errorf("SignedURL failed: %v", err)
+		}
+		if cached.DownloadHandler() == nil {
+			t.Error("Expected the backend download handler")
+		}
+
+		cached, _ = NewCachedStorage(NewMemoryStorage(), CacheOptions{MemoryBytes: 1 << 20})
+		if _, err := cached.SignedURL(ctx, "ns", "obj", time.Minute); err == nil || !strings.Contains(err.Error(), "cannot sign") {
+			t.Errorf("Expected signing to be unsupported, got %v", err)
+		}
+	})
+}
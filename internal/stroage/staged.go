@@ -0,0 +1,263 @@
+package stroage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+)
+
+// stagedNamespace holds the parts of uploads to wrapping storages
+const stagedNamespace = ".uploads"
+
+// stagedUploads implements the upload methods of MultipartStorage for
+// wrapping storages, which must see the whole content of an object. Parts
+// are stored as objects of st in stagedNamespace, so they are encrypted,
+// compressed or replicated like any object and survive restarts.
+// CompleteUpload streams the parts into the object with a single PutIf,
+// which a wrapped backend supporting multipart uploads may still split.
+type stagedUploads struct {
+	st Storage
+}
+
+type stagedUpload struct {
+	Checksum string `json:"checksum,omitempty"`
+}
+
+const stagedStateName = "upload.json"
+
+// prefix returns the prefix of the staged objects of an upload, validating
+// its ID
+func (u stagedUploads) prefix(namespace, objname, uploadID string) (string, error) {
+	if id, err := hex.DecodeString(uploadID); err != nil || len(id) != 16 {
+		return "", &os.PathError{Op: "open", Path: uploadID, Err: os.ErrNotExist}
+	}
+	return namespace + "/" + objname + "/" + uploadID + "/", nil
+}
+
+// state returns the prefix and state of an existing upload
+func (u stagedUploads) state(ctx context.Context, namespace, objname, uploadID string) (string, stagedUpload, error) {
+	var state stagedUpload
+
+	prefix, err := u.prefix(namespace, objname, uploadID)
+	if err != nil {
+		return "", state, err
+	}
+
+	reader, _, err := u.st.Get(ctx, stagedNamespace, prefix+stagedStateName)
+	if err != nil {
+		return "", state, err
+	}
+	defer reader.Close()
+
+	if err := json.NewDecoder(reader).Decode(&state); err != nil {
+		return "", state, errors.ErrBadData.Msg("malformed upload state").Err(err)
+	}
+	return prefix, state, nil
+}
+
+func stagedPartName(number int) string {
+	return fmt.Sprintf("part-%05d", number)
+}
+
+// CreateUpload starts an upload by storing its state
+func (u stagedUploads) CreateUpload(ctx context.Context, namespace, objname, checksum string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	data, err := json.Marshal(stagedUpload{Checksum: checksum})
+	if err != nil {
+		return "", err
+	}
+
+	prefix, err := u.prefix(namespace, objname, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := u.st.PutIf(ctx, stagedNamespace, prefix+stagedStateName, bytes.NewReader(data), PutCondition{}); err != nil {
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// UploadPart stores one part as an object
+func (u stagedUploads) UploadPart(ctx context.Context, namespace, objname, uploadID string, number int, part io.Reader, size int64) (PartInfo, error) {
+	if number < 1 || number > maxPartNumber {
+		return PartInfo{}, errors.ErrBadData.Msg("invalid part number")
+	}
+
+	prefix, _, err := u.state(ctx, namespace, objname, uploadID)
+	if err != nil {
+		return PartInfo{}, err
+	}
+
+	name := prefix + stagedPartName(number)
+	counted := &countingReader{Reader: io.LimitReader(part, size+1)}
+	info, err := u.st.PutIf(ctx, stagedNamespace, name, counted, PutCondition{})
+	if err != nil {
+		return PartInfo{}, err
+	}
+	if counted.n != size {
+		u.st.Delete(ctx, stagedNamespace, name)
+		return PartInfo{}, errors.ErrBadData.Msg("part size does not match the content")
+	}
+
+	return PartInfo{Number: number, Size: size, ETag: info.Checksum}, nil
+}
+
+// ListParts returns the staged parts of an upload
+func (u stagedUploads) ListParts(ctx context.Context, namespace, objname, uploadID string) ([]PartInfo, error) {
+	prefix, _, err := u.state(ctx, namespace, objname, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []PartInfo
+	for name, err := range Objects(ctx, u.st, stagedNamespace, prefix) {
+		if err != nil {
+			return nil, err
+		}
+
+		var number int
+		base := strings.TrimPrefix(name, prefix)
+		if _, err := fmt.Sscanf(base, "part-%05d", &number); err != nil || base != stagedPartName(number) {
+			continue
+		}
+
+		info, err := u.st.Stat(ctx, stagedNamespace, name)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, PartInfo{Number: number, Size: info.Size, ETag: info.Checksum})
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+// CompleteUpload writes the parts, ordered by number, into the object and
+// removes the upload
+func (u stagedUploads) CompleteUpload(ctx context.Context, namespace, objname, uploadID string, parts []PartInfo) (ObjectInfo, error) {
+	prefix, state, err := u.state(ctx, namespace, objname, uploadID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	for i, p := range parts {
+		if i > 0 && p.Number <= parts[i-1].Number {
+			return ObjectInfo{}, errors.ErrBadData.Msg("parts are not ordered by number")
+		}
+
+		info, err := u.st.Stat(ctx, stagedNamespace, prefix+stagedPartName(p.Number))
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		if info.Size != p.Size {
+			return ObjectInfo{}, errors.ErrBadData.Msg(fmt.Sprintf("part %d does not match the uploaded part", p.Number))
+		}
+	}
+
+	joined := &partsReader{ctx: ctx, st: u.st, prefix: prefix, parts: parts}
+	defer joined.Close()
+
+	info, err := u.st.PutIf(ctx, namespace, objname, newCheckedReader(joined, state.Checksum), PutCondition{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	if err := u.remove(ctx, prefix); err != nil {
+		multipartLog.WithField("namespace", namespace).
+			WithField("object", objname).
+			WithField("upload", uploadID).
+			WithError(err).
+			Warn("Removing completed upload failed")
+	}
+	return info, nil
+}
+
+// AbortUpload removes an upload with its parts
+func (u stagedUploads) AbortUpload(ctx context.Context, namespace, objname, uploadID string) error {
+	prefix, _, err := u.state(ctx, namespace, objname, uploadID)
+	if err != nil {
+		return err
+	}
+	return u.remove(ctx, prefix)
+}
+
+// remove deletes the staged objects of an upload, its state last so an
+// interrupted removal can be retried
+func (u stagedUploads) remove(ctx context.Context, prefix string) error {
+	var names []string
+	for name, err := range Objects(ctx, u.st, stagedNamespace, prefix) {
+		if err != nil {
+			return err
+		}
+		if name != prefix+stagedStateName {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range append(names, prefix+stagedStateName) {
+		if err := u.st.Delete(ctx, stagedNamespace, name); err != nil && !IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// partsReader reads staged parts one after the other, opening each once
+// the previous one is done
+type partsReader struct {
+	ctx    context.Context
+	st     Storage
+	prefix string
+	parts  []PartInfo
+
+	current io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			reader, _, err := r.st.Get(r.ctx, stagedNamespace, r.prefix+stagedPartName(r.parts[0].Number))
+			if err != nil {
+				return 0, err
+			}
+			r.current = reader
+			r.parts = r.parts[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
@@ -4,19 +4,116 @@ package stroage
 
 import (
 	"context"
+	stderrors "errors"
 	"io"
+	"os"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/share/errors"
+	"github.com/minio/minio-go/v7"
 )
 
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+
+	// Checksum identifies the object content. Its format is backend specific
+	// (hex SHA-256 for FileStorage, the ETag for MinioStorage), so checksums
+	// are only comparable between objects of the same backend. Get leaves it
+	// empty when the backend cannot provide it without reading the object.
+	Checksum string
+}
+
+// PutCondition restricts when Storage.PutIf writes an object. The zero
+// value writes unconditionally, replacing any previous content.
+type PutCondition struct {
+	// IfAbsent only writes if no object exists under the name.
+	IfAbsent bool
+
+	// IfMatch only writes if the current object has this checksum, as
+	// reported by Stat or a previous PutIf.
+	IfMatch string
+}
+
 // Storage defines the interface for object storage operations.
 // Implementations must support basic operations like Put, Get, and List
 // while handling namespacing for object organization.
+//
+// Operations on a missing object fail with an error matching os.ErrNotExist,
+// writes to an existing name fail with os.ErrExist.
 type Storage interface {
 	// Put stores an object in the specified namespace with the given name.
 	Put(ctx context.Context, namespace string, objname string, obj io.Reader) error
 
+	// PutIf stores an object if cond holds, replacing any previous content.
+	// A violated condition fails with errors.ErrPreconditionFailed.
+	PutIf(ctx context.Context, namespace string, objname string, obj io.Reader, cond PutCondition) (ObjectInfo, error)
+
 	// Get retrieves an object from the specified namespace by its name.
-	Get(ctx context.Context, namespace string, objname string) (io.Reader, error)
+	// The caller must close the returned reader.
+	Get(ctx context.Context, namespace string, objname string) (io.ReadSeekCloser, ObjectInfo, error)
+
+	// GetRange reads length bytes of an object starting at offset. A negative
+	// length reads up to the end of the object. Ranges starting beyond the
+	// end fail with errors.ErrInvalidRange. The caller must close the reader.
+	GetRange(ctx context.Context, namespace string, objname string, offset, length int64) (io.ReadCloser, error)
 
 	// List returns all objects in the specified namespace.
 	List(ctx context.Context, namespace string) ([]string, error)
+
+	// Namespaces returns the namespaces in lexical order. Namespaces whose
+	// objects were all deleted may still be listed.
+	Namespaces(ctx context.Context) ([]string, error)
+
+	// ListPage returns one page of the objects in the specified namespace,
+	// in lexical order. See Objects for iterating over all pages.
+	ListPage(ctx context.Context, namespace string, opts ListOptions) (ListResult, error)
+
+	// Delete removes an object from the specified namespace.
+	Delete(ctx context.Context, namespace string, objname string) error
+
+	// Stat returns the metadata of an object without reading its content.
+	Stat(ctx context.Context, namespace string, objname string) (ObjectInfo, error)
+
+	// Exists reports whether an object is present.
+	Exists(ctx context.Context, namespace string, objname string) (bool, error)
+
+	// Copy duplicates an object without passing its content through the caller.
+	Copy(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error
+
+	// Move renames an object, possibly into another namespace.
+	Move(ctx context.Context, srcNamespace, srcName, dstNamespace, dstName string) error
+}
+
+// IsNotExist reports whether err means that the requested object does not
+// exist, whichever backend produced it.
+func IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if stderrors.Is(err, os.ErrNotExist) {
+		return true
+	}
+
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NoSuchBucket" || code == "NoSuchUpload"
+}
+
+// rangeReader limits a reader to a range while closing the underlying object
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+// checkRange validates a range against the object size and returns the
+// number of bytes to read.
+func checkRange(size, offset, length int64) (int64, error) {
+	if offset < 0 || offset > size {
+		return 0, errors.ErrInvalidRange
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	return length, nil
 }
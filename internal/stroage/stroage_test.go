@@ -2,11 +2,16 @@ package stroage
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
+	serrors "github.com/GoldenDeals/DepGit/internal/share/errors"
 	"github.com/minio/minio-go/v7"
 )
 
@@ -15,10 +20,89 @@ func TestStorageImplementations(t *testing.T) {
 	// This is a compile-time check to ensure implementations satisfy the Storage interface
 	var _ Storage = (*FileStorage)(nil)
 	var _ Storage = (*MinioStorage)(nil)
+	var _ Storage = (*MemoryStorage)(nil)
+	var _ Storage = (*EncryptedStorage)(nil)
+	var _ Storage = (*CompressedStorage)(nil)
+	var _ Storage = (*CachedStorage)(nil)
+	var _ Storage = (*DualWriteStorage)(nil)
+	var _ Storage = (*ReplicatedStorage)(nil)
+
+	var _ MultipartStorage = (*FileStorage)(nil)
+	var _ MultipartStorage = (*MinioStorage)(nil)
+	var _ MultipartStorage = (*EncryptedStorage)(nil)
+	var _ MultipartStorage = (*CompressedStorage)(nil)
+	var _ MultipartStorage = (*CachedStorage)(nil)
+	var _ MultipartStorage = (*DualWriteStorage)(nil)
+	var _ MultipartStorage = (*ReplicatedStorage)(nil)
+
+	var _ URLSigner = (*FileStorage)(nil)
+	var _ URLSigner = (*MinioStorage)(nil)
+	var _ URLSigner = (*CachedStorage)(nil)
+	var _ DownloadServer = (*FileStorage)(nil)
+	var _ DownloadServer = (*CachedStorage)(nil)
 }
 
 // TestStorageCommon contains common tests that should work on any Storage implementation
 func TestStorageCommon(t *testing.T) {
+	t.Run("MemoryStorage", func(t *testing.T) {
+		runCommonStorageTests(t, NewMemoryStorage())
+	})
+
+	t.Run("EncryptedStorage", func(t *testing.T) {
+		runCommonStorageTests(t, NewEncryptedStorage(NewMemoryStorage(), testKeyring(t, "k1")))
+	})
+
+	t.Run("CompressedStorage", func(t *testing.T) {
+		runCommonStorageTests(t, NewCompressedStorage(NewMemoryStorage(), CodecZstd))
+	})
+
+	t.Run("CompressedEncryptedFileStorage", func(t *testing.T) {
+		storage, err := NewFileStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create FileStorage: %v", err)
+		}
+
+		encrypted := NewEncryptedStorage(storage, testKeyring(t, "k1"))
+		runCommonStorageTests(t, NewCompressedStorage(encrypted, CodecGzip))
+	})
+
+	t.Run("CachedStorage", func(t *testing.T) {
+		for name, immutable := range map[string]func(string, string) bool{
+			"Mutable":   nil,
+			"Immutable": func(string, string) bool { return true },
+		} {
+			t.Run(name, func(t *testing.T) {
+				storage, err := NewCachedStorage(NewMemoryStorage(), CacheOptions{
+					MemoryBytes: 1 << 20,
+					DiskPath:    t.TempDir(),
+					DiskBytes:   1 << 20,
+					Immutable:   immutable,
+				})
+				if err != nil {
+					t.Fatalf("Failed to create CachedStorage: %v", err)
+				}
+				runCommonStorageTests(t, storage)
+			})
+		}
+	})
+
+	t.Run("DualWriteStorage", func(t *testing.T) {
+		runCommonStorageTests(t, NewDualWriteStorage(NewMemoryStorage(), NewMemoryStorage()))
+	})
+
+	t.Run("ReplicatedStorage", func(t *testing.T) {
+		file, err := NewFileStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create FileStorage: %v", err)
+		}
+
+		storage, err := NewReplicatedStorage([]Storage{NewMemoryStorage(), file}, ReplicaOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create ReplicatedStorage: %v", err)
+		}
+		runCommonStorageTests(t, storage)
+	})
+
 	t.Run("FileStorage", func(t *testing.T) {
 		// Create a temporary directory for testing
 		tempDir, err := os.MkdirTemp("", "file-storage-test")
@@ -35,22 +119,24 @@ func TestStorageCommon(t *testing.T) {
 		runCommonStorageTests(t, storage)
 	})
 
-	// Note: MinioStorage test is commented out as it requires a real Minio server
-	// The MinioStorage implementation is tested separately with mocks in minio_test.go
+	t.Run("ShardedFileStorage", func(t *testing.T) {
+		storage, err := NewFileStorageWithOptions(t.TempDir(), FileOptions{Fanout: 2})
+		if err != nil {
+			t.Fatalf("Failed to create FileStorage: %v", err)
+		}
 
-	//Uncomment to test with a real Minio server
-	t.Run("MinioStorage", func(t *testing.T) {
+		runCommonStorageTests(t, storage)
+	})
 
-		// Skip if credentials are not available
-		endpoint := "localhost:9000"
+	t.Run("MinioStorage", func(t *testing.T) {
+		endpoint := os.Getenv(minioEndpointEnv)
+		if endpoint == "" {
+			t.Skip(minioEndpointEnv + " is not set")
+		}
 		accessKey := "minioadmin"
 		secretKey := "minioadmin"
 		bucketName := "depgit-testing"
 
-		if endpoint == "" || accessKey == "" || secretKey == "" || bucketName == "" {
-			t.Skip("Minio credentials not available, skipping test")
-		}
-
 		storage, err := NewMinioStorage(endpoint, accessKey, secretKey, bucketName, false)
 		if err != nil {
 			t.Fatalf("Failed to create MinioStorage: %v", err)
@@ -69,6 +155,13 @@ func TestStorageCommon(t *testing.T) {
 	})
 }
 
+// minioEndpointEnv names the MinIO server the conformance suite also runs
+// against, e.g. "localhost:9000" as started by compose.yaml. Its bucket is
+// emptied first.
+const minioEndpointEnv = "DEPGIT_TEST_MINIO_ENDPOINT"
+
+// runCommonStorageTests is the backend conformance suite. Every Storage
+// implementation must pass it, starting from an empty storage.
 func runCommonStorageTests(t *testing.T, storage Storage) {
 	// Test basic operations
 	namespace := "test-namespace-common"
@@ -84,10 +177,11 @@ func runCommonStorageTests(t *testing.T, storage Storage) {
 	}
 
 	// Test get
-	result, err := storage.Get(ctx, namespace, objName)
+	result, _, err := storage.Get(ctx, namespace, objName)
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
+	defer result.Close()
 
 	// Read and verify content
 	data, err := io.ReadAll(result)
@@ -123,4 +217,445 @@ func runCommonStorageTests(t *testing.T, storage Storage) {
 	if err != os.ErrExist {
 		t.Errorf("Expected os.ErrExist when putting existing file, got %v", err)
 	}
+
+	t.Run("Get metadata and seeking", func(t *testing.T) {
+		result, info, err := storage.Get(ctx, namespace, objName)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		defer result.Close()
+
+		if info.Size != int64(len(content)) {
+			t.Errorf("Expected size %d, got %d", len(content), info.Size)
+		}
+
+		if _, err := result.Seek(7, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		data, err := io.ReadAll(result)
+		if err != nil {
+			t.Fatalf("Failed to read result: %v", err)
+		}
+		if string(data) != content[7:] {
+			t.Errorf("Expected content %q after seek, got %q", content[7:], string(data))
+		}
+
+		_, _, err = storage.Get(ctx, namespace, "missing.txt")
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist for missing object, got %v", err)
+		}
+	})
+
+	t.Run("GetRange", func(t *testing.T) {
+		ranges := []struct {
+			offset, length int64
+			expected       string
+		}{
+			{0, 6, content[:6]},
+			{7, 4, content[7:11]},
+			{7, -1, content[7:]},
+			{7, 1000, content[7:]},
+			{int64(len(content)), 5, ""},
+		}
+
+		for _, r := range ranges {
+			reader, err := storage.GetRange(ctx, namespace, objName, r.offset, r.length)
+			if err != nil {
+				t.Fatalf("GetRange(%d, %d) failed: %v", r.offset, r.length, err)
+			}
+			data, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				t.Fatalf("Failed to read range: %v", err)
+			}
+			if string(data) != r.expected {
+				t.Errorf("GetRange(%d, %d): expected %q, got %q", r.offset, r.length, r.expected, string(data))
+			}
+		}
+
+		_, err := storage.GetRange(ctx, namespace, objName, int64(len(content))+1, 1)
+		if !errors.Is(err, serrors.ErrInvalidRange) {
+			t.Errorf("Expected ErrInvalidRange for offset past the end, got %v", err)
+		}
+
+		_, err = storage.GetRange(ctx, namespace, "missing.txt", 0, 1)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist for missing object, got %v", err)
+		}
+	})
+
+	t.Run("PutIf", func(t *testing.T) {
+		name := "conditional.txt"
+
+		first, err := storage.PutIf(ctx, namespace, name, strings.NewReader("v1"), PutCondition{IfAbsent: true})
+		if err != nil {
+			t.Fatalf("PutIf if-absent on missing object failed: %v", err)
+		}
+		if first.Checksum == "" {
+			t.Errorf("Expected checksum of written object")
+		}
+
+		_, err = storage.PutIf(ctx, namespace, name, strings.NewReader("v2"), PutCondition{IfAbsent: true})
+		if !errors.Is(err, serrors.ErrPreconditionFailed) {
+			t.Errorf("Expected ErrPreconditionFailed for if-absent on existing object, got %v", err)
+		}
+
+		second, err := storage.PutIf(ctx, namespace, name, strings.NewReader("v2"), PutCondition{IfMatch: first.Checksum})
+		if err != nil {
+			t.Fatalf("PutIf with matching checksum failed: %v", err)
+		}
+		assertContent(t, storage, namespace, name, "v2")
+
+		stat, err := storage.Stat(ctx, namespace, name)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if stat.Checksum != second.Checksum {
+			t.Errorf("Expected Stat checksum %q to match PutIf checksum %q", stat.Checksum, second.Checksum)
+		}
+
+		_, err = storage.PutIf(ctx, namespace, name, strings.NewReader("v3"), PutCondition{IfMatch: first.Checksum})
+		if !errors.Is(err, serrors.ErrPreconditionFailed) {
+			t.Errorf("Expected ErrPreconditionFailed for stale checksum, got %v", err)
+		}
+		assertContent(t, storage, namespace, name, "v2")
+
+		_, err = storage.PutIf(ctx, namespace, "missing.txt", strings.NewReader("v1"), PutCondition{IfMatch: first.Checksum})
+		if !errors.Is(err, serrors.ErrPreconditionFailed) {
+			t.Errorf("Expected ErrPreconditionFailed for if-match on missing object, got %v", err)
+		}
+
+		if _, err := storage.PutIf(ctx, namespace, name, strings.NewReader("v4"), PutCondition{}); err != nil {
+			t.Fatalf("Unconditional PutIf failed: %v", err)
+		}
+		assertContent(t, storage, namespace, name, "v4")
+	})
+
+	t.Run("PutIf compare and swap", func(t *testing.T) {
+		name := "counter.txt"
+		if _, err := storage.PutIf(ctx, namespace, name, strings.NewReader("0"), PutCondition{}); err != nil {
+			t.Fatalf("PutIf failed: %v", err)
+		}
+
+		const workers = 8
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					info, err := storage.Stat(ctx, namespace, name)
+					if err != nil {
+						t.Errorf("Stat failed: %v", err)
+						return
+					}
+					result, _, err := storage.Get(ctx, namespace, name)
+					if err != nil {
+						t.Errorf("Get failed: %v", err)
+						return
+					}
+					data, _ := io.ReadAll(result)
+					result.Close()
+
+					n, _ := strconv.Atoi(string(data))
+					_, err = storage.PutIf(ctx, namespace, name, strings.NewReader(strconv.Itoa(n+1)), PutCondition{IfMatch: info.Checksum})
+					if errors.Is(err, serrors.ErrPreconditionFailed) {
+						continue
+					}
+					if err != nil {
+						t.Errorf("PutIf failed: %v", err)
+					}
+					return
+				}
+			}()
+		}
+		wg.Wait()
+
+		assertContent(t, storage, namespace, name, strconv.Itoa(workers))
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		info, err := storage.Stat(ctx, namespace, objName)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+
+		if info.Size != int64(len(content)) {
+			t.Errorf("Expected size %d, got %d", len(content), info.Size)
+		}
+		if info.ModTime.IsZero() {
+			t.Errorf("Expected modification time to be set")
+		}
+		if info.Checksum == "" {
+			t.Errorf("Expected checksum to be set")
+		}
+
+		_, err = storage.Stat(ctx, namespace, "missing.txt")
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist for missing object, got %v", err)
+		}
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		exists, err := storage.Exists(ctx, namespace, objName)
+		if err != nil || !exists {
+			t.Errorf("Expected object to exist, got %v, %v", exists, err)
+		}
+
+		exists, err = storage.Exists(ctx, namespace, "missing.txt")
+		if err != nil || exists {
+			t.Errorf("Expected object not to exist, got %v, %v", exists, err)
+		}
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		err := storage.Copy(ctx, namespace, objName, namespace+"-copy", "nested/copy.txt")
+		if err != nil {
+			t.Fatalf("Copy failed: %v", err)
+		}
+
+		src, err := storage.Stat(ctx, namespace, objName)
+		if err != nil {
+			t.Fatalf("Stat of source failed: %v", err)
+		}
+		dst, err := storage.Stat(ctx, namespace+"-copy", "nested/copy.txt")
+		if err != nil {
+			t.Fatalf("Stat of copy failed: %v", err)
+		}
+		if src.Checksum != dst.Checksum {
+			t.Errorf("Expected copy checksum %q, got %q", src.Checksum, dst.Checksum)
+		}
+
+		err = storage.Copy(ctx, namespace, objName, namespace+"-copy", "nested/copy.txt")
+		if err != os.ErrExist {
+			t.Errorf("Expected os.ErrExist when copying onto existing object, got %v", err)
+		}
+
+		err = storage.Copy(ctx, namespace, "missing.txt", namespace, "other.txt")
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist when copying missing object, got %v", err)
+		}
+	})
+
+	t.Run("Move", func(t *testing.T) {
+		err := storage.Move(ctx, namespace+"-copy", "nested/copy.txt", namespace, "moved.txt")
+		if err != nil {
+			t.Fatalf("Move failed: %v", err)
+		}
+
+		if exists, _ := storage.Exists(ctx, namespace+"-copy", "nested/copy.txt"); exists {
+			t.Errorf("Expected source to be gone after move")
+		}
+
+		assertContent(t, storage, namespace, "moved.txt", content)
+
+		err = storage.Move(ctx, namespace, "moved.txt", namespace, objName)
+		if err != os.ErrExist {
+			t.Errorf("Expected os.ErrExist when moving onto existing object, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := storage.Delete(ctx, namespace, "moved.txt"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if exists, _ := storage.Exists(ctx, namespace, "moved.txt"); exists {
+			t.Errorf("Expected object to be gone after delete")
+		}
+
+		err := storage.Delete(ctx, namespace, "moved.txt")
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist when deleting missing object, got %v", err)
+		}
+
+		// A deleted name can be written again
+		if err := storage.Put(ctx, namespace, "moved.txt", strings.NewReader("again")); err != nil {
+			t.Errorf("Put after delete failed: %v", err)
+		}
+	})
+
+	t.Run("Namespaces are isolated", func(t *testing.T) {
+		for _, ns := range []string{"isolated-a", "isolated-b"} {
+			if err := storage.Put(ctx, ns, "same/name.txt", strings.NewReader(ns)); err != nil {
+				t.Fatalf("Put into %s failed: %v", ns, err)
+			}
+		}
+
+		assertContent(t, storage, "isolated-a", "same/name.txt", "isolated-a")
+		assertContent(t, storage, "isolated-b", "same/name.txt", "isolated-b")
+
+		listed, err := storage.List(ctx, "isolated-a")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(listed) != 1 || listed[0] != "same/name.txt" {
+			t.Errorf("Expected only same/name.txt in namespace, got %v", listed)
+		}
+
+		namespaces, err := storage.Namespaces(ctx)
+		if err != nil {
+			t.Fatalf("Namespaces failed: %v", err)
+		}
+		if !slices.Contains(namespaces, "isolated-a") || !slices.Contains(namespaces, "isolated-b") || slices.Contains(namespaces, "isolated-empty") {
+			t.Errorf("Expected both isolated namespaces, got %v", namespaces)
+		}
+		if !slices.IsSorted(namespaces) {
+			t.Errorf("Expected sorted namespaces, got %v", namespaces)
+		}
+
+		listed, err = storage.List(ctx, "isolated-empty")
+		if err != nil {
+			t.Fatalf("List of empty namespace failed: %v", err)
+		}
+		if len(listed) != 0 {
+			t.Errorf("Expected empty namespace listing, got %v", listed)
+		}
+	})
+
+	t.Run("DeleteNamespace", func(t *testing.T) {
+		for _, name := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"} {
+			if err := storage.Put(ctx, "doomed", name, strings.NewReader(name)); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := storage.Put(ctx, "spared", "a.txt", strings.NewReader("spared")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		if err := DeleteNamespace(ctx, storage, "doomed"); err != nil {
+			t.Fatalf("DeleteNamespace failed: %v", err)
+		}
+
+		listed, err := storage.List(ctx, "doomed")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(listed) != 0 {
+			t.Errorf("Expected empty namespace after delete, got %v", listed)
+		}
+		assertContent(t, storage, "spared", "a.txt", "spared")
+
+		for _, namespace := range []string{"", ".", "..", "../spared", "doomed/dir", ".locks"} {
+			if err := DeleteNamespace(ctx, storage, namespace); err == nil {
+				t.Errorf("Expected DeleteNamespace of %q to fail", namespace)
+			}
+		}
+		assertContent(t, storage, "spared", "a.txt", "spared")
+	})
+
+	t.Run("ListPage", func(t *testing.T) {
+		ns := "paged"
+		names := []string{"a-b", "a/b", "a/c/d", "a/c/e", "b", "c/x"}
+		for _, name := range names {
+			if err := storage.Put(ctx, ns, name, strings.NewReader(name)); err != nil {
+				t.Fatalf("Put of %s failed: %v", name, err)
+			}
+		}
+
+		// listAll follows cursors and returns objects and prefixes in page order
+		listAll := func(opts ListOptions) []string {
+			var entries []string
+			for pages := 0; ; pages++ {
+				if pages > len(names) {
+					t.Fatalf("Listing did not terminate")
+				}
+
+				page, err := storage.ListPage(ctx, ns, opts)
+				if err != nil {
+					t.Fatalf("ListPage failed: %v", err)
+				}
+				if opts.PageSize > 0 && len(page.Objects)+len(page.Prefixes) > opts.PageSize {
+					t.Errorf("Page exceeds page size %d: %v %v", opts.PageSize, page.Objects, page.Prefixes)
+				}
+
+				entries = append(entries, page.Objects...)
+				entries = append(entries, page.Prefixes...)
+				if page.NextCursor == "" {
+					return entries
+				}
+				opts.Cursor = page.NextCursor
+			}
+		}
+
+		cases := []struct {
+			name     string
+			opts     ListOptions
+			expected []string
+		}{
+			{"Recursive", ListOptions{}, names},
+			{"Recursive paged", ListOptions{PageSize: 2}, names},
+			{"Prefix", ListOptions{Prefix: "a", PageSize: 3}, []string{"a-b", "a/b", "a/c/d", "a/c/e"}},
+			{"Nested prefix", ListOptions{Prefix: "a/c/"}, []string{"a/c/d", "a/c/e"}},
+			{"Delimiter", ListOptions{Delimiter: "/"}, []string{"a-b", "b", "a/", "c/"}},
+			{"Delimiter paged", ListOptions{Delimiter: "/", PageSize: 1}, []string{"a-b", "a/", "b", "c/"}},
+			{"Delimiter below prefix", ListOptions{Prefix: "a/", Delimiter: "/", PageSize: 1}, []string{"a/b", "a/c/"}},
+			{"No match", ListOptions{Prefix: "z"}, nil},
+		}
+
+		for _, tc := range cases {
+			got := listAll(tc.opts)
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+			}
+		}
+
+		if _, err := storage.ListPage(ctx, ns, ListOptions{Delimiter: ":"}); err == nil {
+			t.Errorf("Expected error for unsupported delimiter")
+		}
+
+		var iterated []string
+		for name, err := range Objects(ctx, storage, ns, "a/") {
+			if err != nil {
+				t.Fatalf("Objects failed: %v", err)
+			}
+			iterated = append(iterated, name)
+		}
+		if strings.Join(iterated, ",") != "a/b,a/c/d,a/c/e" {
+			t.Errorf("Expected Objects to yield a/b,a/c/d,a/c/e, got %v", iterated)
+		}
+	})
+
+	t.Run("Concurrent puts", func(t *testing.T) {
+		const workers = 16
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := "object-" + strconv.Itoa(i)
+				if err := storage.Put(ctx, "concurrent", name, strings.NewReader(name)); err != nil {
+					t.Errorf("Put of %s failed: %v", name, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		listed, err := storage.List(ctx, "concurrent")
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(listed) != workers {
+			t.Errorf("Expected %d objects, got %d", workers, len(listed))
+		}
+	})
+}
+
+func assertContent(t *testing.T, storage Storage, namespace, objName, content string) {
+	t.Helper()
+
+	result, _, err := storage.Get(context.Background(), namespace, objName)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer result.Close()
+
+	data, err := io.ReadAll(result)
+	if err != nil {
+		t.Fatalf("Failed to read result: %v", err)
+	}
+
+	if string(data) != content {
+		t.Errorf("Expected content %q, got %q", content, string(data))
+	}
 }
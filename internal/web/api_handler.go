@@ -2,15 +2,21 @@
 package web
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"math"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/GoldenDeals/DepGit/internal/database"
 	"github.com/GoldenDeals/DepGit/internal/gen/api"
+	"github.com/GoldenDeals/DepGit/internal/git"
+	dberror "github.com/GoldenDeals/DepGit/internal/share/errors"
 	"github.com/GoldenDeals/DepGit/internal/share/logger"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	openapi_types "github.com/oapi-codegen/runtime/types"
@@ -20,21 +26,61 @@ var webLogger = logger.New("web")
 
 // APIHandler implements the ServerInterface from the generated API code
 type APIHandler struct {
-	db *database.DB
+	store    database.Store
+	users    database.UserStore
+	keys     database.KeyStore
+	repos    database.RepoStore
+	roles    database.RoleStore
+	trash    database.TrashStore
+	verifier *git.Verifier
+	signer   stroage.URLSigner
+	storage  stroage.Storage
 }
 
-// NewAPIHandler creates a new API handler with the given database connection
-func NewAPIHandler(db *database.DB) *APIHandler {
+// NewAPIHandler creates a new API handler on top of the given data layer,
+// usually a *database.DB. verifier should be the one the git server checks
+// pushes with, so that key changes made through the API reach both; nil
+// creates one for the handler alone.
+func NewAPIHandler(store database.Store, verifier *git.Verifier) *APIHandler {
+	if verifier == nil {
+		verifier = git.NewVerifier(store)
+	}
 	return &APIHandler{
-		db: db,
+		store:    store,
+		users:    store,
+		keys:     store,
+		repos:    store,
+		roles:    store,
+		trash:    store,
+		verifier: verifier,
 	}
 }
 
+// SetURLSigner lets GetDownloadUrl hand out signed URLs of signer, usually
+// the storage holding the repositories
+func (h *APIHandler) SetURLSigner(signer stroage.URLSigner) {
+	h.signer = signer
+}
+
+// SetStorage lets CreateRepo initialise the storage of new repositories
+func (h *APIHandler) SetStorage(storage stroage.Storage) {
+	h.storage = storage
+}
+
 // GetUsers handles the GET /users endpoint
 func (h *APIHandler) GetUsers(ctx echo.Context, params api.GetUsersParams) error {
-	// Get users from database
-	users, err := h.db.GetUsers(ctx.Request().Context())
+	// Roles are not stored yet and every user has defaultUserRole, so the
+	// role filter keeps either every page or none, never part of one
+	if params.Role != nil && string(*params.Role) != string(defaultUserRole) {
+		return ctx.JSON(http.StatusOK, []api.User{})
+	}
+
+	// Get a page of users from database
+	page, err := h.users.ListUsers(ctx.Request().Context(), listOptions(params.Limit, params.Cursor, params.Q, params.Sort))
 	if err != nil {
+		if errors.Is(err, dberror.ErrBadData) {
+			return badPageRequest(ctx)
+		}
 		webLogger.WithError(err).Error("Failed to get users")
 		return ctx.JSON(http.StatusInternalServerError, api.Error{
 			Code:    intPtr(http.StatusInternalServerError),
@@ -43,20 +89,12 @@ func (h *APIHandler) GetUsers(ctx echo.Context, params api.GetUsersParams) error
 	}
 
 	// Convert database users to API users
-	apiUsers := make([]api.User, 0, len(users))
-	for _, user := range users {
-		apiUser := dbUserToAPIUser(&user)
-
-		// Filter by role if specified
-		if params.Role != nil {
-			if apiUser.Role == nil || string(*apiUser.Role) != string(*params.Role) {
-				continue
-			}
-		}
-
-		apiUsers = append(apiUsers, apiUser)
+	apiUsers := make([]api.User, 0, len(page.Items))
+	for _, user := range page.Items {
+		apiUsers = append(apiUsers, dbUserToAPIUser(&user))
 	}
 
+	setNextPage(ctx, page.NextCursor)
 	return ctx.JSON(http.StatusOK, apiUsers)
 }
 
@@ -89,7 +127,7 @@ func (h *APIHandler) CreateUser(ctx echo.Context) error {
 	}
 
 	// Save user to database
-	err := h.db.CreateUser(ctx.Request().Context(), &dbUser)
+	err := h.users.CreateUser(ctx.Request().Context(), &dbUser)
 	if err != nil {
 		webLogger.WithError(err).Error("Failed to create user")
 		return ctx.JSON(http.StatusInternalServerError, api.Error{
@@ -109,9 +147,9 @@ func (h *APIHandler) GetUser(ctx echo.Context, userId openapi_types.UUID) error
 	dbID := userId
 
 	// Get user from database
-	user, err := h.db.GetUser(ctx.Request().Context(), dbID)
+	user, err := h.users.GetUser(ctx.Request().Context(), dbID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if isNotFound(err) {
 			return ctx.JSON(http.StatusNotFound, api.Error{
 				Code:    intPtr(http.StatusNotFound),
 				Message: strPtr("User not found"),
@@ -144,9 +182,9 @@ func (h *APIHandler) UpdateUser(ctx echo.Context, userId openapi_types.UUID) err
 	dbID := userId
 
 	// Get existing user
-	existingUser, err := h.db.GetUser(ctx.Request().Context(), dbID)
+	existingUser, err := h.users.GetUser(ctx.Request().Context(), dbID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if isNotFound(err) {
 			return ctx.JSON(http.StatusNotFound, api.Error{
 				Code:    intPtr(http.StatusNotFound),
 				Message: strPtr("User not found"),
@@ -177,7 +215,7 @@ func (h *APIHandler) UpdateUser(ctx echo.Context, userId openapi_types.UUID) err
 	existingUser.Edited = time.Now()
 
 	// Save updated user
-	err = h.db.EditUser(ctx.Request().Context(), dbID, &existingUser)
+	err = h.users.EditUser(ctx.Request().Context(), dbID, &existingUser)
 	if err != nil {
 		webLogger.WithError(err).Error("Failed to update user")
 		return ctx.JSON(http.StatusInternalServerError, api.Error{
@@ -197,9 +235,9 @@ func (h *APIHandler) DeleteUser(ctx echo.Context, userId openapi_types.UUID) err
 	dbID := userId
 
 	// Delete user from database
-	err := h.db.DeleteUser(ctx.Request().Context(), dbID)
+	err := h.users.DeleteUser(ctx.Request().Context(), dbID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if isNotFound(err) {
 			return ctx.JSON(http.StatusNotFound, api.Error{
 				Code:    intPtr(http.StatusNotFound),
 				Message: strPtr("User not found"),
@@ -211,19 +249,21 @@ func (h *APIHandler) DeleteUser(ctx echo.Context, userId openapi_types.UUID) err
 			Message: strPtr("Failed to delete user"),
 		})
 	}
+	// The user's keys were deleted along with it
+	h.verifier.Invalidate()
 
 	return ctx.NoContent(http.StatusNoContent)
 }
 
 // GetSshKeys handles the GET /users/{userId}/ssh-keys endpoint
-func (h *APIHandler) GetSshKeys(ctx echo.Context, userId openapi_types.UUID) error {
+func (h *APIHandler) GetSshKeys(ctx echo.Context, userId openapi_types.UUID, params api.GetSshKeysParams) error {
 	// UUID is already a valid UUID object, just use it directly
 	dbID := userId
 
 	// Check if user exists
-	_, err := h.db.GetUser(ctx.Request().Context(), dbID)
+	_, err := h.users.GetUser(ctx.Request().Context(), dbID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if isNotFound(err) {
 			return ctx.JSON(http.StatusNotFound, api.Error{
 				Code:    intPtr(http.StatusNotFound),
 				Message: strPtr("User not found"),
@@ -236,16 +276,26 @@ func (h *APIHandler) GetSshKeys(ctx echo.Context, userId openapi_types.UUID) err
 		})
 	}
 
-	// Get SSH keys from database
-	// TODO: Implement GetSshKeys in database package
-	keys := []database.SshKey{}
+	// Get a page of SSH keys from database
+	page, err := h.keys.ListSshKeys(ctx.Request().Context(), dbID, listOptions(params.Limit, params.Cursor, params.Q, params.Sort))
+	if err != nil {
+		if errors.Is(err, dberror.ErrBadData) {
+			return badPageRequest(ctx)
+		}
+		webLogger.WithError(err).Error("Failed to get SSH keys")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to get SSH keys"),
+		})
+	}
 
 	// Convert database keys to API keys
-	apiKeys := make([]api.SshKey, 0, len(keys))
-	for i := range keys {
-		apiKeys = append(apiKeys, dbSshKeyToAPISshKey(&keys[i]))
+	apiKeys := make([]api.SshKey, 0, len(page.Items))
+	for i := range page.Items {
+		apiKeys = append(apiKeys, dbSshKeyToAPISshKey(&page.Items[i]))
 	}
 
+	setNextPage(ctx, page.NextCursor)
 	return ctx.JSON(http.StatusOK, apiKeys)
 }
 
@@ -264,9 +314,9 @@ func (h *APIHandler) AddSshKey(ctx echo.Context, userId openapi_types.UUID) erro
 	dbID := userId
 
 	// Check if user exists
-	_, err := h.db.GetUser(ctx.Request().Context(), dbID)
+	_, err := h.users.GetUser(ctx.Request().Context(), dbID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if isNotFound(err) {
 			return ctx.JSON(http.StatusNotFound, api.Error{
 				Code:    intPtr(http.StatusNotFound),
 				Message: strPtr("User not found"),
@@ -296,7 +346,7 @@ func (h *APIHandler) AddSshKey(ctx echo.Context, userId openapi_types.UUID) erro
 	}
 
 	// Save SSH key to database
-	err2 := h.db.AddSshKey(ctx.Request().Context(), dbID, &dbKey)
+	err2 := h.keys.AddSshKey(ctx.Request().Context(), dbID, &dbKey)
 	if err2 != nil {
 		webLogger.WithError(err2).Error("Failed to add SSH key")
 		return ctx.JSON(http.StatusInternalServerError, api.Error{
@@ -304,6 +354,7 @@ func (h *APIHandler) AddSshKey(ctx echo.Context, userId openapi_types.UUID) erro
 			Message: strPtr("Failed to add SSH key"),
 		})
 	}
+	h.verifier.Invalidate()
 
 	// Convert to API key and return
 	apiKey := dbSshKeyToAPISshKey(&dbKey)
@@ -316,9 +367,9 @@ func (h *APIHandler) DeleteSshKey(ctx echo.Context, keyId openapi_types.UUID) er
 	dbID := keyId
 
 	// Delete SSH key from database
-	err := h.db.DeleteSshKey(ctx.Request().Context(), dbID)
+	err := h.keys.DeleteSshKey(ctx.Request().Context(), dbID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if isNotFound(err) {
 			return ctx.JSON(http.StatusNotFound, api.Error{
 				Code:    intPtr(http.StatusNotFound),
 				Message: strPtr("SSH key not found"),
@@ -330,19 +381,260 @@ func (h *APIHandler) DeleteSshKey(ctx echo.Context, keyId openapi_types.UUID) er
 			Message: strPtr("Failed to delete SSH key"),
 		})
 	}
+	h.verifier.Invalidate()
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// GetRepos handles the GET /repos endpoint
+func (h *APIHandler) GetRepos(ctx echo.Context, params api.GetReposParams) error {
+	page, err := h.repos.ListRepos(ctx.Request().Context(), listOptions(params.Limit, params.Cursor, params.Q, params.Sort))
+	if err != nil {
+		if errors.Is(err, dberror.ErrBadData) {
+			return badPageRequest(ctx)
+		}
+		webLogger.WithError(err).Error("Failed to get repositories")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to get repositories"),
+		})
+	}
+
+	apiRepos := make([]api.Repo, 0, len(page.Items))
+	for i := range page.Items {
+		apiRepos = append(apiRepos, dbRepoToAPIRepo(&page.Items[i]))
+	}
+
+	setNextPage(ctx, page.NextCursor)
+	return ctx.JSON(http.StatusOK, apiRepos)
+}
+
+// GetAccessRoles handles the GET /repos/{repoId}/access-roles endpoint
+func (h *APIHandler) GetAccessRoles(ctx echo.Context, repoId openapi_types.UUID, params api.GetAccessRolesParams) error {
+	// Check if repository exists
+	_, err := h.repos.GetRepo(ctx.Request().Context(), repoId)
+	if err != nil {
+		if isNotFound(err) {
+			return ctx.JSON(http.StatusNotFound, api.Error{
+				Code:    intPtr(http.StatusNotFound),
+				Message: strPtr("Repository not found"),
+			})
+		}
+		webLogger.WithError(err).Error("Failed to get repository for access roles")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to get repository for access roles"),
+		})
+	}
+
+	page, err := h.roles.ListAccessRoles(ctx.Request().Context(), repoId, listOptions(params.Limit, params.Cursor, nil, params.Sort))
+	if err != nil {
+		if errors.Is(err, dberror.ErrBadData) {
+			return badPageRequest(ctx)
+		}
+		webLogger.WithError(err).Error("Failed to get access roles")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to get access roles"),
+		})
+	}
+
+	apiRoles := make([]api.AccessRole, 0, len(page.Items))
+	for i := range page.Items {
+		apiRoles = append(apiRoles, dbAccessRoleToAPI(&page.Items[i]))
+	}
+
+	setNextPage(ctx, page.NextCursor)
+	return ctx.JSON(http.StatusOK, apiRoles)
+}
+
+// defaultURLExpiry is the validity of signed URLs if not requested
+const defaultURLExpiry = 15 * time.Minute
+
+// GetDownloadUrl handles the GET /repos/{repoId}/download-url endpoint
+func (h *APIHandler) GetDownloadUrl(ctx echo.Context, repoId openapi_types.UUID, params api.GetDownloadUrlParams) error {
+	if h.signer == nil {
+		return ctx.JSON(http.StatusNotImplemented, api.Error{
+			Code:    intPtr(http.StatusNotImplemented),
+			Message: strPtr("Storage cannot sign URLs"),
+		})
+	}
+
+	expiry := defaultURLExpiry
+	if params.ExpiresIn != nil {
+		if *params.ExpiresIn < 1 || *params.ExpiresIn > int(stroage.MaxURLExpiry/time.Second) {
+			return ctx.JSON(http.StatusBadRequest, api.Error{
+				Code:    intPtr(http.StatusBadRequest),
+				Message: strPtr("Invalid expiresIn"),
+			})
+		}
+		expiry = time.Duration(*params.ExpiresIn) * time.Second
+	}
+
+	repo, err := h.repos.GetRepo(ctx.Request().Context(), repoId)
+	if err != nil {
+		if isNotFound(err) {
+			return ctx.JSON(http.StatusNotFound, api.Error{
+				Code:    intPtr(http.StatusNotFound),
+				Message: strPtr("Repository not found"),
+			})
+		}
+		webLogger.WithError(err).Error("Failed to get repository for download")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to get repository for download"),
+		})
+	}
+
+	expiresAt := time.Now().Add(expiry)
+	signed, err := h.signer.SignedURL(ctx.Request().Context(), repo.Name, params.Object, expiry)
+	switch {
+	case err == nil:
+		return ctx.JSON(http.StatusOK, api.DownloadUrl{Url: signed, ExpiresAt: expiresAt})
+	case stroage.IsNotExist(err):
+		return ctx.JSON(http.StatusNotFound, api.Error{
+			Code:    intPtr(http.StatusNotFound),
+			Message: strPtr("Object not found"),
+		})
+	case errors.Is(err, dberror.ErrNotSupported):
+		return ctx.JSON(http.StatusNotImplemented, api.Error{
+			Code:    intPtr(http.StatusNotImplemented),
+			Message: strPtr("Storage cannot sign URLs"),
+		})
+	case errors.Is(err, dberror.ErrBadData):
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Invalid object name"),
+		})
+	default:
+		webLogger.WithError(err).Error("Failed to sign download URL")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to sign download URL"),
+		})
+	}
+}
+
+// GetDeletedRecords handles the GET /admin/trash/{entity} endpoint
+func (h *APIHandler) GetDeletedRecords(ctx echo.Context, entity api.TrashEntity) error {
+	if !slices.Contains(database.Entities, database.Entity(entity)) {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Unknown entity"),
+		})
+	}
+
+	records, err := h.trash.GetDeleted(ctx.Request().Context(), database.Entity(entity))
+	if err != nil {
+		webLogger.WithError(err).Error("Failed to get deleted records")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to get deleted records"),
+		})
+	}
+
+	apiRecords := make([]api.DeletedRecord, 0, len(records))
+	for i := range records {
+		apiRecords = append(apiRecords, dbDeletedRecordToAPI(&records[i]))
+	}
+
+	return ctx.JSON(http.StatusOK, apiRecords)
+}
+
+// RestoreDeletedRecord handles the POST /admin/trash/{entity}/{recordId}/restore endpoint
+func (h *APIHandler) RestoreDeletedRecord(ctx echo.Context, entity api.TrashEntity, recordId openapi_types.UUID) error {
+	if !slices.Contains(database.Entities, database.Entity(entity)) {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Unknown entity"),
+		})
+	}
+
+	err := h.trash.Restore(ctx.Request().Context(), database.Entity(entity), recordId)
+	if err != nil {
+		if isNotFound(err) {
+			return ctx.JSON(http.StatusNotFound, api.Error{
+				Code:    intPtr(http.StatusNotFound),
+				Message: strPtr("Deleted record not found"),
+			})
+		}
+		if errors.Is(err, dberror.ErrBadData) {
+			return ctx.JSON(http.StatusConflict, api.Error{
+				Code:    intPtr(http.StatusConflict),
+				Message: strPtr("The record references a deleted record, restore that first"),
+			})
+		}
+		webLogger.WithError(err).Error("Failed to restore record")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to restore record"),
+		})
+	}
+	// Restored users and keys may sign again
+	h.verifier.Invalidate()
 
 	return ctx.NoContent(http.StatusNoContent)
 }
 
+// VerifySignature handles the POST /signatures/verify endpoint
+func (h *APIHandler) VerifySignature(ctx echo.Context) error {
+	var req api.VerifySignatureJSONRequestBody
+	if err := ctx.Bind(&req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Invalid request body"),
+		})
+	}
+
+	obj := &plumbing.MemoryObject{}
+	switch req.Type {
+	case api.Commit:
+		obj.SetType(plumbing.CommitObject)
+	case api.Tag:
+		obj.SetType(plumbing.TagObject)
+	default:
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Object type must be commit or tag"),
+		})
+	}
+	if _, err := obj.Write(req.Object); err != nil {
+		webLogger.WithError(err).Error("Failed to buffer object")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to verify signature"),
+		})
+	}
+
+	res, err := h.verifier.Verify(ctx.Request().Context(), obj)
+	if err != nil {
+		if errors.Is(err, dberror.ErrBadData) {
+			return ctx.JSON(http.StatusBadRequest, api.Error{
+				Code:    intPtr(http.StatusBadRequest),
+				Message: strPtr("Malformed object"),
+			})
+		}
+		webLogger.WithError(err).Error("Failed to verify signature")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to verify signature"),
+		})
+	}
+
+	return ctx.JSON(http.StatusOK, verificationToAPI(&res))
+}
+
 // Helper functions for converting between database and API models
 
+// defaultUserRole is the role of every user until roles are stored
+const defaultUserRole = api.UserRoleDeveloper
+
 func dbUserToAPIUser(dbUser *database.User) api.User {
 	// UUID is already a valid UUID object
 	id := dbUser.ID
 	email := openapi_types.Email(dbUser.Email)
 
-	// Default role to Developer
-	role := api.UserRoleDeveloper
+	role := defaultUserRole
 
 	return api.User{
 		Id:        &id,
@@ -370,6 +662,121 @@ func dbSshKeyToAPISshKey(dbKey *database.SshKey) api.SshKey {
 	}
 }
 
+func dbRepoToAPIRepo(dbRepo *database.Repo) api.Repo {
+	id := dbRepo.ID
+
+	res := api.Repo{
+		Id:        &id,
+		Name:      dbRepo.Name,
+		CreatedAt: &dbRepo.Created,
+	}
+	if !dbRepo.Edited.IsZero() {
+		res.UpdatedAt = &dbRepo.Edited
+	}
+	return res
+}
+
+func dbAccessRoleToAPI(dbRole *database.AccessRole) api.AccessRole {
+	id := dbRole.RoleID
+
+	// Roles do not record an access level yet, every role grants pushing
+	return api.AccessRole{
+		Id:        &id,
+		UserId:    dbRole.UserID,
+		RepoId:    dbRole.RepoID,
+		Role:      api.Write,
+		CreatedAt: &dbRole.Created,
+	}
+}
+
+func dbDeletedRecordToAPI(rec *database.DeletedRecord) api.DeletedRecord {
+	res := api.DeletedRecord{
+		Entity:    api.TrashEntity(rec.Entity),
+		Id:        rec.ID,
+		DeletedAt: rec.Deleted,
+	}
+	if rec.Name != "" {
+		res.Name = strPtr(rec.Name)
+	}
+	return res
+}
+
+func verificationToAPI(v *git.Verification) api.SignatureVerification {
+	res := api.SignatureVerification{
+		ObjectId: v.ObjectID.String(),
+		Verified: v.Verified(),
+		Status:   api.SignatureVerificationStatus(v.Status),
+	}
+	if v.Format != "" {
+		format := api.SignatureVerificationFormat(v.Format)
+		res.Format = &format
+	}
+	if v.KeyID != uuid.Nil {
+		keyID, userID := v.KeyID, v.UserID
+		res.KeyId = &keyID
+		res.UserId = &userID
+	}
+	if v.Fingerprint != "" {
+		res.Fingerprint = strPtr(v.Fingerprint)
+	}
+	if v.Reason != "" {
+		res.Reason = strPtr(v.Reason)
+	}
+
+	return res
+}
+
+// listOptions converts the paging query parameters of a list endpoint
+func listOptions(limit *int, cursor, filter, sort *string) database.ListOptions {
+	var opts database.ListOptions
+	if limit != nil {
+		opts.Limit = *limit
+		if opts.Limit == 0 {
+			// Zero would fall back to the default page size
+			opts.Limit = -1
+		}
+	}
+	if cursor != nil {
+		opts.Cursor = *cursor
+	}
+	if filter != nil {
+		opts.Filter = *filter
+	}
+	if sort != nil {
+		opts.Sort = *sort
+	}
+	return opts
+}
+
+// setNextPage points the client to the next page of a listing with the
+// Link and X-Next-Cursor headers, unless this is the last page
+func setNextPage(ctx echo.Context, cursor string) {
+	if cursor == "" {
+		return
+	}
+
+	next := *ctx.Request().URL
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+
+	header := ctx.Response().Header()
+	header.Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	header.Set("X-Next-Cursor", cursor)
+}
+
+func badPageRequest(ctx echo.Context) error {
+	return ctx.JSON(http.StatusBadRequest, api.Error{
+		Code:    intPtr(http.StatusBadRequest),
+		Message: strPtr("Invalid limit, cursor or sort"),
+	})
+}
+
+// isNotFound reports whether err means the requested record does not exist
+func isNotFound(err error) bool {
+	return errors.Is(err, dberror.ErrNotFound) || errors.Is(err, sql.ErrNoRows)
+}
+
 // Helper functions for creating pointers to primitives
 
 func strPtr(s string) *string {
@@ -461,18 +868,82 @@ func (h *APIHandler) GetGitInfo(ctx echo.Context) error {
 	})
 }
 
-func (h *APIHandler) GetRepos(ctx echo.Context) error {
-	return ctx.JSON(http.StatusNotImplemented, api.Error{
-		Code:    intPtr(http.StatusNotImplemented),
-		Message: strPtr("Not implemented"),
-	})
-}
-
+// CreateRepo handles the POST /repos endpoint. The owner gets a role on the
+// new repository, whose storage is initialised along with it.
 func (h *APIHandler) CreateRepo(ctx echo.Context) error {
-	return ctx.JSON(http.StatusNotImplemented, api.Error{
-		Code:    intPtr(http.StatusNotImplemented),
-		Message: strPtr("Not implemented"),
-	})
+	var reqRepo api.Repo
+	if err := ctx.Bind(&reqRepo); err != nil {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Invalid request body"),
+		})
+	}
+
+	if reqRepo.Name == "" || reqRepo.Owner == nil || *reqRepo.Owner == "" {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Name and owner are required"),
+		})
+	}
+	// The name is the storage namespace of the repository
+	if err := stroage.CheckNamespace(reqRepo.Name); err != nil {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Invalid repository name"),
+		})
+	}
+
+	reqCtx := ctx.Request().Context()
+	users, err := h.users.GetUsers(reqCtx)
+	if err != nil {
+		webLogger.WithError(err).Error("Failed to get users")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to create repository"),
+		})
+	}
+	i := slices.IndexFunc(users, func(u database.User) bool { return u.Name == *reqRepo.Owner })
+	if i < 0 {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Owner not found"),
+		})
+	}
+	owner := users[i]
+
+	repos, err := h.repos.GetRepos(reqCtx)
+	if err != nil {
+		webLogger.WithError(err).Error("Failed to get repositories")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to create repository"),
+		})
+	}
+	if slices.ContainsFunc(repos, func(r database.Repo) bool { return r.Name == reqRepo.Name }) {
+		return ctx.JSON(http.StatusBadRequest, api.Error{
+			Code:    intPtr(http.StatusBadRequest),
+			Message: strPtr("Repository name is already in use"),
+		})
+	}
+
+	dbRepo := database.NewRepo(reqRepo.Name)
+	var bootstrap func(ctx context.Context) error
+	if h.storage != nil {
+		bootstrap = func(ctx context.Context) error {
+			return git.InitRepository(ctx, h.storage, dbRepo.Name)
+		}
+	}
+	if err := database.CreateRepoWithOwner(reqCtx, h.store, &dbRepo, owner.ID, bootstrap); err != nil {
+		webLogger.WithError(err).Error("Failed to create repository")
+		return ctx.JSON(http.StatusInternalServerError, api.Error{
+			Code:    intPtr(http.StatusInternalServerError),
+			Message: strPtr("Failed to create repository"),
+		})
+	}
+
+	apiRepo := dbRepoToAPIRepo(&dbRepo)
+	apiRepo.Owner = &owner.Name
+	return ctx.JSON(http.StatusCreated, apiRepo)
 }
 
 func (h *APIHandler) DeleteRepo(ctx echo.Context, repoId openapi_types.UUID) error {
@@ -496,13 +967,6 @@ func (h *APIHandler) UpdateRepo(ctx echo.Context, repoId openapi_types.UUID) err
 	})
 }
 
-func (h *APIHandler) GetAccessRoles(ctx echo.Context, repoId openapi_types.UUID) error {
-	return ctx.JSON(http.StatusNotImplemented, api.Error{
-		Code:    intPtr(http.StatusNotImplemented),
-		Message: strPtr("Not implemented"),
-	})
-}
-
 func (h *APIHandler) CreateAccessRole(ctx echo.Context, repoId openapi_types.UUID) error {
 	return ctx.JSON(http.StatusNotImplemented, api.Error{
 		Code:    intPtr(http.StatusNotImplemented),
@@ -0,0 +1,338 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoldenDeals/DepGit/internal/database"
+	"github.com/GoldenDeals/DepGit/internal/gen/api"
+	"github.com/GoldenDeals/DepGit/internal/stroage"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPI(t *testing.T) (*echo.Echo, *database.MemoryStore) {
+	store := database.NewMemoryStore()
+	e := echo.New()
+	api.RegisterHandlers(e, NewAPIHandler(store, nil))
+	return e, store
+}
+
+func doJSON(t *testing.T, e *echo.Echo, method, path, body string, out any) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if out != nil && rec.Body.Len() > 0 {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestAPIHandlerUsers(t *testing.T) {
+	e, store := newTestAPI(t)
+
+	var created api.User
+	code := doJSON(t, e, http.MethodPost, "/users", `{"username":"alice","email":"alice@example.com"}`, &created)
+	require.Equal(t, http.StatusCreated, code)
+	require.NotNil(t, created.Id)
+
+	stored, err := store.GetUser(t.Context(), *created.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", stored.Name)
+
+	t.Run("Gets users", func(t *testing.T) {
+		var user api.User
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, "/users/"+created.Id.String(), "", &user))
+		assert.Equal(t, "alice", user.Username)
+
+		var users []api.User
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, "/users", "", &users))
+		assert.Len(t, users, 1)
+	})
+
+	t.Run("Missing users are not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodGet, "/users/"+uuid.NewString(), "", nil))
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodDelete, "/users/"+uuid.NewString(), "", nil))
+	})
+
+	t.Run("Rejects incomplete users", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodPost, "/users", `{"username":"bob"}`, nil))
+	})
+
+	t.Run("Manages SSH keys", func(t *testing.T) {
+		path := "/users/" + created.Id.String() + "/ssh-keys"
+
+		var key api.SshKey
+		code := doJSON(t, e, http.MethodPost, path, `{"name":"laptop","key":"ssh-ed25519 AAAA","userId":"`+created.Id.String()+`"}`, &key)
+		require.Equal(t, http.StatusCreated, code)
+
+		var keys []api.SshKey
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, path, "", &keys))
+		require.Len(t, keys, 1)
+		assert.Equal(t, "laptop", keys[0].Name)
+
+		assert.Equal(t, http.StatusNoContent, doJSON(t, e, http.MethodDelete, "/ssh-keys/"+key.Id.String(), "", nil))
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodDelete, "/ssh-keys/"+key.Id.String(), "", nil))
+	})
+
+	t.Run("Deletes users", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, doJSON(t, e, http.MethodDelete, "/users/"+created.Id.String(), "", nil))
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodGet, "/users/"+created.Id.String(), "", nil))
+	})
+}
+
+func TestAPIHandlerTrash(t *testing.T) {
+	e, store := newTestAPI(t)
+	ctx := t.Context()
+
+	user := database.NewUser("carol", "carol@example.com")
+	require.NoError(t, store.CreateUser(ctx, &user))
+	key := database.NewSShKey("laptop", database.SSH_RSA, []byte("ssh-rsa AAAA"))
+	require.NoError(t, store.AddSshKey(ctx, user.ID, &key))
+
+	require.Equal(t, http.StatusNoContent, doJSON(t, e, http.MethodDelete, "/users/"+user.ID.String(), "", nil))
+
+	t.Run("Lists deleted records", func(t *testing.T) {
+		var records []api.DeletedRecord
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, "/admin/trash/user", "", &records))
+		require.Len(t, records, 1)
+		assert.Equal(t, user.ID, records[0].Id)
+		assert.Equal(t, api.TrashEntityUser, records[0].Entity)
+		require.NotNil(t, records[0].Name)
+		assert.Equal(t, "carol", *records[0].Name)
+
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodGet, "/admin/trash/branch", "", nil))
+	})
+
+	t.Run("Refuses restoring keys of deleted users", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, doJSON(t, e, http.MethodPost, "/admin/trash/key/"+key.ID.String()+"/restore", "", nil))
+	})
+
+	t.Run("Restores deleted records", func(t *testing.T) {
+		path := "/admin/trash/user/" + user.ID.String() + "/restore"
+		assert.Equal(t, http.StatusNoContent, doJSON(t, e, http.MethodPost, path, "", nil))
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodPost, path, "", nil))
+
+		var keys []api.SshKey
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, "/users/"+user.ID.String()+"/ssh-keys", "", &keys))
+		assert.Len(t, keys, 1)
+	})
+}
+
+func TestAPIHandlerPagination(t *testing.T) {
+	e, store := newTestAPI(t)
+	ctx := t.Context()
+
+	for _, name := range []string{"dave", "alice", "carol"} {
+		user := database.NewUser(name, name+"@example.com")
+		require.NoError(t, store.CreateUser(ctx, &user))
+	}
+
+	t.Run("Follows the next page link", func(t *testing.T) {
+		var names []string
+		path := "/users?limit=2&sort=name"
+		for pages := 0; path != ""; pages++ {
+			require.Less(t, pages, 3)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			var users []api.User
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &users))
+			for _, u := range users {
+				names = append(names, u.Username)
+			}
+
+			path = ""
+			if link := rec.Header().Get("Link"); link != "" {
+				assert.NotEmpty(t, rec.Header().Get("X-Next-Cursor"))
+				next, ok := strings.CutSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+				require.True(t, ok, link)
+				path = next
+			}
+		}
+		assert.Equal(t, []string{"alice", "carol", "dave"}, names)
+	})
+
+	t.Run("Filters by role across pages", func(t *testing.T) {
+		var users []api.User
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users?role=Developer&limit=2", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &users))
+		assert.Len(t, users, 2, "Pages are full")
+		assert.NotEmpty(t, rec.Header().Get("X-Next-Cursor"))
+
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users?role=Viewer&limit=2", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &users))
+		assert.Empty(t, users)
+		assert.Empty(t, rec.Header().Get("X-Next-Cursor"), "No further pages to follow")
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		var users []api.User
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, "/users?q=AR", "", &users))
+		require.Len(t, users, 1)
+		assert.Equal(t, "carol", users[0].Username)
+	})
+
+	t.Run("Rejects bad paging parameters", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodGet, "/users?limit=0", "", nil))
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodGet, "/users?limit=many", "", nil))
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodGet, "/users?sort=password", "", nil))
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodGet, "/users?cursor=bogus", "", nil))
+	})
+
+	t.Run("Lists repositories and their roles", func(t *testing.T) {
+		users, err := store.GetUsers(ctx)
+		require.NoError(t, err)
+		repo := database.NewRepo("depgit")
+		require.NoError(t, database.CreateRepoWithOwner(ctx, store, &repo, users[0].ID, nil))
+		other := database.NewRepo("other")
+		require.NoError(t, store.CreateRepo(ctx, &other))
+
+		var repos []api.Repo
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, "/repos?q=git", "", &repos))
+		require.Len(t, repos, 1)
+		assert.Equal(t, "depgit", repos[0].Name)
+
+		var roles []api.AccessRole
+		assert.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, "/repos/"+repo.ID.String()+"/access-roles?limit=1", "", &roles))
+		require.Len(t, roles, 1)
+		assert.Equal(t, users[0].ID, roles[0].UserId)
+
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodGet, "/repos/"+uuid.NewString()+"/access-roles", "", nil))
+	})
+}
+
+// failingStorage fails every conditional write
+type failingStorage struct {
+	*stroage.MemoryStorage
+}
+
+func (failingStorage) PutIf(context.Context, string, string, io.Reader, stroage.PutCondition) (stroage.ObjectInfo, error) {
+	return stroage.ObjectInfo{}, errors.New("storage unavailable")
+}
+
+func TestAPIHandlerCreateRepo(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	handler := NewAPIHandler(store, nil)
+	storage := stroage.NewMemoryStorage()
+	handler.SetStorage(storage)
+	e := echo.New()
+	api.RegisterHandlers(e, handler)
+
+	owner := database.NewUser("alice", "alice@example.com")
+	require.NoError(t, store.CreateUser(ctx, &owner))
+
+	t.Run("Creates owned repositories", func(t *testing.T) {
+		var created api.Repo
+		require.Equal(t, http.StatusCreated, doJSON(t, e, http.MethodPost, "/repos", `{"name":"project","owner":"alice"}`, &created))
+		require.NotNil(t, created.Id)
+		assert.Equal(t, "project", created.Name)
+		assert.Equal(t, "alice", *created.Owner)
+
+		roles, err := store.GetAccessRoles(ctx)
+		require.NoError(t, err)
+		require.Len(t, roles, 1)
+		assert.Equal(t, owner.ID, roles[0].UserID)
+		assert.Equal(t, *created.Id, roles[0].RepoID)
+
+		head, _, err := storage.Get(ctx, "project", "HEAD")
+		require.NoError(t, err)
+		defer head.Close()
+		data, err := io.ReadAll(head)
+		require.NoError(t, err)
+		assert.Equal(t, "ref: refs/heads/main\n", string(data))
+	})
+
+	t.Run("Rejects bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodPost, "/repos", `{"name":"other"}`, nil))
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodPost, "/repos", `{"name":"../other","owner":"alice"}`, nil))
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodPost, "/repos", `{"name":"other","owner":"bob"}`, nil))
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodPost, "/repos", `{"name":"project","owner":"alice"}`, nil), "names are unique")
+	})
+
+	t.Run("Creates nothing if the storage fails", func(t *testing.T) {
+		handler.SetStorage(failingStorage{stroage.NewMemoryStorage()})
+		defer handler.SetStorage(storage)
+
+		assert.Equal(t, http.StatusInternalServerError, doJSON(t, e, http.MethodPost, "/repos", `{"name":"other","owner":"alice"}`, nil))
+		repos, err := store.GetRepos(ctx)
+		require.NoError(t, err)
+		assert.Len(t, repos, 1)
+	})
+}
+
+func TestAPIHandlerDownloadUrl(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	handler := NewAPIHandler(store, nil)
+
+	storage, err := stroage.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, storage.EnableSignedURLs("http://depgit.example/files/signed", bytes.Repeat([]byte("k"), 32)))
+	require.NoError(t, storage.Put(ctx, "depgit", "objects/pack.pack", strings.NewReader("pack data")))
+
+	repo := database.NewRepo("depgit")
+	require.NoError(t, store.CreateRepo(ctx, &repo))
+	path := "/api/v1/repos/" + repo.ID.String() + "/download-url"
+
+	server := New(Config{
+		StaticDir:    t.TempDir(),
+		APIBasePath:  "/api/v1",
+		Downloads:    storage.DownloadHandler(),
+		DownloadPath: storage.DownloadPath(),
+	}, handler)
+	e := server.echo
+
+	t.Run("Needs a signing storage", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotImplemented, doJSON(t, e, http.MethodGet, path+"?object=objects/pack.pack", "", nil))
+
+		unsigned, err := stroage.NewFileStorage(t.TempDir())
+		require.NoError(t, err)
+		handler.SetURLSigner(unsigned)
+		assert.Equal(t, http.StatusNotImplemented, doJSON(t, e, http.MethodGet, path+"?object=objects/pack.pack", "", nil))
+	})
+
+	handler.SetURLSigner(storage)
+
+	t.Run("Signs URLs served at the base URL path", func(t *testing.T) {
+		var download api.DownloadUrl
+		require.Equal(t, http.StatusOK, doJSON(t, e, http.MethodGet, path+"?object=objects/pack.pack&expiresIn=60", "", &download))
+		assert.WithinDuration(t, time.Now().Add(time.Minute), download.ExpiresAt, 5*time.Second)
+
+		u, err := url.Parse(download.Url)
+		require.NoError(t, err)
+		assert.Equal(t, "/files/signed/depgit/objects/pack.pack", u.Path)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "pack data", rec.Body.String())
+	})
+
+	t.Run("Rejects bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodGet, path+"?object=objects/pack.pack&expiresIn=0", "", nil))
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, e, http.MethodGet, path, "", nil))
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodGet, path+"?object=objects/missing.pack", "", nil))
+		assert.Equal(t, http.StatusNotFound, doJSON(t, e, http.MethodGet, "/api/v1/repos/"+uuid.NewString()+"/download-url?object=objects/pack.pack", "", nil))
+	})
+}
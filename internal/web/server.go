@@ -20,6 +20,11 @@ type Config struct {
 	Address     string
 	StaticDir   string
 	APIBasePath string
+
+	// Downloads serves signed storage URLs below DownloadPath, the path of
+	// their base URL, if set. See stroage.DownloadServer.
+	Downloads    http.Handler
+	DownloadPath string
 }
 
 // Server represents the web server for the DepGit application
@@ -59,6 +64,13 @@ func (s *Server) setupRoutes() {
 	// Set up API routes
 	api.RegisterHandlersWithBaseURL(s.echo, s.handler, s.config.APIBasePath)
 
+	// Signed downloads, which carry their own authorization
+	if s.config.Downloads != nil && s.config.DownloadPath != "" {
+		downloads := echo.WrapHandler(http.StripPrefix(s.config.DownloadPath, s.config.Downloads))
+		s.echo.GET(s.config.DownloadPath+"/*", downloads)
+		s.echo.HEAD(s.config.DownloadPath+"/*", downloads)
+	}
+
 	// SPA fallback - serve index.html for any unmatched routes
 	s.echo.GET("*", func(c echo.Context) error {
 		return c.File(s.config.StaticDir + "/index.html")
@@ -0,0 +1,5 @@
+-- Drop the schema, referencing tables first
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS keys;
+DROP TABLE IF EXISTS permitions;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_roles_rep_id;
+DROP INDEX IF EXISTS idx_roles_user_id;
+DROP INDEX IF EXISTS idx_keys_user_id;
+DROP INDEX IF EXISTS idx_users_email;
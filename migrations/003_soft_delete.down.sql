@@ -0,0 +1,24 @@
+-- Versions before soft delete know neither NULL timestamps nor deleted
+-- records: they would list deleted records, and accept deleted keys, as
+-- live. Deleted records are therefore removed for good, dependents first.
+DELETE FROM roles
+WHERE deleted IS NOT NULL
+   OR user_id IN (SELECT id FROM users WHERE deleted IS NOT NULL)
+   OR rep_id IN (SELECT id FROM permitions WHERE deleted IS NOT NULL);
+DELETE FROM keys
+WHERE deleted IS NOT NULL
+   OR user_id IN (SELECT id FROM users WHERE deleted IS NOT NULL);
+DELETE FROM permitions WHERE deleted IS NOT NULL;
+DELETE FROM users WHERE deleted IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_roles_deleted;
+DROP INDEX IF EXISTS idx_permitions_deleted;
+DROP INDEX IF EXISTS idx_keys_deleted;
+DROP INDEX IF EXISTS idx_users_deleted;
+
+-- Restore the zero time those versions stored instead of NULL
+UPDATE roles SET deleted = '0001-01-01 00:00:00' WHERE deleted IS NULL;
+UPDATE permitions SET deleted = '0001-01-01 00:00:00' WHERE deleted IS NULL;
+UPDATE permitions SET edited = '0001-01-01 00:00:00' WHERE edited IS NULL;
+UPDATE users SET deleted = '0001-01-01 00:00:00' WHERE deleted IS NULL;
+UPDATE users SET edited = '0001-01-01 00:00:00' WHERE edited IS NULL;
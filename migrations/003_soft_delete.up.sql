@@ -0,0 +1,14 @@
+-- Soft delete: a NULL deleted column marks a live record
+
+-- Older versions stored the zero time instead of NULL
+UPDATE users SET edited = NULL WHERE edited = '0001-01-01 00:00:00';
+UPDATE users SET deleted = NULL WHERE deleted = '0001-01-01 00:00:00';
+UPDATE permitions SET edited = NULL WHERE edited = '0001-01-01 00:00:00';
+UPDATE permitions SET deleted = NULL WHERE deleted = '0001-01-01 00:00:00';
+UPDATE roles SET deleted = NULL WHERE deleted = '0001-01-01 00:00:00';
+
+-- Listing and purging deleted records
+CREATE INDEX IF NOT EXISTS idx_users_deleted ON users(deleted);
+CREATE INDEX IF NOT EXISTS idx_keys_deleted ON keys(deleted);
+CREATE INDEX IF NOT EXISTS idx_permitions_deleted ON permitions(deleted);
+CREATE INDEX IF NOT EXISTS idx_roles_deleted ON roles(deleted);
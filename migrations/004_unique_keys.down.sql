@@ -0,0 +1,2 @@
+-- Keys deleted as duplicates stay deleted
+DROP INDEX IF EXISTS idx_keys_user_data;
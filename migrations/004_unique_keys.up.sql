@@ -0,0 +1,13 @@
+-- A user cannot add the same key twice, even with concurrent requests
+
+-- Keep the oldest of keys added twice before the index existed
+UPDATE keys SET deleted = CURRENT_TIMESTAMP
+WHERE deleted IS NULL AND EXISTS (
+    SELECT 1 FROM keys AS older
+    WHERE older.user_id = keys.user_id
+      AND older.data = keys.data
+      AND older.deleted IS NULL
+      AND (older.created < keys.created OR (older.created = keys.created AND older.id < keys.id))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_keys_user_data ON keys(user_id, data) WHERE deleted IS NULL;
@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migrations of the DepGit schema, so
+// that the binary carries the schema it expects.
+package migrations
+
+import "embed"
+
+// FS holds the migration files, see internal/database/migrations for how
+// they are named
+//
+//go:embed *.sql
+var FS embed.FS